@@ -1,14 +1,12 @@
 # app/core/consumer.py
 import aio_pika
 import asyncio
-import json
 from datetime import datetime
 import logging
 from app.core.db import AsyncSessionLocal, NotificationStatus
 from app.core.redis_client import get_redis
 from app.models.status import NotificationStatusUpdate
-import asyncio
-from sqlalchemy import insert
+from sqlalchemy.dialects.postgresql import insert
 
 
 logger = logging.getLogger(__name__)
@@ -17,8 +15,8 @@ QUEUE_STATUS = "status.queue"
 async def handle_message(message: aio_pika.IncomingMessage):
     async with message.process():
         try:
-            data = json.loads(message.body)
-            status_update = NotificationStatusUpdate.parse_obj(data)
+            # push status events arrive wrapped in a CloudEvents envelope
+            status_update = NotificationStatusUpdate.from_message(message.body)
 
             # Save to Postgres
             async with AsyncSessionLocal() as session:
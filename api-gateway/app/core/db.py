@@ -1,5 +1,4 @@
 import os
-from enum import Enum
 from datetime import datetime
 
 from sqlalchemy import Column, String, DateTime
@@ -7,6 +6,8 @@ from sqlalchemy.ext.asyncio import create_async_engine, AsyncSession
 from sqlalchemy.orm import sessionmaker, declarative_base
 from sqlalchemy.types import Enum as SQLAEnum
 
+from app.models.status import NotificationStatus as NotificationStatusEnum
+
 
 # POSTGRES_USER = os.getenv("STATUS_DB_USER")
 # POSTGRES_PASSWORD = os.getenv("STATUS_DB_PASS")
@@ -21,14 +22,19 @@ AsyncSessionLocal = sessionmaker(bind=engine, class_=AsyncSession, expire_on_com
 
 Base = declarative_base()
 
-class NotificationStatusEnum(str, Enum):
-    delivered = "delivered"
-    pending = "pending"
-    failed = "failed"
-
 class NotificationStatus(Base):
     __tablename__ = "notification_status"
     notification_id = Column(String, primary_key=True)
-    status = Column(SQLAEnum(NotificationStatusEnum), nullable=False)
+    # stored as text so statuses added by the push service do not need a type migration,
+    # see migrations/001_notification_status_text.sql for databases created with the native enum
+    status = Column(
+        SQLAEnum(
+            NotificationStatusEnum,
+            native_enum=False,
+            length=32,
+            values_callable=lambda statuses: [status.value for status in statuses],
+        ),
+        nullable=False,
+    )
     error = Column(String, nullable=True)
     updated_at = Column(DateTime, default=datetime.utcnow, onupdate=datetime.utcnow)
\ No newline at end of file
@@ -1,15 +1,36 @@
+import json
 from pydantic import BaseModel
-from typing import Optional
+from typing import Optional, Union
 from enum import Enum
 from datetime import datetime
 
+# statuses published by the push service, see NotificationStatusEnum in push-service/internal/models
 class NotificationStatus(str, Enum):
-    delivered = "delivered"
+    queued = "queued"
+    processing = "processing"
+    retrying = "retrying"
     pending = "pending"
+    delivered = "delivered"
+    partially_delivered = "partially_delivered"
     failed = "failed"
+    suppressed = "suppressed"
+    expired = "expired"
+    aggregated = "aggregated"
+    rate_limited = "rate_limited"
 
 class NotificationStatusUpdate(BaseModel):
     notification_id: str
     status: NotificationStatus
+    sequence: int = 0
     timestamp: Optional[datetime] = None
     error: Optional[str] = None
+    user_id: Optional[str] = None
+    template_code: Optional[str] = None
+
+    @classmethod
+    def from_message(cls, body: Union[bytes, str]) -> "NotificationStatusUpdate":
+        """Parses a status message, unwrapping the CloudEvents envelope push status events arrive in."""
+        data = json.loads(body)
+        if "specversion" in data:
+            data = data["data"]
+        return cls.model_validate(data)
@@ -0,0 +1,6 @@
+-- notification_status.status was created as a native postgres enum holding only
+-- delivered, pending and failed. store it as text so every push status fits
+ALTER TABLE notification_status
+    ALTER COLUMN status TYPE VARCHAR(32) USING status::text;
+
+DROP TYPE IF EXISTS notificationstatusenum;
@@ -0,0 +1,75 @@
+import json
+
+import pytest
+from pydantic import ValidationError
+
+from app.models.status import NotificationStatus, NotificationStatusUpdate
+
+
+# envelope as published by the push service on the status exchange
+CLOUD_EVENT = {
+    "specversion": "1.0",
+    "id": "3f1c2a4e-6d1b-4c55-9a52-0b8f0c9d7e21",
+    "source": "/push-service",
+    "type": "com.notification.push.status.partially_delivered.welcome_email",
+    "subject": "n1",
+    "time": "2025-01-02T03:04:05Z",
+    "datacontenttype": "application/json",
+    "sequence": "4",
+    "data": {
+        "notification_id": "n1",
+        "sequence": 4,
+        "status": "partially_delivered",
+        "timestamp": "2025-01-02T03:04:05Z",
+        "error": None,
+        "user_id": "u1",
+        "notification_type": "push",
+        "template_code": "WELCOME_EMAIL",
+        "metadata": {"success_count": 1, "failed_count": 1},
+    },
+}
+
+
+def test_parse_cloud_event():
+    update = NotificationStatusUpdate.from_message(json.dumps(CLOUD_EVENT).encode())
+
+    assert update.notification_id == "n1"
+    assert update.status == NotificationStatus.partially_delivered
+    assert update.sequence == 4
+    assert update.user_id == "u1"
+    assert update.timestamp.year == 2025
+
+
+def test_parse_bare_status_message():
+    update = NotificationStatusUpdate.from_message(json.dumps(CLOUD_EVENT["data"]))
+
+    assert update.status == NotificationStatus.partially_delivered
+
+
+@pytest.mark.parametrize(
+    "status",
+    [
+        "queued",
+        "processing",
+        "retrying",
+        "pending",
+        "delivered",
+        "partially_delivered",
+        "failed",
+        "suppressed",
+        "expired",
+        "aggregated",
+        "rate_limited",
+    ],
+)
+def test_parse_every_push_status(status):
+    event = {**CLOUD_EVENT, "data": {**CLOUD_EVENT["data"], "status": status}}
+
+    assert NotificationStatusUpdate.from_message(json.dumps(event)).status.value == status
+
+
+def test_unknown_status_is_rejected():
+    event = {**CLOUD_EVENT, "data": {**CLOUD_EVENT["data"], "status": "teleported"}}
+
+    with pytest.raises(ValidationError):
+        NotificationStatusUpdate.from_message(json.dumps(event))
@@ -4,17 +4,24 @@ import (
 	"context"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/campaign"
 	"github.com/zjoart/distributed-notification-system/push-service/internal/config"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/device"
 	handler "github.com/zjoart/distributed-notification-system/push-service/internal/handlers"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/preferences"
 	"github.com/zjoart/distributed-notification-system/push-service/internal/push"
 	"github.com/zjoart/distributed-notification-system/push-service/internal/queue"
 	"github.com/zjoart/distributed-notification-system/push-service/internal/server"
 	"github.com/zjoart/distributed-notification-system/push-service/internal/service"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/status"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/stream"
 	"github.com/zjoart/distributed-notification-system/push-service/internal/template"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/webhook"
 	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
 
 	"github.com/joho/godotenv"
@@ -39,6 +46,7 @@ func main() {
 	rabbitMQ, err := queue.NewRabbitMQ(
 		cfg.GetRabbitMQURL(),
 		cfg.RabbitMQ.Exchange,
+		cfg.RabbitMQ.StatusExchange,
 		cfg.RabbitMQ.PushQueue,
 		cfg.RabbitMQ.FailedQueue,
 		cfg.RabbitMQ.StatusQueue,
@@ -60,6 +68,16 @@ func main() {
 		time.Duration(cfg.Circuit.Timeout)*time.Second,
 	)
 
+	// initialize the outbound throughput limiter shared by all replicas
+	throttle := push.NewThrottle(
+		redisCache,
+		"fcm",
+		cfg.Throughput.ProviderRate,
+		cfg.FCM.ProjectID,
+		cfg.Throughput.ProjectRate,
+		time.Duration(cfg.Throughput.MaxWait)*time.Millisecond,
+	)
+
 	ctx := context.Background()
 	fcmService, err := push.NewFCMService(
 		ctx,
@@ -67,6 +85,7 @@ func main() {
 		cfg.FCM.CredentialsPath,
 		cfg.FCM.Timeout,
 		circuitBreaker,
+		throttle,
 	)
 
 	if err != nil {
@@ -74,6 +93,12 @@ func main() {
 	}
 	logger.Info("FCM service initialized successfully")
 
+	// tenant apps get their own FCM client, built on first use
+	fcmRegistry := push.NewRegistry(fcmService, redisCache, cfg.FCM, cfg.Circuit, cfg.Throughput)
+	if err := fcmRegistry.LoadDir(); err != nil {
+		logger.Fatal("Failed to load FCM app configs", logger.WithError(err))
+	}
+
 	retryService := service.NewRetryService(
 		cfg.Retry.MaxAttempts,
 		cfg.Retry.InitialInterval,
@@ -81,31 +106,160 @@ func main() {
 		cfg.Retry.Multiplier,
 	)
 
+	// the template service gets its own breaker so its outages don't block FCM sends
+	templateCircuitBreaker := push.NewCircuitBreaker(
+		cfg.Circuit.MaxRequests,
+		cfg.Circuit.FailureThreshold,
+		time.Duration(cfg.Circuit.Interval)*time.Second,
+		time.Duration(cfg.Circuit.Timeout)*time.Second,
+	)
+
+	// and a short retry policy of its own, a message falls back to local templates
+	// rather than waiting out the FCM backoff
 	templateClient := template.NewClient(
 		cfg.ExternalServices.TemplateServiceURL,
 		10*time.Second,
+		templateCircuitBreaker,
+		service.NewRetryService(
+			cfg.ExternalServices.TemplateRetries,
+			cfg.ExternalServices.TemplateRetryDelay,
+			cfg.ExternalServices.TemplateRetryDelay,
+			1,
+		),
+	)
+	localRenderer := template.NewLocalRenderer(
+		cfg.ExternalServices.TemplateLocalDir,
+		redisCache,
+		cfg.ExternalServices.DefaultLocale,
+	)
+	if err := localRenderer.LoadDir(); err != nil {
+		logger.Fatal("Failed to load local templates", logger.WithError(err))
+	}
+
+	cachedTemplateClient := template.NewCachedClient(
+		templateClient,
+		redisCache,
+		time.Duration(cfg.ExternalServices.TemplateCacheTTL)*time.Second,
+		cfg.ExternalServices.DefaultLocale,
+		localRenderer,
 	)
 	logger.Info("Template service client initialized", logger.Fields{
-		"url": cfg.ExternalServices.TemplateServiceURL,
+		"url":       cfg.ExternalServices.TemplateServiceURL,
+		"cache_ttl": cfg.ExternalServices.TemplateCacheTTL,
 	})
 
+	deviceRegistry := device.NewRedisRegistry(
+		redisCache,
+		time.Duration(cfg.Device.ActiveDays)*24*time.Hour,
+	)
+
+	// preferences are only enforced when the user service is configured
+	var preferencesProvider service.PreferencesProvider
+	if cfg.ExternalServices.UserServiceURL != "" {
+		userCircuitBreaker := push.NewCircuitBreaker(
+			cfg.Circuit.MaxRequests,
+			cfg.Circuit.FailureThreshold,
+			time.Duration(cfg.Circuit.Interval)*time.Second,
+			time.Duration(cfg.Circuit.Timeout)*time.Second,
+		)
+
+		preferencesProvider = preferences.NewClient(
+			cfg.ExternalServices.UserServiceURL,
+			cfg.ExternalServices.UserServiceAPIKey,
+			5*time.Second,
+			redisCache,
+			time.Duration(cfg.ExternalServices.PreferencesTTL)*time.Second,
+			userCircuitBreaker,
+			retryService,
+		)
+		logger.Info("User preferences client initialized", logger.Fields{
+			"url":       cfg.ExternalServices.UserServiceURL,
+			"cache_ttl": cfg.ExternalServices.PreferencesTTL,
+		})
+	}
+
+	statusStore := status.NewRedisStore(
+		redisCache,
+		time.Duration(cfg.Status.Retention)*time.Second,
+	)
+
+	// status webhooks retry on their own schedule, independent of FCM sends
+	webhookStore := webhook.NewRedisStore(
+		redisCache,
+		time.Duration(cfg.Webhook.Retention)*time.Second,
+	)
+	webhookDispatcher := webhook.NewDispatcher(
+		cfg.Webhook,
+		webhookStore,
+		service.NewRetryService(
+			cfg.Webhook.MaxAttempts,
+			cfg.Webhook.InitialInterval,
+			cfg.Webhook.MaxInterval,
+			cfg.Retry.Multiplier,
+		),
+	)
+
+	// live status stream, relayed between replicas through redis
+	statusBroker := stream.NewBroker(redisCache, cfg.Stream)
+
+	// campaigns fan out through the push queue, paced per campaign
+	campaignStore := campaign.NewRedisStore(
+		redisCache,
+		time.Duration(cfg.Campaign.Retention)*time.Second,
+	)
+	campaignRunner := campaign.NewRunner(campaignStore, deviceRegistry, rabbitMQ, cfg.Campaign)
+
 	notificationService := service.NewNotificationService(
-		fcmService,
+		fcmRegistry,
 		retryService,
 		redisCache,
 		cfg.RateLimit,
+		cfg.Digest,
+		cfg.Status,
+		cfg.Fanout,
 		rabbitMQ,
-		templateClient,
+		cachedTemplateClient,
+		localRenderer,
+		deviceRegistry,
+		preferencesProvider,
+		statusStore,
+		webhookDispatcher,
+		statusBroker,
+		campaignStore,
 	)
 
 	healthHandler := handler.NewHealthHandler(rabbitMQ, redisCache)
-	notificationHandler := handler.NewNotificationHandler(notificationService, rabbitMQ)
+	notificationHandler := handler.NewNotificationHandler(notificationService, rabbitMQ, statusStore, redisCache, cfg.Batch, cfg.Idempotency)
+	statsHandler := handler.NewStatsHandler(circuitBreaker, throttle, fcmRegistry)
+	templateHandler := handler.NewTemplateHandler(cachedTemplateClient)
+	deviceHygiene := device.NewHygiene(deviceRegistry, redisCache, fcmRegistry, cfg.Device)
+	deviceHandler := handler.NewDeviceHandler(deviceRegistry, deviceHygiene)
+	webhookHandler := handler.NewWebhookHandler(webhookStore)
+	streamHandler := handler.NewStreamHandler(statusBroker, time.Duration(cfg.Stream.Heartbeat)*time.Second)
+	campaignHandler := handler.NewCampaignHandler(campaignStore, cfg.Campaign)
+
+	authenticator, err := server.NewAuthenticator(cfg.Auth)
+	if err != nil {
+		logger.Fatal("Failed to configure authentication", logger.WithError(err))
+	}
+	if authenticator == nil {
+		logger.Warn("Authentication is disabled, the HTTP API is open to any caller")
+	}
 
 	httpServer := server.NewServer(
 		cfg.Server.Host,
 		cfg.Server.Port,
+		strings.Split(strings.ReplaceAll(cfg.Server.AllowedOrigins, " ", ""), ","),
+		authenticator,
+		cfg.ExternalServices.TemplateServiceKey,
 		healthHandler,
 		notificationHandler,
+		statsHandler,
+		templateHandler,
+		deviceHandler,
+		webhookHandler,
+		streamHandler,
+		campaignHandler,
 	)
 
 	// start HTTP server in goroutine
@@ -120,6 +274,13 @@ func main() {
 		logger.Fatal("Failed to start consuming messages", logger.WithError(err))
 	}
 
+	go notificationService.RunDigestFlusher(consumerCtx)
+	go deviceHygiene.Run(consumerCtx)
+	go webhookDispatcher.Run(consumerCtx)
+	go statusBroker.Run(consumerCtx)
+	go campaignRunner.Run(consumerCtx)
+	go localRenderer.RunSync(consumerCtx, time.Duration(cfg.ExternalServices.TemplateSyncPeriod)*time.Second)
+
 	logger.Info("Push Service started successfully", logger.Fields{
 		"http_port": cfg.Server.Port,
 		"queue":     cfg.RabbitMQ.PushQueue,
@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+// tokens pushed per RPUSH when storing an uploaded token file
+const campaignTokenChunk = 1000
+
+// campaign progress hash fields besides the outcome counters
+const (
+	CampaignCursorField   = "cursor"
+	CampaignTargetedField = "targeted"
+)
+
+// moves a child message's outcome counter when its outcome changes, so a
+// failure followed by a successful redelivery counts once, as sent
+var campaignOutcomeScript = redis.NewScript(`
+local previous = redis.call('HGET', KEYS[1], ARGV[1])
+if previous == ARGV[2] then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+if previous then
+	redis.call('HINCRBY', KEYS[2], previous, -1)
+end
+redis.call('HINCRBY', KEYS[2], ARGV[2], 1)
+return 1
+`)
+
+// stores a campaign and tracks whether it is running. finished campaigns
+// expire after the retention and their uploaded tokens are removed
+func (c *RedisCache) SaveCampaign(ctx context.Context, campaign *models.Campaign, retention time.Duration) error {
+	stored := *campaign
+	stored.Progress = models.CampaignProgress{} // counters live in the progress hash
+
+	payload, err := json.Marshal(&stored)
+	if err != nil {
+		return fmt.Errorf("failed to marshal campaign: %w", err)
+	}
+
+	key := GetCampaignKey(campaign.ID)
+
+	pipe := c.client.TxPipeline()
+	if campaign.State == models.CampaignStateRunning {
+		pipe.SAdd(ctx, GetRunningCampaignsKey(), campaign.ID)
+	} else {
+		pipe.SRem(ctx, GetRunningCampaignsKey(), campaign.ID)
+	}
+
+	if campaign.Finished() {
+		pipe.Set(ctx, key, payload, retention)
+		pipe.Expire(ctx, GetCampaignProgressKey(campaign.ID), retention)
+		pipe.Expire(ctx, GetCampaignOutcomesKey(campaign.ID), retention)
+		pipe.Del(ctx, GetCampaignTokensKey(campaign.ID))
+	} else {
+		pipe.Set(ctx, key, payload, 0)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to save campaign: %w", err)
+	}
+	return nil
+}
+
+// returns a campaign with its progress, ErrCampaignNotFound when unknown or expired
+func (c *RedisCache) GetCampaign(ctx context.Context, campaignID string) (*models.Campaign, error) {
+	payload, err := c.client.Get(ctx, GetCampaignKey(campaignID)).Result()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("%w: %s", models.ErrCampaignNotFound, campaignID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get campaign: %w", err)
+	}
+
+	campaign := &models.Campaign{}
+	if err := json.Unmarshal([]byte(payload), campaign); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal campaign: %w", err)
+	}
+
+	progress, err := c.client.HGetAll(ctx, GetCampaignProgressKey(campaignID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get campaign progress: %w", err)
+	}
+	counter := func(field string) int64 {
+		value, _ := strconv.ParseInt(progress[field], 10, 64)
+		return value
+	}
+	campaign.Progress = models.CampaignProgress{
+		Targeted:   counter(CampaignTargetedField),
+		Sent:       counter(string(models.NotificationStatusDelivered)),
+		Failed:     counter(string(models.NotificationStatusFailed)),
+		Suppressed: counter(string(models.NotificationStatusSuppressed)),
+	}
+
+	return campaign, nil
+}
+
+// returns the ids of campaigns that are publishing
+func (c *RedisCache) GetRunningCampaignIDs(ctx context.Context) ([]string, error) {
+	ids, err := c.client.SMembers(ctx, GetRunningCampaignsKey()).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list running campaigns: %w", err)
+	}
+	return ids, nil
+}
+
+// returns where a campaign's fan-out continues, empty before the first page
+func (c *RedisCache) GetCampaignCursor(ctx context.Context, campaignID string) (string, error) {
+	cursor, err := c.client.HGet(ctx, GetCampaignProgressKey(campaignID), CampaignCursorField).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get campaign cursor: %w", err)
+	}
+	return cursor, nil
+}
+
+// records a published page of a campaign: the next cursor and the targeted devices
+func (c *RedisCache) AdvanceCampaign(ctx context.Context, campaignID, cursor string, targeted int64) error {
+	key := GetCampaignProgressKey(campaignID)
+
+	pipe := c.client.TxPipeline()
+	pipe.HSet(ctx, key, CampaignCursorField, cursor)
+	pipe.HIncrBy(ctx, key, CampaignTargetedField, targeted)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to advance campaign: %w", err)
+	}
+	return nil
+}
+
+// records the latest outcome of a child message, counted under the outcome name
+func (c *RedisCache) RecordCampaignOutcome(ctx context.Context, campaignID, notificationID, outcome string) error {
+	keys := []string{GetCampaignOutcomesKey(campaignID), GetCampaignProgressKey(campaignID)}
+	if err := campaignOutcomeScript.Run(ctx, c.client, keys, notificationID, outcome).Err(); err != nil {
+		return fmt.Errorf("failed to record campaign outcome: %w", err)
+	}
+	return nil
+}
+
+// stores the uploaded tokens of a campaign in upload order
+func (c *RedisCache) AppendCampaignTokens(ctx context.Context, campaignID string, tokens []string) error {
+	key := GetCampaignTokensKey(campaignID)
+
+	for start := 0; start < len(tokens); start += campaignTokenChunk {
+		end := start + campaignTokenChunk
+		if end > len(tokens) {
+			end = len(tokens)
+		}
+
+		values := make([]interface{}, 0, end-start)
+		for _, token := range tokens[start:end] {
+			values = append(values, token)
+		}
+		if err := c.client.RPush(ctx, key, values...).Err(); err != nil {
+			return fmt.Errorf("failed to store campaign tokens: %w", err)
+		}
+	}
+	return nil
+}
+
+// returns up to count uploaded tokens of a campaign starting at offset
+func (c *RedisCache) GetCampaignTokens(ctx context.Context, campaignID string, offset, count int64) ([]string, error) {
+	tokens, err := c.client.LRange(ctx, GetCampaignTokensKey(campaignID), offset, offset+count-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read campaign tokens: %w", err)
+	}
+	return tokens, nil
+}
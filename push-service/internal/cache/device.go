@@ -0,0 +1,354 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+// attempts to save a device before giving up on concurrent writers
+const maxDeviceSaveAttempts = 5
+
+// stores a device and indexes it under its user, scored by last seen. a
+// token that moved to another user is removed from the previous user and
+// keeps its original creation time. devices are namespaced by their tenant
+func (c *RedisCache) SaveDevice(ctx context.Context, device *models.Device) error {
+	return c.watchDevice(ctx, device.TenantID, device.Token, func(tx *redis.Tx, previous *models.Device) error {
+		if previous != nil && !previous.CreatedAt.IsZero() {
+			device.CreatedAt = previous.CreatedAt
+		}
+		return c.writeDevice(ctx, tx, device, previous)
+	})
+}
+
+// applies update to the stored device and saves it when update returns true.
+// returns the device as stored, ErrDeviceNotFound when it is not registered
+func (c *RedisCache) UpdateDevice(ctx context.Context, tenantID, token string, update func(device *models.Device) bool) (*models.Device, error) {
+	var current *models.Device
+	err := c.watchDevice(ctx, tenantID, token, func(tx *redis.Tx, previous *models.Device) error {
+		if previous == nil {
+			return models.ErrDeviceNotFound
+		}
+
+		current = previous
+		device := *previous
+		if !update(&device) {
+			return nil
+		}
+		current = &device
+		return c.writeDevice(ctx, tx, &device, previous)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+// runs fn with the stored device, nil when missing, while the device key is
+// watched. a concurrent write of the same token makes fn run again on the
+// new state instead of being lost
+func (c *RedisCache) watchDevice(ctx context.Context, tenantID, token string, fn func(tx *redis.Tx, previous *models.Device) error) error {
+	tokenKey := TenantKey(tenantID, GetDeviceTokenCacheKey(token))
+
+	watched := func(tx *redis.Tx) error {
+		previous, err := decodeDevice(tx.Get(ctx, tokenKey))
+		switch {
+		case errors.Is(err, models.ErrDeviceNotFound):
+			previous = nil
+		case err != nil:
+			return err
+		default:
+			previous.TenantID = tenantID
+		}
+		return fn(tx, previous)
+	}
+
+	for attempt := 0; attempt < maxDeviceSaveAttempts; attempt++ {
+		err := c.client.Watch(ctx, watched, tokenKey)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if errors.Is(err, models.ErrDeviceNotFound) {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save device: %w", err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("failed to save device: %w", redis.TxFailedErr)
+}
+
+// writes a device and its indexes in one transaction, recording its tenant
+// so hygiene sweeps find every tenant index
+func (c *RedisCache) writeDevice(ctx context.Context, tx *redis.Tx, device, previous *models.Device) error {
+	payload, err := json.Marshal(device)
+	if err != nil {
+		return fmt.Errorf("failed to marshal device: %w", err)
+	}
+
+	lastSeen := redis.Z{
+		Score:  float64(device.LastSeen.Unix()),
+		Member: device.Token,
+	}
+
+	_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, TenantKey(device.TenantID, GetDeviceTokenCacheKey(device.Token)), payload, 0)
+		pipe.ZAdd(ctx, TenantKey(device.TenantID, GetUserDevicesKey(device.UserID)), lastSeen)
+		pipe.ZAdd(ctx, TenantKey(device.TenantID, GetDeviceLastSeenKey()), lastSeen)
+		pipe.SAdd(ctx, GetDeviceTenantsKey(), device.TenantID)
+		if previous != nil && previous.UserID != device.UserID {
+			pipe.ZRem(ctx, TenantKey(device.TenantID, GetUserDevicesKey(previous.UserID)), device.Token)
+		}
+		return nil
+	})
+	return err
+}
+
+// returns a device of a tenant by token, ErrDeviceNotFound when it is not registered
+func (c *RedisCache) GetDevice(ctx context.Context, tenantID, token string) (*models.Device, error) {
+	device, err := decodeDevice(c.client.Get(ctx, TenantKey(tenantID, GetDeviceTokenCacheKey(token))))
+	if err != nil {
+		return nil, err
+	}
+	device.TenantID = tenantID
+	return device, nil
+}
+
+// decodes the reply of a device GET, ErrDeviceNotFound when the key is missing
+func decodeDevice(cmd *redis.StringCmd) (*models.Device, error) {
+	payload, err := cmd.Result()
+	if err == redis.Nil {
+		return nil, models.ErrDeviceNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device: %w", err)
+	}
+
+	device := &models.Device{}
+	if err := json.Unmarshal([]byte(payload), device); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal device: %w", err)
+	}
+	return device, nil
+}
+
+// removes a device and its user index entry
+func (c *RedisCache) DeleteDevice(ctx context.Context, device *models.Device) error {
+	pipe := c.client.TxPipeline()
+	pipe.Del(ctx, TenantKey(device.TenantID, GetDeviceTokenCacheKey(device.Token)))
+	pipe.ZRem(ctx, TenantKey(device.TenantID, GetUserDevicesKey(device.UserID)), device.Token)
+	pipe.ZRem(ctx, TenantKey(device.TenantID, GetDeviceLastSeenKey()), device.Token)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to delete device: %w", err)
+	}
+	return nil
+}
+
+// returns the user's devices seen at or after since, most recent first.
+// index entries whose device no longer exists are cleaned up
+func (c *RedisCache) GetUserDevices(ctx context.Context, tenantID, userID string, since time.Time) ([]*models.Device, error) {
+	userKey := TenantKey(tenantID, GetUserDevicesKey(userID))
+
+	min := "-inf"
+	if !since.IsZero() {
+		min = strconv.FormatInt(since.Unix(), 10)
+	}
+
+	tokens, err := c.client.ZRevRangeByScore(ctx, userKey, &redis.ZRangeBy{
+		Min: min,
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user devices: %w", err)
+	}
+	if len(tokens) == 0 {
+		return []*models.Device{}, nil
+	}
+
+	keys := make([]string, len(tokens))
+	for i, token := range tokens {
+		keys[i] = TenantKey(tenantID, GetDeviceTokenCacheKey(token))
+	}
+
+	payloads, err := c.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read devices: %w", err)
+	}
+
+	devices := make([]*models.Device, 0, len(tokens))
+	missing := make([]interface{}, 0)
+	for i, payload := range payloads {
+		raw, ok := payload.(string)
+		if !ok {
+			missing = append(missing, tokens[i])
+			continue
+		}
+
+		device := &models.Device{}
+		if err := json.Unmarshal([]byte(raw), device); err != nil || device.UserID != userID {
+			missing = append(missing, tokens[i])
+			continue
+		}
+		device.TenantID = tenantID
+		devices = append(devices, device)
+	}
+
+	if len(missing) > 0 {
+		c.client.ZRem(ctx, userKey, missing...)
+	}
+
+	return devices, nil
+}
+
+// returns up to limit tokens last seen before the given time, oldest first
+func (c *RedisCache) GetStaleDeviceTokens(ctx context.Context, tenantID string, before time.Time, limit int64) ([]string, error) {
+	tokens, err := c.client.ZRangeByScore(ctx, TenantKey(tenantID, GetDeviceLastSeenKey()), &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   "(" + strconv.FormatInt(before.Unix(), 10),
+		Count: limit,
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stale devices: %w", err)
+	}
+	return tokens, nil
+}
+
+// returns a random window of up to n tokens last seen between from and before
+func (c *RedisCache) SampleDeviceTokens(ctx context.Context, tenantID string, from, before time.Time, n int64) ([]string, error) {
+	indexKey := TenantKey(tenantID, GetDeviceLastSeenKey())
+	min := strconv.FormatInt(from.Unix(), 10)
+	max := "(" + strconv.FormatInt(before.Unix(), 10)
+
+	count, err := c.client.ZCount(ctx, indexKey, min, max).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to count devices: %w", err)
+	}
+	if count == 0 {
+		return []string{}, nil
+	}
+
+	offset := int64(0)
+	if count > n {
+		offset = rand.Int63n(count - n + 1)
+	}
+
+	tokens, err := c.client.ZRangeByScore(ctx, indexKey, &redis.ZRangeBy{
+		Min:    min,
+		Max:    max,
+		Offset: offset,
+		Count:  n,
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to sample devices: %w", err)
+	}
+	return tokens, nil
+}
+
+// iterates the last seen index of a tenant with ZSCAN, returning about count
+// tokens and the cursor of the next call, 0 once every token was returned.
+// tokens indexed for the whole scan are returned at least once
+func (c *RedisCache) ScanDeviceTokens(ctx context.Context, tenantID string, cursor uint64, count int64) ([]string, uint64, error) {
+	pairs, next, err := c.client.ZScan(ctx, TenantKey(tenantID, GetDeviceLastSeenKey()), cursor, "", count).Result()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to scan devices: %w", err)
+	}
+
+	// members and scores alternate
+	tokens := make([]string, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		tokens = append(tokens, pairs[i])
+	}
+	return tokens, next, nil
+}
+
+// adds devices stored before the last seen index existed to the index of
+// their tenant, returns how many were added. both the default and the tenant
+// device keys are scanned, indexed devices are left as they are
+func (c *RedisCache) BackfillDeviceIndex(ctx context.Context, batch int64) (int, error) {
+	added := 0
+	for _, pattern := range []string{GetDeviceTokenCacheKey("*"), TenantKey("*", GetDeviceTokenCacheKey("*"))} {
+		count, err := c.backfillDeviceKeys(ctx, pattern, batch)
+		added += count
+		if err != nil {
+			return added, err
+		}
+	}
+	return added, nil
+}
+
+func (c *RedisCache) backfillDeviceKeys(ctx context.Context, pattern string, batch int64) (int, error) {
+	added := 0
+	cursor := uint64(0)
+	for {
+		keys, next, err := c.client.Scan(ctx, cursor, pattern, batch).Result()
+		if err != nil {
+			return added, fmt.Errorf("failed to scan devices: %w", err)
+		}
+
+		if len(keys) > 0 {
+			payloads, err := c.client.MGet(ctx, keys...).Result()
+			if err != nil {
+				return added, fmt.Errorf("failed to read devices: %w", err)
+			}
+
+			members := make(map[string][]redis.Z)
+			for i, payload := range payloads {
+				raw, ok := payload.(string)
+				if !ok {
+					continue
+				}
+				device := &models.Device{}
+				if err := json.Unmarshal([]byte(raw), device); err != nil || device.Token == "" {
+					continue
+				}
+				// the key, not the payload, tells the tenant of a device
+				if TenantKey(device.TenantID, GetDeviceTokenCacheKey(device.Token)) != keys[i] {
+					continue
+				}
+				members[device.TenantID] = append(members[device.TenantID], redis.Z{Score: float64(device.LastSeen.Unix()), Member: device.Token})
+			}
+
+			for tenantID, tenantMembers := range members {
+				count, err := c.client.ZAddNX(ctx, TenantKey(tenantID, GetDeviceLastSeenKey()), tenantMembers...).Result()
+				if err != nil {
+					return added, fmt.Errorf("failed to index devices: %w", err)
+				}
+				if err := c.client.SAdd(ctx, GetDeviceTenantsKey(), tenantID).Err(); err != nil {
+					return added, fmt.Errorf("failed to index device tenant: %w", err)
+				}
+				added += int(count)
+			}
+		}
+
+		if next == 0 {
+			return added, nil
+		}
+		cursor = next
+	}
+}
+
+// removes a token from the last seen index of a tenant, used when its device is gone
+func (c *RedisCache) RemoveDeviceIndex(ctx context.Context, tenantID, token string) error {
+	if err := c.client.ZRem(ctx, TenantKey(tenantID, GetDeviceLastSeenKey()), token).Err(); err != nil {
+		return fmt.Errorf("failed to remove device index: %w", err)
+	}
+	return nil
+}
+
+// returns every tenant that registered a device, the default tenant as ""
+func (c *RedisCache) GetDeviceTenants(ctx context.Context) ([]string, error) {
+	tenants, err := c.client.SMembers(ctx, GetDeviceTenantsKey()).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device tenants: %w", err)
+	}
+	return tenants, nil
+}
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// sorted set of digest buffers scored by their flush time
+const digestScheduleKey = "digest:schedule"
+
+// claims the due digest buffers by pushing their flush time back by a lease,
+// so a buffer whose flush never completes is flushed again once it lapses
+var claimDigestsScript = redis.NewScript(`
+local keys = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, key in ipairs(keys) do
+	redis.call('ZADD', KEYS[1], ARGV[3], key)
+end
+return keys
+`)
+
+// removes the flushed items from the front of a digest buffer. items appended
+// during the flush stay buffered and are scheduled for the next window
+var completeDigestScript = redis.NewScript(`
+redis.call('LTRIM', KEYS[1], ARGV[1], -1)
+if redis.call('LLEN', KEYS[1]) == 0 then
+	redis.call('ZREM', KEYS[2], KEYS[1])
+else
+	redis.call('ZADD', KEYS[2], ARGV[2], KEYS[1])
+end
+return 1
+`)
+
+// appends a payload to a digest buffer and schedules the buffer for flushing.
+// the flush time is only set by the first message of a window
+func (c *RedisCache) AppendDigest(ctx context.Context, key string, payload string, flushAt time.Time, ttl int) (int64, error) {
+	pipe := c.client.TxPipeline()
+	pushCmd := pipe.RPush(ctx, key, payload)
+	pipe.Expire(ctx, key, time.Duration(ttl)*time.Second)
+	pipe.ZAddNX(ctx, digestScheduleKey, redis.Z{
+		Score:  float64(flushAt.Unix()),
+		Member: key,
+	})
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to append digest: %w", err)
+	}
+
+	return pushCmd.Val(), nil
+}
+
+// claims digest buffers due for flushing until the lease ends. a buffer is
+// only returned to one replica per lease
+func (c *RedisCache) ClaimDueDigests(ctx context.Context, now time.Time, limit int64, lease time.Duration) ([]string, error) {
+	keys, err := claimDigestsScript.Run(ctx, c.client, []string{digestScheduleKey},
+		now.Unix(), limit, now.Add(lease).Unix()).StringSlice()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to claim digests: %w", err)
+	}
+	return keys, nil
+}
+
+// returns every payload in a digest buffer, oldest first, without removing them
+func (c *RedisCache) ReadDigest(ctx context.Context, key string) ([]string, error) {
+	items, err := c.client.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read digest: %w", err)
+	}
+	return items, nil
+}
+
+// removes the first count payloads of a flushed digest buffer. the buffer is
+// scheduled for nextFlush when more payloads were appended meanwhile
+func (c *RedisCache) CompleteDigest(ctx context.Context, key string, count int, nextFlush time.Time) error {
+	err := completeDigestScript.Run(ctx, c.client, []string{key, digestScheduleKey}, count, nextFlush.Unix()).Err()
+	if err != nil {
+		return fmt.Errorf("failed to complete digest: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestCache(t *testing.T) (*RedisCache, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+
+	redisCache, err := NewRedisCache(server.Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("failed to connect to miniredis: %v", err)
+	}
+	t.Cleanup(func() { redisCache.Close() })
+
+	return redisCache, server
+}
+
+// tests a digest is claimed once per lease and only loses the items it flushed
+func TestDigestClaimAndComplete(t *testing.T) {
+	redisCache, _ := newTestCache(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	for _, item := range []string{"a", "b"} {
+		if _, err := redisCache.AppendDigest(ctx, "digest:u1:comments", item, now.Add(-time.Second), 3600); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	claimed, err := redisCache.ClaimDueDigests(ctx, now, 10, time.Minute)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("Expected one claimed digest, got %v, %v", claimed, err)
+	}
+
+	if again, _ := redisCache.ClaimDueDigests(ctx, now, 10, time.Minute); len(again) != 0 {
+		t.Errorf("Expected the claimed digest to be leased, got %v", again)
+	}
+
+	t.Run("Claim lapses", func(t *testing.T) {
+		lapsed, err := redisCache.ClaimDueDigests(ctx, now.Add(2*time.Minute), 10, time.Minute)
+		if err != nil || len(lapsed) != 1 {
+			t.Errorf("Expected the digest to be claimed again after its lease, got %v, %v", lapsed, err)
+		}
+	})
+
+	items, err := redisCache.ReadDigest(ctx, claimed[0])
+	if err != nil || len(items) != 2 {
+		t.Fatalf("Expected the buffered items to be kept while flushing, got %v, %v", items, err)
+	}
+
+	// appended while the summary was being sent
+	if _, err := redisCache.AppendDigest(ctx, claimed[0], "c", now.Add(time.Minute), 3600); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	nextFlush := now.Add(time.Hour)
+	if err := redisCache.CompleteDigest(ctx, claimed[0], len(items), nextFlush); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	remaining, _ := redisCache.ReadDigest(ctx, claimed[0])
+	if len(remaining) != 1 || remaining[0] != "c" {
+		t.Errorf("Expected only the late item to remain, got %v", remaining)
+	}
+	if due, _ := redisCache.ClaimDueDigests(ctx, nextFlush, 10, time.Minute); len(due) != 1 {
+		t.Errorf("Expected the remaining item to be scheduled for the next window, got %v", due)
+	}
+
+	if err := redisCache.CompleteDigest(ctx, claimed[0], 1, nextFlush); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if due, _ := redisCache.ClaimDueDigests(ctx, nextFlush.Add(time.Hour), 10, time.Minute); len(due) != 0 {
+		t.Errorf("Expected an empty digest to be unscheduled, got %v", due)
+	}
+}
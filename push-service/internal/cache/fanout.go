@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// aggregated results of the child messages of a split message
+type FanoutProgress struct {
+	Children int64 // child messages the parent was split into, 0 when unknown
+	Finished int64 // child messages with a final status
+	Success  int64 // devices delivered to across all children
+	Failed   int64 // devices not delivered to across all children
+}
+
+// complete once every child has reported a final status
+func (p *FanoutProgress) Complete() bool {
+	return p.Children > 0 && p.Finished >= p.Children
+}
+
+// records a child's device counts, replacing its earlier counts so a child
+// reported twice is counted once. the reply starts with 0 when nothing changed
+var fanoutChildScript = redis.NewScript(`
+local field = 'child:' .. ARGV[1]
+local value = ARGV[2] .. ',' .. ARGV[3]
+local previous = redis.call('HGET', KEYS[1], field)
+if previous == value then
+	return {0, 0, 0, 0, 0}
+end
+if previous then
+	local success, failed = string.match(previous, '(%d+),(%d+)')
+	redis.call('HINCRBY', KEYS[1], 'success', -tonumber(success))
+	redis.call('HINCRBY', KEYS[1], 'failed', -tonumber(failed))
+else
+	redis.call('HINCRBY', KEYS[1], 'finished', 1)
+end
+redis.call('HSET', KEYS[1], field, value)
+redis.call('HINCRBY', KEYS[1], 'success', ARGV[2])
+redis.call('HINCRBY', KEYS[1], 'failed', ARGV[3])
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+local totals = redis.call('HMGET', KEYS[1], 'children', 'finished', 'success', 'failed')
+return {1, tonumber(totals[1] or 0), tonumber(totals[2]), tonumber(totals[3]), tonumber(totals[4])}
+`)
+
+// records how many children a message was split into. splitting the same
+// message again keeps the results already reported
+func (c *RedisCache) StartFanout(ctx context.Context, key string, children int, ttl time.Duration) error {
+	pipe := c.client.TxPipeline()
+	pipe.HSetNX(ctx, key, "children", children)
+	pipe.Expire(ctx, key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to start fan-out: %w", err)
+	}
+	return nil
+}
+
+// records the final device counts of a child message, returns the parent's
+// progress and whether it changed
+func (c *RedisCache) RecordFanoutChild(ctx context.Context, key, childID string, success, failed int, ttl time.Duration) (*FanoutProgress, bool, error) {
+	values, err := fanoutChildScript.Run(ctx, c.client, []string{key}, childID, success, failed, ttl.Milliseconds()).Int64Slice()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to record fan-out child: %w", err)
+	}
+	if len(values) != 5 {
+		return nil, false, fmt.Errorf("unexpected fan-out reply: %v", values)
+	}
+
+	progress := &FanoutProgress{
+		Children: values[1],
+		Finished: values[2],
+		Success:  values[3],
+		Failed:   values[4],
+	}
+	return progress, values[0] == 1, nil
+}
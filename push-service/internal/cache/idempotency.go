@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// a request stored under its idempotency key. the response is empty while
+// the first request is still in progress
+type IdempotencyRecord struct {
+	BodyHash  string          `json:"body_hash"`
+	Status    int             `json:"status,omitempty"`
+	Response  json.RawMessage `json:"response,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// whether the first request finished and its response can be replayed
+func (r *IdempotencyRecord) Completed() bool {
+	return r.Status != 0
+}
+
+// claims a key for a new request, or returns the record already stored. the
+// claim and the lookup are one step so concurrent retries cannot both claim
+var claimIdempotencyScript = redis.NewScript(`
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return false
+end
+return redis.call('GET', KEYS[1])
+`)
+
+// claims an idempotency key for the lock timeout. returns nil and true when
+// claimed, the stored record and false when the key was used before
+func (c *RedisCache) ClaimIdempotencyKey(ctx context.Context, key, bodyHash string, lockTimeout time.Duration) (*IdempotencyRecord, bool, error) {
+	claim, err := json.Marshal(&IdempotencyRecord{BodyHash: bodyHash, CreatedAt: time.Now()})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to marshal idempotency record: %w", err)
+	}
+
+	payload, err := claimIdempotencyScript.Run(ctx, c.client, []string{key}, claim, lockTimeout.Milliseconds()).Text()
+	if err == redis.Nil {
+		return nil, true, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+
+	record := &IdempotencyRecord{}
+	if err := json.Unmarshal([]byte(payload), record); err != nil {
+		return nil, false, fmt.Errorf("failed to unmarshal idempotency record: %w", err)
+	}
+	return record, false, nil
+}
+
+// stores the response of a claimed request, replayed until the ttl ends
+func (c *RedisCache) CompleteIdempotencyKey(ctx context.Context, key string, record *IdempotencyRecord, ttl time.Duration) error {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal idempotency record: %w", err)
+	}
+
+	if err := c.client.Set(ctx, key, payload, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store idempotency record: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// extends a lock only while it is still held by the owner
+var renewLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// deletes a lock only while it is still held by the owner
+var releaseLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// acquires or renews a lock for owner. returns false while another owner
+// holds it, the lock expires after ttl unless renewed
+func (c *RedisCache) AcquireLock(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	acquired, err := c.client.SetNX(ctx, key, owner, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	if acquired {
+		return true, nil
+	}
+
+	renewed, err := renewLockScript.Run(ctx, c.client, []string{key}, owner, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to renew lock: %w", err)
+	}
+	return renewed == 1, nil
+}
+
+// releases a lock held by owner
+func (c *RedisCache) ReleaseLock(ctx context.Context, key, owner string) error {
+	if err := releaseLockScript.Run(ctx, c.client, []string{key}, owner).Err(); err != nil {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+)
+
+// publishes a payload to every subscriber of a channel, on any replica
+func (c *RedisCache) Publish(ctx context.Context, channel, payload string) error {
+	if err := c.client.Publish(ctx, channel, payload).Err(); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", channel, err)
+	}
+	return nil
+}
+
+// subscribes to a channel and returns its payloads. the channel is closed once
+// the context is cancelled
+func (c *RedisCache) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	pubsub := c.client.Subscribe(ctx, channel)
+
+	// wait for the confirmation so publishes after this call are received
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("failed to subscribe to %s: %w", channel, err)
+	}
+
+	payloads := make(chan string)
+	go func() {
+		defer close(payloads)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				select {
+				case payloads <- msg.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return payloads, nil
+}
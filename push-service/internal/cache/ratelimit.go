@@ -0,0 +1,212 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/id"
+)
+
+// rate limiting algorithms
+const (
+	RateLimitSlidingWindow = "sliding_window"
+	RateLimitTokenBucket   = "token_bucket"
+)
+
+// outcome of a rate limit check
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int64
+	Remaining  int64
+	ResetAfter time.Duration // time until the next unit of quota is restored
+}
+
+// time at which the next unit of quota is restored
+func (r *RateLimitResult) ResetAt() time.Time {
+	return time.Now().Add(r.ResetAfter)
+}
+
+// a limit checked together with others, one unit of quota per request
+type RateLimit struct {
+	Key    string
+	Limit  int64
+	Window int // seconds
+}
+
+// sliding log: one sorted set member per accepted request, scored by its time.
+// every key is checked first and a request is only logged when all of them
+// have room. redis server time is used so every replica shares the same clock.
+// ARGV holds the member then a window and limit pair per key, the reply a
+// room, remaining and reset triple per key
+var slidingWindowScript = redis.NewScript(`
+local member = ARGV[1]
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local counts = {}
+local room = {}
+local allowed = true
+for i, key in ipairs(KEYS) do
+	local window = tonumber(ARGV[i * 2])
+	local limit = tonumber(ARGV[i * 2 + 1])
+
+	redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+	counts[i] = redis.call('ZCARD', key)
+	room[i] = counts[i] < limit and 1 or 0
+	if room[i] == 0 then
+		allowed = false
+	end
+end
+
+local reply = {}
+for i, key in ipairs(KEYS) do
+	local window = tonumber(ARGV[i * 2])
+	local limit = tonumber(ARGV[i * 2 + 1])
+
+	if allowed then
+		redis.call('ZADD', key, now, now .. '-' .. member)
+		counts[i] = counts[i] + 1
+	end
+	redis.call('PEXPIRE', key, window)
+
+	local reset = 0
+	local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+	if oldest[2] then
+		reset = tonumber(oldest[2]) + window - now
+	end
+
+	table.insert(reply, room[i])
+	table.insert(reply, limit - counts[i])
+	table.insert(reply, reset)
+end
+
+return reply
+`)
+
+// token bucket: each bucket holds up to its limit of tokens and refills
+// completely over its window. a missing key is a full bucket, and a token is
+// only taken when every bucket has one. ARGV holds the cost then a window and
+// capacity pair per key, the reply a room, remaining and reset triple per key
+var tokenBucketScript = redis.NewScript(`
+local cost = tonumber(ARGV[1])
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local tokens = {}
+local room = {}
+local allowed = true
+for i, key in ipairs(KEYS) do
+	local window = tonumber(ARGV[i * 2])
+	local capacity = tonumber(ARGV[i * 2 + 1])
+	local rate = capacity / window
+
+	local state = redis.call('HMGET', key, 'tokens', 'ts')
+	local available = tonumber(state[1]) or capacity
+	local ts = tonumber(state[2]) or now
+
+	tokens[i] = math.min(capacity, available + math.max(0, now - ts) * rate)
+	room[i] = tokens[i] >= cost and 1 or 0
+	if room[i] == 0 then
+		allowed = false
+	end
+end
+
+local reply = {}
+for i, key in ipairs(KEYS) do
+	local window = tonumber(ARGV[i * 2])
+	local capacity = tonumber(ARGV[i * 2 + 1])
+	local rate = capacity / window
+
+	if allowed then
+		tokens[i] = tokens[i] - cost
+	end
+
+	redis.call('HSET', key, 'tokens', tostring(tokens[i]), 'ts', now)
+	redis.call('PEXPIRE', key, window)
+
+	local reset = 0
+	if tokens[i] < capacity then
+		reset = math.ceil((math.floor(tokens[i]) + 1 - tokens[i]) / rate)
+	end
+
+	table.insert(reply, room[i])
+	table.insert(reply, math.floor(tokens[i]))
+	table.insert(reply, reset)
+end
+
+return reply
+`)
+
+// checks every limit with the given algorithm in one step and consumes one
+// unit of quota from each only when all of them allow it, so a rejected
+// request costs no quota. returns one result per limit
+func (c *RedisCache) CheckRateLimits(ctx context.Context, algorithm string, limits []RateLimit) ([]*RateLimitResult, error) {
+	if len(limits) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, len(limits))
+	args := make([]interface{}, 0, 1+2*len(limits))
+
+	switch algorithm {
+	case RateLimitTokenBucket:
+		args = append(args, 1)
+	case RateLimitSlidingWindow, "":
+		args = append(args, id.Generate())
+	default:
+		return nil, fmt.Errorf("unknown rate limit algorithm: %s", algorithm)
+	}
+
+	for i, limit := range limits {
+		keys[i] = limit.Key
+		args = append(args, (time.Duration(limit.Window) * time.Second).Milliseconds(), limit.Limit)
+	}
+
+	script := slidingWindowScript
+	if algorithm == RateLimitTokenBucket {
+		script = tokenBucketScript
+	}
+
+	values, err := script.Run(ctx, c.client, keys, args...).Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check rate limit: %w", err)
+	}
+	if len(values) != 3*len(limits) {
+		return nil, fmt.Errorf("unexpected rate limit reply: %v", values)
+	}
+
+	results := make([]*RateLimitResult, len(limits))
+	for i, limit := range limits {
+		result, err := parseRateLimitResult(values[3*i:3*i+3], limit.Limit)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = result
+	}
+
+	return results, nil
+}
+
+func parseRateLimitResult(values []interface{}, limit int64) (*RateLimitResult, error) {
+	if len(values) != 3 {
+		return nil, fmt.Errorf("unexpected rate limit reply: %v", values)
+	}
+
+	allowed, ok1 := values[0].(int64)
+	remaining, ok2 := values[1].(int64)
+	reset, ok3 := values[2].(int64)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, fmt.Errorf("unexpected rate limit reply: %v", values)
+	}
+
+	return &RateLimitResult{
+		Allowed:    allowed == 1,
+		Limit:      limit,
+		Remaining:  remaining,
+		ResetAfter: time.Duration(reset) * time.Millisecond,
+	}, nil
+}
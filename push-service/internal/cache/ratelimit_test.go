@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// tests parsing of the rate limit script reply
+func TestParseRateLimitResult(t *testing.T) {
+	t.Run("Allowed", func(t *testing.T) {
+		result, err := parseRateLimitResult([]interface{}{int64(1), int64(7), int64(1500)}, 10)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if !result.Allowed || result.Remaining != 7 || result.Limit != 10 {
+			t.Errorf("Unexpected result %+v", result)
+		}
+
+		if result.ResetAfter != 1500*time.Millisecond {
+			t.Errorf("Expected reset after 1.5s, got %v", result.ResetAfter)
+		}
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		result, err := parseRateLimitResult([]interface{}{int64(0), int64(0), int64(30000)}, 10)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if result.Allowed {
+			t.Error("Expected result to be rejected")
+		}
+	})
+
+	t.Run("Malformed reply", func(t *testing.T) {
+		if _, err := parseRateLimitResult([]interface{}{int64(1)}, 10); err == nil {
+			t.Error("Expected error for short reply")
+		}
+
+		if _, err := parseRateLimitResult([]interface{}{"1", int64(0), int64(0)}, 10); err == nil {
+			t.Error("Expected error for non integer reply")
+		}
+	})
+}
+
+// tests a request rejected by one limit costs no quota on the others
+func TestCheckRateLimits(t *testing.T) {
+	for _, algorithm := range []string{RateLimitSlidingWindow, RateLimitTokenBucket} {
+		t.Run(algorithm, func(t *testing.T) {
+			redisCache, _ := newTestCache(t)
+			ctx := context.Background()
+
+			user := RateLimit{Key: "rate_limit:" + algorithm + ":u1", Limit: 5, Window: 60}
+			tenant := RateLimit{Key: "rate_limit:" + algorithm + ":t1", Limit: 1, Window: 60}
+
+			results, err := redisCache.CheckRateLimits(ctx, algorithm, []RateLimit{user, tenant})
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if !results[0].Allowed || !results[1].Allowed {
+				t.Fatalf("Expected the first request to be allowed, got %+v, %+v", results[0], results[1])
+			}
+
+			for i := 0; i < 3; i++ {
+				results, err = redisCache.CheckRateLimits(ctx, algorithm, []RateLimit{user, tenant})
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				if results[1].Allowed {
+					t.Fatalf("Expected the tenant limit to reject the request")
+				}
+			}
+
+			if results[0].Remaining != 4 {
+				t.Errorf("Expected rejected requests to leave the user quota at 4, got %d", results[0].Remaining)
+			}
+		})
+	}
+
+	t.Run("Unknown algorithm", func(t *testing.T) {
+		redisCache, _ := newTestCache(t)
+
+		_, err := redisCache.CheckRateLimits(context.Background(), "leaky_bucket", []RateLimit{{Key: "k", Limit: 1, Window: 1}})
+		if err == nil {
+			t.Error("Expected an error for an unknown algorithm")
+		}
+	})
+}
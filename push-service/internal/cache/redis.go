@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
 	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
 )
 
@@ -40,7 +41,7 @@ func NewRedisCache(addr, password string, db int) (*RedisCache, error) {
 func (c *RedisCache) Get(ctx context.Context, key string) (string, error) {
 	val, err := c.client.Get(ctx, key).Result()
 	if err == redis.Nil {
-		return "", fmt.Errorf("key not found: %s", key)
+		return "", fmt.Errorf("%w: key not found: %s", models.ErrCacheMiss, key)
 	}
 	if err != nil {
 		return "", fmt.Errorf("failed to get from cache: %w", err)
@@ -132,13 +133,67 @@ func (c *RedisCache) SetMulti(ctx context.Context, pairs map[string]string, ttl
 	return nil
 }
 
-func (c *RedisCache) CheckRateLimit(ctx context.Context, key string, limit int64, window int) (bool, error) {
-	count, err := c.IncrementWithExpiry(ctx, key, window)
+func (c *RedisCache) HSet(ctx context.Context, key, field, value string) error {
+	if err := c.client.HSet(ctx, key, field, value).Err(); err != nil {
+		return fmt.Errorf("failed to set hash field: %w", err)
+	}
+	return nil
+}
+
+func (c *RedisCache) HGet(ctx context.Context, key, field string) (string, error) {
+	val, err := c.client.HGet(ctx, key, field).Result()
+	if err == redis.Nil {
+		return "", fmt.Errorf("%w: field not found: %s %s", models.ErrCacheMiss, key, field)
+	}
 	if err != nil {
-		return false, err
+		return "", fmt.Errorf("failed to get hash field: %w", err)
 	}
+	return val, nil
+}
 
-	return count <= limit, nil
+func (c *RedisCache) HDel(ctx context.Context, key string, fields ...string) error {
+	if err := c.client.HDel(ctx, key, fields...).Err(); err != nil {
+		return fmt.Errorf("failed to delete hash fields: %w", err)
+	}
+	return nil
+}
+
+func (c *RedisCache) Expire(ctx context.Context, key string, ttl int) error {
+	if err := c.client.Expire(ctx, key, time.Duration(ttl)*time.Second).Err(); err != nil {
+		return fmt.Errorf("failed to set expiry: %w", err)
+	}
+	return nil
+}
+
+func (c *RedisCache) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	values, err := c.client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hash: %w", err)
+	}
+	return values, nil
+}
+
+// deletes every key matching a glob pattern, returns how many were deleted
+func (c *RedisCache) DeleteMatching(ctx context.Context, pattern string) (int, error) {
+	deleted := 0
+	cursor := uint64(0)
+	for {
+		keys, next, err := c.client.Scan(ctx, cursor, pattern, 100).Result()
+		if err != nil {
+			return deleted, fmt.Errorf("failed to scan keys: %w", err)
+		}
+		if len(keys) > 0 {
+			count, err := c.client.Del(ctx, keys...).Result()
+			if err != nil {
+				return deleted, fmt.Errorf("failed to delete keys: %w", err)
+			}
+			deleted += int(count)
+		}
+		if next == 0 {
+			return deleted, nil
+		}
+		cursor = next
+	}
 }
 
 func (c *RedisCache) Health(ctx context.Context) error {
@@ -149,14 +204,134 @@ func (c *RedisCache) Close() error {
 	return c.client.Close()
 }
 
+// namespaces a key by tenant, keys of messages without a tenant are unchanged
+func TenantKey(tenantID, key string) string {
+	if tenantID == "" {
+		return key
+	}
+	return fmt.Sprintf("tenant:%s:%s", tenantID, key)
+}
+
 func GetIdempotencyKey(notificationID string) string {
 	return fmt.Sprintf("idempotency:notification:%s", notificationID)
 }
 
+func GetRequestIdempotencyKey(key string) string {
+	return fmt.Sprintf("idempotency:request:%s", key)
+}
+
+func GetNotificationStatusKey(notificationID string) string {
+	return fmt.Sprintf("status:notification:%s", notificationID)
+}
+
+func GetRequestStatusKey(requestID string) string {
+	return fmt.Sprintf("status:request:%s", requestID)
+}
+
+func GetStatusSequenceKey(notificationID string) string {
+	return fmt.Sprintf("status:sequence:%s", notificationID)
+}
+
+func GetBatchKey(batchID string) string {
+	return fmt.Sprintf("batch:%s", batchID)
+}
+
+func GetCampaignKey(campaignID string) string {
+	return fmt.Sprintf("campaign:%s", campaignID)
+}
+
+func GetCampaignProgressKey(campaignID string) string {
+	return fmt.Sprintf("campaign:%s:progress", campaignID)
+}
+
+func GetCampaignOutcomesKey(campaignID string) string {
+	return fmt.Sprintf("campaign:%s:outcomes", campaignID)
+}
+
+func GetCampaignTokensKey(campaignID string) string {
+	return fmt.Sprintf("campaign:%s:tokens", campaignID)
+}
+
+func GetRunningCampaignsKey() string {
+	return "campaigns:running"
+}
+
+func GetFanoutKey(parentID string) string {
+	return fmt.Sprintf("fanout:%s", parentID)
+}
+
+func GetWebhookDeliveryKey(deliveryID string) string {
+	return fmt.Sprintf("webhook:delivery:%s", deliveryID)
+}
+
+func GetWebhookSecretKey(deliveryID string) string {
+	return fmt.Sprintf("webhook:secret:%s", deliveryID)
+}
+
+func GetFailedWebhooksKey() string {
+	return "webhook:failed"
+}
+
 func GetRateLimitKey(userID string) string {
 	return fmt.Sprintf("ratelimit:user:%s", userID)
 }
 
+func GetTemplateRateLimitKey(templateCode string) string {
+	return fmt.Sprintf("ratelimit:template:%s", templateCode)
+}
+
+func GetTenantRateLimitKey(tenantID string) string {
+	return fmt.Sprintf("ratelimit:tenant:%s", tenantID)
+}
+
 func GetDeviceTokenCacheKey(token string) string {
 	return fmt.Sprintf("device:token:%s", token)
 }
+
+func GetUserDevicesKey(userID string) string {
+	return fmt.Sprintf("device:user:%s", userID)
+}
+
+func GetDeviceLastSeenKey() string {
+	return "device:last_seen"
+}
+
+func GetDeviceTenantsKey() string {
+	return "device:tenants"
+}
+
+func GetDeviceHygieneReportKey() string {
+	return "device:hygiene:report"
+}
+
+func GetUserPreferencesKey(userID string) string {
+	return fmt.Sprintf("preferences:user:%s", userID)
+}
+
+func GetLeaderLockKey(name string) string {
+	return fmt.Sprintf("leader:%s", name)
+}
+
+func GetDigestKey(userID, group string) string {
+	return fmt.Sprintf("digest:user:%s:group:%s", userID, group)
+}
+
+func GetThroughputKey(scope, name string) string {
+	return fmt.Sprintf("throughput:%s:%s", scope, name)
+}
+
+func GetTemplateCacheKey(templateCode string) string {
+	return fmt.Sprintf("template:push:%s", templateCode)
+}
+
+func GetTemplateSnapshotKey() string {
+	return "template:snapshots"
+}
+
+func GetStatusStreamChannel() string {
+	return "status:stream"
+}
+
+func GetStatusStreamListenersKey() string {
+	return "status:stream:listeners"
+}
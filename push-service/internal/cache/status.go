@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+// attempts to update a status before giving up on concurrent writers
+const maxStatusUpdateAttempts = 10
+
+// applies an update to the stored status of a notification, nil when none is
+// stored, while its key is watched, so concurrent updates of the same
+// notification are applied one after the other instead of overwriting each
+// other. apply returns the new status and the key of the request index it
+// belongs to, empty for none. both keys expire after the retention, the index
+// is extended on every save
+func (c *RedisCache) UpdateNotificationStatus(ctx context.Context, key string, retention time.Duration, apply func(current *models.NotificationStatusResponse) (*models.NotificationStatusResponse, string)) error {
+	watched := func(tx *redis.Tx) error {
+		current, err := decodeNotificationStatus(tx.Get(ctx, key))
+		if err != nil && !errors.Is(err, models.ErrNotificationNotFound) {
+			return err
+		}
+
+		next, requestKey := apply(current)
+		payload, err := json.Marshal(next)
+		if err != nil {
+			return fmt.Errorf("failed to marshal notification status: %w", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, payload, retention)
+			if requestKey != "" {
+				pipe.SAdd(ctx, requestKey, next.ID)
+				pipe.Expire(ctx, requestKey, retention)
+			}
+			return nil
+		})
+		return err
+	}
+
+	for attempt := 0; attempt < maxStatusUpdateAttempts; attempt++ {
+		err := c.client.Watch(ctx, watched, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			// jitter so writers that collided do not collide again
+			time.Sleep(time.Duration(rand.Intn(attempt+1)+1) * time.Millisecond)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save notification status: %w", err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("failed to save notification status: %w", redis.TxFailedErr)
+}
+
+// returns a notification status, ErrNotificationNotFound when none is stored
+func (c *RedisCache) GetNotificationStatus(ctx context.Context, key string) (*models.NotificationStatusResponse, error) {
+	return decodeNotificationStatus(c.client.Get(ctx, key))
+}
+
+// returns the stored statuses of several notifications in one round trip,
+// skipping keys without a stored status
+func (c *RedisCache) GetNotificationStatuses(ctx context.Context, keys []string) ([]*models.NotificationStatusResponse, error) {
+	if len(keys) == 0 {
+		return []*models.NotificationStatusResponse{}, nil
+	}
+
+	payloads, err := c.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notification statuses: %w", err)
+	}
+
+	statuses := make([]*models.NotificationStatusResponse, 0, len(payloads))
+	for i, payload := range payloads {
+		raw, ok := payload.(string)
+		if !ok {
+			continue
+		}
+
+		status := &models.NotificationStatusResponse{}
+		if err := json.Unmarshal([]byte(raw), status); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal notification status %s: %w", keys[i], err)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// decodes the reply of a status GET, ErrNotificationNotFound when the key is missing
+func decodeNotificationStatus(cmd *redis.StringCmd) (*models.NotificationStatusResponse, error) {
+	payload, err := cmd.Result()
+	if err == redis.Nil {
+		return nil, models.ErrNotificationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notification status: %w", err)
+	}
+
+	status := &models.NotificationStatusResponse{}
+	if err := json.Unmarshal([]byte(payload), status); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal notification status: %w", err)
+	}
+	return status, nil
+}
+
+// returns the ids of the notifications indexed under a request
+func (c *RedisCache) GetRequestNotificationIDs(ctx context.Context, requestKey string) ([]string, error) {
+	ids, err := c.client.SMembers(ctx, requestKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get request notifications: %w", err)
+	}
+	return ids, nil
+}
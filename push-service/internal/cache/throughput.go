@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// multi-bucket token acquire. every bucket refills at its own rate per second and
+// holds at most one second of tokens. tokens are only taken when every bucket
+// can pay the cost, otherwise the longest wait in milliseconds is returned
+var acquireThroughputScript = redis.NewScript(`
+local cost = tonumber(ARGV[1])
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local wait = 0
+local levels = {}
+for i, key in ipairs(KEYS) do
+	local rate = tonumber(ARGV[i + 1])
+	local state = redis.call('HMGET', key, 'tokens', 'ts')
+	local tokens = tonumber(state[1]) or rate
+	local ts = tonumber(state[2]) or now
+
+	tokens = math.min(rate, tokens + math.max(0, now - ts) * rate / 1000)
+	levels[i] = tokens
+
+	if tokens < cost then
+		wait = math.max(wait, math.ceil((cost - tokens) * 1000 / rate))
+	end
+end
+
+if wait == 0 then
+	for i, key in ipairs(KEYS) do
+		redis.call('HSET', key, 'tokens', tostring(levels[i] - cost), 'ts', now)
+		redis.call('PEXPIRE', key, 2000)
+	end
+end
+
+return wait
+`)
+
+// returns tokens to every bucket, capped at one second of tokens
+var releaseThroughputScript = redis.NewScript(`
+local amount = tonumber(ARGV[1])
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+for i, key in ipairs(KEYS) do
+	local rate = tonumber(ARGV[i + 1])
+	local state = redis.call('HMGET', key, 'tokens', 'ts')
+	local tokens = tonumber(state[1]) or rate
+	local ts = tonumber(state[2]) or now
+
+	tokens = math.min(rate, tokens + math.max(0, now - ts) * rate / 1000 + amount)
+	redis.call('HSET', key, 'tokens', tostring(tokens), 'ts', now)
+	redis.call('PEXPIRE', key, 2000)
+end
+
+return 1
+`)
+
+// current token level of a throughput bucket, in thousandths of a token
+var throughputLevelScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or rate
+local ts = tonumber(state[2]) or now
+
+tokens = math.min(rate, tokens + math.max(0, now - ts) * rate / 1000)
+return math.floor(tokens * 1000)
+`)
+
+// takes cost tokens from every bucket, rates are tokens per second per key.
+// returns zero when the tokens were acquired, otherwise how long to wait
+func (c *RedisCache) AcquireThroughput(ctx context.Context, keys []string, rates []int, cost int) (time.Duration, error) {
+	if len(keys) != len(rates) {
+		return 0, fmt.Errorf("throughput keys and rates mismatch")
+	}
+
+	args := make([]interface{}, 0, len(rates)+1)
+	args = append(args, cost)
+	for _, rate := range rates {
+		args = append(args, rate)
+	}
+
+	wait, err := acquireThroughputScript.Run(ctx, c.client, keys, args...).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("failed to acquire throughput tokens: %w", err)
+	}
+
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+// gives back tokens taken by AcquireThroughput that were not used
+func (c *RedisCache) ReleaseThroughput(ctx context.Context, keys []string, rates []int, amount int) error {
+	if len(keys) != len(rates) {
+		return fmt.Errorf("throughput keys and rates mismatch")
+	}
+
+	args := make([]interface{}, 0, len(rates)+1)
+	args = append(args, amount)
+	for _, rate := range rates {
+		args = append(args, rate)
+	}
+
+	if err := releaseThroughputScript.Run(ctx, c.client, keys, args...).Err(); err != nil {
+		return fmt.Errorf("failed to release throughput tokens: %w", err)
+	}
+
+	return nil
+}
+
+// returns the tokens currently available in a throughput bucket
+func (c *RedisCache) ThroughputLevel(ctx context.Context, key string, rate int) (float64, error) {
+	level, err := throughputLevelScript.Run(ctx, c.client, []string{key}, strconv.Itoa(rate)).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read throughput level: %w", err)
+	}
+
+	return float64(level) / 1000, nil
+}
@@ -0,0 +1,161 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+// sorted set of pending webhook deliveries scored by their next attempt
+const webhookScheduleKey = "webhook:schedule"
+
+// claims the due webhook deliveries by pushing their next attempt back by a
+// lease, so a delivery whose attempt never completes is tried again once it lapses
+var claimWebhooksScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, id in ipairs(ids) do
+	redis.call('ZADD', KEYS[1], ARGV[3], id)
+end
+return ids
+`)
+
+// stores a webhook delivery. a delivery with a next attempt is scheduled for
+// it, one given up on is indexed by time under failedKey so it can be listed.
+// finished deliveries leave the schedule and drop their signing secret
+func (c *RedisCache) SaveWebhookDelivery(ctx context.Context, failedKey string, delivery *models.WebhookDelivery, retention time.Duration) error {
+	payload, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook delivery: %w", err)
+	}
+
+	pipe := c.client.TxPipeline()
+	pipe.Set(ctx, GetWebhookDeliveryKey(delivery.ID), payload, retention)
+	switch {
+	case delivery.NextAttemptAt != nil:
+		pipe.ZAdd(ctx, webhookScheduleKey, redis.Z{
+			Score:  float64(delivery.NextAttemptAt.Unix()),
+			Member: delivery.ID,
+		})
+	case delivery.Delivered:
+		pipe.ZRem(ctx, webhookScheduleKey, delivery.ID)
+		pipe.Del(ctx, GetWebhookSecretKey(delivery.ID))
+		pipe.ZRem(ctx, failedKey, delivery.ID)
+	default:
+		pipe.ZRem(ctx, webhookScheduleKey, delivery.ID)
+		pipe.Del(ctx, GetWebhookSecretKey(delivery.ID))
+		pipe.ZAdd(ctx, failedKey, redis.Z{
+			Score:  float64(delivery.UpdatedAt.Unix()),
+			Member: delivery.ID,
+		})
+		pipe.Expire(ctx, failedKey, retention)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to save webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// stores a new webhook delivery with the secret signing it and schedules its
+// first attempt. the secret is kept until the delivery finishes
+func (c *RedisCache) QueueWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery, secret string, retention time.Duration) error {
+	if delivery.NextAttemptAt == nil {
+		return fmt.Errorf("failed to queue webhook delivery: no attempt scheduled")
+	}
+
+	payload, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook delivery: %w", err)
+	}
+
+	pipe := c.client.TxPipeline()
+	pipe.Set(ctx, GetWebhookDeliveryKey(delivery.ID), payload, retention)
+	if secret != "" {
+		pipe.Set(ctx, GetWebhookSecretKey(delivery.ID), secret, retention)
+	}
+	pipe.ZAdd(ctx, webhookScheduleKey, redis.Z{
+		Score:  float64(delivery.NextAttemptAt.Unix()),
+		Member: delivery.ID,
+	})
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to queue webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// claims webhook deliveries due for an attempt until the lease ends. a
+// delivery is only returned to one replica per lease
+func (c *RedisCache) ClaimDueWebhooks(ctx context.Context, now time.Time, limit int64, lease time.Duration) ([]string, error) {
+	ids, err := claimWebhooksScript.Run(ctx, c.client, []string{webhookScheduleKey},
+		now.Unix(), limit, now.Add(lease).Unix()).StringSlice()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to claim webhooks: %w", err)
+	}
+	return ids, nil
+}
+
+// returns the secret signing a pending webhook delivery, empty when unsigned
+func (c *RedisCache) GetWebhookSecret(ctx context.Context, deliveryID string) (string, error) {
+	secret, err := c.client.Get(ctx, GetWebhookSecretKey(deliveryID)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get webhook secret: %w", err)
+	}
+	return secret, nil
+}
+
+// removes a delivery from the schedule, used when its document expired
+func (c *RedisCache) UnscheduleWebhook(ctx context.Context, deliveryID string) error {
+	if err := c.client.ZRem(ctx, webhookScheduleKey, deliveryID).Err(); err != nil {
+		return fmt.Errorf("failed to unschedule webhook: %w", err)
+	}
+	return nil
+}
+
+// returns a webhook delivery, ErrWebhookNotFound when it is unknown or expired
+func (c *RedisCache) GetWebhookDelivery(ctx context.Context, deliveryID string) (*models.WebhookDelivery, error) {
+	payload, err := c.client.Get(ctx, GetWebhookDeliveryKey(deliveryID)).Result()
+	if err == redis.Nil {
+		return nil, models.ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook delivery: %w", err)
+	}
+
+	delivery := &models.WebhookDelivery{}
+	if err := json.Unmarshal([]byte(payload), delivery); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal webhook delivery: %w", err)
+	}
+	return delivery, nil
+}
+
+// returns up to limit failed webhook deliveries, most recent first. index
+// entries whose delivery expired are cleaned up
+func (c *RedisCache) GetFailedWebhookDeliveries(ctx context.Context, failedKey string, limit int64) ([]*models.WebhookDelivery, error) {
+	ids, err := c.client.ZRevRange(ctx, failedKey, 0, limit-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read failed webhooks: %w", err)
+	}
+
+	deliveries := make([]*models.WebhookDelivery, 0, len(ids))
+	for _, id := range ids {
+		delivery, err := c.GetWebhookDelivery(ctx, id)
+		if err == models.ErrWebhookNotFound {
+			c.client.ZRem(ctx, failedKey, id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
@@ -0,0 +1,114 @@
+package campaign
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+// returns whether a registry device of a tenant belongs to the audience.
+// invalid devices and devices of other tenants never match
+func Matches(tenantID string, audience models.CampaignAudience, device *models.Device, now time.Time) bool {
+	if device.Invalid || device.TenantID != tenantID {
+		return false
+	}
+	if audience.Platform != "" && device.Platform != audience.Platform {
+		return false
+	}
+	if audience.AppID != "" && device.AppID != audience.AppID {
+		return false
+	}
+	if audience.ActiveDays > 0 && device.LastSeen.Before(now.AddDate(0, 0, -audience.ActiveDays)) {
+		return false
+	}
+	if audience.Locale != "" && !matchesLocale(device.Locale, audience.Locale) {
+		return false
+	}
+	if audience.MinAppVersion != "" && (device.AppVersion == "" || CompareVersions(device.AppVersion, audience.MinAppVersion) < 0) {
+		return false
+	}
+	if audience.MaxAppVersion != "" && (device.AppVersion == "" || CompareVersions(device.AppVersion, audience.MaxAppVersion) >= 0) {
+		return false
+	}
+	return true
+}
+
+// a language matches its regional locales, "pt" matches "pt-BR" and "pt_BR"
+func matchesLocale(locale, target string) bool {
+	locale = strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	target = strings.ToLower(strings.ReplaceAll(target, "_", "-"))
+	return locale == target || strings.HasPrefix(locale, target+"-")
+}
+
+// compares dot separated versions part by part, numerically where both parts
+// are numbers. missing parts count as 0, so "5.2" equals "5.2.0"
+func CompareVersions(a, b string) int {
+	left := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	right := strings.Split(strings.TrimPrefix(b, "v"), ".")
+
+	for i := 0; i < len(left) || i < len(right); i++ {
+		l, r := "0", "0"
+		if i < len(left) {
+			l = left[i]
+		}
+		if i < len(right) {
+			r = right[i]
+		}
+
+		ln, lerr := strconv.Atoi(l)
+		rn, rerr := strconv.Atoi(r)
+		switch {
+		case lerr == nil && rerr == nil:
+			if ln != rn {
+				if ln < rn {
+					return -1
+				}
+				return 1
+			}
+		case l != r:
+			if l < r {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// reads an uploaded token file: one token per line, or csv with the token in
+// the first column. blank lines, "#" comments, a "token" header and repeated
+// tokens are skipped
+func ParseTokens(r io.Reader, maxTokens int) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024)
+
+	seen := make(map[string]bool)
+	tokens := []string{}
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		token := strings.Trim(strings.TrimSpace(strings.SplitN(line, ",", 2)[0]), `"`)
+		if token == "" || strings.EqualFold(token, "token") || seen[token] {
+			continue
+		}
+
+		seen[token] = true
+		tokens = append(tokens, token)
+		if len(tokens) > maxTokens {
+			return nil, fmt.Errorf("token file exceeds %d tokens", maxTokens)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+
+	return tokens, nil
+}
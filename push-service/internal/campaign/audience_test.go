@@ -0,0 +1,118 @@
+package campaign
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"5.2.0", "5.2", 0},
+		{"5.10", "5.9", 1},
+		{"v1.0.0", "1.0.1", -1},
+		{"2.0.0-beta", "2.0.0-alpha", 1},
+		{"3", "3.0.0", 0},
+	}
+
+	for _, c := range cases {
+		if got := CompareVersions(c.a, c.b); got != c.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	now := time.Now()
+	device := &models.Device{
+		Token:      "token-1",
+		Platform:   "android",
+		AppID:      "shop",
+		AppVersion: "5.4.1",
+		Locale:     "pt_BR",
+		LastSeen:   now.AddDate(0, 0, -3),
+	}
+
+	cases := []struct {
+		name     string
+		audience models.CampaignAudience
+		want     bool
+	}{
+		{"empty audience", models.CampaignAudience{}, true},
+		{"platform", models.CampaignAudience{Platform: "android"}, true},
+		{"other platform", models.CampaignAudience{Platform: "ios"}, false},
+		{"other app", models.CampaignAudience{AppID: "news"}, false},
+		{"language of regional locale", models.CampaignAudience{Locale: "pt"}, true},
+		{"other language", models.CampaignAudience{Locale: "en"}, false},
+		{"version range", models.CampaignAudience{MinAppVersion: "5.2", MaxAppVersion: "6.0"}, true},
+		{"below min version", models.CampaignAudience{MinAppVersion: "5.10"}, false},
+		{"max version is exclusive", models.CampaignAudience{MaxAppVersion: "5.4.1"}, false},
+		{"recently active", models.CampaignAudience{ActiveDays: 7}, true},
+		{"inactive", models.CampaignAudience{ActiveDays: 2}, false},
+	}
+
+	for _, c := range cases {
+		if got := Matches("", c.audience, device, now); got != c.want {
+			t.Errorf("%s: Matches = %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	if Matches("acme", models.CampaignAudience{}, device, now) {
+		t.Error("expected a device of another tenant not to match")
+	}
+	device.TenantID = "acme"
+	if !Matches("acme", models.CampaignAudience{}, device, now) {
+		t.Error("expected a device of the campaign's tenant to match")
+	}
+
+	device.Invalid = true
+	if Matches("acme", models.CampaignAudience{}, device, now) {
+		t.Error("expected an invalid device not to match")
+	}
+}
+
+func TestParseTokens(t *testing.T) {
+	file := "token,user\n# exported tokens\n\"tok-a\",u1\n\ntok-b\ntok-a\n  tok-c  \n"
+
+	tokens, err := ParseTokens(strings.NewReader(file), 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{"tok-a", "tok-b", "tok-c"}
+	if strings.Join(tokens, ",") != strings.Join(want, ",") {
+		t.Errorf("expected tokens %v, got %v", want, tokens)
+	}
+
+	if _, err := ParseTokens(strings.NewReader(file), 2); err == nil {
+		t.Error("expected an error when the file exceeds the token limit")
+	}
+}
+
+func TestTransition(t *testing.T) {
+	campaign := &models.Campaign{State: models.CampaignStateRunning}
+
+	if err := Transition(campaign, "resume"); err == nil {
+		t.Error("expected resuming a running campaign to fail")
+	}
+	if err := Transition(campaign, "pause"); err != nil || campaign.State != models.CampaignStatePaused {
+		t.Fatalf("expected paused, got %s (%v)", campaign.State, err)
+	}
+	if err := Transition(campaign, "resume"); err != nil || campaign.State != models.CampaignStateRunning {
+		t.Fatalf("expected running, got %s (%v)", campaign.State, err)
+	}
+	if err := Transition(campaign, "cancel"); err != nil || campaign.State != models.CampaignStateCancelled {
+		t.Fatalf("expected cancelled, got %s (%v)", campaign.State, err)
+	}
+	if campaign.FinishedAt == nil {
+		t.Error("expected a cancelled campaign to have a finish time")
+	}
+	if err := Transition(campaign, "cancel"); err == nil {
+		t.Error("expected cancelling a cancelled campaign to fail")
+	}
+}
@@ -0,0 +1,310 @@
+package campaign
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/config"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
+)
+
+// the fan-out publishes one page of rate messages per tick
+const pageInterval = time.Second
+
+// how long the broker may take to confirm a page
+const publishTimeout = 10 * time.Second
+
+// pages a device registry
+type DeviceScanner interface {
+	ScanDevices(ctx context.Context, tenantID string, cursor uint64, count int) ([]*models.Device, uint64, error)
+}
+
+// publishes child messages, returning an error per message, nil once confirmed
+type Publisher interface {
+	PublishBatch(ctx context.Context, routingKey string, messages []*models.NotificationMessage) []error
+}
+
+// Runner fans running campaigns out into child messages on the push queue.
+// each campaign is published by the replica holding its lock, one page per
+// second, and picked up by another replica when that one goes away. a page is
+// republished until every message of it is confirmed, child messages have
+// stable ids so the idempotency check drops the repeats
+type Runner struct {
+	store     Store
+	devices   DeviceScanner
+	publisher Publisher
+	config    config.CampaignConfig
+	owner     string
+
+	mutex  sync.Mutex
+	active map[string]bool
+}
+
+func NewRunner(store Store, devices DeviceScanner, publisher Publisher, cfg config.CampaignConfig) *Runner {
+	hostname, _ := os.Hostname()
+
+	return &Runner{
+		store:     store,
+		devices:   devices,
+		publisher: publisher,
+		config:    cfg,
+		owner:     fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		active:    make(map[string]bool),
+	}
+}
+
+// picks up running campaigns until the context is cancelled
+func (r *Runner) Run(ctx context.Context) {
+	interval := time.Duration(r.config.PollInterval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	logger.Info("Campaign runner started", logger.Fields{
+		"interval": interval.String(),
+		"owner":    r.owner,
+	})
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Stopping campaign runner")
+			return
+		case <-ticker.C:
+			r.pickUp(ctx)
+		}
+	}
+}
+
+func (r *Runner) pickUp(ctx context.Context) {
+	ids, err := r.store.Running(ctx)
+	if err != nil {
+		logger.Error("Failed to list running campaigns", logger.WithError(err))
+		return
+	}
+
+	for _, id := range ids {
+		r.mutex.Lock()
+		busy := r.active[id]
+		r.mutex.Unlock()
+		if busy {
+			continue
+		}
+
+		acquired, err := r.store.AcquireLock(ctx, id, r.owner, r.lockTTL())
+		if err != nil {
+			logger.Error("Failed to acquire campaign lock", logger.Merge(
+				logger.Fields{"campaign_id": id},
+				logger.WithError(err),
+			))
+			continue
+		}
+		if !acquired {
+			continue
+		}
+
+		r.mutex.Lock()
+		r.active[id] = true
+		r.mutex.Unlock()
+
+		go r.execute(ctx, id)
+	}
+}
+
+// publishes pages of a campaign until it finishes, is paused or cancelled,
+// or the lock is lost
+func (r *Runner) execute(ctx context.Context, campaignID string) {
+	fields := logger.Fields{"campaign_id": campaignID, "owner": r.owner}
+	logger.Info("Campaign fan-out started", fields)
+
+	defer func() {
+		if err := r.store.ReleaseLock(context.WithoutCancel(ctx), campaignID, r.owner); err != nil {
+			logger.Error("Failed to release campaign lock", logger.Merge(fields, logger.WithError(err)))
+		}
+		r.mutex.Lock()
+		delete(r.active, campaignID)
+		r.mutex.Unlock()
+	}()
+
+	ticker := time.NewTicker(pageInterval)
+	defer ticker.Stop()
+
+	for {
+		campaign, err := r.store.Get(ctx, campaignID)
+		if err != nil {
+			logger.Error("Failed to load campaign", logger.Merge(fields, logger.WithError(err)))
+			return
+		}
+		if campaign.State != models.CampaignStateRunning {
+			logger.Info("Campaign fan-out stopped", logger.Merge(fields, logger.Fields{"state": campaign.State}))
+			return
+		}
+
+		held, err := r.store.AcquireLock(ctx, campaignID, r.owner, r.lockTTL())
+		if err != nil || !held {
+			logger.Warn("Campaign lock lost, fan-out stopped", fields)
+			return
+		}
+
+		done, err := r.Step(ctx, campaign)
+		if err != nil {
+			// the page is retried on the next tick
+			logger.Error("Failed to publish campaign page", logger.Merge(fields, logger.WithError(err)))
+		}
+		if done {
+			r.complete(ctx, campaignID)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishes the next page of a campaign and advances its cursor, returns
+// true once the audience is exhausted
+func (r *Runner) Step(ctx context.Context, campaign *models.Campaign) (bool, error) {
+	cursor, err := r.store.Cursor(ctx, campaign.ID)
+	if err != nil {
+		return false, err
+	}
+
+	rate := campaign.Rate
+	if rate <= 0 {
+		rate = r.config.DefaultRate
+	}
+
+	var children []*models.NotificationMessage
+	var next string
+	var done bool
+
+	switch campaign.Source {
+	case models.CampaignSourceTokens:
+		offset, _ := strconv.ParseInt(cursor, 10, 64)
+		tokens, err := r.store.Tokens(ctx, campaign.ID, offset, int64(rate))
+		if err != nil {
+			return false, err
+		}
+		for _, token := range tokens {
+			children = append(children, childMessage(campaign, token, nil))
+		}
+		next = strconv.FormatInt(offset+int64(len(tokens)), 10)
+		done = len(tokens) < rate
+
+	default:
+		// an empty cursor starts the scan, "0" is where a finished scan ends
+		var scanCursor uint64
+		if cursor != "" {
+			if scanCursor, err = strconv.ParseUint(cursor, 10, 64); err != nil || scanCursor == 0 {
+				return true, nil
+			}
+		}
+		devices, nextCursor, err := r.devices.ScanDevices(ctx, campaign.TenantID, scanCursor, rate)
+		if err != nil {
+			return false, err
+		}
+		now := time.Now()
+		for _, device := range devices {
+			if Matches(campaign.TenantID, campaign.Audience, device, now) {
+				children = append(children, childMessage(campaign, device.Token, device))
+			}
+		}
+		next = strconv.FormatUint(nextCursor, 10)
+		done = nextCursor == 0
+	}
+
+	if len(children) > 0 {
+		publishCtx, cancel := context.WithTimeout(ctx, publishTimeout)
+		errs := r.publisher.PublishBatch(publishCtx, "push.queue", children)
+		cancel()
+
+		if err := errors.Join(errs...); err != nil {
+			return false, err
+		}
+	}
+
+	if err := r.store.Advance(ctx, campaign.ID, next, int64(len(children))); err != nil {
+		return false, err
+	}
+
+	return done, nil
+}
+
+// marks a campaign completed unless it was paused or cancelled meanwhile
+func (r *Runner) complete(ctx context.Context, campaignID string) {
+	campaign, err := r.store.Get(ctx, campaignID)
+	if err != nil || campaign.State != models.CampaignStateRunning {
+		return
+	}
+
+	now := time.Now()
+	campaign.State = models.CampaignStateCompleted
+	campaign.UpdatedAt = now
+	campaign.FinishedAt = &now
+
+	if err := r.store.Save(ctx, campaign); err != nil {
+		logger.Error("Failed to complete campaign", logger.Merge(
+			logger.Fields{"campaign_id": campaignID},
+			logger.WithError(err),
+		))
+		return
+	}
+
+	logger.Info("Campaign fan-out completed", logger.Fields{
+		"campaign_id": campaignID,
+		"targeted":    campaign.Progress.Targeted,
+	})
+}
+
+// the lock outlives a few pages so a slow page does not lose it
+func (r *Runner) lockTTL() time.Duration {
+	return 3*pageInterval + publishTimeout
+}
+
+// builds the child message of a campaign for one device. registry devices
+// keep their user, platform and locale, uploaded tokens get a user id derived
+// from the token so per-user limits and preferences stay per device
+func childMessage(campaign *models.Campaign, token string, device *models.Device) *models.NotificationMessage {
+	sum := sha256.Sum256([]byte(token))
+	tokenID := hex.EncodeToString(sum[:8])
+
+	msg := &models.NotificationMessage{
+		ID:               fmt.Sprintf("%s-%s", campaign.ID, tokenID),
+		NotificationType: "push",
+		UserID:           "token:" + tokenID,
+		TenantID:         campaign.TenantID,
+		AppID:            campaign.AppID,
+		TemplateCode:     campaign.TemplateCode,
+		Category:         campaign.Category,
+		DeviceTokens:     []string{token},
+		Variables:        campaign.Variables,
+		Platform:         campaign.Audience.Platform,
+		Priority:         campaign.Priority,
+		CampaignID:       campaign.ID,
+		SubmittedBy:      campaign.CreatedBy,
+		ExpiresAt:        campaign.ExpiresAt,
+		CreatedAt:        time.Now(),
+	}
+
+	if device != nil {
+		msg.UserID = device.UserID
+		msg.Platform = device.Platform
+		msg.Locale = device.Locale
+	}
+
+	return msg
+}
@@ -0,0 +1,220 @@
+package campaign
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/config"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+// keeps one campaign's cursor, targeted count and tokens in memory
+type memoryStore struct {
+	cursor   string
+	targeted int64
+	tokens   []string
+}
+
+func (s *memoryStore) Create(ctx context.Context, campaign *models.Campaign, tokens []string) error {
+	s.tokens = tokens
+	return nil
+}
+
+func (s *memoryStore) Save(ctx context.Context, campaign *models.Campaign) error { return nil }
+
+func (s *memoryStore) Get(ctx context.Context, campaignID string) (*models.Campaign, error) {
+	return nil, models.ErrCampaignNotFound
+}
+
+func (s *memoryStore) Running(ctx context.Context) ([]string, error) { return nil, nil }
+
+func (s *memoryStore) Cursor(ctx context.Context, campaignID string) (string, error) {
+	return s.cursor, nil
+}
+
+func (s *memoryStore) Advance(ctx context.Context, campaignID, cursor string, targeted int64) error {
+	s.cursor = cursor
+	s.targeted += targeted
+	return nil
+}
+
+func (s *memoryStore) Tokens(ctx context.Context, campaignID string, offset, count int64) ([]string, error) {
+	if offset >= int64(len(s.tokens)) {
+		return []string{}, nil
+	}
+	end := offset + count
+	if end > int64(len(s.tokens)) {
+		end = int64(len(s.tokens))
+	}
+	return s.tokens[offset:end], nil
+}
+
+func (s *memoryStore) RecordOutcome(ctx context.Context, campaignID, notificationID string, status models.NotificationStatusEnum) error {
+	return nil
+}
+
+func (s *memoryStore) AcquireLock(ctx context.Context, campaignID, owner string, ttl time.Duration) (bool, error) {
+	return true, nil
+}
+
+func (s *memoryStore) ReleaseLock(ctx context.Context, campaignID, owner string) error { return nil }
+
+// records published messages, failing every message while fail is set
+type recordingPublisher struct {
+	fail      bool
+	published []*models.NotificationMessage
+}
+
+func (p *recordingPublisher) PublishBatch(ctx context.Context, routingKey string, messages []*models.NotificationMessage) []error {
+	errs := make([]error, len(messages))
+	for i, msg := range messages {
+		if p.fail {
+			errs[i] = errors.New("not confirmed")
+			continue
+		}
+		p.published = append(p.published, msg)
+	}
+	return errs
+}
+
+// serves devices in pages of the requested size, cursor is the next index
+type pagedDevices struct {
+	devices []*models.Device
+}
+
+func (d *pagedDevices) ScanDevices(ctx context.Context, tenantID string, cursor uint64, count int) ([]*models.Device, uint64, error) {
+	end := int(cursor) + count
+	if end >= len(d.devices) {
+		return d.devices[cursor:], 0, nil
+	}
+	return d.devices[cursor:end], uint64(end), nil
+}
+
+func TestStepPublishesUploadedTokensInPages(t *testing.T) {
+	store := &memoryStore{tokens: []string{"tok-1", "tok-2", "tok-3", "tok-4", "tok-5"}}
+	publisher := &recordingPublisher{}
+	runner := NewRunner(store, &pagedDevices{}, publisher, config.CampaignConfig{DefaultRate: 100})
+
+	campaign := &models.Campaign{
+		ID:           "camp-1",
+		TenantID:     "tenant-1",
+		TemplateCode: "promo",
+		Source:       models.CampaignSourceTokens,
+		Rate:         2,
+	}
+
+	var pages int
+	for {
+		done, err := runner.Step(context.Background(), campaign)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		pages++
+		if done {
+			break
+		}
+	}
+
+	if pages != 3 {
+		t.Errorf("expected 3 pages, got %d", pages)
+	}
+	if len(publisher.published) != 5 || store.targeted != 5 {
+		t.Fatalf("expected 5 messages published and targeted, got %d and %d", len(publisher.published), store.targeted)
+	}
+
+	first := publisher.published[0]
+	if first.CampaignID != "camp-1" || first.TenantID != "tenant-1" || first.DeviceTokens[0] != "tok-1" {
+		t.Errorf("unexpected child message %+v", first)
+	}
+	if again := childMessage(campaign, "tok-1", nil); again.ID != first.ID {
+		t.Errorf("expected a stable child id, got %s and %s", first.ID, again.ID)
+	}
+}
+
+func TestStepRepublishesUnconfirmedPage(t *testing.T) {
+	store := &memoryStore{tokens: []string{"tok-1", "tok-2"}}
+	publisher := &recordingPublisher{fail: true}
+	runner := NewRunner(store, &pagedDevices{}, publisher, config.CampaignConfig{DefaultRate: 100})
+
+	campaign := &models.Campaign{ID: "camp-1", Source: models.CampaignSourceTokens, Rate: 10}
+
+	if _, err := runner.Step(context.Background(), campaign); err == nil {
+		t.Fatal("expected an error when the page is not confirmed")
+	}
+	if store.cursor != "" || store.targeted != 0 {
+		t.Fatalf("expected the cursor not to advance, got %q with %d targeted", store.cursor, store.targeted)
+	}
+
+	publisher.fail = false
+	done, err := runner.Step(context.Background(), campaign)
+	if err != nil || !done {
+		t.Fatalf("expected the page to be republished and finish, got done=%v err=%v", done, err)
+	}
+	if store.cursor != "2" || len(publisher.published) != 2 {
+		t.Errorf("expected cursor 2 and 2 published, got %q and %d", store.cursor, len(publisher.published))
+	}
+}
+
+func TestStepFiltersRegistryDevicesByAudience(t *testing.T) {
+	devices := &pagedDevices{}
+	for i := 0; i < 5; i++ {
+		platform := "android"
+		if i%2 == 1 {
+			platform = "ios"
+		}
+		devices.devices = append(devices.devices, &models.Device{
+			Token:    fmt.Sprintf("tok-%d", i),
+			UserID:   "user-" + strconv.Itoa(i),
+			TenantID: "tenant-1",
+			Platform: platform,
+			LastSeen: time.Now(),
+		})
+	}
+	// a device of another tenant is never targeted, even if the scan returns it
+	devices.devices = append(devices.devices, &models.Device{
+		Token:    "tok-other",
+		UserID:   "user-other",
+		TenantID: "tenant-2",
+		Platform: "android",
+		LastSeen: time.Now(),
+	})
+
+	store := &memoryStore{}
+	publisher := &recordingPublisher{}
+	runner := NewRunner(store, devices, publisher, config.CampaignConfig{DefaultRate: 100})
+
+	campaign := &models.Campaign{
+		ID:       "camp-1",
+		TenantID: "tenant-1",
+		Source:   models.CampaignSourceRegistry,
+		Audience: models.CampaignAudience{Platform: "android"},
+		Rate:     2,
+	}
+
+	for {
+		done, err := runner.Step(context.Background(), campaign)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if done {
+			break
+		}
+	}
+
+	if len(publisher.published) != 3 || store.targeted != 3 {
+		t.Fatalf("expected 3 android devices targeted, got %d published and %d targeted", len(publisher.published), store.targeted)
+	}
+	for _, msg := range publisher.published {
+		if msg.Platform != "android" || msg.UserID == "" || msg.TenantID != "tenant-1" {
+			t.Errorf("unexpected child message %+v", msg)
+		}
+	}
+
+	if done, _ := runner.Step(context.Background(), campaign); !done {
+		t.Error("expected a finished scan to stay done")
+	}
+}
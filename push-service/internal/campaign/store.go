@@ -0,0 +1,137 @@
+package campaign
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+// Store keeps campaigns, their fan-out cursor and progress counters
+type Store interface {
+	// stores a new campaign with its uploaded tokens, if any
+	Create(ctx context.Context, campaign *models.Campaign, tokens []string) error
+	// stores a campaign after a state change
+	Save(ctx context.Context, campaign *models.Campaign) error
+	// returns a campaign with its progress, ErrCampaignNotFound when unknown or expired
+	Get(ctx context.Context, campaignID string) (*models.Campaign, error)
+	// returns the ids of running campaigns
+	Running(ctx context.Context) ([]string, error)
+	// returns where the fan-out continues, empty before the first page
+	Cursor(ctx context.Context, campaignID string) (string, error)
+	// records a published page: the next cursor and the number of child messages queued
+	Advance(ctx context.Context, campaignID, cursor string, targeted int64) error
+	// returns up to count uploaded tokens starting at offset
+	Tokens(ctx context.Context, campaignID string, offset, count int64) ([]string, error)
+	// records the final status of a child message
+	RecordOutcome(ctx context.Context, campaignID, notificationID string, status models.NotificationStatusEnum) error
+
+	// acquires or renews the right to publish a campaign, one replica at a time
+	AcquireLock(ctx context.Context, campaignID, owner string, ttl time.Duration) (bool, error)
+	ReleaseLock(ctx context.Context, campaignID, owner string) error
+}
+
+// RedisStore keeps campaigns in redis, finished ones expire after the retention
+type RedisStore struct {
+	cache     *cache.RedisCache
+	retention time.Duration
+}
+
+func NewRedisStore(redisCache *cache.RedisCache, retention time.Duration) *RedisStore {
+	return &RedisStore{
+		cache:     redisCache,
+		retention: retention,
+	}
+}
+
+func (s *RedisStore) Create(ctx context.Context, campaign *models.Campaign, tokens []string) error {
+	if len(tokens) > 0 {
+		if err := s.cache.AppendCampaignTokens(ctx, campaign.ID, tokens); err != nil {
+			return err
+		}
+	}
+	return s.cache.SaveCampaign(ctx, campaign, s.retention)
+}
+
+func (s *RedisStore) Save(ctx context.Context, campaign *models.Campaign) error {
+	return s.cache.SaveCampaign(ctx, campaign, s.retention)
+}
+
+func (s *RedisStore) Get(ctx context.Context, campaignID string) (*models.Campaign, error) {
+	return s.cache.GetCampaign(ctx, campaignID)
+}
+
+func (s *RedisStore) Running(ctx context.Context) ([]string, error) {
+	return s.cache.GetRunningCampaignIDs(ctx)
+}
+
+func (s *RedisStore) Cursor(ctx context.Context, campaignID string) (string, error) {
+	return s.cache.GetCampaignCursor(ctx, campaignID)
+}
+
+func (s *RedisStore) Advance(ctx context.Context, campaignID, cursor string, targeted int64) error {
+	return s.cache.AdvanceCampaign(ctx, campaignID, cursor, targeted)
+}
+
+func (s *RedisStore) Tokens(ctx context.Context, campaignID string, offset, count int64) ([]string, error) {
+	return s.cache.GetCampaignTokens(ctx, campaignID, offset, count)
+}
+
+func (s *RedisStore) RecordOutcome(ctx context.Context, campaignID, notificationID string, status models.NotificationStatusEnum) error {
+	outcome, ok := Outcome(status)
+	if !ok {
+		return nil
+	}
+	return s.cache.RecordCampaignOutcome(ctx, campaignID, notificationID, string(outcome))
+}
+
+func (s *RedisStore) AcquireLock(ctx context.Context, campaignID, owner string, ttl time.Duration) (bool, error) {
+	return s.cache.AcquireLock(ctx, lockKey(campaignID), owner, ttl)
+}
+
+func (s *RedisStore) ReleaseLock(ctx context.Context, campaignID, owner string) error {
+	return s.cache.ReleaseLock(ctx, lockKey(campaignID), owner)
+}
+
+// returns the counter a final status is counted under: delivered for sent,
+// failed or suppressed. other statuses are not counted
+func Outcome(status models.NotificationStatusEnum) (models.NotificationStatusEnum, bool) {
+	switch status {
+	case models.NotificationStatusDelivered, models.NotificationStatusPartiallyDelivered:
+		return models.NotificationStatusDelivered, true
+	case models.NotificationStatusFailed, models.NotificationStatusExpired:
+		return models.NotificationStatusFailed, true
+	case models.NotificationStatusSuppressed:
+		return models.NotificationStatusSuppressed, true
+	}
+	return "", false
+}
+
+// applies a pause, resume or cancel action to a campaign
+func Transition(campaign *models.Campaign, action string) error {
+	next := campaign.State
+	switch {
+	case action == "pause" && campaign.State == models.CampaignStateRunning:
+		next = models.CampaignStatePaused
+	case action == "resume" && campaign.State == models.CampaignStatePaused:
+		next = models.CampaignStateRunning
+	case action == "cancel" && !campaign.Finished():
+		next = models.CampaignStateCancelled
+	default:
+		return fmt.Errorf("%w: cannot %s a %s campaign", models.ErrInvalidCampaignState, action, campaign.State)
+	}
+
+	now := time.Now()
+	campaign.State = next
+	campaign.UpdatedAt = now
+	if campaign.Finished() {
+		campaign.FinishedAt = &now
+	}
+	return nil
+}
+
+func lockKey(campaignID string) string {
+	return cache.GetLeaderLockKey("campaign:" + campaignID)
+}
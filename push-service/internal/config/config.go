@@ -4,37 +4,62 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 )
 
 // app configuration
 type Config struct {
 	Server           ServerConfig
+	Auth             AuthConfig
 	RabbitMQ         RabbitMQConfig
 	Redis            RedisConfig
 	FCM              FCMConfig
 	Circuit          CircuitBreakerConfig
 	Retry            RetryConfig
 	RateLimit        RateLimitConfig
+	Digest           DigestConfig
+	Throughput       ThroughputConfig
+	Device           DeviceConfig
+	Status           StatusConfig
+	Webhook          WebhookConfig
+	Stream           StreamConfig
+	Batch            BatchConfig
+	Idempotency      IdempotencyConfig
+	Campaign         CampaignConfig
+	Fanout           FanoutConfig
 	ExternalServices ExternalServicesConfig
 }
 
 // server configuration
 type ServerConfig struct {
-	Host string
-	Port int
+	Host           string
+	Port           int
+	AllowedOrigins string // comma separated CORS origins, "*" allows any origin without credentials
+}
+
+// HTTP API authentication, requests need an API key or a JWT when enabled
+type AuthConfig struct {
+	Enabled          bool
+	APIKeys          string // comma separated name:sha256hex:scope|scope entries
+	JWTSecret        string // verifies HS256 tokens, shared with the user-service
+	JWTPublicKeyFile string // verifies RS256 or ES256 tokens, optional
+	JWTIssuer        string // required issuer when set
+	JWTAudience      string // required audience when set
+	JWTDefaultScopes string // space separated scopes added to notifications:self for tokens without a scope claim
 }
 
 // rabbitMQ connection settings
 type RabbitMQConfig struct {
-	Host          string
-	Port          int
-	User          string
-	Password      string
-	Exchange      string
-	PushQueue     string
-	FailedQueue   string
-	StatusQueue   string
-	PrefetchCount int
+	Host           string
+	Port           int
+	User           string
+	Password       string
+	Exchange       string
+	StatusExchange string // topic exchange of status events
+	PushQueue      string
+	FailedQueue    string
+	StatusQueue    string
+	PrefetchCount  int
 }
 
 // redis connection settings
@@ -49,7 +74,8 @@ type RedisConfig struct {
 type FCMConfig struct {
 	ProjectID       string
 	CredentialsPath string
-	Timeout         int // seconds
+	Timeout         int    // seconds
+	AppsDir         string // directory of per tenant app credential configs, optional
 }
 
 // circuit breaker settings
@@ -70,31 +96,144 @@ type RetryConfig struct {
 
 // rate limiting configuration
 type RateLimitConfig struct {
-	Requests int // max requests per window
-	Window   int // window duration in seconds
+	Algorithm string // "sliding_window" or "token_bucket"
+	Requests  int    // max requests per user per window
+	Window    int    // window duration in seconds
+	Policy    string // "reject", "defer" or "drop_low_priority"
+	MaxDefers int    // max times one message can be deferred
+
+	// optional limits, disabled when requests is 0
+	TemplateRequests int // max requests per template_code per window
+	TemplateWindow   int
+	TenantRequests   int // max requests per tenant per window
+	TenantWindow     int
+}
+
+// outbound send throughput shared by all replicas, a rate of 0 disables the limit
+type ThroughputConfig struct {
+	ProviderRate int // max sends per second per provider
+	ProjectRate  int // max sends per second per FCM project
+	MaxWait      int // milliseconds a send may wait for tokens
+}
+
+// digest (notification aggregation) configuration
+type DigestConfig struct {
+	Window        int // seconds messages are buffered before a summary is sent
+	MaxItems      int // number of latest items rendered into the summary
+	FlushInterval int // seconds between flush sweeps
+}
+
+// device registry configuration
+type DeviceConfig struct {
+	ActiveDays int // devices seen within this many days receive user_id only messages, 0 for all
+
+	// hygiene sweeps, run by one leader replica
+	ExpireDays        int // devices not seen for this many days are removed, 0 disables expiry
+	ValidateAfterDays int // devices not seen for this many days are sampled for validation
+	ValidateSample    int // devices of each tenant validated per sweep, 0 disables validation
+	HygieneInterval   int // seconds between sweeps
+}
+
+// notification status store configuration
+type StatusConfig struct {
+	Retention    int  // seconds a notification status can be queried after its last update
+	DeviceEvents bool // publish a status event per device with the provider message id
+}
+
+// status webhook configuration
+type WebhookConfig struct {
+	Secret          string // signs webhooks of apps without their own secret, unsigned when empty
+	Timeout         int    // seconds per request
+	MaxAttempts     int
+	InitialInterval int  // seconds
+	MaxInterval     int  // seconds
+	Workers         int  // concurrent deliveries per replica
+	PollInterval    int  // seconds an idle worker waits before checking for due deliveries
+	Retention       int  // seconds a delivery can be retrieved
+	AllStatuses     bool // post every status, only final ones when false
+
+	CallbackHosts        string // comma separated hosts a callback_url without a tenant may name, *.example.com matches subdomains
+	AllowPrivateNetworks bool   // allow webhooks to loopback, private and link-local addresses
+}
+
+// live status stream configuration
+type StreamConfig struct {
+	MaxConnections int // concurrent stream clients per replica
+	BufferSize     int // events buffered per client, later events are dropped while it is full
+	Heartbeat      int // seconds between keep-alive comments
+}
+
+// bulk send configuration
+type BatchConfig struct {
+	MaxSize        int // notifications per batch request
+	ConfirmTimeout int // seconds to wait for the broker to confirm a batch
+}
+
+// Idempotency-Key handling of the notification endpoint
+type IdempotencyConfig struct {
+	TTL         int // seconds a response is replayed for a repeated key
+	LockTimeout int // seconds a key stays claimed by a request that did not finish
+}
+
+// campaign fan-out configuration
+type CampaignConfig struct {
+	DefaultRate  int // child messages per second when a campaign sets none
+	MaxRate      int // highest rate a campaign may set
+	MaxTokens    int // tokens per uploaded token file
+	PollInterval int // seconds between checks for running campaigns to pick up
+	Retention    int // seconds a finished campaign can be queried
+}
+
+// splitting of messages with large device token lists
+type FanoutConfig struct {
+	ChunkSize       int // device tokens per child message, larger messages are split, 0 disables splitting
+	Retention       int // seconds child results are kept for the parent status
+	PublishAttempts int // attempts to have every child confirmed by the broker
+	PublishInterval int // seconds between publish attempts
 }
 
 // external services configuration
 type ExternalServicesConfig struct {
 	TemplateServiceURL string
+	TemplateCacheTTL   int    // seconds a cached template is used before revalidation
+	TemplateLocalDir   string // directory of local fallback templates, optional
+	TemplateSyncPeriod int    // seconds between template snapshot syncs
+	TemplateRetries    int    // attempts per template service request
+	TemplateRetryDelay int    // seconds between template service attempts
+	TemplateServiceKey string // sent by the template service as X-Service-Key to invalidate the cache when auth is disabled
+	DefaultLocale      string // last locale tried when a template has no copy for the requested one
+	UserServiceURL     string // preferences are not checked when empty
+	UserServiceAPIKey  string // sent as X-Service-Key on user service requests
+	PreferencesTTL     int    // seconds user preferences are cached
 }
 
 func Load() *Config {
 	config := &Config{
 		Server: ServerConfig{
-			Host: getEnv("SERVER_HOST"),
-			Port: getEnvAsInt("SERVER_PORT"),
+			Host:           getEnv("SERVER_HOST"),
+			Port:           getEnvAsInt("SERVER_PORT"),
+			AllowedOrigins: getEnvWithDefault("CORS_ALLOWED_ORIGINS", "*"),
+		},
+		Auth: AuthConfig{
+			Enabled:          getEnvAsBoolWithDefault("AUTH_ENABLED", true),
+			APIKeys:          getEnvWithDefault("AUTH_API_KEYS", ""),
+			JWTSecret:        getEnvWithDefault("AUTH_JWT_SECRET", ""),
+			JWTPublicKeyFile: getEnvWithDefault("AUTH_JWT_PUBLIC_KEY_FILE", ""),
+			JWTIssuer:        getEnvWithDefault("AUTH_JWT_ISSUER", ""),
+			JWTAudience:      getEnvWithDefault("AUTH_JWT_AUDIENCE", ""),
+			JWTDefaultScopes: getEnvWithDefault("AUTH_JWT_DEFAULT_SCOPES", ""),
 		},
 		RabbitMQ: RabbitMQConfig{
-			Host:          getEnv("RABBITMQ_HOST"),
-			Port:          getEnvAsInt("RABBITMQ_PORT"),
-			User:          getEnv("RABBITMQ_USER"),
-			Password:      getEnv("RABBITMQ_PASS"),
-			Exchange:      getEnv("RABBITMQ_EXCHANGE"),
-			PushQueue:     getEnv("RABBITMQ_PUSH_QUEUE"),
-			FailedQueue:   getEnv("RABBITMQ_FAILED_QUEUE"),
-			StatusQueue:   getEnv("RABBITMQ_STATUS_QUEUE"),
-			PrefetchCount: getEnvAsInt("RABBITMQ_PREFETCH_COUNT"),
+			Host:           getEnv("RABBITMQ_HOST"),
+			Port:           getEnvAsInt("RABBITMQ_PORT"),
+			User:           getEnv("RABBITMQ_USER"),
+			Password:       getEnv("RABBITMQ_PASS"),
+			Exchange:       getEnv("RABBITMQ_EXCHANGE"),
+			StatusExchange: getEnvWithDefault("RABBITMQ_STATUS_EXCHANGE", "notifications.status"),
+			PushQueue:      getEnv("RABBITMQ_PUSH_QUEUE"),
+			FailedQueue:    getEnv("RABBITMQ_FAILED_QUEUE"),
+			StatusQueue:    getEnv("RABBITMQ_STATUS_QUEUE"),
+			PrefetchCount:  getEnvAsInt("RABBITMQ_PREFETCH_COUNT"),
 		},
 		Redis: RedisConfig{
 			Host:     getEnv("REDIS_HOST"),
@@ -106,6 +245,7 @@ func Load() *Config {
 			ProjectID:       getEnv("FCM_PROJECT_ID"),
 			CredentialsPath: getEnv("FCM_CREDENTIALS_FILE"),
 			Timeout:         getEnvAsInt("FCM_TIMEOUT"),
+			AppsDir:         getEnvWithDefault("FCM_APPS_DIR", ""),
 		},
 		Circuit: CircuitBreakerConfig{
 			MaxRequests:      uint32(getEnvAsInt("CIRCUIT_MAX_REQUESTS")),
@@ -120,11 +260,89 @@ func Load() *Config {
 			Multiplier:      getEnvAsFloat("RETRY_MULTIPLIER"),
 		},
 		RateLimit: RateLimitConfig{
-			Requests: getEnvAsInt("RATE_LIMIT_REQUESTS"),
-			Window:   getEnvAsInt("RATE_LIMIT_WINDOW"),
+			Algorithm:        getEnvOneOf("RATE_LIMIT_ALGORITHM", "sliding_window", "token_bucket"),
+			Requests:         getEnvAsInt("RATE_LIMIT_REQUESTS"),
+			Window:           getEnvAsInt("RATE_LIMIT_WINDOW"),
+			Policy:           getEnvOneOf("RATE_LIMIT_POLICY", "reject", "defer", "drop_low_priority"),
+			MaxDefers:        getEnvAsIntWithDefault("RATE_LIMIT_MAX_DEFERS", 3),
+			TemplateRequests: getEnvAsIntWithDefault("RATE_LIMIT_TEMPLATE_REQUESTS", 0),
+			TemplateWindow:   getEnvAsIntWithDefault("RATE_LIMIT_TEMPLATE_WINDOW", 60),
+			TenantRequests:   getEnvAsIntWithDefault("RATE_LIMIT_TENANT_REQUESTS", 0),
+			TenantWindow:     getEnvAsIntWithDefault("RATE_LIMIT_TENANT_WINDOW", 60),
+		},
+		Digest: DigestConfig{
+			Window:        getEnvAsIntWithDefault("DIGEST_WINDOW", 60),
+			MaxItems:      getEnvAsIntWithDefault("DIGEST_MAX_ITEMS", 5),
+			FlushInterval: getEnvAsIntWithDefault("DIGEST_FLUSH_INTERVAL", 1),
+		},
+		Throughput: ThroughputConfig{
+			ProviderRate: getEnvAsIntWithDefault("THROUGHPUT_PROVIDER_RATE", 0),
+			ProjectRate:  getEnvAsIntWithDefault("THROUGHPUT_PROJECT_RATE", 0),
+			MaxWait:      getEnvAsIntWithDefault("THROUGHPUT_MAX_WAIT_MS", 5000),
+		},
+		Device: DeviceConfig{
+			ActiveDays:        getEnvAsIntWithDefault("DEVICE_ACTIVE_DAYS", 30),
+			ExpireDays:        getEnvAsIntWithDefault("DEVICE_EXPIRE_DAYS", 270),
+			ValidateAfterDays: getEnvAsIntWithDefault("DEVICE_VALIDATE_AFTER_DAYS", 30),
+			ValidateSample:    getEnvAsIntWithDefault("DEVICE_VALIDATE_SAMPLE", 100),
+			HygieneInterval:   getEnvAsIntWithDefault("DEVICE_HYGIENE_INTERVAL", 3600),
+		},
+		Status: StatusConfig{
+			Retention:    getEnvAsIntWithDefault("STATUS_RETENTION", 604800),
+			DeviceEvents: getEnvAsBoolWithDefault("STATUS_DEVICE_EVENTS", false),
+		},
+		Webhook: WebhookConfig{
+			Secret:          getEnvWithDefault("WEBHOOK_SECRET", ""),
+			Timeout:         getEnvAsIntWithDefault("WEBHOOK_TIMEOUT", 10),
+			MaxAttempts:     getEnvAsIntWithDefault("WEBHOOK_MAX_ATTEMPTS", 5),
+			InitialInterval: getEnvAsIntWithDefault("WEBHOOK_INITIAL_INTERVAL", 1),
+			MaxInterval:     getEnvAsIntWithDefault("WEBHOOK_MAX_INTERVAL", 60),
+			Workers:         getEnvAsIntWithDefault("WEBHOOK_WORKERS", 4),
+			PollInterval:    getEnvAsIntWithDefault("WEBHOOK_POLL_INTERVAL", 1),
+			Retention:       getEnvAsIntWithDefault("WEBHOOK_RETENTION", 604800),
+			AllStatuses:     getEnvAsBoolWithDefault("WEBHOOK_ALL_STATUSES", false),
+
+			CallbackHosts:        getEnvWithDefault("WEBHOOK_CALLBACK_HOSTS", ""),
+			AllowPrivateNetworks: getEnvAsBoolWithDefault("WEBHOOK_ALLOW_PRIVATE_NETWORKS", false),
+		},
+		Stream: StreamConfig{
+			MaxConnections: getEnvAsIntWithDefault("STREAM_MAX_CONNECTIONS", 100),
+			BufferSize:     getEnvAsIntWithDefault("STREAM_BUFFER_SIZE", 256),
+			Heartbeat:      getEnvAsIntWithDefault("STREAM_HEARTBEAT", 15),
+		},
+		Batch: BatchConfig{
+			MaxSize:        getEnvAsIntWithDefault("BATCH_MAX_SIZE", 500),
+			ConfirmTimeout: getEnvAsIntWithDefault("BATCH_CONFIRM_TIMEOUT", 10),
+		},
+		Idempotency: IdempotencyConfig{
+			TTL:         getEnvAsIntWithDefault("IDEMPOTENCY_TTL", 86400),
+			LockTimeout: getEnvAsIntWithDefault("IDEMPOTENCY_LOCK_TIMEOUT", 60),
+		},
+		Campaign: CampaignConfig{
+			DefaultRate:  getEnvAsIntWithDefault("CAMPAIGN_DEFAULT_RATE", 100),
+			MaxRate:      getEnvAsIntWithDefault("CAMPAIGN_MAX_RATE", 1000),
+			MaxTokens:    getEnvAsIntWithDefault("CAMPAIGN_MAX_TOKENS", 1000000),
+			PollInterval: getEnvAsIntWithDefault("CAMPAIGN_POLL_INTERVAL", 5),
+			Retention:    getEnvAsIntWithDefault("CAMPAIGN_RETENTION", 2592000),
+		},
+		Fanout: FanoutConfig{
+			ChunkSize:       getEnvAsIntWithDefault("FANOUT_CHUNK_SIZE", 500),
+			Retention:       getEnvAsIntWithDefault("FANOUT_RETENTION", 86400),
+			PublishAttempts: getEnvAsIntWithDefault("FANOUT_PUBLISH_ATTEMPTS", 5),
+			PublishInterval: getEnvAsIntWithDefault("FANOUT_PUBLISH_INTERVAL", 1),
 		},
 		ExternalServices: ExternalServicesConfig{
 			TemplateServiceURL: getEnv("TEMPLATE_SERVICE_URL"),
+			TemplateCacheTTL:   getEnvAsIntWithDefault("TEMPLATE_CACHE_TTL", 300),
+			TemplateLocalDir:   getEnvWithDefault("TEMPLATE_LOCAL_DIR", ""),
+			TemplateSyncPeriod: getEnvAsIntWithDefault("TEMPLATE_SYNC_PERIOD", 60),
+			TemplateRetries:    getEnvAsIntWithDefault("TEMPLATE_RETRY_MAX_ATTEMPTS", 2),
+			TemplateRetryDelay: getEnvAsIntWithDefault("TEMPLATE_RETRY_INTERVAL", 1),
+			TemplateServiceKey: getEnvWithDefault("TEMPLATE_SERVICE_KEY", ""),
+			DefaultLocale:      getEnvWithDefault("TEMPLATE_DEFAULT_LOCALE", "en"),
+			UserServiceURL:     getEnvWithDefault("USER_SERVICE_URL", ""),
+			UserServiceAPIKey:  getEnvWithDefault("USER_SERVICE_API_KEY", ""),
+			PreferencesTTL:     getEnvAsIntWithDefault("PREFERENCES_CACHE_TTL", 60),
 		},
 	}
 
@@ -153,6 +371,27 @@ func getEnv(key string) string {
 	panic(fmt.Sprintf("%s is required", key))
 }
 
+func getEnvWithDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return defaultValue
+}
+
+// returns the value of key, the first allowed value when unset. any other
+// value is rejected so a typo cannot silently change behaviour
+func getEnvOneOf(key string, allowed ...string) string {
+	value := getEnvWithDefault(key, allowed[0])
+	for _, candidate := range allowed {
+		if value == candidate {
+			return value
+		}
+	}
+
+	panic(fmt.Sprintf("%s must be one of %s, got %q", key, strings.Join(allowed, ", "), value))
+}
+
 func getEnvAsInt(key string) int {
 	valueStr := os.Getenv(key)
 
@@ -165,6 +404,28 @@ func getEnvAsInt(key string) int {
 	return value
 }
 
+func getEnvAsIntWithDefault(key string, defaultValue int) int {
+	if os.Getenv(key) == "" {
+		return defaultValue
+	}
+
+	return getEnvAsInt(key)
+}
+
+func getEnvAsBoolWithDefault(key string, defaultValue bool) bool {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		panic(fmt.Sprintf("Bool key error: %s", err.Error()))
+	}
+
+	return value
+}
+
 func getEnvAsFloat(key string) float64 {
 	valueStr := os.Getenv(key)
 
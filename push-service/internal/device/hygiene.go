@@ -0,0 +1,259 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/config"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/id"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
+)
+
+// devices removed per expiry batch
+const expireBatch = 500
+
+// name of the leader lock shared by all replicas
+const hygieneLockName = "device-hygiene"
+
+// dry-run validates a device token with the push provider credentials of
+// its tenant app. a nil validation with an error means the token could not be checked
+type TokenValidator interface {
+	ValidateDeviceToken(ctx context.Context, tenantID, appID, deviceToken string) (*models.DeviceTokenValidation, error)
+}
+
+// Hygiene expires devices that have not been seen for a long time and
+// validates a sample of old devices of every tenant. only the replica
+// holding the leader lock runs sweeps
+type Hygiene struct {
+	registry  Registry
+	cache     *cache.RedisCache
+	validator TokenValidator
+	config    config.DeviceConfig
+	owner     string
+
+	backfilled bool // devices registered before the last seen index were indexed
+}
+
+func NewHygiene(registry Registry, redisCache *cache.RedisCache, validator TokenValidator, cfg config.DeviceConfig) *Hygiene {
+	hostname, _ := os.Hostname()
+
+	return &Hygiene{
+		registry:  registry,
+		cache:     redisCache,
+		validator: validator,
+		config:    cfg,
+		owner:     fmt.Sprintf("%s-%s", hostname, id.Generate()),
+	}
+}
+
+// runs a sweep every interval while this replica is the leader
+func (h *Hygiene) Run(ctx context.Context) {
+	interval := time.Duration(h.config.HygieneInterval) * time.Second
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lockKey := cache.GetLeaderLockKey(hygieneLockName)
+
+	logger.Info("Device hygiene started", logger.Fields{
+		"interval": interval.String(),
+		"owner":    h.owner,
+	})
+
+	for {
+		select {
+		case <-ctx.Done():
+			// let another replica take over without waiting for the lock to expire
+			if err := h.cache.ReleaseLock(context.Background(), lockKey, h.owner); err != nil {
+				logger.Error("Failed to release hygiene lock", logger.WithError(err))
+			}
+			logger.Info("Stopping device hygiene")
+			return
+		case <-ticker.C:
+			if _, err := h.sweepIfLeader(ctx, lockKey, interval); err != nil {
+				logger.Error("Device hygiene sweep failed", logger.WithError(err))
+			}
+		}
+	}
+}
+
+// sweeps when this replica holds the leader lock, returns whether it did.
+// the lock outlives one interval so the leader keeps it between sweeps
+func (h *Hygiene) sweepIfLeader(ctx context.Context, lockKey string, interval time.Duration) (bool, error) {
+	leader, err := h.cache.AcquireLock(ctx, lockKey, h.owner, 2*interval)
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire hygiene lock: %w", err)
+	}
+	if !leader {
+		logger.Debug("Skipping device hygiene, another replica is leader")
+		return false, nil
+	}
+
+	_, err = h.Sweep(ctx)
+	return true, err
+}
+
+// expires stale devices, validates a sample of old devices and stores the report
+func (h *Hygiene) Sweep(ctx context.Context) (*models.HygieneReport, error) {
+	now := time.Now()
+	report := &models.HygieneReport{
+		Leader:    h.owner,
+		StartedAt: now,
+	}
+
+	// devices registered before the index existed would never expire or be sampled
+	if !h.backfilled {
+		indexed, err := h.registry.BackfillIndex(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to backfill device index: %w", err)
+		}
+		h.backfilled = true
+		if indexed > 0 {
+			logger.Info("Indexed devices registered before the last seen index", logger.Fields{
+				"indexed": indexed,
+			})
+		}
+	}
+
+	tenants, err := h.registry.Tenants(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list device tenants: %w", err)
+	}
+
+	expireBefore := time.Time{}
+	if h.config.ExpireDays > 0 {
+		expireBefore = now.AddDate(0, 0, -h.config.ExpireDays)
+		report.ExpiredBefore = expireBefore
+	}
+
+	for _, tenantID := range tenants {
+		if err := h.sweepTenant(ctx, tenantID, now, expireBefore, report); err != nil {
+			return nil, err
+		}
+	}
+
+	report.FinishedAt = time.Now()
+
+	logger.Info("Device hygiene sweep completed", logger.Fields{
+		"expired":           report.Expired,
+		"sampled":           report.Sampled,
+		"invalidated":       report.Invalidated,
+		"validation_errors": report.ValidationErrors,
+		"duration":          report.FinishedAt.Sub(report.StartedAt).String(),
+	})
+
+	h.storeReport(ctx, report)
+
+	return report, nil
+}
+
+// expires the stale devices of one tenant and validates a sample of its old devices
+func (h *Hygiene) sweepTenant(ctx context.Context, tenantID string, now, expireBefore time.Time, report *models.HygieneReport) error {
+	if !expireBefore.IsZero() {
+		for {
+			expired, err := h.registry.ExpireDevices(ctx, tenantID, expireBefore, expireBatch)
+			report.Expired += expired
+			if err != nil {
+				return fmt.Errorf("failed to expire devices of tenant %q: %w", tenantID, err)
+			}
+			if expired < expireBatch {
+				break
+			}
+		}
+	}
+
+	if h.config.ValidateSample > 0 && h.validator != nil {
+		validateBefore := now.AddDate(0, 0, -h.config.ValidateAfterDays)
+
+		devices, err := h.registry.SampleDevices(ctx, tenantID, expireBefore, validateBefore, h.config.ValidateSample)
+		if err != nil {
+			return fmt.Errorf("failed to sample devices of tenant %q: %w", tenantID, err)
+		}
+
+		for _, device := range devices {
+			h.validate(ctx, device, report)
+		}
+	}
+
+	return nil
+}
+
+// validates one sampled device and records the outcome in the report
+func (h *Hygiene) validate(ctx context.Context, device *models.Device, report *models.HygieneReport) {
+	// invalid devices and devices checked since their last sighting are skipped
+	if device.Invalid || (device.ValidatedAt != nil && device.ValidatedAt.After(device.LastSeen)) {
+		return
+	}
+
+	report.Sampled++
+
+	checkedAt := time.Now()
+	validation, err := h.validator.ValidateDeviceToken(ctx, device.TenantID, device.AppID, device.Token)
+	if err != nil {
+		report.ValidationErrors++
+		logger.Warn("Failed to validate device token", logger.Merge(
+			logger.WithUserID(device.UserID),
+			logger.WithError(err),
+			logger.Fields{"tenant_id": device.TenantID},
+		))
+		return
+	}
+
+	if err := h.registry.RecordValidation(ctx, device.TenantID, validation, checkedAt); err != nil && !errors.Is(err, models.ErrDeviceNotFound) {
+		report.ValidationErrors++
+		logger.Error("Failed to record device validation", logger.Merge(
+			logger.WithUserID(device.UserID),
+			logger.WithError(err),
+			logger.Fields{"tenant_id": device.TenantID},
+		))
+		return
+	}
+
+	if validation.Valid {
+		report.Valid++
+		return
+	}
+
+	report.Invalidated++
+	report.InvalidDevices = append(report.InvalidDevices, models.InvalidDevice{
+		Token:    device.Token,
+		TenantID: device.TenantID,
+		UserID:   device.UserID,
+		Reason:   validation.Reason,
+	})
+}
+
+func (h *Hygiene) storeReport(ctx context.Context, report *models.HygieneReport) {
+	body, err := json.Marshal(report)
+	if err != nil {
+		logger.Error("Failed to marshal hygiene report", logger.WithError(err))
+		return
+	}
+
+	if err := h.cache.Set(ctx, cache.GetDeviceHygieneReportKey(), string(body), 0); err != nil {
+		logger.Error("Failed to store hygiene report", logger.WithError(err))
+	}
+}
+
+// returns the report of the latest sweep by any replica
+func (h *Hygiene) LastReport(ctx context.Context) (*models.HygieneReport, error) {
+	body, err := h.cache.Get(ctx, cache.GetDeviceHygieneReportKey())
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.HygieneReport{}
+	if err := json.Unmarshal([]byte(body), report); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal hygiene report: %w", err)
+	}
+	return report, nil
+}
@@ -0,0 +1,224 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/config"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+// keeps devices in memory
+type fakeRegistry struct {
+	Registry
+	validations map[string]*models.DeviceTokenValidation
+}
+
+func (f *fakeRegistry) RecordValidation(ctx context.Context, tenantID string, validation *models.DeviceTokenValidation, checkedAt time.Time) error {
+	f.validations[validation.Token] = validation
+	return nil
+}
+
+// answers validations from a fixed table, unknown tokens fail to validate.
+// tokens are looked up by tenant and token
+type fakeValidator struct {
+	valid map[string]bool
+}
+
+func (f *fakeValidator) ValidateDeviceToken(ctx context.Context, tenantID, appID, token string) (*models.DeviceTokenValidation, error) {
+	valid, ok := f.valid[cache.TenantKey(tenantID, token)]
+	if !ok {
+		return nil, errors.New("provider unavailable")
+	}
+	validation := &models.DeviceTokenValidation{Token: token, Valid: valid}
+	if !valid {
+		validation.Reason = "registration token is not registered"
+	}
+	return validation, nil
+}
+
+// tests sampled devices are validated and invalid ones reported
+func TestHygieneValidate(t *testing.T) {
+	registry := &fakeRegistry{validations: make(map[string]*models.DeviceTokenValidation)}
+	hygiene := &Hygiene{
+		registry:  registry,
+		validator: &fakeValidator{valid: map[string]bool{"good": true, "dead": false}},
+	}
+
+	lastSeen := time.Now().AddDate(0, 0, -60)
+	validatedAt := lastSeen.Add(time.Hour)
+	devices := []*models.Device{
+		{Token: "good", UserID: "u1", LastSeen: lastSeen},
+		{Token: "dead", UserID: "u2", LastSeen: lastSeen},
+		{Token: "flaky", UserID: "u3", LastSeen: lastSeen},
+		{Token: "checked", UserID: "u4", LastSeen: lastSeen, ValidatedAt: &validatedAt},
+		{Token: "invalid", UserID: "u5", LastSeen: lastSeen, Invalid: true},
+	}
+
+	report := &models.HygieneReport{}
+	for _, device := range devices {
+		hygiene.validate(context.Background(), device, report)
+	}
+
+	if report.Sampled != 3 {
+		t.Errorf("Expected 3 sampled devices, got %d", report.Sampled)
+	}
+	if report.Valid != 1 || report.Invalidated != 1 || report.ValidationErrors != 1 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if len(report.InvalidDevices) != 1 || report.InvalidDevices[0].Token != "dead" {
+		t.Errorf("Expected dead device to be reported, got %v", report.InvalidDevices)
+	}
+
+	// provider errors do not mark a device invalid
+	if _, ok := registry.validations["flaky"]; ok {
+		t.Error("Expected no validation recorded for a provider error")
+	}
+	if v := registry.validations["dead"]; v == nil || v.Valid {
+		t.Errorf("Expected dead device recorded invalid, got %v", v)
+	}
+}
+
+// tests only the replica holding the leader lock sweeps
+func TestHygieneLeaderLock(t *testing.T) {
+	registry, redisCache := newTestRegistry(t)
+	ctx := context.Background()
+	lockKey := cache.GetLeaderLockKey(hygieneLockName)
+
+	leader := NewHygiene(registry, redisCache, nil, config.DeviceConfig{})
+	follower := NewHygiene(registry, redisCache, nil, config.DeviceConfig{})
+
+	if swept, err := leader.sweepIfLeader(ctx, lockKey, time.Minute); err != nil || !swept {
+		t.Fatalf("Expected the first replica to sweep, got %v, %v", swept, err)
+	}
+	if swept, err := follower.sweepIfLeader(ctx, lockKey, time.Minute); err != nil || swept {
+		t.Errorf("Expected the second replica to skip, got %v, %v", swept, err)
+	}
+	if swept, _ := leader.sweepIfLeader(ctx, lockKey, time.Minute); !swept {
+		t.Error("Expected the leader to keep its lock between sweeps")
+	}
+
+	if err := redisCache.ReleaseLock(ctx, lockKey, leader.owner); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if swept, _ := follower.sweepIfLeader(ctx, lockKey, time.Minute); !swept {
+		t.Error("Expected another replica to take over a released lock")
+	}
+}
+
+// tests stale devices are expired and old devices sampled for validation
+func TestHygieneSweep(t *testing.T) {
+	registry, redisCache := newTestRegistry(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	devices := []*models.Device{
+		{Token: "gone", UserID: "u1", LastSeen: now.AddDate(0, 0, -100)},
+		{Token: "dead", UserID: "u2", LastSeen: now.AddDate(0, 0, -40)},
+		{Token: "good", UserID: "u3", LastSeen: now.AddDate(0, 0, -40)},
+		{Token: "fresh", UserID: "u4", LastSeen: now},
+		{Token: "gone", TenantID: "acme", UserID: "u1", LastSeen: now.AddDate(0, 0, -100)},
+		{Token: "dead", TenantID: "acme", UserID: "u2", LastSeen: now.AddDate(0, 0, -40)},
+	}
+	for _, device := range devices {
+		if err := redisCache.SaveDevice(ctx, device); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	validator := &fakeValidator{valid: map[string]bool{"dead": false, "good": true, "fresh": true, "tenant:acme:dead": true}}
+	hygiene := NewHygiene(registry, redisCache, validator, config.DeviceConfig{
+		ExpireDays:        90,
+		ValidateAfterDays: 30,
+		ValidateSample:    10,
+	})
+
+	report, err := hygiene.Sweep(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if report.Expired != 2 {
+		t.Errorf("Expected 2 expired devices, got %d", report.Expired)
+	}
+	if _, err := redisCache.GetDevice(ctx, "", "gone"); !errors.Is(err, models.ErrDeviceNotFound) {
+		t.Errorf("Expected the stale device to be removed, got %v", err)
+	}
+
+	// only devices between the expiry and validation cutoffs are sampled, each
+	// validated with the credentials of its tenant
+	if report.Sampled != 3 || report.Invalidated != 1 || report.Valid != 2 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if active, _ := registry.ActiveDevices(ctx, "", "u2"); len(active) != 0 {
+		t.Errorf("Expected the invalid device to stop receiving notifications, got %v", active)
+	}
+	if devices, _ := registry.ListDevices(ctx, "acme", "u2"); len(devices) != 1 || devices[0].Invalid {
+		t.Errorf("Expected the tenant device to stay valid, got %v", devices)
+	}
+}
+
+// tests devices stored before the last seen index are indexed on the first sweep
+func TestHygieneBackfill(t *testing.T) {
+	registry, redisCache := newTestRegistry(t)
+	ctx := context.Background()
+
+	if err := redisCache.SaveDevice(ctx, &models.Device{Token: "legacy", UserID: "u1", LastSeen: time.Now().AddDate(0, 0, -100)}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := redisCache.SaveDevice(ctx, &models.Device{Token: "legacy", TenantID: "acme", UserID: "u1", LastSeen: time.Now().AddDate(0, 0, -100)}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, tenantID := range []string{"", "acme"} {
+		if err := redisCache.RemoveDeviceIndex(ctx, tenantID, "legacy"); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	report, err := NewHygiene(registry, redisCache, nil, config.DeviceConfig{ExpireDays: 90}).Sweep(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if report.Expired != 2 {
+		t.Errorf("Expected the backfilled devices of both tenants to expire, got %d", report.Expired)
+	}
+}
+
+// tests a validation does not override a device seen while it was checked
+func TestRecordValidation(t *testing.T) {
+	registry, _ := newTestRegistry(t)
+	ctx := context.Background()
+
+	checkedAt := time.Now().Add(-time.Minute)
+	if _, err := registry.Register(ctx, &models.Device{Token: "t1", UserID: "u1"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	invalid := &models.DeviceTokenValidation{Token: "t1", Valid: false, Reason: "unregistered"}
+	if err := registry.RecordValidation(ctx, "", invalid, checkedAt); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if active, _ := registry.ActiveDevices(ctx, "", "u1"); len(active) != 1 {
+		t.Errorf("Expected a device registered during the check to stay valid, got %v", active)
+	}
+
+	if err := registry.RecordValidation(ctx, "", invalid, time.Now()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if active, _ := registry.ActiveDevices(ctx, "", "u1"); len(active) != 0 {
+		t.Errorf("Expected the device to be invalid, got %v", active)
+	}
+
+	t.Run("Refresh clears invalid", func(t *testing.T) {
+		device, err := registry.Refresh(ctx, "", "t1")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if device.Invalid || device.InvalidReason != "" {
+			t.Errorf("Expected the refreshed device to be valid, got %+v", device)
+		}
+	})
+}
@@ -0,0 +1,244 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
+)
+
+// Registry stores the devices registered for each user of a tenant. the redis
+// implementation is used today, the interface leaves room for a SQL store
+type Registry interface {
+	// creates or updates a device, keyed by its tenant and token
+	Register(ctx context.Context, device *models.Device) (*models.Device, error)
+	// marks a device as seen now
+	Refresh(ctx context.Context, tenantID, token string) (*models.Device, error)
+	// removes a device
+	Unregister(ctx context.Context, tenantID, token string) error
+	// returns every device of a user, most recently seen first
+	ListDevices(ctx context.Context, tenantID, userID string) ([]*models.Device, error)
+	// returns the valid devices of a user seen within the active window
+	ActiveDevices(ctx context.Context, tenantID, userID string) ([]*models.Device, error)
+
+	// returns every tenant with registered devices, the default tenant as ""
+	Tenants(ctx context.Context) ([]string, error)
+	// removes up to limit devices of a tenant last seen before the given time, returns how many were removed
+	ExpireDevices(ctx context.Context, tenantID string, seenBefore time.Time, limit int) (int, error)
+	// returns up to n devices of a tenant last seen between from and before
+	SampleDevices(ctx context.Context, tenantID string, seenFrom, seenBefore time.Time, n int) ([]*models.Device, error)
+	// stores the result of a token validation checked at the given time, invalid devices
+	// stop receiving notifications. devices seen since the check are left as they are
+	RecordValidation(ctx context.Context, tenantID string, validation *models.DeviceTokenValidation, checkedAt time.Time) error
+	// returns a page of about count devices of a tenant and the cursor of the next page, 0 after the last
+	ScanDevices(ctx context.Context, tenantID string, cursor uint64, count int) ([]*models.Device, uint64, error)
+	// indexes devices registered before the last seen index existed, returns how many were added
+	BackfillIndex(ctx context.Context) (int, error)
+}
+
+// RedisRegistry keeps devices in redis, one key per token plus a sorted set
+// of tokens per user scored by last seen, all namespaced by tenant
+type RedisRegistry struct {
+	cache        *cache.RedisCache
+	activeWindow time.Duration
+}
+
+func NewRedisRegistry(redisCache *cache.RedisCache, activeWindow time.Duration) *RedisRegistry {
+	return &RedisRegistry{
+		cache:        redisCache,
+		activeWindow: activeWindow,
+	}
+}
+
+func (r *RedisRegistry) Register(ctx context.Context, device *models.Device) (*models.Device, error) {
+	// a device registered before keeps its creation time, see SaveDevice
+	now := time.Now()
+	device.CreatedAt = now
+	device.LastSeen = now
+
+	if err := r.cache.SaveDevice(ctx, device); err != nil {
+		return nil, err
+	}
+
+	logger.Info("Device registered", logger.Merge(
+		logger.WithUserID(device.UserID),
+		logger.Fields{
+			"tenant_id":   device.TenantID,
+			"platform":    device.Platform,
+			"app_id":      device.AppID,
+			"app_version": device.AppVersion,
+		},
+	))
+
+	return device, nil
+}
+
+// a refreshed token was just handed out by the provider, so an earlier
+// invalid validation no longer applies
+func (r *RedisRegistry) Refresh(ctx context.Context, tenantID, token string) (*models.Device, error) {
+	return r.cache.UpdateDevice(ctx, tenantID, token, func(device *models.Device) bool {
+		device.LastSeen = time.Now()
+		device.Invalid = false
+		device.InvalidReason = ""
+		return true
+	})
+}
+
+func (r *RedisRegistry) Unregister(ctx context.Context, tenantID, token string) error {
+	device, err := r.cache.GetDevice(ctx, tenantID, token)
+	if err != nil {
+		return err
+	}
+
+	if err := r.cache.DeleteDevice(ctx, device); err != nil {
+		return err
+	}
+
+	logger.Info("Device unregistered", logger.Merge(
+		logger.WithUserID(device.UserID),
+		logger.Fields{"platform": device.Platform},
+	))
+
+	return nil
+}
+
+func (r *RedisRegistry) ListDevices(ctx context.Context, tenantID, userID string) ([]*models.Device, error) {
+	return r.cache.GetUserDevices(ctx, tenantID, userID, time.Time{})
+}
+
+func (r *RedisRegistry) ActiveDevices(ctx context.Context, tenantID, userID string) ([]*models.Device, error) {
+	since := time.Time{}
+	if r.activeWindow > 0 {
+		since = time.Now().Add(-r.activeWindow)
+	}
+
+	devices, err := r.cache.GetUserDevices(ctx, tenantID, userID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list active devices: %w", err)
+	}
+
+	active := make([]*models.Device, 0, len(devices))
+	for _, device := range devices {
+		if !device.Invalid {
+			active = append(active, device)
+		}
+	}
+	return active, nil
+}
+
+func (r *RedisRegistry) Tenants(ctx context.Context) ([]string, error) {
+	return r.cache.GetDeviceTenants(ctx)
+}
+
+func (r *RedisRegistry) ExpireDevices(ctx context.Context, tenantID string, seenBefore time.Time, limit int) (int, error) {
+	tokens, err := r.cache.GetStaleDeviceTokens(ctx, tenantID, seenBefore, int64(limit))
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, token := range tokens {
+		device, err := r.cache.GetDevice(ctx, tenantID, token)
+		if errors.Is(err, models.ErrDeviceNotFound) {
+			if err := r.cache.RemoveDeviceIndex(ctx, tenantID, token); err != nil {
+				return expired, err
+			}
+			continue
+		}
+		if err != nil {
+			return expired, err
+		}
+
+		// refreshed since the index was read
+		if !device.LastSeen.Before(seenBefore) {
+			continue
+		}
+
+		if err := r.cache.DeleteDevice(ctx, device); err != nil {
+			return expired, err
+		}
+		expired++
+	}
+
+	return expired, nil
+}
+
+func (r *RedisRegistry) SampleDevices(ctx context.Context, tenantID string, seenFrom, seenBefore time.Time, n int) ([]*models.Device, error) {
+	tokens, err := r.cache.SampleDeviceTokens(ctx, tenantID, seenFrom, seenBefore, int64(n))
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]*models.Device, 0, len(tokens))
+	for _, token := range tokens {
+		device, err := r.cache.GetDevice(ctx, tenantID, token)
+		if errors.Is(err, models.ErrDeviceNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+
+	return devices, nil
+}
+
+func (r *RedisRegistry) RecordValidation(ctx context.Context, tenantID string, validation *models.DeviceTokenValidation, checkedAt time.Time) error {
+	_, err := r.cache.UpdateDevice(ctx, tenantID, validation.Token, func(device *models.Device) bool {
+		// registered or refreshed while the token was being checked
+		if device.LastSeen.After(checkedAt) {
+			return false
+		}
+
+		device.ValidatedAt = &checkedAt
+		device.Invalid = !validation.Valid
+		device.InvalidReason = validation.Reason
+		return true
+	})
+	return err
+}
+
+func (r *RedisRegistry) ScanDevices(ctx context.Context, tenantID string, cursor uint64, count int) ([]*models.Device, uint64, error) {
+	tokens, next, err := r.cache.ScanDeviceTokens(ctx, tenantID, cursor, int64(count))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	devices := make([]*models.Device, 0, len(tokens))
+	for _, token := range tokens {
+		device, err := r.cache.GetDevice(ctx, tenantID, token)
+		if errors.Is(err, models.ErrDeviceNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		devices = append(devices, device)
+	}
+
+	return devices, next, nil
+}
+
+// devices read per backfill batch
+const backfillBatch = 500
+
+func (r *RedisRegistry) BackfillIndex(ctx context.Context) (int, error) {
+	return r.cache.BackfillDeviceIndex(ctx, backfillBatch)
+}
+
+// returns the tokens of devices, optionally only those of one platform
+func Tokens(devices []*models.Device, platform string) []string {
+	tokens := make([]string, 0, len(devices))
+	for _, device := range devices {
+		if platform != "" && device.Platform != platform {
+			continue
+		}
+		tokens = append(tokens, device.Token)
+	}
+	return tokens
+}
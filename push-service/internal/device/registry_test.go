@@ -0,0 +1,138 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+func newTestRegistry(t *testing.T) (*RedisRegistry, *cache.RedisCache) {
+	t.Helper()
+
+	redisCache, err := cache.NewRedisCache(miniredis.RunT(t).Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("failed to connect to miniredis: %v", err)
+	}
+	t.Cleanup(func() { redisCache.Close() })
+
+	return NewRedisRegistry(redisCache, 24*time.Hour), redisCache
+}
+
+// tests device tokens are selected by platform
+func TestTokens(t *testing.T) {
+	devices := []*models.Device{
+		{Token: "a", Platform: models.PlatformIOS},
+		{Token: "b", Platform: models.PlatformAndroid},
+		{Token: "c", Platform: models.PlatformIOS},
+	}
+
+	testCases := []struct {
+		platform string
+		expected []string
+	}{
+		{"", []string{"a", "b", "c"}},
+		{models.PlatformIOS, []string{"a", "c"}},
+		{models.PlatformWeb, []string{}},
+	}
+
+	for _, tc := range testCases {
+		if got := Tokens(devices, tc.platform); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("Expected %v for platform %q, got %v", tc.expected, tc.platform, got)
+		}
+	}
+}
+
+// tests devices are registered, refreshed and unregistered with their indexes
+func TestRedisRegistry(t *testing.T) {
+	registry, _ := newTestRegistry(t)
+	ctx := context.Background()
+
+	first, err := registry.Register(ctx, &models.Device{Token: "t1", UserID: "u1", Platform: models.PlatformIOS})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	createdAt := first.CreatedAt
+
+	t.Run("Register", func(t *testing.T) {
+		devices, err := registry.ListDevices(ctx, "", "u1")
+		if err != nil || len(devices) != 1 || devices[0].Token != "t1" {
+			t.Fatalf("Expected the device to be listed for its user, got %v, %v", devices, err)
+		}
+	})
+
+	t.Run("Register moves the token to another user", func(t *testing.T) {
+		moved, err := registry.Register(ctx, &models.Device{Token: "t1", UserID: "u2", Platform: models.PlatformIOS})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !moved.CreatedAt.Equal(createdAt) {
+			t.Errorf("Expected creation time %v to be kept, got %v", createdAt, moved.CreatedAt)
+		}
+
+		if devices, _ := registry.ListDevices(ctx, "", "u1"); len(devices) != 0 {
+			t.Errorf("Expected the previous user to lose the device, got %v", devices)
+		}
+		if devices, _ := registry.ListDevices(ctx, "", "u2"); len(devices) != 1 {
+			t.Errorf("Expected the new user to own the device, got %v", devices)
+		}
+	})
+
+	t.Run("Tenants are isolated", func(t *testing.T) {
+		if _, err := registry.Register(ctx, &models.Device{Token: "t1", TenantID: "acme", UserID: "u2", Platform: models.PlatformAndroid}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		devices, _ := registry.ListDevices(ctx, "", "u2")
+		if len(devices) != 1 || devices[0].Platform != models.PlatformIOS {
+			t.Errorf("Expected the default tenant device to be unchanged, got %v", devices)
+		}
+		devices, _ = registry.ListDevices(ctx, "acme", "u2")
+		if len(devices) != 1 || devices[0].TenantID != "acme" {
+			t.Errorf("Expected the tenant device to be listed for its tenant, got %v", devices)
+		}
+
+		if tenants, _ := registry.Tenants(ctx); len(tenants) != 2 {
+			t.Errorf("Expected both tenants to be indexed, got %v", tenants)
+		}
+		if err := registry.Unregister(ctx, "acme", "t1"); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		before := time.Now().Add(-time.Second)
+		refreshed, err := registry.Refresh(ctx, "", "t1")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if refreshed.LastSeen.Before(before) {
+			t.Errorf("Expected last seen to be updated, got %v", refreshed.LastSeen)
+		}
+
+		if _, err := registry.Refresh(ctx, "", "missing"); !errors.Is(err, models.ErrDeviceNotFound) {
+			t.Errorf("Expected ErrDeviceNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Unregister", func(t *testing.T) {
+		if err := registry.Unregister(ctx, "", "t1"); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if devices, _ := registry.ListDevices(ctx, "", "u2"); len(devices) != 0 {
+			t.Errorf("Expected no devices after unregister, got %v", devices)
+		}
+		if err := registry.Unregister(ctx, "", "t1"); !errors.Is(err, models.ErrDeviceNotFound) {
+			t.Errorf("Expected ErrDeviceNotFound, got %v", err)
+		}
+		if devices, _, _ := registry.ScanDevices(ctx, "", 0, 10); len(devices) != 0 {
+			t.Errorf("Expected the device to leave the last seen index, got %v", devices)
+		}
+	})
+}
@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/status"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/handler"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/id"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
+)
+
+// queues many notifications in one request. every item is validated on its
+// own, valid items are published together with broker confirms, and the
+// response carries a result per item plus a batch id to query progress with
+func (h *NotificationHandler) CreateBatch(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	if len(req.Notifications) == 0 {
+		handler.RespondWithError(w, http.StatusBadRequest, "notifications is required", nil)
+		return
+	}
+	if len(req.Notifications) > h.batch.MaxSize {
+		handler.RespondWithError(w, http.StatusBadRequest,
+			fmt.Sprintf("%s of %d notifications", models.ErrBatchTooLarge.Error(), h.batch.MaxSize), nil)
+		return
+	}
+
+	tenantID, ok := resolveTenant(w, r, req.TenantID)
+	if !ok {
+		return
+	}
+	req.TenantID = tenantID
+
+	batch := &models.Batch{
+		ID:        id.Generate(),
+		TenantID:  req.TenantID,
+		Total:     len(req.Notifications),
+		CreatedAt: time.Now(),
+	}
+
+	results := make([]models.BatchItemResult, len(req.Notifications))
+	accepted := make([]*models.NotificationMessage, 0, len(req.Notifications))
+	acceptedIndex := make([]int, 0, len(req.Notifications))
+	seen := make(map[string]bool, len(req.Notifications))
+	submittedBy := callerIdentity(r)
+
+	for i := range req.Notifications {
+		item := req.Notifications[i]
+		results[i] = models.BatchItemResult{Index: i, Status: models.BatchItemRejected}
+
+		if item.RequestID == "" {
+			item.RequestID = id.Generate()
+		}
+		results[i].NotificationID = item.RequestID
+
+		if errs := h.validateBatchItem(&item, req.TenantID, seen); len(errs) > 0 {
+			results[i].Errors = errs
+			continue
+		}
+		seen[item.RequestID] = true
+
+		message := newNotificationMessage(&item)
+		message.SubmittedBy = submittedBy
+
+		if err := message.Validate(); err != nil {
+			results[i].Errors = []string{err.Error()}
+			continue
+		}
+
+		accepted = append(accepted, message)
+		acceptedIndex = append(acceptedIndex, i)
+	}
+
+	if len(accepted) > 0 {
+		// queued is published first so its sequence precedes the consumer's statuses
+		h.service.PublishQueuedAll(r.Context(), accepted)
+
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(h.batch.ConfirmTimeout)*time.Second)
+		errs := h.queue.PublishBatch(ctx, "push.queue", accepted)
+		cancel()
+
+		for j, err := range errs {
+			i := acceptedIndex[j]
+			if err != nil {
+				h.service.PublishQueueFailed(r.Context(), accepted[j], err)
+				results[i].Errors = []string{"Failed to queue notification"}
+				continue
+			}
+			results[i].Status = models.BatchItemQueued
+		}
+	}
+
+	batch.NotificationIDs = []string{}
+	for _, result := range results {
+		if result.Status == models.BatchItemQueued {
+			batch.Queued++
+			batch.NotificationIDs = append(batch.NotificationIDs, result.NotificationID)
+		} else {
+			batch.Rejected++
+		}
+	}
+
+	if err := h.statuses.RecordBatch(r.Context(), batch); err != nil {
+		logger.Error("Failed to record batch", logger.Merge(
+			logger.Fields{"batch_id": batch.ID},
+			logger.WithError(err),
+		))
+	}
+
+	logger.Info("Batch processed", logger.Fields{
+		"batch_id": batch.ID,
+		"total":    batch.Total,
+		"queued":   batch.Queued,
+		"rejected": batch.Rejected,
+	})
+
+	response := &models.BatchResponse{Batch: *batch, Results: results}
+	if batch.Queued == 0 {
+		handler.WriteJSON(w, http.StatusBadRequest, handler.ApiResponse{
+			Message: "No notifications were queued",
+			Error:   true,
+			Data:    response,
+		})
+		return
+	}
+
+	handler.RespondWithSuccessAndStatus(w, http.StatusAccepted, "Batch queued successfully", response)
+}
+
+// returns the aggregate progress of a batch's queued notifications
+func (h *NotificationHandler) GetBatch(w http.ResponseWriter, r *http.Request) {
+	batchID := mux.Vars(r)["id"]
+	tenantID, ok := resolveTenant(w, r, r.URL.Query().Get("tenant_id"))
+	if !ok {
+		return
+	}
+
+	batch, err := h.statuses.GetBatch(r.Context(), tenantID, batchID)
+	if errors.Is(err, models.ErrBatchNotFound) {
+		handler.RespondWithError(w, http.StatusNotFound, "Batch not found", nil)
+		return
+	}
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, "Failed to get batch", err)
+		return
+	}
+
+	statuses, err := h.statuses.GetMany(r.Context(), tenantID, batch.NotificationIDs)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, "Failed to get batch statuses", err)
+		return
+	}
+
+	handler.RespondWithSuccess(w, "Batch progress retrieved successfully", status.Progress(batch, statuses))
+}
+
+// returns the reasons a batch item is rejected before publishing
+func (h *NotificationHandler) validateBatchItem(item *models.CreateNotificationRequest, tenantID string, seen map[string]bool) []string {
+	if item.TenantID != "" && item.TenantID != tenantID {
+		return []string{"tenant_id must match the batch tenant_id"}
+	}
+	item.TenantID = tenantID
+
+	if seen[item.RequestID] {
+		return []string{"request_id is repeated in the batch"}
+	}
+
+	if err := h.validator.Struct(item); err != nil {
+		var validationErrors validator.ValidationErrors
+		if !errors.As(err, &validationErrors) {
+			return []string{err.Error()}
+		}
+
+		messages := make([]string, 0, len(validationErrors))
+		for _, fieldErr := range validationErrors {
+			messages = append(messages, handler.GetValidationErrorMessage(fieldErr))
+		}
+		return messages
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/campaign"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/config"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/handler"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/id"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
+)
+
+// memory used for a multipart campaign upload, larger token files spill to disk
+const maxCampaignUploadMemory = 32 << 20
+
+var campaignActionPastTense = map[string]string{
+	"pause":  "paused",
+	"resume": "resumed",
+	"cancel": "cancelled",
+}
+
+type CampaignHandler struct {
+	store     campaign.Store
+	config    config.CampaignConfig
+	validator *validator.Validate
+}
+
+func NewCampaignHandler(store campaign.Store, cfg config.CampaignConfig) *CampaignHandler {
+	return &CampaignHandler{
+		store:     store,
+		config:    cfg,
+		validator: validator.New(),
+	}
+}
+
+// creates a campaign and starts its fan-out. a json body targets the devices
+// of the registry matching the audience, a multipart body with a "campaign"
+// json field and a "tokens" file targets the uploaded tokens
+func (h *CampaignHandler) CreateCampaign(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateCampaignRequest
+	var tokens []string
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(maxCampaignUploadMemory); err != nil {
+			handler.RespondWithError(w, http.StatusBadRequest, "Invalid multipart body", err)
+			return
+		}
+		if err := json.Unmarshal([]byte(r.FormValue("campaign")), &req); err != nil {
+			handler.RespondWithError(w, http.StatusBadRequest, "Invalid campaign field", err)
+			return
+		}
+
+		file, _, err := r.FormFile("tokens")
+		if err != nil {
+			handler.RespondWithError(w, http.StatusBadRequest, "tokens file is required", nil)
+			return
+		}
+		defer file.Close()
+
+		tokens, err = campaign.ParseTokens(file, h.config.MaxTokens)
+		if err != nil {
+			handler.RespondWithError(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		if len(tokens) == 0 {
+			handler.RespondWithError(w, http.StatusBadRequest, "tokens file has no tokens", nil)
+			return
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		handler.RespondWithValidationError(w, validationErrors)
+		return
+	}
+
+	tenantID, ok := resolveTenant(w, r, req.TenantID)
+	if !ok {
+		return
+	}
+
+	rate := req.Rate
+	if rate == 0 {
+		rate = h.config.DefaultRate
+	}
+	if rate > h.config.MaxRate {
+		handler.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("rate must be at most %d", h.config.MaxRate), nil)
+		return
+	}
+
+	now := time.Now()
+	created := &models.Campaign{
+		ID:           id.Generate(),
+		Name:         req.Name,
+		TenantID:     tenantID,
+		AppID:        req.AppID,
+		TemplateCode: req.TemplateCode,
+		Variables:    req.Variables,
+		Category:     req.Category,
+		Priority:     priorityToString(req.Priority),
+		Source:       models.CampaignSourceRegistry,
+		Audience:     req.Audience,
+		Rate:         rate,
+		State:        models.CampaignStateRunning,
+		ExpiresAt:    req.ExpiresAt,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		CreatedBy:    callerIdentity(r),
+	}
+	if tokens != nil {
+		created.Source = models.CampaignSourceTokens
+		created.TokenCount = len(tokens)
+	}
+
+	if err := h.store.Create(r.Context(), created, tokens); err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, "Failed to create campaign", err)
+		return
+	}
+
+	logger.Info("Campaign created", logger.Fields{
+		"campaign_id":   created.ID,
+		"source":        created.Source,
+		"template_code": created.TemplateCode,
+		"rate":          created.Rate,
+		"created_by":    created.CreatedBy,
+	})
+
+	handler.RespondWithSuccessAndStatus(w, http.StatusCreated, "Campaign created successfully", created)
+}
+
+// returns a campaign with its progress counters
+func (h *CampaignHandler) GetCampaign(w http.ResponseWriter, r *http.Request) {
+	found, ok := h.load(w, r)
+	if !ok {
+		return
+	}
+
+	handler.RespondWithSuccess(w, "Campaign retrieved successfully", found)
+}
+
+// pauses, resumes or cancels a campaign. messages already queued are still sent
+func (h *CampaignHandler) ChangeState(w http.ResponseWriter, r *http.Request) {
+	action := mux.Vars(r)["action"]
+
+	found, ok := h.load(w, r)
+	if !ok {
+		return
+	}
+
+	if err := campaign.Transition(found, action); err != nil {
+		handler.RespondWithError(w, http.StatusConflict, err.Error(), nil)
+		return
+	}
+
+	if err := h.store.Save(r.Context(), found); err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, "Failed to update campaign", err)
+		return
+	}
+
+	logger.Info("Campaign state changed", logger.Fields{
+		"campaign_id": found.ID,
+		"state":       found.State,
+	})
+
+	handler.RespondWithSuccess(w, fmt.Sprintf("Campaign %s successfully", campaignActionPastTense[action]), found)
+}
+
+// loads the campaign of the request, responding when it cannot be found.
+// campaigns of other tenants are not disclosed
+func (h *CampaignHandler) load(w http.ResponseWriter, r *http.Request) (*models.Campaign, bool) {
+	tenantID, ok := resolveTenant(w, r, r.URL.Query().Get("tenant_id"))
+	if !ok {
+		return nil, false
+	}
+
+	found, err := h.store.Get(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, models.ErrCampaignNotFound) || (err == nil && found.TenantID != tenantID) {
+		handler.RespondWithError(w, http.StatusNotFound, "Campaign not found", nil)
+		return nil, false
+	}
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, "Failed to get campaign", err)
+		return nil, false
+	}
+	return found, true
+}
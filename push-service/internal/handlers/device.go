@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/device"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/handler"
+)
+
+type DeviceHandler struct {
+	registry  device.Registry
+	hygiene   *device.Hygiene
+	validator *validator.Validate
+}
+
+func NewDeviceHandler(registry device.Registry, hygiene *device.Hygiene) *DeviceHandler {
+	return &DeviceHandler{
+		registry:  registry,
+		hygiene:   hygiene,
+		validator: validator.New(),
+	}
+}
+
+// registers a device, or updates it when the token is already registered
+func (h *DeviceHandler) RegisterDevice(w http.ResponseWriter, r *http.Request) {
+	var req models.RegisterDeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		validationErrors := err.(validator.ValidationErrors)
+		handler.RespondWithValidationError(w, validationErrors)
+		return
+	}
+
+	tenantID, ok := resolveTenant(w, r, req.TenantID)
+	if !ok {
+		return
+	}
+
+	registered, err := h.registry.Register(r.Context(), &models.Device{
+		Token:      req.Token,
+		TenantID:   tenantID,
+		UserID:     req.UserID,
+		Platform:   req.Platform,
+		AppID:      req.AppID,
+		AppVersion: req.AppVersion,
+		Locale:     req.Locale,
+	})
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, "Failed to register device", err)
+		return
+	}
+
+	handler.RespondWithSuccessAndStatus(w, http.StatusCreated, "Device registered successfully", registered)
+}
+
+// marks a device as seen now so it stays active
+func (h *DeviceHandler) RefreshDevice(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	tenantID, ok := resolveTenant(w, r, r.URL.Query().Get("tenant_id"))
+	if !ok {
+		return
+	}
+
+	refreshed, err := h.registry.Refresh(r.Context(), tenantID, token)
+	if errors.Is(err, models.ErrDeviceNotFound) {
+		handler.RespondWithError(w, http.StatusNotFound, "Device not found", nil)
+		return
+	}
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, "Failed to refresh device", err)
+		return
+	}
+
+	handler.RespondWithSuccess(w, "Device refreshed successfully", refreshed)
+}
+
+func (h *DeviceHandler) DeleteDevice(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	tenantID, ok := resolveTenant(w, r, r.URL.Query().Get("tenant_id"))
+	if !ok {
+		return
+	}
+
+	err := h.registry.Unregister(r.Context(), tenantID, token)
+	if errors.Is(err, models.ErrDeviceNotFound) {
+		handler.RespondWithError(w, http.StatusNotFound, "Device not found", nil)
+		return
+	}
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, "Failed to delete device", err)
+		return
+	}
+
+	handler.RespondWithSuccess(w, "Device deleted successfully", nil)
+}
+
+// lists the devices registered for a user
+func (h *DeviceHandler) ListDevices(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		handler.RespondWithError(w, http.StatusBadRequest, "user_id is required", nil)
+		return
+	}
+
+	tenantID, ok := resolveTenant(w, r, r.URL.Query().Get("tenant_id"))
+	if !ok {
+		return
+	}
+
+	devices, err := h.registry.ListDevices(r.Context(), tenantID, userID)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, "Failed to list devices", err)
+		return
+	}
+
+	handler.RespondWithSuccess(w, "Devices retrieved successfully", devices)
+}
+
+// returns the report of the latest device hygiene sweep
+func (h *DeviceHandler) HygieneReport(w http.ResponseWriter, r *http.Request) {
+	report, err := h.hygiene.LastReport(r.Context())
+	if errors.Is(err, models.ErrCacheMiss) {
+		handler.RespondWithError(w, http.StatusNotFound, "No hygiene sweep has run yet", nil)
+		return
+	}
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, "Failed to get hygiene report", err)
+		return
+	}
+
+	handler.RespondWithSuccess(w, "Hygiene report retrieved successfully", report)
+}
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/handler"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
+)
+
+// request header naming a retry safe request, request_id is used without it
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// response header set on a replayed response
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+const maxIdempotencyKeyLength = 255
+
+// attempts at storing a response for replays, and the wait between them
+const (
+	completeIdempotencyAttempts = 3
+	completeIdempotencyInterval = 100 * time.Millisecond
+)
+
+// a request holding the claim on its idempotency key
+type idempotentRequest struct {
+	key      string
+	bodyHash string
+}
+
+// claims the idempotency key of a request. a repeated key replays the stored
+// response, or conflicts when its body differs or the first request is still
+// in progress, and false is returned once responded. requests are let through
+// unclaimed when redis is unavailable
+func (h *NotificationHandler) claimIdempotency(w http.ResponseWriter, r *http.Request, tenantID, key string, body []byte) (*idempotentRequest, bool) {
+	if len(key) > maxIdempotencyKeyLength {
+		handler.RespondWithError(w, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters", nil)
+		return nil, false
+	}
+
+	// keys are per caller so two callers cannot collide on the same key
+	scoped := key
+	if caller := callerIdentity(r); caller != "" {
+		scoped = caller + ":" + key
+	}
+
+	sum := sha256.Sum256(body)
+	claim := &idempotentRequest{
+		key:      cache.TenantKey(tenantID, cache.GetRequestIdempotencyKey(scoped)),
+		bodyHash: hex.EncodeToString(sum[:]),
+	}
+
+	lockTimeout := time.Duration(h.idempotency.LockTimeout) * time.Second
+	record, claimed, err := h.cache.ClaimIdempotencyKey(r.Context(), claim.key, claim.bodyHash, lockTimeout)
+	if err != nil {
+		logger.Error("Failed to claim idempotency key, continuing without it", logger.Merge(
+			logger.Fields{"idempotency_key": key},
+			logger.WithError(err),
+		))
+		return nil, true
+	}
+	if claimed {
+		return claim, true
+	}
+
+	switch {
+	case record.BodyHash != claim.bodyHash:
+		handler.RespondWithError(w, http.StatusConflict, "Idempotency-Key was already used with a different request body", nil)
+	case !record.Completed():
+		handler.RespondWithError(w, http.StatusConflict, "A request with this Idempotency-Key is still in progress", nil)
+	default:
+		logger.Info("Replaying response for idempotency key", logger.Fields{
+			"idempotency_key": key,
+			"status":          record.Status,
+		})
+		w.Header().Set(IdempotentReplayedHeader, "true")
+		handler.WriteJSON(w, record.Status, record.Response)
+	}
+	return nil, false
+}
+
+// releases a claimed key after a failure, so the client can retry
+func (h *NotificationHandler) releaseIdempotency(ctx context.Context, claim *idempotentRequest) {
+	if claim == nil {
+		return
+	}
+	if err := h.cache.Delete(ctx, claim.key); err != nil {
+		logger.Error("Failed to release idempotency key", logger.WithError(err))
+	}
+}
+
+// writes a success response and stores it for replays of the claimed key.
+// the request already took effect, so the response is written even when it
+// cannot be stored. the key is released then, and a retry is queued again
+// under the same notification id, which consumers drop as already processed
+func (h *NotificationHandler) respondIdempotent(w http.ResponseWriter, ctx context.Context, claim *idempotentRequest, status int, message string, data any) {
+	payload, err := json.Marshal(handler.ApiResponse{Message: message, Data: data})
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, "Failed to encode response", err)
+		return
+	}
+
+	if claim != nil {
+		record := &cache.IdempotencyRecord{
+			BodyHash:  claim.bodyHash,
+			Status:    status,
+			Response:  payload,
+			CreatedAt: time.Now(),
+		}
+		if err := h.completeIdempotency(ctx, claim, record); err != nil {
+			logger.Error("Failed to store idempotent response, releasing the key", logger.WithError(err))
+			h.releaseIdempotency(ctx, claim)
+		}
+	}
+
+	handler.WriteJSON(w, status, json.RawMessage(payload))
+}
+
+// stores the response of a claimed key, retrying briefly
+func (h *NotificationHandler) completeIdempotency(ctx context.Context, claim *idempotentRequest, record *cache.IdempotencyRecord) error {
+	ttl := time.Duration(h.idempotency.TTL) * time.Second
+
+	var err error
+	for attempt := 1; attempt <= completeIdempotencyAttempts; attempt++ {
+		if err = h.cache.CompleteIdempotencyKey(ctx, claim.key, record, ttl); err == nil {
+			return nil
+		}
+		if attempt < completeIdempotencyAttempts {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(completeIdempotencyInterval):
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-playground/validator/v10"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/config"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/service"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/handler"
+)
+
+// records published notifications and statuses, onPublish runs inside Publish
+type fakeQueue struct {
+	published []interface{}
+	statuses  []*models.NotificationStatusMessage
+	err       error
+	onPublish func()
+}
+
+func (q *fakeQueue) Publish(ctx context.Context, queueName string, message interface{}) error {
+	if q.onPublish != nil {
+		q.onPublish()
+	}
+	if q.err != nil {
+		return q.err
+	}
+	q.published = append(q.published, message)
+	return nil
+}
+
+func (q *fakeQueue) PublishBatch(ctx context.Context, routingKey string, messages []*models.NotificationMessage) []error {
+	return make([]error, len(messages))
+}
+
+func (q *fakeQueue) PublishStatus(ctx context.Context, statusMsg *models.NotificationStatusMessage) error {
+	q.statuses = append(q.statuses, statusMsg)
+	return nil
+}
+
+func (q *fakeQueue) PublishDelayed(ctx context.Context, msg *models.NotificationMessage, delay time.Duration) error {
+	return nil
+}
+
+func (q *fakeQueue) PublishDeviceStatus(ctx context.Context, deviceMsg *models.DeviceStatusMessage) error {
+	return nil
+}
+
+func newIdempotencyHandler(t *testing.T) (*NotificationHandler, *fakeQueue, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	redisCache, err := cache.NewRedisCache(server.Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("failed to connect to miniredis: %v", err)
+	}
+	t.Cleanup(func() { redisCache.Close() })
+
+	queue := &fakeQueue{}
+	notifications := service.NewNotificationService(nil, nil, nil, config.RateLimitConfig{}, config.DigestConfig{},
+		config.StatusConfig{}, config.FanoutConfig{}, queue, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	h := &NotificationHandler{
+		service:     notifications,
+		queue:       queue,
+		cache:       redisCache,
+		idempotency: config.IdempotencyConfig{TTL: 3600, LockTimeout: 60},
+		validator:   validator.New(),
+	}
+	return h, queue, server
+}
+
+func sendNotification(h *NotificationHandler, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/notifications/", strings.NewReader(body))
+	r.Header.Set(IdempotencyKeyHeader, key)
+	w := httptest.NewRecorder()
+	h.CreateNotification(w, r)
+	return w
+}
+
+const notificationBody = `{"user_id": "u1", "device_tokens": ["tok-1"], "title": "Hi", "body": "There"}`
+
+// tests a repeated Idempotency-Key replays the first response, and conflicts
+// for a different body or while the first request is in progress
+func TestCreateNotificationIdempotency(t *testing.T) {
+	t.Run("First request claims the key", func(t *testing.T) {
+		h, queue, _ := newIdempotencyHandler(t)
+
+		w := sendNotification(h, "key-1", notificationBody)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("Expected 202, got %d: %s", w.Code, w.Body.String())
+		}
+		if w.Header().Get(IdempotentReplayedHeader) != "" {
+			t.Error("Expected the first response not to be marked replayed")
+		}
+		if len(queue.published) != 1 {
+			t.Errorf("Expected the notification to be queued once, got %d", len(queue.published))
+		}
+	})
+
+	t.Run("Replay returns the stored response", func(t *testing.T) {
+		h, queue, _ := newIdempotencyHandler(t)
+
+		first := sendNotification(h, "key-1", notificationBody)
+		replay := sendNotification(h, "key-1", notificationBody)
+
+		if replay.Code != first.Code {
+			t.Errorf("Expected the stored status %d, got %d", first.Code, replay.Code)
+		}
+		if replay.Header().Get(IdempotentReplayedHeader) != "true" {
+			t.Error("Expected the replayed response to be marked")
+		}
+		if replay.Body.String() != first.Body.String() {
+			t.Errorf("Expected the stored body %s, got %s", first.Body.String(), replay.Body.String())
+		}
+		if len(queue.published) != 1 {
+			t.Errorf("Expected a replay not to queue again, got %d", len(queue.published))
+		}
+	})
+
+	t.Run("Different body conflicts", func(t *testing.T) {
+		h, queue, _ := newIdempotencyHandler(t)
+
+		sendNotification(h, "key-1", notificationBody)
+		w := sendNotification(h, "key-1", `{"user_id": "u2", "device_tokens": ["tok-2"], "title": "Hi", "body": "There"}`)
+
+		if w.Code != http.StatusConflict {
+			t.Errorf("Expected 409, got %d", w.Code)
+		}
+		if len(queue.published) != 1 {
+			t.Errorf("Expected the conflicting request not to be queued, got %d", len(queue.published))
+		}
+	})
+
+	t.Run("Conflicts while in progress", func(t *testing.T) {
+		h, queue, _ := newIdempotencyHandler(t)
+
+		var during *httptest.ResponseRecorder
+		queue.onPublish = func() {
+			queue.onPublish = nil
+			during = sendNotification(h, "key-1", notificationBody)
+		}
+
+		if w := sendNotification(h, "key-1", notificationBody); w.Code != http.StatusAccepted {
+			t.Fatalf("Expected 202, got %d", w.Code)
+		}
+		if during == nil || during.Code != http.StatusConflict {
+			t.Fatalf("Expected 409 while the first request is in progress, got %v", during)
+		}
+
+		response := handler.ApiResponse{}
+		_ = json.Unmarshal(during.Body.Bytes(), &response)
+		if !strings.Contains(response.Message, "in progress") {
+			t.Errorf("Expected an in progress message, got %q", response.Message)
+		}
+	})
+
+	t.Run("Publish failure releases the key", func(t *testing.T) {
+		h, queue, _ := newIdempotencyHandler(t)
+
+		queue.err = errors.New("broker unavailable")
+		if w := sendNotification(h, "key-1", notificationBody); w.Code != http.StatusInternalServerError {
+			t.Fatalf("Expected 500, got %d", w.Code)
+		}
+
+		queue.err = nil
+		w := sendNotification(h, "key-1", notificationBody)
+		if w.Code != http.StatusAccepted || w.Header().Get(IdempotentReplayedHeader) != "" {
+			t.Errorf("Expected the retry to be queued, got %d", w.Code)
+		}
+		if len(queue.published) != 1 {
+			t.Errorf("Expected the retry to be queued, got %d", len(queue.published))
+		}
+	})
+
+	t.Run("Unstored response still reports the queued notification", func(t *testing.T) {
+		h, queue, server := newIdempotencyHandler(t)
+
+		// redis fails after the key is claimed
+		queue.onPublish = func() { server.SetError("connection lost") }
+
+		w := sendNotification(h, "key-1", notificationBody)
+		if w.Code != http.StatusAccepted {
+			t.Errorf("Expected 202 for a queued notification, got %d", w.Code)
+		}
+		if len(queue.published) != 1 {
+			t.Errorf("Expected the notification to be queued once, got %d", len(queue.published))
+		}
+	})
+}
@@ -1,34 +1,63 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"time"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/config"
 	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
-	"github.com/zjoart/distributed-notification-system/push-service/internal/queue"
 	"github.com/zjoart/distributed-notification-system/push-service/internal/service"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/status"
 	"github.com/zjoart/distributed-notification-system/push-service/pkg/handler"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/middleware"
 )
 
+// publishes accepted notifications to the push queue
+type NotificationPublisher interface {
+	Publish(ctx context.Context, queueName string, message interface{}) error
+	PublishBatch(ctx context.Context, routingKey string, messages []*models.NotificationMessage) []error
+}
+
 type NotificationHandler struct {
-	service   *service.NotificationService
-	queue     *queue.RabbitMQ
-	validator *validator.Validate
+	service     *service.NotificationService
+	queue       NotificationPublisher
+	statuses    status.Store
+	cache       *cache.RedisCache
+	batch       config.BatchConfig
+	idempotency config.IdempotencyConfig
+	validator   *validator.Validate
 }
 
-func NewNotificationHandler(service *service.NotificationService, queue *queue.RabbitMQ) *NotificationHandler {
+func NewNotificationHandler(service *service.NotificationService, queue NotificationPublisher, statuses status.Store, redisCache *cache.RedisCache, batch config.BatchConfig, idempotency config.IdempotencyConfig) *NotificationHandler {
 	return &NotificationHandler{
-		service:   service,
-		queue:     queue,
-		validator: validator.New(),
+		service:     service,
+		queue:       queue,
+		statuses:    statuses,
+		cache:       redisCache,
+		batch:       batch,
+		idempotency: idempotency,
+		validator:   validator.New(),
 	}
 }
 
 func (h *NotificationHandler) CreateNotification(w http.ResponseWriter, r *http.Request) {
+	// the raw body is hashed to tell a retry from a different request
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "Failed to read request body", err)
+		return
+	}
+
 	var req models.CreateNotificationRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := json.Unmarshal(body, &req); err != nil {
 		handler.RespondWithError(w, http.StatusBadRequest, "Invalid request body", err)
 		return
 	}
@@ -40,30 +69,46 @@ func (h *NotificationHandler) CreateNotification(w http.ResponseWriter, r *http.
 		return
 	}
 
-	variables := map[string]string{
-		"name": req.Variables.Name,
+	tenantID, ok := resolveTenant(w, r, req.TenantID)
+	if !ok {
+		return
 	}
-	for key, val := range req.Variables.Meta {
-		if strVal, ok := val.(string); ok {
-			variables[key] = strVal
-		}
+	req.TenantID = tenantID
+
+	// the Idempotency-Key names the notification when request_id is omitted
+	idempotencyKey := r.Header.Get(IdempotencyKeyHeader)
+	if req.RequestID == "" {
+		req.RequestID = idempotencyKey
+	}
+	if idempotencyKey == "" {
+		idempotencyKey = req.RequestID
 	}
 
-	message := &models.NotificationMessage{
-		ID:               req.RequestID,
-		NotificationType: "push",
-		UserID:           req.UserID,
-		TemplateCode:     req.TemplateCode,
-		DeviceTokens:     req.DeviceTokens,
-		Variables:        variables,
-		Platform:         req.Platform,
-		Priority:         priorityToString(req.Priority),
-		RequestID:        req.RequestID,
-		CreatedAt:        time.Now(),
+	message := newNotificationMessage(&req)
+	message.SubmittedBy = callerIdentity(r)
+
+	if err := checkSelfSend(r, message); err != nil {
+		handler.RespondWithError(w, http.StatusForbidden, err.Error(), nil)
+		return
+	}
+
+	if err := message.Validate(); err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
 	}
 
+	claim, claimed := h.claimIdempotency(w, r, message.TenantID, idempotencyKey, body)
+	if !claimed {
+		return
+	}
+
+	// queued is published first so its sequence precedes the consumer's statuses
+	h.service.PublishQueued(r.Context(), message)
+
 	// push message to queue
 	if err := h.queue.Publish(r.Context(), "push.queue", message); err != nil {
+		h.service.PublishQueueFailed(r.Context(), message, err)
+		h.releaseIdempotency(r.Context(), claim)
 		handler.RespondWithError(w, http.StatusInternalServerError, "Failed to queue notification", err)
 		return
 	}
@@ -71,15 +116,18 @@ func (h *NotificationHandler) CreateNotification(w http.ResponseWriter, r *http.
 	response := map[string]interface{}{
 		"notification_id": req.RequestID,
 		"status":          "queued",
+		"content_mode":    message.ContentMode(),
 		"message":         "Notification queued successfully",
 	}
 
-	handler.RespondWithSuccessAndStatus(w, http.StatusAccepted, "Notification queued successfully", response)
+	h.respondIdempotent(w, r.Context(), claim, http.StatusAccepted, "Notification queued successfully", response)
 }
 
 func (h *NotificationHandler) ValidateDeviceTokens(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Tokens []string `json:"tokens"`
+		Tokens   []string `json:"tokens"`
+		TenantID string   `json:"tenant_id,omitempty"`
+		AppID    string   `json:"app_id,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -92,7 +140,16 @@ func (h *NotificationHandler) ValidateDeviceTokens(w http.ResponseWriter, r *htt
 		return
 	}
 
-	validations, err := h.service.ValidateDeviceTokens(r.Context(), req.Tokens)
+	tenantID, ok := resolveTenant(w, r, req.TenantID)
+	if !ok {
+		return
+	}
+
+	validations, err := h.service.ValidateDeviceTokens(r.Context(), tenantID, req.AppID, req.Tokens)
+	if errors.Is(err, models.ErrUnknownApp) {
+		handler.RespondWithError(w, http.StatusBadRequest, "Unknown tenant app", err)
+		return
+	}
 	if err != nil {
 		handler.RespondWithError(w, http.StatusInternalServerError, "Failed to validate tokens", err)
 		return
@@ -101,6 +158,123 @@ func (h *NotificationHandler) ValidateDeviceTokens(w http.ResponseWriter, r *htt
 	handler.RespondWithSuccess(w, "Tokens validated successfully", validations)
 }
 
+// returns the current status, counts, per-device outcomes and history of a notification
+func (h *NotificationHandler) GetNotification(w http.ResponseWriter, r *http.Request) {
+	notificationID := mux.Vars(r)["id"]
+	tenantID, ok := resolveTenant(w, r, r.URL.Query().Get("tenant_id"))
+	if !ok {
+		return
+	}
+
+	notificationStatus, err := h.statuses.Get(r.Context(), tenantID, notificationID)
+	if errors.Is(err, models.ErrNotificationNotFound) {
+		handler.RespondWithError(w, http.StatusNotFound, "Notification not found", nil)
+		return
+	}
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, "Failed to get notification status", err)
+		return
+	}
+
+	handler.RespondWithSuccess(w, "Notification status retrieved successfully", notificationStatus)
+}
+
+// lists the status of every notification created for a request id
+func (h *NotificationHandler) ListNotifications(w http.ResponseWriter, r *http.Request) {
+	requestID := r.URL.Query().Get("request_id")
+	if requestID == "" {
+		handler.RespondWithError(w, http.StatusBadRequest, "request_id is required", nil)
+		return
+	}
+	tenantID, ok := resolveTenant(w, r, r.URL.Query().Get("tenant_id"))
+	if !ok {
+		return
+	}
+
+	statuses, err := h.statuses.FindByRequestID(r.Context(), tenantID, requestID)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, "Failed to list notification statuses", err)
+		return
+	}
+	if len(statuses) == 0 {
+		handler.RespondWithError(w, http.StatusNotFound, "No notifications found for request", nil)
+		return
+	}
+
+	handler.RespondWithSuccess(w, "Notification statuses retrieved successfully", statuses)
+}
+
+// builds the queue message of a create request. meta values keep their JSON
+// type so templates can use numbers, lists and objects, and without a
+// template the request content is sent as is
+func newNotificationMessage(req *models.CreateNotificationRequest) *models.NotificationMessage {
+	variables := map[string]interface{}{
+		"name": req.Variables.Name,
+	}
+	for key, val := range req.Variables.Meta {
+		variables[key] = val
+	}
+
+	var content *models.InlineContent
+	if req.TemplateCode == "" {
+		content = &models.InlineContent{
+			Title:    req.Title,
+			Body:     req.Body,
+			ImageURL: req.ImageURL,
+			Link:     req.Link,
+			Data:     req.Metadata,
+		}
+	}
+
+	return &models.NotificationMessage{
+		ID:               req.RequestID,
+		NotificationType: "push",
+		UserID:           req.UserID,
+		TenantID:         req.TenantID,
+		AppID:            req.AppID,
+		TemplateCode:     req.TemplateCode,
+		Content:          content,
+		DeviceTokens:     req.DeviceTokens,
+		Variables:        variables,
+		Platform:         req.Platform,
+		Locale:           req.Locale,
+		Priority:         priorityToString(req.Priority),
+		RequestID:        req.RequestID,
+		DigestGroup:      req.DigestGroup,
+		CallbackURL:      req.CallbackURL,
+		ExpiresAt:        req.ExpiresAt,
+		Category:         req.Category,
+		CreatedAt:        time.Now(),
+	}
+}
+
+// callers limited to their own user, such as user-service tokens, may only
+// send to the devices registered for that user and without a callback url
+func checkSelfSend(r *http.Request, msg *models.NotificationMessage) error {
+	principal, ok := middleware.PrincipalFromContext(r.Context())
+	if !ok || !principal.SelfOnly() {
+		return nil
+	}
+
+	switch {
+	case msg.UserID != principal.ID:
+		return fmt.Errorf("user_id must be the authenticated user")
+	case len(msg.DeviceTokens) > 0:
+		return fmt.Errorf("device_tokens are not allowed for user tokens, the user's registered devices are used")
+	case msg.CallbackURL != "":
+		return fmt.Errorf("callback_url is not allowed for user tokens")
+	}
+	return nil
+}
+
+// returns the identity of the authenticated caller, empty when auth is disabled
+func callerIdentity(r *http.Request) string {
+	if principal, ok := middleware.PrincipalFromContext(r.Context()); ok {
+		return principal.Identity()
+	}
+	return ""
+}
+
 func priorityToString(priority int) string {
 	if priority >= 5 {
 		return "high"
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/push"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/handler"
+)
+
+type StatsHandler struct {
+	circuitBreaker *push.CircuitBreaker
+	throttle       *push.Throttle
+	apps           *push.Registry
+}
+
+func NewStatsHandler(circuitBreaker *push.CircuitBreaker, throttle *push.Throttle, apps *push.Registry) *StatsHandler {
+	return &StatsHandler{
+		circuitBreaker: circuitBreaker,
+		throttle:       throttle,
+		apps:           apps,
+	}
+}
+
+// returns circuit breaker and outbound throughput statistics for dashboards,
+// tenant apps report their own breakers
+func (h *StatsHandler) HandleStats(w http.ResponseWriter, r *http.Request) {
+	stats := map[string]interface{}{
+		"circuit_breaker": h.circuitBreaker.GetStats(),
+		"throughput":      h.throttle.GetStats(r.Context()),
+		"tenant_apps":     h.apps.GetStats(),
+	}
+
+	handler.RespondWithSuccess(w, "Stats retrieved successfully", stats)
+}
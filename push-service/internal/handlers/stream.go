@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/stream"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/handler"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
+)
+
+type StreamHandler struct {
+	broker    *stream.Broker
+	heartbeat time.Duration
+}
+
+func NewStreamHandler(broker *stream.Broker, heartbeat time.Duration) *StreamHandler {
+	if heartbeat <= 0 {
+		heartbeat = 15 * time.Second
+	}
+
+	return &StreamHandler{
+		broker:    broker,
+		heartbeat: heartbeat,
+	}
+}
+
+// streams live status events of the caller's tenant as Server-Sent Events,
+// filtered by notification_id, user_id, template_code and a comma separated
+// status list. a "dropped" event tells a client that fell behind how many
+// events it missed
+func (h *StreamHandler) StreamStatuses(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	tenantID, ok := resolveTenant(w, r, query.Get("tenant_id"))
+	if !ok {
+		return
+	}
+
+	filter := stream.Filter{
+		TenantID:       tenantID,
+		NotificationID: query.Get("notification_id"),
+		UserID:         query.Get("user_id"),
+		TemplateCode:   query.Get("template_code"),
+		Statuses:       stream.ParseStatuses(query.Get("status")),
+	}
+
+	sub, err := h.broker.Subscribe(filter)
+	if errors.Is(err, models.ErrTooManyStreams) {
+		handler.RespondWithError(w, http.StatusServiceUnavailable, "Too many stream connections", nil)
+		return
+	}
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, "Failed to open status stream", err)
+		return
+	}
+	defer h.broker.Unsubscribe(sub)
+
+	// the stream outlives the server write timeout
+	controller := http.NewResponseController(w)
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		logger.Warn("Failed to clear stream write deadline", logger.WithError(err))
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := fmt.Fprint(w, ": connected\n\n"); err != nil || controller.Flush() != nil {
+		return
+	}
+
+	ticker := time.NewTicker(h.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case statusMsg := <-sub.Events():
+			if dropped := sub.TakeDropped(); dropped > 0 {
+				if err := writeEvent(w, "dropped", map[string]int64{"count": dropped}); err != nil {
+					return
+				}
+			}
+			if err := writeEvent(w, "status", statusMsg); err != nil {
+				return
+			}
+		}
+
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writes one Server-Sent Event with a json payload
+func writeEvent(w http.ResponseWriter, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
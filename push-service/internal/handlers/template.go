@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/template"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/handler"
+)
+
+type TemplateHandler struct {
+	templates *template.CachedClient
+}
+
+func NewTemplateHandler(templates *template.CachedClient) *TemplateHandler {
+	return &TemplateHandler{
+		templates: templates,
+	}
+}
+
+func (h *TemplateHandler) InvalidateTemplate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TemplateCodes []string `json:"template_codes"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handler.RespondWithError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	if len(req.TemplateCodes) == 0 {
+		handler.RespondWithError(w, http.StatusBadRequest, "No template codes provided", nil)
+		return
+	}
+
+	for _, code := range req.TemplateCodes {
+		if err := h.templates.Invalidate(r.Context(), code); err != nil {
+			handler.RespondWithError(w, http.StatusInternalServerError, "Failed to invalidate template", err)
+			return
+		}
+	}
+
+	handler.RespondWithSuccess(w, "Templates invalidated successfully", map[string]interface{}{
+		"template_codes": req.TemplateCodes,
+	})
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/handler"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/middleware"
+)
+
+// returns the tenant a request acts for. a caller bound to a tenant acts for
+// it and may only name that tenant, an admin without a tenant may name any
+// tenant, and other callers act for the default tenant. the tenant given by
+// the request is used as is when auth is disabled
+func requestTenant(r *http.Request, given string) (string, error) {
+	principal, ok := middleware.PrincipalFromContext(r.Context())
+	if !ok {
+		return given, nil
+	}
+
+	if principal.TenantID == "" && principal.HasScope(middleware.ScopeAdmin) {
+		return given, nil
+	}
+	if given != "" && given != principal.TenantID {
+		return "", models.ErrTenantMismatch
+	}
+	return principal.TenantID, nil
+}
+
+// resolves the tenant of a request, responding with 403 when the caller names
+// a tenant it does not act for
+func resolveTenant(w http.ResponseWriter, r *http.Request, given string) (string, bool) {
+	tenantID, err := requestTenant(r, given)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusForbidden, err.Error(), nil)
+		return "", false
+	}
+	return tenantID, true
+}
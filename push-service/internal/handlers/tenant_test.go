@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/middleware"
+)
+
+// tests the tenant of a request comes from its principal
+func TestRequestTenant(t *testing.T) {
+	tenantKey := &middleware.Principal{ID: "billing", Method: "api_key", TenantID: "acme", Scopes: []string{middleware.ScopeNotificationsSend}}
+	tenantAdmin := &middleware.Principal{ID: "ops", Method: "api_key", TenantID: "acme", Scopes: []string{middleware.ScopeAdmin}}
+	globalKey := &middleware.Principal{ID: "gateway", Method: "api_key", Scopes: []string{middleware.ScopeNotificationsSend}}
+	globalAdmin := &middleware.Principal{ID: "root", Method: "api_key", Scopes: []string{middleware.ScopeAdmin}}
+
+	testCases := []struct {
+		name      string
+		principal *middleware.Principal
+		given     string
+		expect    string
+		expectErr error
+	}{
+		{"Auth disabled", nil, "acme", "acme", nil},
+		{"Tenant key without tenant_id", tenantKey, "", "acme", nil},
+		{"Tenant key naming its tenant", tenantKey, "acme", "acme", nil},
+		{"Tenant key naming another tenant", tenantKey, "globex", "", models.ErrTenantMismatch},
+		{"Tenant admin naming another tenant", tenantAdmin, "globex", "", models.ErrTenantMismatch},
+		{"Key without tenant", globalKey, "", "", nil},
+		{"Key without tenant naming a tenant", globalKey, "acme", "", models.ErrTenantMismatch},
+		{"Admin without tenant", globalAdmin, "globex", "globex", nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/notifications", nil)
+			if tc.principal != nil {
+				r = r.WithContext(middleware.WithPrincipal(r.Context(), tc.principal))
+			}
+
+			tenantID, err := requestTenant(r, tc.given)
+			if !errors.Is(err, tc.expectErr) {
+				t.Fatalf("Expected error %v, got %v", tc.expectErr, err)
+			}
+			if tenantID != tc.expect {
+				t.Errorf("Expected tenant '%s', got '%s'", tc.expect, tenantID)
+			}
+		})
+	}
+}
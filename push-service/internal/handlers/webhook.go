@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/webhook"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/handler"
+)
+
+// failed deliveries returned when no limit is given, and at most
+const (
+	defaultFailedWebhooks = 50
+	maxFailedWebhooks     = 500
+)
+
+type WebhookHandler struct {
+	store webhook.Store
+}
+
+func NewWebhookHandler(store webhook.Store) *WebhookHandler {
+	return &WebhookHandler{
+		store: store,
+	}
+}
+
+// lists the webhook deliveries of a tenant that failed after every retry
+func (h *WebhookHandler) ListFailed(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := resolveTenant(w, r, r.URL.Query().Get("tenant_id"))
+	if !ok {
+		return
+	}
+
+	limit := int64(defaultFailedWebhooks)
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || parsed <= 0 || parsed > maxFailedWebhooks {
+			handler.RespondWithError(w, http.StatusBadRequest, "limit must be between 1 and 500", nil)
+			return
+		}
+		limit = parsed
+	}
+
+	deliveries, err := h.store.ListFailed(r.Context(), tenantID, limit)
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, "Failed to list failed webhooks", err)
+		return
+	}
+
+	handler.RespondWithSuccess(w, "Failed webhooks retrieved successfully", deliveries)
+}
+
+// returns a webhook delivery with its attempts
+func (h *WebhookHandler) GetDelivery(w http.ResponseWriter, r *http.Request) {
+	deliveryID := mux.Vars(r)["id"]
+	tenantID, ok := resolveTenant(w, r, r.URL.Query().Get("tenant_id"))
+	if !ok {
+		return
+	}
+
+	delivery, err := h.store.Get(r.Context(), deliveryID)
+	if errors.Is(err, models.ErrWebhookNotFound) {
+		handler.RespondWithError(w, http.StatusNotFound, "Webhook delivery not found", nil)
+		return
+	}
+	if err != nil {
+		handler.RespondWithError(w, http.StatusInternalServerError, "Failed to get webhook delivery", err)
+		return
+	}
+
+	// deliveries of other tenants are not disclosed
+	if delivery.TenantID != tenantID {
+		handler.RespondWithError(w, http.StatusNotFound, "Webhook delivery not found", nil)
+		return
+	}
+
+	handler.RespondWithSuccess(w, "Webhook delivery retrieved successfully", delivery)
+}
@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// outcome of one batch item
+const (
+	BatchItemQueued   = "queued"
+	BatchItemRejected = "rejected"
+)
+
+// send many notifications in one request
+type CreateBatchRequest struct {
+	TenantID      string                      `json:"tenant_id,omitempty"` // applies to every item
+	Notifications []CreateNotificationRequest `json:"notifications"`
+}
+
+// result of one batch item, in request order
+type BatchItemResult struct {
+	Index          int      `json:"index"`
+	NotificationID string   `json:"notification_id,omitempty"`
+	Status         string   `json:"status"` // "queued" or "rejected"
+	Errors         []string `json:"errors,omitempty"`
+}
+
+// a bulk send. its notifications keep their own request ids, the batch lists
+// the ids of the notifications it queued
+type Batch struct {
+	ID              string    `json:"batch_id"`
+	TenantID        string    `json:"tenant_id,omitempty"`
+	Total           int       `json:"total"`
+	Queued          int       `json:"queued"`
+	Rejected        int       `json:"rejected"`
+	NotificationIDs []string  `json:"notification_ids"` // queued notifications, in request order
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// response of a bulk send
+type BatchResponse struct {
+	Batch
+	Results []BatchItemResult `json:"results"`
+}
+
+// aggregate progress of the queued notifications of a batch
+type BatchProgress struct {
+	Batch
+	Counts    map[NotificationStatusEnum]int `json:"counts"`    // queued notifications per current status
+	Completed int                            `json:"completed"` // notifications in a final status
+	Done      bool                           `json:"done"`      // every queued notification is final
+}
@@ -0,0 +1,81 @@
+package models
+
+import "time"
+
+// lifecycle of a campaign
+type CampaignState string
+
+const (
+	CampaignStateRunning   CampaignState = "running"
+	CampaignStatePaused    CampaignState = "paused"
+	CampaignStateCancelled CampaignState = "cancelled"
+	CampaignStateCompleted CampaignState = "completed" // every targeted device was queued
+)
+
+// where a campaign's audience comes from
+const (
+	CampaignSourceRegistry = "registry" // devices of the registry matching the audience
+	CampaignSourceTokens   = "tokens"   // an uploaded token file, sent as is
+)
+
+// devices of the registry a campaign targets, empty fields match every device
+type CampaignAudience struct {
+	Platform      string `json:"platform,omitempty" validate:"omitempty,oneof=ios android web"`
+	AppID         string `json:"app_id,omitempty" validate:"max=255"`
+	MinAppVersion string `json:"min_app_version,omitempty" validate:"max=64"` // inclusive, compared numerically per dot separated part
+	MaxAppVersion string `json:"max_app_version,omitempty" validate:"max=64"` // exclusive
+	Locale        string `json:"locale,omitempty" validate:"max=35"`          // a language such as "pt" also matches "pt-BR"
+	ActiveDays    int    `json:"active_days,omitempty" validate:"min=0"`      // only devices seen within this many days
+}
+
+// create a campaign request. with a token file upload the audience filters
+// are not applied, every uploaded token is targeted
+type CreateCampaignRequest struct {
+	Name         string                 `json:"name" validate:"required,max=255"`
+	TenantID     string                 `json:"tenant_id,omitempty"`
+	AppID        string                 `json:"app_id,omitempty"`
+	TemplateCode string                 `json:"template_code" validate:"required"`
+	Variables    map[string]interface{} `json:"variables,omitempty"`
+	Category     string                 `json:"category,omitempty"`
+	Priority     int                    `json:"priority"`
+	Audience     CampaignAudience       `json:"audience"`
+	Rate         int                    `json:"rate,omitempty" validate:"min=0"` // messages per second, the configured default when 0
+	ExpiresAt    *time.Time             `json:"expires_at,omitempty"`            // children not sent by then are expired
+}
+
+// a broadcast or segment send, fanned out as one child message per device
+type Campaign struct {
+	ID           string                 `json:"id"`
+	Name         string                 `json:"name"`
+	TenantID     string                 `json:"tenant_id,omitempty"`
+	AppID        string                 `json:"app_id,omitempty"`
+	TemplateCode string                 `json:"template_code"`
+	Variables    map[string]interface{} `json:"variables,omitempty"`
+	Category     string                 `json:"category,omitempty"`
+	Priority     string                 `json:"priority"`
+	Source       string                 `json:"source"`
+	Audience     CampaignAudience       `json:"audience"`
+	TokenCount   int                    `json:"token_count,omitempty"` // uploaded tokens
+	Rate         int                    `json:"rate"`
+	State        CampaignState          `json:"state"`
+	ExpiresAt    *time.Time             `json:"expires_at,omitempty"`
+	CreatedAt    time.Time              `json:"created_at"`
+	UpdatedAt    time.Time              `json:"updated_at"`
+	FinishedAt   *time.Time             `json:"finished_at,omitempty"`
+	CreatedBy    string                 `json:"created_by,omitempty"` // authenticated API caller
+	Progress     CampaignProgress       `json:"progress"`
+}
+
+// counters of a campaign, sent, failed and suppressed follow the final status
+// of each child message
+type CampaignProgress struct {
+	Targeted   int64 `json:"targeted"` // child messages queued
+	Sent       int64 `json:"sent"`     // delivered to at least one device
+	Failed     int64 `json:"failed"`
+	Suppressed int64 `json:"suppressed"`
+}
+
+// returns whether the campaign will not publish again
+func (c *Campaign) Finished() bool {
+	return c.State == CampaignStateCancelled || c.State == CampaignStateCompleted
+}
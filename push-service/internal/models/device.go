@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+// device platforms
+const (
+	PlatformIOS     = "ios"
+	PlatformAndroid = "android"
+	PlatformWeb     = "web"
+)
+
+// a device registered to receive push notifications for a user
+type Device struct {
+	Token      string    `json:"token"`
+	TenantID   string    `json:"tenant_id,omitempty"`
+	UserID     string    `json:"user_id"`
+	Platform   string    `json:"platform"`
+	AppID      string    `json:"app_id,omitempty"`
+	AppVersion string    `json:"app_version,omitempty"`
+	Locale     string    `json:"locale,omitempty"`
+	LastSeen   time.Time `json:"last_seen"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	// set by hygiene sweeps, invalid devices no longer receive notifications
+	Invalid       bool       `json:"invalid,omitempty"`
+	InvalidReason string     `json:"invalid_reason,omitempty"`
+	ValidatedAt   *time.Time `json:"validated_at,omitempty"`
+}
+
+// register or update a device request
+type RegisterDeviceRequest struct {
+	TenantID   string `json:"tenant_id,omitempty"`
+	UserID     string `json:"user_id" validate:"required"`
+	Token      string `json:"token" validate:"required,max=4096"`
+	Platform   string `json:"platform" validate:"required,oneof=ios android web"`
+	AppID      string `json:"app_id,omitempty" validate:"max=255"`
+	AppVersion string `json:"app_version,omitempty" validate:"max=64"`
+	Locale     string `json:"locale,omitempty" validate:"max=35"`
+}
+
+// result of a device hygiene sweep
+type HygieneReport struct {
+	Leader           string          `json:"leader"`
+	StartedAt        time.Time       `json:"started_at"`
+	FinishedAt       time.Time       `json:"finished_at"`
+	ExpiredBefore    time.Time       `json:"expired_before"`
+	Expired          int             `json:"expired"`
+	Sampled          int             `json:"sampled"`
+	Valid            int             `json:"valid"`
+	Invalidated      int             `json:"invalidated"`
+	ValidationErrors int             `json:"validation_errors"`
+	InvalidDevices   []InvalidDevice `json:"invalid_devices,omitempty"`
+}
+
+// a device marked invalid by a hygiene sweep
+type InvalidDevice struct {
+	Token    string `json:"token"`
+	TenantID string `json:"tenant_id,omitempty"`
+	UserID   string `json:"user_id"`
+	Reason   string `json:"reason"`
+}
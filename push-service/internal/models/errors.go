@@ -8,23 +8,49 @@ var (
 	ErrInvalidUserID             = errors.New("invalid user ID")
 	ErrNoDeviceTokens            = errors.New("no device tokens provided")
 	ErrEmptyNotificationContent  = errors.New("notification content cannot be empty")
+	ErrContentTooLarge           = errors.New("notification content exceeds limits")
 	ErrInvalidDeviceToken        = errors.New("invalid device token")
 	ErrTemplateNotFound          = errors.New("template not found")
 	ErrTemplateVariableMissing   = errors.New("required template variable missing")
 	ErrInvalidRequestID          = errors.New("invalid request ID")
 	ErrInvalidNotificationStatus = errors.New("invalid notification status")
+	ErrNotificationNotFound      = errors.New("notification not found")
+	ErrBatchNotFound             = errors.New("batch not found")
+	ErrBatchTooLarge             = errors.New("batch exceeds the maximum size")
+	ErrCampaignNotFound          = errors.New("campaign not found")
+	ErrInvalidCampaignState      = errors.New("campaign state does not allow this action")
+	ErrInvalidCallbackURL        = errors.New("callback_url must be an absolute http or https URL")
+	ErrTenantMismatch            = errors.New("tenant_id does not match the authenticated tenant")
+
+	// device errors
+	ErrDeviceNotFound = errors.New("device not found")
+
+	// template errors
+	ErrTemplateRenderFailed       = errors.New("template render failed")
+	ErrTemplateServiceUnavailable = errors.New("template service unavailable")
 
 	// user errors
 	ErrInvalidUserName = errors.New("invalid user name")
 	ErrInvalidEmail    = errors.New("invalid email")
 	ErrInvalidPassword = errors.New("invalid password")
+	ErrUserNotFound    = errors.New("user not found")
+
+	// user service errors
+	ErrUserServiceUnavailable = errors.New("user service unavailable")
 
 	// service errors
-	ErrCircuitBreakerOpen    = errors.New("circuit breaker is open")
-	ErrMaxRetriesExceeded    = errors.New("max retry attempts exceeded")
-	ErrFCMServiceUnavailable = errors.New("FCM service unavailable")
-	ErrInvalidFCMResponse    = errors.New("invalid FCM response")
-	ErrRateLimitExceeded     = errors.New("rate limit exceeded")
+	ErrCircuitBreakerOpen     = errors.New("circuit breaker is open")
+	ErrMaxRetriesExceeded     = errors.New("max retry attempts exceeded")
+	ErrFCMServiceUnavailable  = errors.New("FCM service unavailable")
+	ErrUnknownApp             = errors.New("no FCM credentials for tenant app")
+	ErrInvalidFCMResponse     = errors.New("invalid FCM response")
+	ErrRateLimitExceeded      = errors.New("rate limit exceeded")
+	ErrThroughputExceeded     = errors.New("outbound throughput limit exceeded")
+	ErrWebhookFailed          = errors.New("webhook delivery failed")
+	ErrWebhookNotFound        = errors.New("webhook delivery not found")
+	ErrCallbackHostNotAllowed = errors.New("callback_url host is not allowed")
+	ErrWebhookAddressBlocked  = errors.New("webhook address is not public")
+	ErrTooManyStreams         = errors.New("too many status stream connections")
 
 	// database errors
 	ErrDatabaseConnection = errors.New("database connection error")
@@ -41,3 +67,30 @@ var (
 	ErrMessageConsumeFailed = errors.New("failed to consume message")
 	ErrInvalidMessageFormat = errors.New("invalid message format")
 )
+
+// errors that will fail the same way on every attempt and must not be retried
+var permanentErrors = []error{
+	ErrEmptyNotificationContent,
+	ErrContentTooLarge,
+	ErrTemplateNotFound,
+	ErrTemplateRenderFailed,
+	ErrTemplateVariableMissing,
+	ErrInvalidMessageFormat,
+	ErrUserNotFound,
+	ErrUnknownApp,
+	ErrWebhookAddressBlocked,
+}
+
+// reports whether err is a permanent failure
+func IsPermanent(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	for _, permanent := range permanentErrors {
+		if errors.Is(err, permanent) {
+			return true
+		}
+	}
+	return false
+}
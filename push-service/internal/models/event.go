@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// version of the CloudEvents specification used for status events
+const CloudEventsSpecVersion = "1.0"
+
+// status event in the CloudEvents 1.0 structured JSON format
+type CloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Subject         string      `json:"subject,omitempty"` // the notification id
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Sequence        string      `json:"sequence,omitempty"` // sequence extension, the transition sequence
+	Data            interface{} `json:"data"`
+}
@@ -1,24 +1,62 @@
 package models
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"time"
+	"unicode/utf8"
+)
+
+// how the content of a notification is produced
+const (
+	ContentModeTemplate = "template"
+	ContentModeInline   = "inline"
+)
+
+// content limits for inline notifications
+const (
+	MaxTitleLength = 200  // characters
+	MaxBodyLength  = 2000 // characters
+	MaxURLLength   = 2048 // characters
+	MaxDataSize    = 4096 // bytes of JSON encoded data
 )
 
 // status of a notification
 type NotificationStatusEnum string
 
 const (
-	NotificationStatusDelivered NotificationStatusEnum = "delivered"
-	NotificationStatusPending   NotificationStatusEnum = "pending"
-	NotificationStatusFailed    NotificationStatusEnum = "failed"
+	NotificationStatusQueued             NotificationStatusEnum = "queued"     // accepted by the HTTP API
+	NotificationStatusProcessing         NotificationStatusEnum = "processing" // picked up by a consumer, rendering and sending
+	NotificationStatusRetrying           NotificationStatusEnum = "retrying"   // a send attempt failed and is retried
+	NotificationStatusPartiallyDelivered NotificationStatusEnum = "partially_delivered"
+	NotificationStatusDelivered          NotificationStatusEnum = "delivered"
+	NotificationStatusFailed             NotificationStatusEnum = "failed"
+	NotificationStatusSuppressed         NotificationStatusEnum = "suppressed" // opted out by user preferences
+	NotificationStatusExpired            NotificationStatusEnum = "expired"    // not sent before expires_at
+	NotificationStatusPending            NotificationStatusEnum = "pending"    // buffered for a digest
+	NotificationStatusAggregated         NotificationStatusEnum = "aggregated" // folded into a digest
+	NotificationStatusRateLimited        NotificationStatusEnum = "rate_limited"
 )
 
+// returns whether no further transitions follow the status
+func (s NotificationStatusEnum) Final() bool {
+	switch s {
+	case NotificationStatusDelivered, NotificationStatusPartiallyDelivered, NotificationStatusFailed,
+		NotificationStatusSuppressed, NotificationStatusExpired, NotificationStatusAggregated:
+		return true
+	}
+	return false
+}
+
 // create a push notification request
 type CreateNotificationRequest struct {
 	UserID       string                 `json:"user_id"`
+	TenantID     string                 `json:"tenant_id,omitempty"`
+	AppID        string                 `json:"app_id,omitempty"` // selects the tenant's FCM credentials
 	DeviceTokens []string               `json:"device_tokens"`
 	Platform     string                 `json:"platform,omitempty"` // "ios", "android", "web"
+	Locale       string                 `json:"locale,omitempty"`
 	Title        string                 `json:"title,omitempty"`
 	Body         string                 `json:"body,omitempty"`
 	ImageURL     string                 `json:"image_url,omitempty"`
@@ -28,6 +66,10 @@ type CreateNotificationRequest struct {
 	RequestID    string                 `json:"request_id"`
 	Priority     int                    `json:"priority"`
 	Metadata     map[string]interface{} `json:"metadata,omitempty"`
+	DigestGroup  string                 `json:"digest_group,omitempty"` // opt-in aggregation group
+	ExpiresAt    *time.Time             `json:"expires_at,omitempty"`   // not sent after this time
+	Category     string                 `json:"category,omitempty"`     // preference category for inline content, e.g. "marketing"
+	CallbackURL  string                 `json:"callback_url,omitempty"` // receives status events, overrides the tenant webhook
 }
 
 // user-specific data for notification variables
@@ -46,18 +88,39 @@ type UpdateNotificationStatusRequest struct {
 
 // notification message from queue
 type NotificationMessage struct {
-	ID               string            `json:"id"`
-	NotificationType string            `json:"notification_type"` // "email", "push", "sms"
-	UserID           string            `json:"user_id"`
-	TemplateCode     string            `json:"template_code"`
-	DeviceTokens     []string          `json:"device_tokens"`
-	Variables        map[string]string `json:"variables,omitempty"`
-	Platform         string            `json:"platform,omitempty"` // "ios", "android", "web"
-	Priority         string            `json:"priority,omitempty"` // "high", "normal"
-	CorrelationID    string            `json:"correlation_id,omitempty"`
-	RequestID        string            `json:"request_id,omitempty"`
-	ScheduledAt      *time.Time        `json:"scheduled_at,omitempty"`
-	CreatedAt        time.Time         `json:"created_at,omitempty"`
+	ID               string                 `json:"id"`
+	NotificationType string                 `json:"notification_type"` // "email", "push", "sms"
+	UserID           string                 `json:"user_id"`
+	TenantID         string                 `json:"tenant_id,omitempty"`
+	AppID            string                 `json:"app_id,omitempty"` // with tenant_id selects the FCM credentials, the tenant's default app when empty
+	TemplateCode     string                 `json:"template_code"`
+	Content          *InlineContent         `json:"content,omitempty"`   // sent as is instead of rendering a template
+	Category         string                 `json:"category,omitempty"`  // preference category, a template category takes precedence
+	DeviceTokens     []string               `json:"device_tokens"`       // optional, the user's active devices are used when empty
+	Variables        map[string]interface{} `json:"variables,omitempty"` // any JSON value, string-only producers decode unchanged
+	Platform         string                 `json:"platform,omitempty"`  // "ios", "android", "web"
+	Priority         string                 `json:"priority,omitempty"`  // "high", "normal"
+	Locale           string                 `json:"locale,omitempty"`    // e.g. "pt-BR", falls back to the language then the default locale
+	CorrelationID    string                 `json:"correlation_id,omitempty"`
+	RequestID        string                 `json:"request_id,omitempty"`
+	DigestGroup      string                 `json:"digest_group,omitempty"` // buffer into a per-user digest when set
+	DeferCount       int                    `json:"defer_count,omitempty"`  // times deferred by the rate limiter
+	ScheduledAt      *time.Time             `json:"scheduled_at,omitempty"`
+	ExpiresAt        *time.Time             `json:"expires_at,omitempty"`   // published as expired instead of sent after this time
+	CallbackURL      string                 `json:"callback_url,omitempty"` // status events are posted here, the tenant webhook when empty
+	CampaignID       string                 `json:"campaign_id,omitempty"`  // set on the child messages of a campaign
+	ParentID         string                 `json:"parent_id,omitempty"`    // set on the child messages of a split message
+	SubmittedBy      string                 `json:"submitted_by,omitempty"` // authenticated API caller, e.g. "api_key:billing"
+	CreatedAt        time.Time              `json:"created_at,omitempty"`
+}
+
+// notification content carried directly by the message
+type InlineContent struct {
+	Title    string                 `json:"title,omitempty"`
+	Body     string                 `json:"body,omitempty"`
+	ImageURL string                 `json:"image_url,omitempty"`
+	Link     string                 `json:"link,omitempty"`
+	Data     map[string]interface{} `json:"data,omitempty"`
 }
 
 type PushNotification struct {
@@ -67,6 +130,12 @@ type PushNotification struct {
 	Link     string                 `json:"link,omitempty"`
 	Data     map[string]interface{} `json:"data,omitempty"`
 	Priority string                 `json:"priority,omitempty"`
+
+	// client-side localization, the device looks these keys up in its own strings
+	TitleLocKey  string   `json:"title_loc_key,omitempty"`
+	TitleLocArgs []string `json:"title_loc_args,omitempty"`
+	BodyLocKey   string   `json:"body_loc_key,omitempty"`
+	BodyLocArgs  []string `json:"body_loc_args,omitempty"`
 }
 
 type NotificationResult struct {
@@ -91,6 +160,7 @@ type DeviceTokenValidation struct {
 	Token  string `json:"token"`
 	Valid  bool   `json:"valid"`
 	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"` // set when the provider could not check the token
 }
 
 type NotificationStatusResponse struct {
@@ -101,20 +171,57 @@ type NotificationStatusResponse struct {
 	LastUpdated   time.Time              `json:"last_updated"`
 	CorrelationID string                 `json:"correlation_id,omitempty"`
 	RequestID     string                 `json:"request_id,omitempty"`
+	UserID        string                 `json:"user_id"`
+	TenantID      string                 `json:"tenant_id,omitempty"`
+	TemplateCode  string                 `json:"template_code,omitempty"`
+	Error         string                 `json:"error,omitempty"`
+	CreatedAt     time.Time              `json:"created_at"`
+	Sequence      int64                  `json:"sequence"`          // sequence of the current status
+	Results       []*NotificationResult  `json:"results,omitempty"` // latest outcome per device token
+	History       []StatusTransition     `json:"history"`           // oldest first
+}
+
+// a status change recorded for a notification
+type StatusTransition struct {
+	Sequence  int64                  `json:"sequence"`
+	Status    NotificationStatusEnum `json:"status"`
+	Message   string                 `json:"message,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
 }
 
 // status queue message
 type NotificationStatusMessage struct {
 	NotificationID   string                 `json:"notification_id"`
+	Sequence         int64                  `json:"sequence"` // increases with every transition of a notification, 0 when unknown
 	Status           NotificationStatusEnum `json:"status"`
 	Timestamp        time.Time              `json:"timestamp"`
 	Error            *string                `json:"error"`
 	UserID           string                 `json:"user_id"`
+	TenantID         string                 `json:"tenant_id,omitempty"`
 	NotificationType string                 `json:"notification_type"`
 	TemplateCode     string                 `json:"template_code"`
 	Metadata         map[string]interface{} `json:"metadata,omitempty"`
 }
 
+// outcome of a send to one device, published when device events are enabled
+type DeviceStatusMessage struct {
+	NotificationID    string                 `json:"notification_id"`
+	Sequence          int64                  `json:"sequence"` // sequence of the notification transition that produced it
+	UserID            string                 `json:"user_id"`
+	TenantID          string                 `json:"tenant_id,omitempty"`
+	TemplateCode      string                 `json:"template_code,omitempty"`
+	DeviceToken       string                 `json:"device_token"`
+	Status            NotificationStatusEnum `json:"status"` // delivered or failed
+	ProviderMessageID string                 `json:"provider_message_id,omitempty"`
+	Error             string                 `json:"error,omitempty"`
+	Timestamp         time.Time              `json:"timestamp"`
+}
+
+// reports whether the message expired before now
+func (n *NotificationMessage) Expired(now time.Time) bool {
+	return n.ExpiresAt != nil && now.After(*n.ExpiresAt)
+}
+
 // validates notification message
 func (n *NotificationMessage) Validate() error {
 	if n.ID == "" {
@@ -123,11 +230,15 @@ func (n *NotificationMessage) Validate() error {
 	if n.UserID == "" {
 		return ErrInvalidUserID
 	}
-	if len(n.DeviceTokens) == 0 {
-		return ErrNoDeviceTokens
-	}
-	if n.TemplateCode == "" {
-		return fmt.Errorf("template_code is required")
+	if n.Content != nil {
+		if n.TemplateCode != "" {
+			return fmt.Errorf("template_code and content are mutually exclusive")
+		}
+		if err := n.Content.Validate(); err != nil {
+			return err
+		}
+	} else if n.TemplateCode == "" {
+		return fmt.Errorf("template_code or content is required")
 	}
 	if n.NotificationType == "" {
 		return fmt.Errorf("notification_type is required")
@@ -135,5 +246,45 @@ func (n *NotificationMessage) Validate() error {
 	if n.NotificationType != "push" {
 		return fmt.Errorf("notification_type must be 'push', got '%s'", n.NotificationType)
 	}
+	if n.CallbackURL != "" {
+		callback, err := url.Parse(n.CallbackURL)
+		if err != nil || (callback.Scheme != "http" && callback.Scheme != "https") || callback.Host == "" {
+			return fmt.Errorf("%w: %s", ErrInvalidCallbackURL, n.CallbackURL)
+		}
+	}
+	return nil
+}
+
+// returns whether the message uses a template or inline content
+func (n *NotificationMessage) ContentMode() string {
+	if n.Content != nil {
+		return ContentModeInline
+	}
+	return ContentModeTemplate
+}
+
+// validates inline content against the push payload limits
+func (c *InlineContent) Validate() error {
+	if c.Title == "" && c.Body == "" {
+		return ErrEmptyNotificationContent
+	}
+	if utf8.RuneCountInString(c.Title) > MaxTitleLength {
+		return fmt.Errorf("%w: title exceeds %d characters", ErrContentTooLarge, MaxTitleLength)
+	}
+	if utf8.RuneCountInString(c.Body) > MaxBodyLength {
+		return fmt.Errorf("%w: body exceeds %d characters", ErrContentTooLarge, MaxBodyLength)
+	}
+	if len(c.ImageURL) > MaxURLLength || len(c.Link) > MaxURLLength {
+		return fmt.Errorf("%w: urls must be at most %d characters", ErrContentTooLarge, MaxURLLength)
+	}
+	if len(c.Data) > 0 {
+		data, err := json.Marshal(c.Data)
+		if err != nil {
+			return fmt.Errorf("invalid data: %w", err)
+		}
+		if len(data) > MaxDataSize {
+			return fmt.Errorf("%w: data exceeds %d bytes", ErrContentTooLarge, MaxDataSize)
+		}
+	}
 	return nil
 }
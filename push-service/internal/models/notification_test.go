@@ -0,0 +1,127 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// tests validation of template and inline messages
+func TestNotificationMessageValidate(t *testing.T) {
+	base := func() *NotificationMessage {
+		return &NotificationMessage{
+			ID:               "n1",
+			UserID:           "u1",
+			NotificationType: "push",
+			DeviceTokens:     []string{"token"},
+		}
+	}
+
+	t.Run("Template mode", func(t *testing.T) {
+		msg := base()
+		msg.TemplateCode = "welcome"
+
+		if err := msg.Validate(); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		if msg.ContentMode() != ContentModeTemplate {
+			t.Errorf("Expected template mode, got %s", msg.ContentMode())
+		}
+	})
+
+	t.Run("Inline mode", func(t *testing.T) {
+		msg := base()
+		msg.Content = &InlineContent{Title: "Order shipped", Body: "Your order is on its way"}
+
+		if err := msg.Validate(); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		if msg.ContentMode() != ContentModeInline {
+			t.Errorf("Expected inline mode, got %s", msg.ContentMode())
+		}
+	})
+
+	t.Run("Template or content required", func(t *testing.T) {
+		if err := base().Validate(); err == nil {
+			t.Error("Expected error without template or content")
+		}
+	})
+
+	t.Run("Template and content are exclusive", func(t *testing.T) {
+		msg := base()
+		msg.TemplateCode = "welcome"
+		msg.Content = &InlineContent{Title: "Hi"}
+
+		if err := msg.Validate(); err == nil {
+			t.Error("Expected error with both template and content")
+		}
+	})
+
+	t.Run("Callback URL must be absolute http", func(t *testing.T) {
+		for _, callback := range []string{"/hooks", "ftp://example.com/hooks", "https://"} {
+			msg := base()
+			msg.TemplateCode = "welcome"
+			msg.CallbackURL = callback
+
+			if err := msg.Validate(); !errors.Is(err, ErrInvalidCallbackURL) {
+				t.Errorf("Expected ErrInvalidCallbackURL for %q, got %v", callback, err)
+			}
+		}
+
+		msg := base()
+		msg.TemplateCode = "welcome"
+		msg.CallbackURL = "https://example.com/hooks/push"
+		if err := msg.Validate(); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+}
+
+// tests inline content limits
+func TestInlineContentValidate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		content  InlineContent
+		expected error
+	}{
+		{"Empty", InlineContent{}, ErrEmptyNotificationContent},
+		{"Title too long", InlineContent{Title: strings.Repeat("a", MaxTitleLength+1)}, ErrContentTooLarge},
+		{"Body too long", InlineContent{Body: strings.Repeat("é", MaxBodyLength+1)}, ErrContentTooLarge},
+		{"Link too long", InlineContent{Body: "b", Link: strings.Repeat("a", MaxURLLength+1)}, ErrContentTooLarge},
+		{"Data too large", InlineContent{Body: "b", Data: map[string]interface{}{"k": strings.Repeat("a", MaxDataSize)}}, ErrContentTooLarge},
+		{"Within limits", InlineContent{Title: strings.Repeat("a", MaxTitleLength), Body: "b"}, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.content.Validate()
+			if !errors.Is(err, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+// tests string-only and typed variables decode from queue messages
+func TestNotificationMessageVariables(t *testing.T) {
+	payload := `{"id": "n1", "variables": {"name": "Ada", "count": "3", "total": 3, "items": ["a", "b"], "order": {"id": "o1"}}}`
+
+	var msg NotificationMessage
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if msg.Variables["name"] != "Ada" || msg.Variables["count"] != "3" {
+		t.Errorf("Expected string variables unchanged, got %v", msg.Variables)
+	}
+	if msg.Variables["total"] != float64(3) {
+		t.Errorf("Expected number variable, got %v", msg.Variables["total"])
+	}
+	if items, ok := msg.Variables["items"].([]interface{}); !ok || len(items) != 2 {
+		t.Errorf("Expected list variable, got %v", msg.Variables["items"])
+	}
+	if order, ok := msg.Variables["order"].(map[string]interface{}); !ok || order["id"] != "o1" {
+		t.Errorf("Expected object variable, got %v", msg.Variables["order"])
+	}
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// reasons a notification is suppressed by user preferences
+const (
+	SuppressionPushDisabled   = "push_disabled"
+	SuppressionCategoryOptOut = "category_opt_out"
+	SuppressionMuted          = "muted"
+)
+
+// push preferences of a user, owned by the user service
+type UserPreferences struct {
+	UserID             string     `json:"user_id"`
+	Push               bool       `json:"push"`
+	DisabledCategories []string   `json:"disabled_categories,omitempty"`
+	MutedUntil         *time.Time `json:"muted_until,omitempty"`
+}
+
+// preferences used for users without stored preferences, push is on by default
+func DefaultPreferences(userID string) *UserPreferences {
+	return &UserPreferences{
+		UserID: userID,
+		Push:   true,
+	}
+}
+
+// returns why a notification of the category must not be sent, empty when it may be sent
+func (p *UserPreferences) SuppressionReason(category string, now time.Time) string {
+	if !p.Push {
+		return SuppressionPushDisabled
+	}
+	if category != "" {
+		for _, disabled := range p.DisabledCategories {
+			if strings.EqualFold(disabled, category) {
+				return SuppressionCategoryOptOut
+			}
+		}
+	}
+	if p.MutedUntil != nil && now.Before(*p.MutedUntil) {
+		return SuppressionMuted
+	}
+	return ""
+}
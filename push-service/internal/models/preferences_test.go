@@ -0,0 +1,68 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// tests push opt-in, category opt-outs and mutes
+func TestUserPreferencesSuppressionReason(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+	earlier := now.Add(-time.Hour)
+
+	testCases := []struct {
+		name     string
+		prefs    *UserPreferences
+		category string
+		expected string
+	}{
+		{
+			name:     "Defaults allow everything",
+			prefs:    DefaultPreferences("u1"),
+			category: "marketing",
+			expected: "",
+		},
+		{
+			name:     "Push disabled",
+			prefs:    &UserPreferences{Push: false},
+			category: "transactional",
+			expected: SuppressionPushDisabled,
+		},
+		{
+			name:     "Category opt-out",
+			prefs:    &UserPreferences{Push: true, DisabledCategories: []string{"Marketing"}},
+			category: "marketing",
+			expected: SuppressionCategoryOptOut,
+		},
+		{
+			name:     "Other category allowed",
+			prefs:    &UserPreferences{Push: true, DisabledCategories: []string{"marketing"}},
+			category: "security",
+			expected: "",
+		},
+		{
+			name:     "Uncategorized ignores opt-outs",
+			prefs:    &UserPreferences{Push: true, DisabledCategories: []string{"marketing"}},
+			expected: "",
+		},
+		{
+			name:     "Muted",
+			prefs:    &UserPreferences{Push: true, MutedUntil: &later},
+			expected: SuppressionMuted,
+		},
+		{
+			name:     "Mute expired",
+			prefs:    &UserPreferences{Push: true, MutedUntil: &earlier},
+			expected: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if reason := tc.prefs.SuppressionReason(tc.category, now); reason != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// headers sent with every webhook request
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature" // "sha256=" + hex HMAC-SHA256 of "<timestamp>.<url>.<body>"
+	WebhookTimestampHeader = "X-Webhook-Timestamp" // unix seconds, part of the signed payload
+	WebhookIDHeader        = "X-Webhook-ID"        // delivery id, the same on every attempt
+)
+
+// a status event posted to a callback URL, with every attempt made. pending
+// deliveries are retried at their next attempt, by whichever replica claims them
+type WebhookDelivery struct {
+	ID             string                 `json:"id"`
+	NotificationID string                 `json:"notification_id"`
+	TenantID       string                 `json:"tenant_id,omitempty"`
+	URL            string                 `json:"url"`
+	Status         NotificationStatusEnum `json:"status"` // the notification status that was posted
+	Sequence       int64                  `json:"sequence"`
+	Delivered      bool                   `json:"delivered"`
+	Error          string                 `json:"error,omitempty"` // last failure of an undelivered webhook
+	Event          *CloudEvent            `json:"event"`           // the posted body
+	Attempts       []WebhookAttempt       `json:"attempts"`
+	NextAttemptAt  *time.Time             `json:"next_attempt_at,omitempty"` // set while the delivery is pending
+	CreatedAt      time.Time              `json:"created_at"`
+	UpdatedAt      time.Time              `json:"updated_at"`
+}
+
+// one request made for a webhook delivery
+type WebhookAttempt struct {
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"` // 0 when no response was received
+	Error      string    `json:"error,omitempty"`
+	Duration   string    `json:"duration"`
+	Timestamp  time.Time `json:"timestamp"`
+}
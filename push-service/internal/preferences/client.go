@@ -0,0 +1,202 @@
+package preferences
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/push"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
+)
+
+// Client looks up user push preferences from the user service and caches
+// them in redis for a short time, so opt-outs apply within one ttl
+type Client struct {
+	baseURL        string
+	apiKey         string
+	httpClient     *http.Client
+	cache          *cache.RedisCache
+	ttl            time.Duration
+	circuitBreaker *push.CircuitBreaker
+	retrier        Retrier
+}
+
+// retries transient failures with backoff, permanent errors are returned at once
+type Retrier interface {
+	RetryWithBackoff(ctx context.Context, fn func() error) error
+}
+
+// response envelope of the user service
+type userServiceResponse struct {
+	Success bool                    `json:"success"`
+	Data    *models.UserPreferences `json:"data"`
+	Message string                  `json:"message"`
+}
+
+func NewClient(baseURL, apiKey string, timeout time.Duration, redisCache *cache.RedisCache, ttl time.Duration, cb *push.CircuitBreaker, retrier Retrier) *Client {
+	return &Client{
+		baseURL: baseURL,
+		apiKey:  apiKey,
+		httpClient: &http.Client{
+			Timeout: timeout,
+		},
+		cache:          redisCache,
+		ttl:            ttl,
+		circuitBreaker: cb,
+		retrier:        retrier,
+	}
+}
+
+// returns the preferences of a user of a tenant, from the cache when fresh.
+// users the user service does not know get the default preferences
+func (c *Client) GetPreferences(ctx context.Context, tenantID, userID string) (*models.UserPreferences, error) {
+	key := cache.TenantKey(tenantID, cache.GetUserPreferencesKey(userID))
+
+	if c.cache != nil {
+		raw, err := c.cache.Get(ctx, key)
+		switch {
+		case err == nil:
+			prefs := &models.UserPreferences{}
+			if err := json.Unmarshal([]byte(raw), prefs); err == nil {
+				return prefs, nil
+			}
+		case !errors.Is(err, models.ErrCacheMiss):
+			logger.Error("Failed to read preferences cache", logger.Merge(
+				logger.WithUserID(userID),
+				logger.WithError(err),
+			))
+		}
+	}
+
+	prefs, err := c.fetch(ctx, userID)
+	if errors.Is(err, models.ErrUserNotFound) {
+		prefs, err = models.DefaultPreferences(userID), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.store(ctx, key, prefs)
+
+	return prefs, nil
+}
+
+func (c *Client) fetch(ctx context.Context, userID string) (*models.UserPreferences, error) {
+	url := fmt.Sprintf("%s/api/users/%s/preferences", c.baseURL, neturl.PathEscape(userID))
+
+	var prefs *models.UserPreferences
+	err := c.call(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		if c.apiKey != "" {
+			req.Header.Set("X-Service-Key", c.apiKey)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("%w: %w", models.ErrUserServiceUnavailable, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return statusError(resp, userID)
+		}
+
+		var body userServiceResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			return fmt.Errorf("%w: failed to decode preferences: %w", models.ErrInvalidMessageFormat, err)
+		}
+		if body.Data == nil {
+			return fmt.Errorf("%w: %s", models.ErrUserNotFound, userID)
+		}
+
+		prefs = body.Data
+		prefs.UserID = userID
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, models.ErrUserNotFound) {
+			logger.Error("Failed to fetch user preferences", logger.Merge(
+				logger.WithUserID(userID),
+				logger.WithError(err),
+			))
+		}
+		return nil, err
+	}
+
+	return prefs, nil
+}
+
+func (c *Client) store(ctx context.Context, key string, prefs *models.UserPreferences) {
+	if c.cache == nil {
+		return
+	}
+
+	body, err := json.Marshal(prefs)
+	if err != nil {
+		logger.Error("Failed to marshal user preferences", logger.WithError(err))
+		return
+	}
+
+	if err := c.cache.Set(ctx, key, string(body), int(c.ttl.Seconds())); err != nil {
+		logger.Error("Failed to cache user preferences", logger.Merge(
+			logger.WithUserID(prefs.UserID),
+			logger.WithError(err),
+		))
+	}
+}
+
+// runs a user service request with retries behind the circuit breaker.
+// permanent errors such as an unknown user are not retried and do not count as breaker failures
+func (c *Client) call(ctx context.Context, fn func() error) error {
+	attempt := func() error {
+		if c.circuitBreaker == nil {
+			return fn()
+		}
+
+		var permanentErr error
+		err := c.circuitBreaker.Call(func() error {
+			err := fn()
+			if models.IsPermanent(err) {
+				permanentErr = err
+				return nil
+			}
+			return err
+		})
+
+		if permanentErr != nil {
+			return permanentErr
+		}
+		if errors.Is(err, models.ErrCircuitBreakerOpen) {
+			return fmt.Errorf("%w: %w", models.ErrUserServiceUnavailable, err)
+		}
+		return err
+	}
+
+	if c.retrier == nil {
+		return attempt()
+	}
+	return c.retrier.RetryWithBackoff(ctx, attempt)
+}
+
+// maps a user service response to a typed error. a 404 only means an unknown
+// user when it carries the user service envelope, a missing route or a proxy
+// answering for it is an unavailable service
+func statusError(resp *http.Response, userID string) error {
+	if resp.StatusCode == http.StatusNotFound {
+		var body userServiceResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err == nil && !body.Success && body.Message != "" {
+			return fmt.Errorf("%w: %s", models.ErrUserNotFound, userID)
+		}
+		return fmt.Errorf("%w: preferences route not found", models.ErrUserServiceUnavailable)
+	}
+	return fmt.Errorf("%w: status %d", models.ErrUserServiceUnavailable, resp.StatusCode)
+}
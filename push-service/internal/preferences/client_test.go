@@ -0,0 +1,108 @@
+package preferences
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/push"
+)
+
+// retries immediately, stopping on permanent errors like the retry service
+type testRetrier struct {
+	maxAttempts int
+}
+
+func (r *testRetrier) RetryWithBackoff(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 0; attempt < r.maxAttempts; attempt++ {
+		if err = fn(); err == nil || models.IsPermanent(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// tests preferences are decoded from the user service envelope
+func TestClientGetPreferences(t *testing.T) {
+	t.Run("Preferences are decoded", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/users/u1/preferences" {
+				t.Errorf("Unexpected path %s", r.URL.Path)
+			}
+			if key := r.Header.Get("X-Service-Key"); key != "secret" {
+				t.Errorf("Expected the service key to be sent, got %q", key)
+			}
+			w.Write([]byte(`{"success":true,"data":{"push":true,"disabled_categories":["marketing"]},"message":"ok"}`))
+		}))
+		defer server.Close()
+
+		client := NewClient(server.URL, "secret", time.Second, nil, time.Minute, nil, nil)
+
+		prefs, err := client.GetPreferences(context.Background(), "", "u1")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if prefs.UserID != "u1" || !prefs.Push || len(prefs.DisabledCategories) != 1 {
+			t.Errorf("Unexpected preferences %+v", prefs)
+		}
+	})
+
+	t.Run("Unknown user gets defaults without retries", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"success":false,"data":null,"message":"User not found","error":"No user with id missing"}`))
+		}))
+		defer server.Close()
+
+		cb := push.NewCircuitBreaker(3, 1, time.Minute, time.Minute)
+		client := NewClient(server.URL, "", time.Second, nil, time.Minute, cb, &testRetrier{maxAttempts: 3})
+
+		prefs, err := client.GetPreferences(context.Background(), "", "missing")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !prefs.Push || prefs.SuppressionReason("marketing", time.Now()) != "" {
+			t.Errorf("Expected default preferences, got %+v", prefs)
+		}
+		if calls.Load() != 1 {
+			t.Errorf("Expected 1 call, got %d", calls.Load())
+		}
+		if cb.GetState() != push.StateClosed {
+			t.Errorf("Expected breaker to stay closed, got %s", cb.GetState().String())
+		}
+	})
+
+	t.Run("Missing route is not an unknown user", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		defer server.Close()
+
+		client := NewClient(server.URL, "", time.Second, nil, time.Minute, nil, &testRetrier{maxAttempts: 2})
+
+		_, err := client.GetPreferences(context.Background(), "", "u1")
+		if !errors.Is(err, models.ErrUserServiceUnavailable) {
+			t.Errorf("Expected ErrUserServiceUnavailable, got %v", err)
+		}
+	})
+
+	t.Run("Outage is reported as unavailable", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.URL, "", time.Second, nil, time.Minute, nil, &testRetrier{maxAttempts: 2})
+
+		_, err := client.GetPreferences(context.Background(), "", "u1")
+		if !errors.Is(err, models.ErrUserServiceUnavailable) {
+			t.Errorf("Expected ErrUserServiceUnavailable, got %v", err)
+		}
+	})
+}
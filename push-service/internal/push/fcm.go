@@ -13,13 +13,17 @@ import (
 	"google.golang.org/api/option"
 )
 
+// max tokens FCM accepts in one multicast request
+const maxMulticastTokens = 500
+
 type FCMService struct {
 	client         *messaging.Client
 	timeout        time.Duration
 	circuitBreaker *CircuitBreaker
+	throttle       *Throttle
 }
 
-func NewFCMService(ctx context.Context, projectID, credentialsPath string, timeout int, cb *CircuitBreaker) (*FCMService, error) {
+func NewFCMService(ctx context.Context, projectID, credentialsPath string, timeout int, cb *CircuitBreaker, throttle *Throttle) (*FCMService, error) {
 	opt := option.WithCredentialsFile(credentialsPath)
 
 	config := &firebase.Config{
@@ -44,6 +48,7 @@ func NewFCMService(ctx context.Context, projectID, credentialsPath string, timeo
 		client:         client,
 		timeout:        time.Duration(timeout) * time.Second,
 		circuitBreaker: cb,
+		throttle:       throttle,
 	}, nil
 }
 
@@ -58,6 +63,14 @@ func (s *FCMService) SendNotification(ctx context.Context, deviceToken string, n
 		SentAt:      time.Now(),
 	}
 
+	// wait for outbound quota before the breaker, so throttling never trips it
+	if err := s.throttle.Acquire(ctx, 1); err != nil {
+		result.Success = false
+		result.Error = err.Error()
+		logger.Warn("Outbound throughput limit reached", logger.WithDeviceToken(deviceToken))
+		return result, err
+	}
+
 	// check circuit breaker
 	if err := s.circuitBreaker.Call(func() error {
 		// create timeout context
@@ -83,20 +96,13 @@ func (s *FCMService) SendNotification(ctx context.Context, deviceToken string, n
 				},
 			},
 			Android: &messaging.AndroidConfig{
-				Priority: "high",
-				Notification: &messaging.AndroidNotification{
-					Title:       notification.Title,
-					Body:        notification.Body,
-					ClickAction: notification.Link,
-				},
+				Priority:     "high",
+				Notification: androidNotification(notification),
 			},
 			APNS: &messaging.APNSConfig{
 				Payload: &messaging.APNSPayload{
 					Aps: &messaging.Aps{
-						Alert: &messaging.ApsAlert{
-							Title: notification.Title,
-							Body:  notification.Body,
-						},
+						Alert: apsAlert(notification),
 						Sound: "default",
 					},
 				},
@@ -144,7 +150,23 @@ func (s *FCMService) SendNotification(ctx context.Context, deviceToken string, n
 	return result, nil
 }
 
-// sends notification to multiple devices
+// returned by SendToMultipleDevices when a chunk could not be sent. the first
+// Sent tokens were answered by FCM, the tokens after them were never sent
+type PartialSendError struct {
+	Sent int
+	Err  error
+}
+
+func (e *PartialSendError) Error() string {
+	return fmt.Sprintf("sent %d tokens before a chunk failed: %v", e.Sent, e.Err)
+}
+
+func (e *PartialSendError) Unwrap() error {
+	return e.Err
+}
+
+// sends notification to multiple devices, in chunks FCM accepts. when a chunk
+// fails the later chunks are not attempted and a *PartialSendError is returned
 func (s *FCMService) SendToMultipleDevices(ctx context.Context, deviceTokens []string, notification *models.PushNotification) ([]*models.NotificationResult, error) {
 
 	// trim whitespace from all tokens
@@ -152,8 +174,62 @@ func (s *FCMService) SendToMultipleDevices(ctx context.Context, deviceTokens []s
 		deviceTokens[i] = strings.TrimSpace(deviceTokens[i])
 	}
 
+	return sendInChunks(ctx, deviceTokens, maxMulticastTokens, func(ctx context.Context, chunk []string) ([]*models.NotificationResult, error) {
+		return s.sendMulticastChunk(ctx, chunk, notification)
+	})
+}
+
+// sends the tokens in chunks of at most size tokens, in order. the tokens of a
+// failed chunk and of every later chunk get a failed result
+func sendInChunks(ctx context.Context, deviceTokens []string, size int, send func(context.Context, []string) ([]*models.NotificationResult, error)) ([]*models.NotificationResult, error) {
 	results := make([]*models.NotificationResult, 0, len(deviceTokens))
 
+	for start := 0; start < len(deviceTokens); start += size {
+		end := min(start+size, len(deviceTokens))
+
+		chunkResults, err := send(ctx, deviceTokens[start:end])
+		if err != nil {
+			if len(chunkResults) != end-start {
+				chunkResults = failedResults(deviceTokens[start:end], err)
+			}
+			results = append(results, chunkResults...)
+			results = append(results, failedResults(deviceTokens[end:], err)...)
+			return results, &PartialSendError{Sent: start, Err: err}
+		}
+
+		results = append(results, chunkResults...)
+	}
+
+	return results, nil
+}
+
+// a failed result per token
+func failedResults(deviceTokens []string, err error) []*models.NotificationResult {
+	results := make([]*models.NotificationResult, 0, len(deviceTokens))
+	for _, token := range deviceTokens {
+		results = append(results, &models.NotificationResult{
+			DeviceToken: token,
+			Success:     false,
+			Error:       err.Error(),
+			SentAt:      time.Now(),
+		})
+	}
+	return results
+}
+
+// sends one multicast request of at most maxMulticastTokens tokens
+func (s *FCMService) sendMulticastChunk(ctx context.Context, deviceTokens []string, notification *models.PushNotification) ([]*models.NotificationResult, error) {
+	results := make([]*models.NotificationResult, 0, len(deviceTokens))
+
+	// wait for outbound quota before the breaker, so throttling never trips it
+	if err := s.throttle.Acquire(ctx, len(deviceTokens)); err != nil {
+		results = failedResults(deviceTokens, err)
+		logger.Warn("Outbound throughput limit reached", logger.Fields{
+			"tokens_len": len(deviceTokens),
+		})
+		return results, err
+	}
+
 	// check circuit breaker
 	if err := s.circuitBreaker.Call(func() error {
 
@@ -180,20 +256,13 @@ func (s *FCMService) SendToMultipleDevices(ctx context.Context, deviceTokens []s
 				},
 			},
 			Android: &messaging.AndroidConfig{
-				Priority: "high",
-				Notification: &messaging.AndroidNotification{
-					Title:       notification.Title,
-					Body:        notification.Body,
-					ClickAction: notification.Link,
-				},
+				Priority:     "high",
+				Notification: androidNotification(notification),
 			},
 			APNS: &messaging.APNSConfig{
 				Payload: &messaging.APNSPayload{
 					Aps: &messaging.Aps{
-						Alert: &messaging.ApsAlert{
-							Title: notification.Title,
-							Body:  notification.Body,
-						},
+						Alert: apsAlert(notification),
 						Sound: "default",
 					},
 				},
@@ -295,6 +364,10 @@ func (s *FCMService) ValidateDeviceToken(ctx context.Context, deviceToken string
 	// dry run to validate token
 	_, err := s.client.SendDryRun(ctx, message)
 	if err != nil {
+		if !isInvalidTokenError(err) {
+			// the token could not be checked, it is not known to be invalid
+			return nil, fmt.Errorf("failed to validate device token: %w", err)
+		}
 		validation.Valid = false
 		validation.Reason = err.Error()
 		return validation, nil
@@ -304,6 +377,36 @@ func (s *FCMService) ValidateDeviceToken(ctx context.Context, deviceToken string
 	return validation, nil
 }
 
+// reports whether FCM rejected the token itself rather than failing to send
+func isInvalidTokenError(err error) bool {
+	return messaging.IsUnregistered(err) || messaging.IsInvalidArgument(err) || messaging.IsSenderIDMismatch(err)
+}
+
+// builds the android notification, localization keys are resolved on the device
+func androidNotification(notification *models.PushNotification) *messaging.AndroidNotification {
+	return &messaging.AndroidNotification{
+		Title:        notification.Title,
+		Body:         notification.Body,
+		ClickAction:  notification.Link,
+		TitleLocKey:  notification.TitleLocKey,
+		TitleLocArgs: notification.TitleLocArgs,
+		BodyLocKey:   notification.BodyLocKey,
+		BodyLocArgs:  notification.BodyLocArgs,
+	}
+}
+
+// builds the apns alert, localization keys are resolved on the device
+func apsAlert(notification *models.PushNotification) *messaging.ApsAlert {
+	return &messaging.ApsAlert{
+		Title:        notification.Title,
+		Body:         notification.Body,
+		TitleLocKey:  notification.TitleLocKey,
+		TitleLocArgs: notification.TitleLocArgs,
+		LocKey:       notification.BodyLocKey,
+		LocArgs:      notification.BodyLocArgs,
+	}
+}
+
 // converts map[string]interface{} to map[string]string for FCM
 func convertDataToString(data map[string]interface{}) map[string]string {
 	if data == nil {
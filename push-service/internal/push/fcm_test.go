@@ -0,0 +1,58 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+// tests a failed chunk leaves the earlier results and reports how many tokens were sent
+func TestSendInChunks(t *testing.T) {
+	tokens := []string{"t1", "t2", "t3", "t4", "t5"}
+	unavailable := errors.New("fcm unavailable")
+
+	var sentChunks [][]string
+	send := func(ctx context.Context, chunk []string) ([]*models.NotificationResult, error) {
+		sentChunks = append(sentChunks, chunk)
+		if len(sentChunks) == 2 {
+			return nil, unavailable
+		}
+
+		results := make([]*models.NotificationResult, 0, len(chunk))
+		for _, token := range chunk {
+			results = append(results, &models.NotificationResult{DeviceToken: token, Success: true})
+		}
+		return results, nil
+	}
+
+	results, err := sendInChunks(context.Background(), tokens, 2, send)
+
+	var partial *PartialSendError
+	if !errors.As(err, &partial) {
+		t.Fatalf("Expected a PartialSendError, got %v", err)
+	}
+	if partial.Sent != 2 {
+		t.Errorf("Expected 2 sent tokens, got %d", partial.Sent)
+	}
+	if !errors.Is(err, unavailable) {
+		t.Errorf("Expected the chunk error to be wrapped, got %v", err)
+	}
+	if len(sentChunks) != 2 {
+		t.Errorf("Expected the third chunk not to be attempted, got %d chunks", len(sentChunks))
+	}
+
+	if len(results) != len(tokens) {
+		t.Fatalf("Expected a result per token, got %d", len(results))
+	}
+	for i, result := range results {
+		if result.DeviceToken != tokens[i] {
+			t.Errorf("Expected result %d for %s, got %s", i, tokens[i], result.DeviceToken)
+		}
+		if expected := i < 2; result.Success != expected {
+			t.Errorf("Expected success %v for %s, got %v", expected, result.DeviceToken, result.Success)
+		}
+	}
+
+}
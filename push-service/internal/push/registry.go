@@ -0,0 +1,302 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/config"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
+	"golang.org/x/sync/singleflight"
+)
+
+// FCM credentials of one tenant app, loaded from a json file in the apps directory
+type AppConfig struct {
+	TenantID        string   `json:"tenant_id"`
+	AppID           string   `json:"app_id,omitempty"` // empty for the tenant's default app
+	ProjectID       string   `json:"project_id"`
+	CredentialsFile string   `json:"credentials_file"`         // relative to the apps directory
+	ProjectRate     int      `json:"project_rate,omitempty"`   // sends per second, the configured project rate when 0
+	WebhookURL      string   `json:"webhook_url,omitempty"`    // receives status events of the app's notifications
+	WebhookSecret   string   `json:"webhook_secret,omitempty"` // signs webhook requests, the configured secret when empty
+	CallbackHosts   []string `json:"callback_hosts,omitempty"` // hosts a callback_url may name, like hooks.acme.com or *.acme.com
+}
+
+// how long a failed app initialization is returned before it is tried again
+const initFailureTTL = 30 * time.Second
+
+// an app initialization that failed, returned until it expires
+type initFailure struct {
+	err   error
+	until time.Time
+}
+
+// Registry hands out one FCM service per tenant app. services are built on
+// first use, each with its own circuit breaker and project throughput bucket,
+// so one tenant's outage or quota does not affect the others. credentials are
+// loaded outside the registry lock, once per app however many callers wait
+type Registry struct {
+	defaultService *FCMService // used for messages without a tenant
+	cache          *cache.RedisCache
+	fcm            config.FCMConfig
+	circuit        config.CircuitBreakerConfig
+	throughput     config.ThroughputConfig
+
+	mutex    sync.Mutex
+	apps     map[string]*AppConfig
+	services map[string]*FCMService
+	breakers map[string]*CircuitBreaker
+	failures map[string]*initFailure
+
+	initGroup singleflight.Group
+	build     func(ctx context.Context, app *AppConfig) (*FCMService, *CircuitBreaker, error)
+}
+
+func NewRegistry(defaultService *FCMService, redisCache *cache.RedisCache, fcm config.FCMConfig, circuit config.CircuitBreakerConfig, throughput config.ThroughputConfig) *Registry {
+	r := &Registry{
+		defaultService: defaultService,
+		cache:          redisCache,
+		fcm:            fcm,
+		circuit:        circuit,
+		throughput:     throughput,
+		apps:           make(map[string]*AppConfig),
+		services:       make(map[string]*FCMService),
+		breakers:       make(map[string]*CircuitBreaker),
+		failures:       make(map[string]*initFailure),
+	}
+	r.build = r.buildService
+	return r
+}
+
+// loads every app config in the apps directory, a missing directory leaves
+// only the default app
+func (r *Registry) LoadDir() error {
+	dir := r.fcm.AppsDir
+	if dir == "" {
+		return nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list app configs: %w", err)
+	}
+
+	loaded := make(map[string]*AppConfig, len(files))
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read app config %s: %w", file, err)
+		}
+
+		app := &AppConfig{}
+		if err := json.Unmarshal(raw, app); err != nil {
+			return fmt.Errorf("failed to decode app config %s: %w", file, err)
+		}
+		if app.TenantID == "" || app.ProjectID == "" || app.CredentialsFile == "" {
+			return fmt.Errorf("app config %s requires tenant_id, project_id and credentials_file", file)
+		}
+		if !filepath.IsAbs(app.CredentialsFile) {
+			app.CredentialsFile = filepath.Join(dir, app.CredentialsFile)
+		}
+
+		loaded[appKey(app.TenantID, app.AppID)] = app
+	}
+
+	r.mutex.Lock()
+	for key, app := range loaded {
+		r.apps[key] = app
+	}
+	r.mutex.Unlock()
+
+	logger.Info("FCM app configs loaded", logger.Fields{
+		"dir":   dir,
+		"count": len(loaded),
+	})
+
+	return nil
+}
+
+// returns the FCM service of a tenant app, building it on first use. messages
+// without a tenant use the default app, unknown apps use the tenant's default
+// app. an app that failed to initialize returns the same error for a short while
+func (r *Registry) Service(ctx context.Context, tenantID, appID string) (*FCMService, error) {
+	if tenantID == "" {
+		return r.defaultService, nil
+	}
+
+	key, app, service, err := r.lookup(tenantID, appID)
+	if service != nil || err != nil {
+		return service, err
+	}
+
+	// callers of the same app share one initialization, which is not cancelled
+	// when the caller that started it goes away
+	initCtx := context.WithoutCancel(ctx)
+	result, err, _ := r.initGroup.Do(key, func() (interface{}, error) {
+		if _, _, service, err := r.lookup(tenantID, appID); service != nil || err != nil {
+			return service, err
+		}
+
+		service, breaker, err := r.build(initCtx, app)
+
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		if err != nil {
+			err = fmt.Errorf("failed to initialize FCM service for tenant %s: %w", tenantID, err)
+			r.failures[key] = &initFailure{err: err, until: time.Now().Add(initFailureTTL)}
+			return nil, err
+		}
+
+		delete(r.failures, key)
+		r.services[key] = service
+		r.breakers[key] = breaker
+		return service, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*FCMService), nil
+}
+
+// returns the registry key and config of a tenant app with its service when
+// built, or the error of an unknown app or a recent failed initialization
+func (r *Registry) lookup(tenantID, appID string) (string, *AppConfig, *FCMService, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	key := appKey(tenantID, appID)
+	app, ok := r.apps[key]
+	if !ok {
+		key = appKey(tenantID, "")
+		app, ok = r.apps[key]
+	}
+	if !ok {
+		return "", nil, nil, fmt.Errorf("%w: tenant %s app %s", models.ErrUnknownApp, tenantID, appID)
+	}
+
+	if service, ok := r.services[key]; ok {
+		return key, app, service, nil
+	}
+	if failure, ok := r.failures[key]; ok && time.Now().Before(failure.until) {
+		return key, app, nil, failure.err
+	}
+	return key, app, nil, nil
+}
+
+// loads the credentials of an app and builds its service and circuit breaker
+func (r *Registry) buildService(ctx context.Context, app *AppConfig) (*FCMService, *CircuitBreaker, error) {
+	projectRate := app.ProjectRate
+	if projectRate == 0 {
+		projectRate = r.throughput.ProjectRate
+	}
+
+	breaker := NewCircuitBreaker(
+		r.circuit.MaxRequests,
+		r.circuit.FailureThreshold,
+		time.Duration(r.circuit.Interval)*time.Second,
+		time.Duration(r.circuit.Timeout)*time.Second,
+	)
+
+	// the provider bucket is shared by every app, the project bucket is the app's own
+	throttle := NewThrottle(
+		r.cache,
+		"fcm",
+		r.throughput.ProviderRate,
+		app.ProjectID,
+		projectRate,
+		time.Duration(r.throughput.MaxWait)*time.Millisecond,
+	)
+
+	service, err := NewFCMService(ctx, app.ProjectID, app.CredentialsFile, r.fcm.Timeout, breaker, throttle)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logger.Info("FCM service initialized for tenant app", logger.Fields{
+		"tenant_id":  app.TenantID,
+		"app_id":     app.AppID,
+		"project_id": app.ProjectID,
+	})
+
+	return service, breaker, nil
+}
+
+// dry-run validates a device token with the credentials of its tenant app
+func (r *Registry) ValidateDeviceToken(ctx context.Context, tenantID, appID, deviceToken string) (*models.DeviceTokenValidation, error) {
+	service, err := r.Service(ctx, tenantID, appID)
+	if err != nil {
+		return nil, err
+	}
+	return service.ValidateDeviceToken(ctx, deviceToken)
+}
+
+// returns the webhook url and secret of a tenant app, falling back to the
+// tenant's default app. both are empty when no webhook is configured
+func (r *Registry) Webhook(tenantID, appID string) (string, string) {
+	if tenantID == "" {
+		return "", ""
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	app, ok := r.apps[appKey(tenantID, appID)]
+	if !ok || app.WebhookURL == "" {
+		app, ok = r.apps[appKey(tenantID, "")]
+	}
+	if !ok {
+		return "", ""
+	}
+	return app.WebhookURL, app.WebhookSecret
+}
+
+// returns the hosts the callback urls of a tenant app's notifications may name,
+// the app's own hosts and those of the tenant's default app
+func (r *Registry) CallbackHosts(tenantID, appID string) []string {
+	if tenantID == "" {
+		return nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	hosts := []string{}
+	if app, ok := r.apps[appKey(tenantID, appID)]; ok {
+		hosts = append(hosts, app.CallbackHosts...)
+	}
+	if appID != "" {
+		if app, ok := r.apps[appKey(tenantID, "")]; ok {
+			hosts = append(hosts, app.CallbackHosts...)
+		}
+	}
+	return hosts
+}
+
+// returns circuit breaker statistics of every initialized tenant app
+func (r *Registry) GetStats() map[string]interface{} {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stats := make(map[string]interface{}, len(r.breakers))
+	for key, breaker := range r.breakers {
+		stats[key] = breaker.GetStats()
+	}
+
+	return map[string]interface{}{
+		"configured":  len(r.apps),
+		"initialized": len(r.services),
+		"apps":        stats,
+	}
+}
+
+// registry key of a tenant app
+func appKey(tenantID, appID string) string {
+	return fmt.Sprintf("%s/%s", tenantID, appID)
+}
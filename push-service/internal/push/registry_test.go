@@ -0,0 +1,144 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/config"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+// tests app configs are loaded and messages are routed to their tenant app
+func TestRegistry(t *testing.T) {
+	dir := t.TempDir()
+	app := `{"tenant_id": "acme", "app_id": "shop", "project_id": "acme-shop", "credentials_file": "acme-shop.json"}`
+	if err := os.WriteFile(filepath.Join(dir, "acme-shop.json"), []byte(app), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	defaultService := &FCMService{}
+	registry := NewRegistry(defaultService, nil, config.FCMConfig{AppsDir: dir}, config.CircuitBreakerConfig{}, config.ThroughputConfig{})
+	if err := registry.LoadDir(); err != nil {
+		t.Fatalf("Expected no error loading app configs, got %v", err)
+	}
+
+	loaded := registry.apps[appKey("acme", "shop")]
+	if loaded == nil {
+		t.Fatal("Expected acme/shop to be loaded")
+	}
+	if loaded.CredentialsFile != filepath.Join(dir, "acme-shop.json") {
+		t.Errorf("Expected credentials relative to the apps directory, got %s", loaded.CredentialsFile)
+	}
+
+	t.Run("Messages without a tenant use the default app", func(t *testing.T) {
+		service, err := registry.Service(context.Background(), "", "shop")
+		if err != nil || service != defaultService {
+			t.Errorf("Expected default service, got %v %v", service, err)
+		}
+	})
+
+	t.Run("Unknown tenant is a permanent error", func(t *testing.T) {
+		_, err := registry.Service(context.Background(), "globex", "")
+		if !errors.Is(err, models.ErrUnknownApp) || !models.IsPermanent(err) {
+			t.Errorf("Expected permanent ErrUnknownApp, got %v", err)
+		}
+	})
+
+	t.Run("Webhook falls back to the tenant default app", func(t *testing.T) {
+		registry.apps[appKey("acme", "")] = &AppConfig{TenantID: "acme", WebhookURL: "https://acme.test/hooks", WebhookSecret: "s3cret"}
+
+		url, secret := registry.Webhook("acme", "shop")
+		if url != "https://acme.test/hooks" || secret != "s3cret" {
+			t.Errorf("Expected the default app webhook, got %q %q", url, secret)
+		}
+		if url, _ := registry.Webhook("", "shop"); url != "" {
+			t.Errorf("Expected no webhook without a tenant, got %q", url)
+		}
+	})
+
+	t.Run("Incomplete config is rejected", func(t *testing.T) {
+		if err := os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"tenant_id": "acme"}`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := registry.LoadDir(); err == nil {
+			t.Error("Expected error for config without credentials")
+		}
+	})
+}
+
+// tests concurrent callers share one app initialization and failures are kept briefly
+func TestRegistryServiceInit(t *testing.T) {
+	newRegistry := func() *Registry {
+		registry := NewRegistry(&FCMService{}, nil, config.FCMConfig{}, config.CircuitBreakerConfig{}, config.ThroughputConfig{})
+		registry.apps[appKey("acme", "")] = &AppConfig{TenantID: "acme", ProjectID: "acme"}
+		return registry
+	}
+
+	t.Run("Concurrent callers build once", func(t *testing.T) {
+		registry := newRegistry()
+		var builds atomic.Int32
+		release := make(chan struct{})
+		registry.build = func(ctx context.Context, app *AppConfig) (*FCMService, *CircuitBreaker, error) {
+			builds.Add(1)
+			<-release
+			return &FCMService{}, nil, nil
+		}
+
+		var wg sync.WaitGroup
+		services := make([]*FCMService, 5)
+		for i := range services {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				services[i], _ = registry.Service(context.Background(), "acme", "")
+			}(i)
+		}
+
+		// other tenants are not blocked by a slow initialization
+		if service, _ := registry.Service(context.Background(), "", ""); service != registry.defaultService {
+			t.Error("Expected the default app while another app initializes")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		if builds.Load() != 1 {
+			t.Errorf("Expected 1 build, got %d", builds.Load())
+		}
+		for _, service := range services {
+			if service == nil || service != services[0] {
+				t.Fatalf("Expected every caller to get the same service, got %v", services)
+			}
+		}
+	})
+
+	t.Run("Failures are cached", func(t *testing.T) {
+		registry := newRegistry()
+		var builds atomic.Int32
+		registry.build = func(ctx context.Context, app *AppConfig) (*FCMService, *CircuitBreaker, error) {
+			builds.Add(1)
+			return nil, nil, errors.New("credentials not found")
+		}
+
+		for i := 0; i < 3; i++ {
+			if _, err := registry.Service(context.Background(), "acme", ""); err == nil {
+				t.Fatal("Expected an initialization error")
+			}
+		}
+		if builds.Load() != 1 {
+			t.Errorf("Expected the failure to be reused, got %d builds", builds.Load())
+		}
+
+		registry.failures[appKey("acme", "")].until = time.Now()
+		if _, err := registry.Service(context.Background(), "acme", ""); err == nil || builds.Load() != 2 {
+			t.Errorf("Expected an expired failure to be retried, got %v after %d builds", err, builds.Load())
+		}
+	})
+}
@@ -0,0 +1,171 @@
+package push
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
+)
+
+// a rate limited bucket shared by every replica
+type throttleBucket struct {
+	scope string
+	key   string
+	rate  int // tokens per second
+}
+
+// Throttle limits outbound sends per provider and per project with token
+// buckets kept in redis, so the limit holds across all replicas
+type Throttle struct {
+	cache   *cache.RedisCache
+	buckets []throttleBucket
+	burst   int
+	maxWait time.Duration
+
+	acquired  atomic.Int64
+	throttled atomic.Int64
+	timeouts  atomic.Int64
+}
+
+// returns nil when every rate is disabled
+func NewThrottle(redisCache *cache.RedisCache, provider string, providerRate int, projectID string, projectRate int, maxWait time.Duration) *Throttle {
+	t := &Throttle{
+		cache:   redisCache,
+		maxWait: maxWait,
+	}
+
+	if providerRate > 0 {
+		t.buckets = append(t.buckets, throttleBucket{
+			scope: "provider",
+			key:   cache.GetThroughputKey("provider", provider),
+			rate:  providerRate,
+		})
+	}
+	if projectRate > 0 {
+		t.buckets = append(t.buckets, throttleBucket{
+			scope: "project",
+			key:   cache.GetThroughputKey("project", projectID),
+			rate:  projectRate,
+		})
+	}
+
+	if len(t.buckets) == 0 {
+		return nil
+	}
+
+	// a single acquire can never take more than the smallest bucket holds
+	t.burst = t.buckets[0].rate
+	for _, b := range t.buckets {
+		if b.rate < t.burst {
+			t.burst = b.rate
+		}
+	}
+
+	return t
+}
+
+// waits until n send tokens are available, up to the max wait. tokens are
+// taken in burst sized pieces, and the pieces already taken are given back
+// when the deadline would be passed or ctx is done, so a failed acquire
+// never drains the buckets. returns ErrThroughputExceeded on the deadline
+func (t *Throttle) Acquire(ctx context.Context, n int) error {
+	if t == nil {
+		return nil
+	}
+
+	keys := make([]string, len(t.buckets))
+	rates := make([]int, len(t.buckets))
+	for i, b := range t.buckets {
+		keys[i] = b.key
+		rates[i] = b.rate
+	}
+
+	deadline := time.Now().Add(t.maxWait)
+	taken := 0
+
+	for taken < n {
+		piece := min(n-taken, t.burst)
+
+		for {
+			wait, err := t.cache.AcquireThroughput(ctx, keys, rates, piece)
+			if err != nil {
+				logger.Error("Failed to acquire throughput tokens", logger.WithError(err))
+				return nil // continue on cache error
+			}
+
+			if wait == 0 {
+				break
+			}
+
+			t.throttled.Add(1)
+			if time.Now().Add(wait).After(deadline) {
+				t.timeouts.Add(1)
+				t.release(keys, rates, taken)
+				return models.ErrThroughputExceeded
+			}
+
+			select {
+			case <-ctx.Done():
+				t.release(keys, rates, taken)
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+
+		taken += piece
+	}
+
+	t.acquired.Add(int64(taken))
+	return nil
+}
+
+// gives back tokens of an acquire that did not complete
+func (t *Throttle) release(keys []string, rates []int, taken int) {
+	if taken == 0 {
+		return
+	}
+
+	// the request context may be done already
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := t.cache.ReleaseThroughput(ctx, keys, rates, taken); err != nil {
+		logger.Error("Failed to release throughput tokens", logger.WithError(err))
+	}
+}
+
+// return throughput statistics
+func (t *Throttle) GetStats(ctx context.Context) map[string]interface{} {
+	if t == nil {
+		return map[string]interface{}{"enabled": false}
+	}
+
+	buckets := make(map[string]interface{}, len(t.buckets))
+	for _, b := range t.buckets {
+		stats := map[string]interface{}{
+			"rate": b.rate,
+		}
+
+		level, err := t.cache.ThroughputLevel(ctx, b.key, b.rate)
+		if err != nil {
+			stats["error"] = err.Error()
+		} else {
+			stats["available"] = level
+			stats["utilization"] = 1 - level/float64(b.rate)
+		}
+
+		buckets[b.scope] = stats
+	}
+
+	return map[string]interface{}{
+		"enabled":        true,
+		"buckets":        buckets,
+		"max_wait":       t.maxWait.String(),
+		"acquired_total": t.acquired.Load(),
+		"throttled":      t.throttled.Load(),
+		"timeouts":       t.timeouts.Load(),
+	}
+}
@@ -0,0 +1,78 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+// tests throttle construction from configured rates
+func TestNewThrottle(t *testing.T) {
+	t.Run("Disabled when no rate is set", func(t *testing.T) {
+		throttle := NewThrottle(nil, "fcm", 0, "project", 0, time.Second)
+		if throttle != nil {
+			t.Fatalf("Expected nil throttle, got %+v", throttle)
+		}
+
+		// a disabled throttle never blocks
+		if err := throttle.Acquire(context.Background(), 1000); err != nil {
+			t.Errorf("Expected no error from disabled throttle, got %v", err)
+		}
+
+		if stats := throttle.GetStats(context.Background()); stats["enabled"] != false {
+			t.Errorf("Expected disabled stats, got %v", stats)
+		}
+	})
+
+	t.Run("Burst is the smallest rate", func(t *testing.T) {
+		throttle := NewThrottle(nil, "fcm", 600, "project", 200, time.Second)
+		if throttle == nil {
+			t.Fatal("Expected throttle to be enabled")
+		}
+
+		if len(throttle.buckets) != 2 {
+			t.Errorf("Expected 2 buckets, got %d", len(throttle.buckets))
+		}
+
+		if throttle.burst != 200 {
+			t.Errorf("Expected burst 200, got %d", throttle.burst)
+		}
+	})
+}
+
+// tests an acquire that passes its deadline gives back the pieces it took
+func TestThrottleAcquireRefund(t *testing.T) {
+	redisCache, err := cache.NewRedisCache(miniredis.RunT(t).Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("failed to connect to miniredis: %v", err)
+	}
+	t.Cleanup(func() { redisCache.Close() })
+
+	throttle := NewThrottle(redisCache, "fcm", 10, "project", 0, 50*time.Millisecond)
+
+	// the first piece empties the bucket, the second can not be had in time
+	err = throttle.Acquire(context.Background(), 25)
+	if !errors.Is(err, models.ErrThroughputExceeded) {
+		t.Fatalf("Expected ErrThroughputExceeded, got %v", err)
+	}
+
+	level, err := redisCache.ThroughputLevel(context.Background(), throttle.buckets[0].key, 10)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if level < 10 {
+		t.Errorf("Expected the taken tokens to be given back, got level %v", level)
+	}
+
+	if err := throttle.Acquire(context.Background(), 10); err != nil {
+		t.Errorf("Expected a full burst to be available, got %v", err)
+	}
+	if acquired := throttle.acquired.Load(); acquired != 10 {
+		t.Errorf("Expected 10 acquired tokens, got %d", acquired)
+	}
+}
@@ -5,23 +5,37 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/rabbitmq/amqp091-go"
 	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/id"
 	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
 )
 
+// source of the status events published by this service
+const eventSource = "/push-service"
+
+// binding patterns of the status queues on the status exchange
+const (
+	statusBindingKey = "push.status.#"
+	deviceBindingKey = "push.device.#"
+)
+
 // RabbitMQ wraps RabbitMQ connection and operations
 type RabbitMQ struct {
 	conn           *amqp091.Connection
 	channel        *amqp091.Channel
 	url            string
 	exchange       string
+	statusExchange string // topic exchange of status events
 	pushQueue      string
 	failedQueue    string
 	statusQueue    string
+	deviceQueue    string
 	prefetchCount  int
 	reconnectMutex sync.Mutex
 	isConnected    bool
@@ -30,17 +44,19 @@ type RabbitMQ struct {
 
 type MessageHandler func(ctx context.Context, msg *models.NotificationMessage) error
 
-func NewRabbitMQ(url, exchange, pushQueue, failedQueue, statusQueue string, prefetchCount int) (*RabbitMQ, error) {
+func NewRabbitMQ(url, exchange, statusExchange, pushQueue, failedQueue, statusQueue string, prefetchCount int) (*RabbitMQ, error) {
 	logger.Info("initializing rabbitmq connection")
 
 	rmq := &RabbitMQ{
-		url:           url,
-		exchange:      exchange,
-		pushQueue:     pushQueue,
-		failedQueue:   failedQueue,
-		statusQueue:   statusQueue,
-		prefetchCount: prefetchCount,
-		isConnected:   false,
+		url:            url,
+		exchange:       exchange,
+		statusExchange: statusExchange,
+		pushQueue:      pushQueue,
+		failedQueue:    failedQueue,
+		statusQueue:    statusQueue,
+		deviceQueue:    statusQueue + ".devices",
+		prefetchCount:  prefetchCount,
+		isConnected:    false,
 	}
 
 	if err := rmq.connect(); err != nil {
@@ -82,6 +98,19 @@ func (r *RabbitMQ) connect() error {
 		return fmt.Errorf("failed to declare exchange: %w", err)
 	}
 
+	// status events go to a topic exchange, subscribers bind their own queues and filters
+	if err := r.channel.ExchangeDeclare(
+		r.statusExchange,
+		"topic",
+		true,
+		false,
+		false,
+		false,
+		nil,
+	); err != nil {
+		return fmt.Errorf("failed to declare status exchange: %w", err)
+	}
+
 	// declare and bind push queue
 	if _, err := r.channel.QueueDeclare(
 		r.pushQueue,
@@ -104,6 +133,38 @@ func (r *RabbitMQ) connect() error {
 		return fmt.Errorf("failed to bind push queue: %w", err)
 	}
 
+	// declare and bind one delay queue per delay bucket, expired messages are
+	// dead-lettered back to the push queue. every message in a queue shares its
+	// ttl, so the head always expires first and no message waits behind a
+	// longer one
+	for _, bucket := range delayBuckets {
+		queueName := r.delayQueue(bucket)
+		if _, err := r.channel.QueueDeclare(
+			queueName,
+			true,
+			false,
+			false,
+			false,
+			amqp091.Table{
+				"x-message-ttl":             bucket.Milliseconds(),
+				"x-dead-letter-exchange":    r.exchange,
+				"x-dead-letter-routing-key": r.pushQueue,
+			},
+		); err != nil {
+			return fmt.Errorf("failed to declare delay queue %s: %w", queueName, err)
+		}
+
+		if err := r.channel.QueueBind(
+			queueName,
+			queueName,
+			r.exchange,
+			false,
+			nil,
+		); err != nil {
+			return fmt.Errorf("failed to bind delay queue %s: %w", queueName, err)
+		}
+	}
+
 	// declare and bind failed queue
 	if _, err := r.channel.QueueDeclare(
 		r.failedQueue,
@@ -148,13 +209,48 @@ func (r *RabbitMQ) connect() error {
 		return fmt.Errorf("failed to bind status queue: %w", err)
 	}
 
+	if err := r.channel.QueueBind(
+		r.statusQueue,
+		statusBindingKey,
+		r.statusExchange,
+		false,
+		nil,
+	); err != nil {
+		return fmt.Errorf("failed to bind status queue to status exchange: %w", err)
+	}
+
+	// declare and bind device status queue, it only receives events when device events are enabled
+	if _, err := r.channel.QueueDeclare(
+		r.deviceQueue,
+		true,
+		false,
+		false,
+		false,
+		nil,
+	); err != nil {
+		return fmt.Errorf("failed to declare device status queue: %w", err)
+	}
+
+	if err := r.channel.QueueBind(
+		r.deviceQueue,
+		deviceBindingKey,
+		r.statusExchange,
+		false,
+		nil,
+	); err != nil {
+		return fmt.Errorf("failed to bind device status queue: %w", err)
+	}
+
 	r.isConnected = true
 
 	logger.Info("Connected to RabbitMQ successfully", logger.Fields{
-		"exchange":     r.exchange,
-		"push_queue":   r.pushQueue,
-		"failed_queue": r.failedQueue,
-		"status_queue": r.statusQueue,
+		"exchange":        r.exchange,
+		"status_exchange": r.statusExchange,
+		"push_queue":      r.pushQueue,
+		"delay_queues":    len(delayBuckets),
+		"failed_queue":    r.failedQueue,
+		"status_queue":    r.statusQueue,
+		"device_queue":    r.deviceQueue,
 	})
 
 	go r.handleReconnection()
@@ -250,6 +346,11 @@ func (r *RabbitMQ) Consume(ctx context.Context, handler MessageHandler) error {
 
 					}
 
+					// permanent errors were not retried
+					if models.IsPermanent(err) {
+						logger.Warn("Permanent failure, message sent to failed queue without retrying", logDetails)
+					}
+
 					// sends the message to failed queue, retry logic has been handled in the service layer
 					r.PublishFailed(ctx, &notification, err.Error())
 
@@ -297,6 +398,143 @@ func (r *RabbitMQ) Publish(ctx context.Context, queueName string, message interf
 	return nil
 }
 
+// publishes notifications on a dedicated confirm mode channel without waiting
+// between messages, then waits for the broker to confirm each. the returned
+// errors line up with the messages, nil for a confirmed message
+func (r *RabbitMQ) PublishBatch(ctx context.Context, routingKey string, messages []*models.NotificationMessage) []error {
+	errs := make([]error, len(messages))
+	fail := func(err error) []error {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+		return errs
+	}
+
+	if r.conn == nil || r.conn.IsClosed() {
+		return fail(fmt.Errorf("%w: connection is closed", models.ErrMessagePublishFailed))
+	}
+
+	channel, err := r.conn.Channel()
+	if err != nil {
+		return fail(fmt.Errorf("%w: failed to open channel: %w", models.ErrMessagePublishFailed, err))
+	}
+	defer channel.Close()
+
+	if err := channel.Confirm(false); err != nil {
+		return fail(fmt.Errorf("%w: failed to enable confirms: %w", models.ErrMessagePublishFailed, err))
+	}
+
+	confirms := make([]*amqp091.DeferredConfirmation, len(messages))
+	for i, message := range messages {
+		body, err := json.Marshal(message)
+		if err != nil {
+			errs[i] = fmt.Errorf("failed to marshal message: %w", err)
+			continue
+		}
+
+		confirms[i], err = channel.PublishWithDeferredConfirmWithContext(
+			ctx,
+			r.exchange,
+			routingKey,
+			false,
+			false,
+			amqp091.Publishing{
+				ContentType:  "application/json",
+				DeliveryMode: amqp091.Persistent,
+				Timestamp:    time.Now(),
+				Body:         body,
+			},
+		)
+		if err != nil {
+			// the channel is unusable after a failed publish
+			errs[i] = fmt.Errorf("%w: %w", models.ErrMessagePublishFailed, err)
+			for j := i + 1; j < len(messages); j++ {
+				errs[j] = errs[i]
+			}
+			break
+		}
+	}
+
+	for i, confirm := range confirms {
+		if confirm == nil {
+			continue
+		}
+		acked, err := confirm.WaitContext(ctx)
+		switch {
+		case err != nil:
+			errs[i] = fmt.Errorf("%w: confirm not received: %w", models.ErrMessagePublishFailed, err)
+		case !acked:
+			errs[i] = fmt.Errorf("%w: rejected by the broker", models.ErrMessagePublishFailed)
+		}
+	}
+
+	return errs
+}
+
+// delays a message can wait in a delay queue for
+var delayBuckets = []time.Duration{
+	time.Second,
+	5 * time.Second,
+	15 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	time.Hour,
+}
+
+// returns the shortest delay bucket not shorter than delay, or the longest bucket
+func delayBucket(delay time.Duration) time.Duration {
+	for _, bucket := range delayBuckets {
+		if delay <= bucket {
+			return bucket
+		}
+	}
+	return delayBuckets[len(delayBuckets)-1]
+}
+
+// name of the delay queue of a bucket
+func (r *RabbitMQ) delayQueue(bucket time.Duration) string {
+	return fmt.Sprintf("%s.delay.%d", r.pushQueue, bucket.Milliseconds())
+}
+
+// re-enqueues a notification on the push queue after the given delay, rounded
+// up to the next delay bucket
+func (r *RabbitMQ) PublishDelayed(ctx context.Context, notification *models.NotificationMessage, delay time.Duration) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	bucket := delayBucket(delay)
+	logger.Info("Publishing notification to delay queue", logger.Merge(
+		logger.WithNotificationID(notification.ID),
+		logger.Fields{"delay": delay.String(), "bucket": bucket.String()},
+	))
+
+	err = r.channel.PublishWithContext(
+		ctx,
+		r.exchange,
+		r.delayQueue(bucket),
+		false,
+		false,
+		amqp091.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp091.Persistent,
+			Timestamp:    time.Now(),
+			Body:         body,
+		},
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to publish delayed message: %w", err)
+	}
+
+	return nil
+}
+
 func (r *RabbitMQ) PublishFailed(ctx context.Context, notification *models.NotificationMessage, reason string) error {
 	failedMsg := models.FailedMessage{
 		OriginalMessage: *notification,
@@ -327,9 +565,88 @@ func (r *RabbitMQ) PublishStatus(ctx context.Context, statusMsg *models.Notifica
 		logger.WithUserID(statusMsg.UserID),
 	)
 
-	logger.Info("Publishing notification status to status queue", logDetails)
+	routingKey := StatusRoutingKey("status", statusMsg.Status, statusMsg.TemplateCode)
 
-	return r.Publish(ctx, r.statusQueue, statusMsg)
+	logger.Info("Publishing notification status event", logger.Merge(logDetails, logger.Fields{
+		"routing_key": routingKey,
+	}))
+
+	return r.publishEvent(ctx, routingKey, statusMsg.NotificationID, statusMsg.Sequence, statusMsg.Timestamp, statusMsg)
+}
+
+// publishes the outcome of a send to one device to the device status queue
+func (r *RabbitMQ) PublishDeviceStatus(ctx context.Context, deviceMsg *models.DeviceStatusMessage) error {
+	logger.Debug("Publishing device status to device status queue", logger.Merge(
+		logger.Fields{
+			"status":   deviceMsg.Status,
+			"sequence": deviceMsg.Sequence,
+		},
+		logger.WithNotificationID(deviceMsg.NotificationID),
+		logger.WithDeviceToken(deviceMsg.DeviceToken),
+	))
+
+	routingKey := StatusRoutingKey("device", deviceMsg.Status, deviceMsg.TemplateCode)
+
+	return r.publishEvent(ctx, routingKey, deviceMsg.NotificationID, deviceMsg.Sequence, deviceMsg.Timestamp, deviceMsg)
+}
+
+// wraps data in a CloudEvents envelope and publishes it to the status exchange
+func (r *RabbitMQ) publishEvent(ctx context.Context, routingKey, subject string, sequence int64, timestamp time.Time, data interface{}) error {
+	body, err := json.Marshal(NewStatusEvent(routingKey, subject, sequence, timestamp, data))
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	err = r.channel.PublishWithContext(
+		ctx,
+		r.statusExchange,
+		routingKey,
+		false,
+		false,
+		amqp091.Publishing{
+			ContentType:  "application/cloudevents+json",
+			DeliveryMode: amqp091.Persistent,
+			Timestamp:    time.Now(),
+			Body:         body,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to publish event: %w", err)
+	}
+
+	return nil
+}
+
+// builds the CloudEvents envelope of a status event, its type mirrors the routing key
+func NewStatusEvent(routingKey, subject string, sequence int64, timestamp time.Time, data interface{}) *models.CloudEvent {
+	event := &models.CloudEvent{
+		SpecVersion:     models.CloudEventsSpecVersion,
+		ID:              id.Generate(),
+		Source:          eventSource,
+		Type:            "com.notification." + routingKey,
+		Subject:         subject,
+		Time:            timestamp,
+		DataContentType: "application/json",
+		Data:            data,
+	}
+	if sequence > 0 {
+		event.Sequence = strconv.FormatInt(sequence, 10)
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	return event
+}
+
+// returns the routing key of a status event, e.g. push.status.delivered.welcome.
+// dots in the template code are replaced so it stays one segment, inline
+// content uses "inline"
+func StatusRoutingKey(kind string, status models.NotificationStatusEnum, templateCode string) string {
+	template := strings.ReplaceAll(templateCode, ".", "_")
+	if template == "" {
+		template = "inline"
+	}
+	return fmt.Sprintf("push.%s.%s.%s", kind, status, template)
 }
 
 func (r *RabbitMQ) Health() error {
@@ -0,0 +1,93 @@
+package queue
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+// tests routing keys keep the template code in a single segment
+func TestStatusRoutingKey(t *testing.T) {
+	testCases := []struct {
+		kind         string
+		status       models.NotificationStatusEnum
+		templateCode string
+		expected     string
+	}{
+		{"status", models.NotificationStatusDelivered, "welcome", "push.status.delivered.welcome"},
+		{"status", models.NotificationStatusFailed, "", "push.status.failed.inline"},
+		{"status", models.NotificationStatusPartiallyDelivered, "order.shipped", "push.status.partially_delivered.order_shipped"},
+		{"device", models.NotificationStatusDelivered, "welcome", "push.device.delivered.welcome"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expected, func(t *testing.T) {
+			if key := StatusRoutingKey(tc.kind, tc.status, tc.templateCode); key != tc.expected {
+				t.Errorf("Expected %s, got %s", tc.expected, key)
+			}
+		})
+	}
+}
+
+// tests the CloudEvents envelope carries the required attributes
+func TestNewStatusEvent(t *testing.T) {
+	timestamp := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	statusMsg := &models.NotificationStatusMessage{NotificationID: "n1", Sequence: 4, Status: models.NotificationStatusDelivered}
+
+	event := NewStatusEvent("push.status.delivered.welcome", "n1", 4, timestamp, statusMsg)
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"specversion":     "1.0",
+		"source":          "/push-service",
+		"type":            "com.notification.push.status.delivered.welcome",
+		"subject":         "n1",
+		"time":            "2025-01-02T03:04:05Z",
+		"datacontenttype": "application/json",
+		"sequence":        "4",
+	}
+	for key, value := range expected {
+		if decoded[key] != value {
+			t.Errorf("Expected %s %v, got %v", key, value, decoded[key])
+		}
+	}
+	if decoded["id"] == "" {
+		t.Error("Expected an event id")
+	}
+	if data, ok := decoded["data"].(map[string]interface{}); !ok || data["notification_id"] != "n1" {
+		t.Errorf("Expected the status message as data, got %v", decoded["data"])
+	}
+}
+
+// tests delays are rounded up to the next delay queue
+func TestDelayBucket(t *testing.T) {
+	testCases := []struct {
+		delay    time.Duration
+		expected time.Duration
+	}{
+		{0, time.Second},
+		{time.Second, time.Second},
+		{1200 * time.Millisecond, 5 * time.Second},
+		{40 * time.Second, time.Minute},
+		{3 * time.Hour, time.Hour},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.delay.String(), func(t *testing.T) {
+			if bucket := delayBucket(tc.delay); bucket != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, bucket)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/config"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/middleware"
+)
+
+// builds the authenticators configured for the HTTP API, API keys first.
+// returns nil when authentication is disabled
+func NewAuthenticator(cfg config.AuthConfig) (middleware.Authenticator, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	var authenticators middleware.Authenticators
+
+	apiKeys, err := middleware.NewAPIKeyAuthenticator(cfg.APIKeys)
+	if err != nil {
+		return nil, err
+	}
+	if apiKeys.Len() > 0 {
+		authenticators = append(authenticators, apiKeys)
+	}
+
+	if cfg.JWTSecret != "" || cfg.JWTPublicKeyFile != "" {
+		options := middleware.JWTOptions{
+			Secret:        cfg.JWTSecret,
+			Issuer:        cfg.JWTIssuer,
+			Audience:      cfg.JWTAudience,
+			DefaultScopes: strings.Fields(cfg.JWTDefaultScopes),
+		}
+		if cfg.JWTPublicKeyFile != "" {
+			if options.PublicKeyPEM, err = os.ReadFile(cfg.JWTPublicKeyFile); err != nil {
+				return nil, fmt.Errorf("failed to read jwt public key: %w", err)
+			}
+		}
+
+		jwtAuth, err := middleware.NewJWTAuthenticator(options)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, jwtAuth)
+	}
+
+	if len(authenticators) == 0 {
+		return nil, fmt.Errorf("authentication is enabled but no api keys or jwt keys are configured")
+	}
+
+	return authenticators, nil
+}
@@ -21,8 +21,17 @@ type Server struct {
 func NewServer(
 	host string,
 	port int,
+	allowedOrigins []string,
+	authenticator middleware.Authenticator,
+	templateServiceKey string,
 	healthHandler *handler.HealthHandler,
 	notificationHandler *handler.NotificationHandler,
+	statsHandler *handler.StatsHandler,
+	templateHandler *handler.TemplateHandler,
+	deviceHandler *handler.DeviceHandler,
+	webhookHandler *handler.WebhookHandler,
+	streamHandler *handler.StreamHandler,
+	campaignHandler *handler.CampaignHandler,
 ) *Server {
 	router := mux.NewRouter()
 
@@ -30,18 +39,85 @@ func NewServer(
 
 	router.Use(middleware.LoggingMiddleware)
 
-	allowedOrigins := []string{
-		"*",
-	}
 	router.Use(middleware.CorsMiddleware(allowedOrigins))
 
+	// routes need an authenticated caller granted one of the scopes, open when auth is disabled
+	requireScope := func(scopes ...string) []mux.MiddlewareFunc {
+		if authenticator == nil {
+			return nil
+		}
+		return []mux.MiddlewareFunc{
+			middleware.AuthMiddleware(authenticator),
+			middleware.RequireScope(scopes...),
+		}
+	}
+
 	// health check
 	router.HandleFunc("/health", healthHandler.HandleHealth).Methods("GET")
 
+	// runtime stats
+	stats := router.PathPrefix("/stats").Subrouter()
+	stats.Use(requireScope(middleware.ScopeAdmin)...)
+	stats.HandleFunc("", statsHandler.HandleStats).Methods("GET")
+
+	// token validation, registered ahead of the notification routes for its own scope
+	tokens := router.PathPrefix("/notifications/validate-tokens").Subrouter()
+	tokens.Use(requireScope(middleware.ScopeTokensValidate)...)
+	tokens.HandleFunc("", notificationHandler.ValidateDeviceTokens).Methods("POST")
+
+	// notification creation, also open to end users sending to themselves
+	create := router.PathPrefix("/notifications").Subrouter()
+	create.Use(requireScope(middleware.ScopeNotificationsSend, middleware.ScopeNotificationsSelf)...)
+	create.HandleFunc("/", notificationHandler.CreateNotification).Methods("POST")
+
 	// Notification endpoints
 	notifications := router.PathPrefix("/notifications").Subrouter()
-	notifications.HandleFunc("/", notificationHandler.CreateNotification).Methods("POST")
-	notifications.HandleFunc("/validate-tokens", notificationHandler.ValidateDeviceTokens).Methods("POST")
+	notifications.Use(requireScope(middleware.ScopeNotificationsSend)...)
+	notifications.HandleFunc("", notificationHandler.ListNotifications).Methods("GET")
+	notifications.HandleFunc("/stream", streamHandler.StreamStatuses).Methods("GET")
+	notifications.HandleFunc("/batch", notificationHandler.CreateBatch).Methods("POST")
+	notifications.HandleFunc("/batch/{id}", notificationHandler.GetBatch).Methods("GET")
+	notifications.HandleFunc("/{id}", notificationHandler.GetNotification).Methods("GET")
+
+	// template cache endpoints, called by the template service when a template changes.
+	// they change shared state, so without auth they need the template service key
+	templates := router.PathPrefix("/templates").Subrouter()
+	switch {
+	case authenticator != nil:
+		templates.Use(requireScope(middleware.ScopeAdmin)...)
+		templates.HandleFunc("/invalidate", templateHandler.InvalidateTemplate).Methods("POST")
+	case templateServiceKey != "":
+		templates.Use(middleware.ServiceKeyMiddleware(templateServiceKey))
+		templates.HandleFunc("/invalidate", templateHandler.InvalidateTemplate).Methods("POST")
+	default:
+		logger.Warn("Template cache invalidation is disabled, set TEMPLATE_SERVICE_KEY or enable auth")
+	}
+
+	// device registry endpoints
+	devices := router.PathPrefix("/devices").Subrouter()
+	devices.Use(requireScope(middleware.ScopeNotificationsSend)...)
+	devices.HandleFunc("", deviceHandler.ListDevices).Methods("GET")
+	devices.HandleFunc("", deviceHandler.RegisterDevice).Methods("POST")
+	devices.HandleFunc("/{token}/refresh", deviceHandler.RefreshDevice).Methods("POST")
+	devices.HandleFunc("/{token}", deviceHandler.DeleteDevice).Methods("DELETE")
+
+	// status webhook deliveries
+	webhooks := router.PathPrefix("/webhooks").Subrouter()
+	webhooks.Use(requireScope(middleware.ScopeNotificationsSend)...)
+	webhooks.HandleFunc("/failed", webhookHandler.ListFailed).Methods("GET")
+	webhooks.HandleFunc("/deliveries/{id}", webhookHandler.GetDelivery).Methods("GET")
+
+	// campaign endpoints
+	campaigns := router.PathPrefix("/campaigns").Subrouter()
+	campaigns.Use(requireScope(middleware.ScopeNotificationsSend)...)
+	campaigns.HandleFunc("", campaignHandler.CreateCampaign).Methods("POST")
+	campaigns.HandleFunc("/{id}", campaignHandler.GetCampaign).Methods("GET")
+	campaigns.HandleFunc("/{id}/{action:pause|resume|cancel}", campaignHandler.ChangeState).Methods("POST")
+
+	// admin endpoints
+	admin := router.PathPrefix("/admin").Subrouter()
+	admin.Use(requireScope(middleware.ScopeAdmin)...)
+	admin.HandleFunc("/devices/hygiene", deviceHandler.HygieneReport).Methods("GET")
 
 	// swagger documentation
 	router.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
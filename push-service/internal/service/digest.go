@@ -0,0 +1,313 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/id"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
+)
+
+// max digest buffers claimed per flush sweep
+const digestClaimBatch = 100
+
+// time a claimed digest has to be flushed before another sweep claims it again
+const digestClaimLease = 5 * time.Minute
+
+// buffers a message into the user's digest for its group
+func (s *NotificationService) bufferForDigest(ctx context.Context, msg *models.NotificationMessage) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal digest item: %w", err)
+	}
+
+	key := cache.TenantKey(msg.TenantID, cache.GetDigestKey(msg.UserID, msg.DigestGroup))
+	flushAt := time.Now().Add(time.Duration(s.digest.Window) * time.Second)
+
+	// keep the buffer well past its window in case a flush sweep is missed
+	ttl := s.digest.Window + 3600
+
+	count, err := s.cache.AppendDigest(ctx, key, string(payload), flushAt, ttl)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Notification buffered for digest", logger.Merge(
+		logger.WithNotificationID(msg.ID),
+		logger.WithUserID(msg.UserID),
+		logger.Fields{
+			"digest_group": msg.DigestGroup,
+			"buffered":     count,
+		},
+	))
+
+	s.publishStatus(ctx, msg, nil, models.NotificationStatusPending, "Buffered for digest", 0, 0)
+
+	return nil
+}
+
+// periodically flushes digests whose window has elapsed
+func (s *NotificationService) RunDigestFlusher(ctx context.Context) {
+	interval := time.Duration(s.digest.FlushInterval) * time.Second
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	logger.Info("Digest flusher started", logger.Fields{
+		"window":   s.digest.Window,
+		"interval": interval.String(),
+	})
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Stopping digest flusher")
+			return
+		case <-ticker.C:
+			s.FlushDueDigests(ctx)
+		}
+	}
+}
+
+// flushes every digest that is due, one summary notification per buffer.
+// items are only removed once their summary reached a final outcome, a flush
+// that fails or never finishes is retried when the claim lease lapses
+func (s *NotificationService) FlushDueDigests(ctx context.Context) {
+	keys, err := s.cache.ClaimDueDigests(ctx, time.Now(), digestClaimBatch, digestClaimLease)
+	if err != nil {
+		logger.Error("Failed to claim due digests", logger.WithError(err))
+	}
+
+	for _, key := range keys {
+		items, err := s.cache.ReadDigest(ctx, key)
+		if err != nil {
+			logger.Error("Failed to read digest", logger.Merge(
+				logger.WithError(err),
+				logger.Fields{"digest_key": key},
+			))
+			continue
+		}
+
+		messages := make([]*models.NotificationMessage, 0, len(items))
+		for _, item := range items {
+			var msg models.NotificationMessage
+			if err := json.Unmarshal([]byte(item), &msg); err != nil {
+				logger.Error("Failed to unmarshal digest item", logger.Merge(
+					logger.WithError(err),
+					logger.Fields{"digest_key": key},
+				))
+				continue
+			}
+			messages = append(messages, &msg)
+		}
+
+		if len(messages) > 0 {
+			if err := s.sendDigest(ctx, messages); err != nil {
+				logger.Warn("Digest flush failed, retrying after the claim lease", logger.Merge(
+					logger.WithError(err),
+					logger.Fields{
+						"digest_key": key,
+						"lease":      digestClaimLease.String(),
+					},
+				))
+				continue
+			}
+		}
+
+		nextFlush := time.Now().Add(time.Duration(s.digest.Window) * time.Second)
+		if err := s.cache.CompleteDigest(ctx, key, len(items), nextFlush); err != nil {
+			logger.Error("Failed to complete digest", logger.Merge(
+				logger.WithError(err),
+				logger.Fields{"digest_key": key},
+			))
+		}
+	}
+}
+
+// renders and sends the summary of a digest and reports its outcome for the
+// summary and every buffered message. returns an error, without reporting,
+// when nothing could be sent for a reason that may pass, so the flush is retried
+func (s *NotificationService) sendDigest(ctx context.Context, messages []*models.NotificationMessage) error {
+	latest := messages[len(messages)-1]
+
+	summary := &models.NotificationMessage{
+		ID:               id.Generate(),
+		NotificationType: latest.NotificationType,
+		UserID:           latest.UserID,
+		TenantID:         latest.TenantID,
+		AppID:            latest.AppID,
+		TemplateCode:     latest.TemplateCode,
+		DeviceTokens:     mergeDeviceTokens(messages),
+		Platform:         latest.Platform,
+		Priority:         latest.Priority,
+		Locale:           latest.Locale,
+		CorrelationID:    latest.CorrelationID,
+		DigestGroup:      latest.DigestGroup,
+		CreatedAt:        time.Now(),
+	}
+
+	loggerDetails := logger.Merge(
+		logger.WithNotificationID(summary.ID),
+		logger.WithUserID(summary.UserID),
+		logger.Fields{
+			"digest_group": summary.DigestGroup,
+			"count":        len(messages),
+		},
+	)
+
+	// render only the latest items, newest first
+	maxItems := s.digest.MaxItems
+	if maxItems <= 0 || maxItems > len(messages) {
+		maxItems = len(messages)
+	}
+
+	var renderErr error
+	items := make([]*models.PushNotification, 0, maxItems)
+	for i := len(messages) - 1; i >= len(messages)-maxItems; i-- {
+		notification, err := s.prepareNotification(ctx, messages[i], nil, nil)
+		if err != nil {
+			logger.Warn("Skipping digest item that failed to render", logger.Merge(
+				loggerDetails,
+				logger.WithError(err),
+			))
+			renderErr = err
+			continue
+		}
+		items = append(items, notification)
+	}
+
+	aggregatedIDs := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		aggregatedIDs = append(aggregatedIDs, msg.ID)
+	}
+
+	if len(items) == 0 {
+		if !models.IsPermanent(renderErr) {
+			return fmt.Errorf("failed to render digest items: %w", renderErr)
+		}
+
+		logger.Error("Failed to render any digest item", loggerDetails)
+		s.publishDigestOutcome(ctx, summary, messages, nil, models.NotificationStatusFailed, "Failed to render digest items", 0, 0)
+		return nil
+	}
+
+	notification := buildDigestNotification(summary.DigestGroup, len(messages), items, aggregatedIDs)
+	notification.Priority = summary.Priority
+
+	logger.Info("Sending digest notification", loggerDetails)
+
+	results, err := s.sendNotification(ctx, summary, notification)
+	if err != nil {
+		// nothing was sent, e.g. the provider or the device registry is unavailable
+		if results == nil && !models.IsPermanent(err) && !errors.Is(err, models.ErrNoDeviceTokens) {
+			return fmt.Errorf("failed to send digest: %w", err)
+		}
+
+		logger.Error("Failed to send digest notification", logger.Merge(loggerDetails, logger.WithError(err)))
+		s.publishDigestOutcome(ctx, summary, messages, results, models.NotificationStatusFailed, err.Error(), 0, len(results))
+		return nil
+	}
+
+	successCount, failedCount := countResults(results)
+
+	finalStatus := models.NotificationStatusDelivered
+	statusMessage := fmt.Sprintf("Digest of %d notifications delivered", len(messages))
+	if failedCount > 0 {
+		finalStatus = models.NotificationStatusPartiallyDelivered
+		statusMessage = fmt.Sprintf("Digest of %d notifications partially delivered: %d succeeded, %d failed",
+			len(messages), successCount, failedCount)
+	}
+
+	s.publishDigestOutcome(ctx, summary, messages, results, finalStatus, statusMessage, successCount, failedCount)
+	return nil
+}
+
+// reports the outcome of a digest summary. the buffered messages are reported
+// as aggregated once the summary reached any device, and as failed otherwise
+func (s *NotificationService) publishDigestOutcome(ctx context.Context, summary *models.NotificationMessage, messages []*models.NotificationMessage, results []*models.NotificationResult, status models.NotificationStatusEnum, message string, successCount, failedCount int) {
+	s.publishStatus(ctx, summary, results, status, message, successCount, failedCount)
+
+	for _, msg := range messages {
+		if status == models.NotificationStatusFailed {
+			s.publishStatus(ctx, msg, nil, models.NotificationStatusFailed,
+				fmt.Sprintf("Digest %s failed: %s", summary.ID, message), 0, 0)
+			continue
+		}
+		s.publishStatus(ctx, msg, nil, models.NotificationStatusAggregated,
+			fmt.Sprintf("Aggregated into digest %s", summary.ID), 0, 0)
+	}
+}
+
+// builds a summary notification from a count and the latest rendered items
+func buildDigestNotification(group string, count int, items []*models.PushNotification, aggregatedIDs []string) *models.PushNotification {
+	latest := items[0]
+
+	if count == 1 {
+		return latest
+	}
+
+	lines := make([]string, 0, len(items)+1)
+	for _, item := range items {
+		if item.Title != "" {
+			lines = append(lines, fmt.Sprintf("• %s: %s", item.Title, item.Body))
+		} else {
+			lines = append(lines, fmt.Sprintf("• %s", item.Body))
+		}
+	}
+	if remaining := count - len(items); remaining > 0 {
+		lines = append(lines, fmt.Sprintf("and %d more", remaining))
+	}
+
+	return &models.PushNotification{
+		Title:    fmt.Sprintf("You have %d new notifications", count),
+		Body:     strings.Join(lines, "\n"),
+		ImageURL: latest.ImageURL,
+		Link:     latest.Link,
+		Data: map[string]interface{}{
+			"digest_group":     group,
+			"digest_count":     count,
+			"notification_ids": strings.Join(aggregatedIDs, ","),
+		},
+	}
+}
+
+// returns the distinct device tokens across messages, in first seen order
+func mergeDeviceTokens(messages []*models.NotificationMessage) []string {
+	seen := make(map[string]bool)
+	tokens := make([]string, 0)
+
+	for _, msg := range messages {
+		for _, token := range msg.DeviceTokens {
+			if token == "" || seen[token] {
+				continue
+			}
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+
+	return tokens
+}
+
+// counts successful and failed send results
+func countResults(results []*models.NotificationResult) (int, int) {
+	successCount := 0
+	failedCount := 0
+	for _, result := range results {
+		if result.Success {
+			successCount++
+		} else {
+			failedCount++
+		}
+	}
+	return successCount, failedCount
+}
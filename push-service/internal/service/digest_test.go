@@ -0,0 +1,62 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+// tests the summary built from buffered items
+func TestBuildDigestNotification(t *testing.T) {
+	items := []*models.PushNotification{
+		{Title: "Ada commented", Body: "Looks good", Link: "https://example.com/3"},
+		{Title: "Bob commented", Body: "Nice"},
+	}
+
+	t.Run("Single item is sent as is", func(t *testing.T) {
+		notification := buildDigestNotification("comments", 1, items[:1], []string{"n1"})
+
+		if notification != items[0] {
+			t.Errorf("Expected the single item to be returned, got %+v", notification)
+		}
+	})
+
+	t.Run("Summary with count and latest items", func(t *testing.T) {
+		notification := buildDigestNotification("comments", 40, items, []string{"n1", "n2"})
+
+		if notification.Title != "You have 40 new notifications" {
+			t.Errorf("Unexpected title '%s'", notification.Title)
+		}
+
+		if !strings.HasPrefix(notification.Body, "• Ada commented: Looks good\n• Bob commented: Nice") {
+			t.Errorf("Expected latest items in body, got '%s'", notification.Body)
+		}
+
+		if !strings.HasSuffix(notification.Body, "and 38 more") {
+			t.Errorf("Expected remaining count in body, got '%s'", notification.Body)
+		}
+
+		if notification.Link != "https://example.com/3" {
+			t.Errorf("Expected link of latest item, got '%s'", notification.Link)
+		}
+
+		if notification.Data["digest_count"] != 40 {
+			t.Errorf("Expected digest_count 40, got %v", notification.Data["digest_count"])
+		}
+	})
+}
+
+// tests device tokens are merged without duplicates
+func TestMergeDeviceTokens(t *testing.T) {
+	messages := []*models.NotificationMessage{
+		{DeviceTokens: []string{"a", "b"}},
+		{DeviceTokens: []string{"b", "", "c"}},
+	}
+
+	tokens := mergeDeviceTokens(messages)
+
+	if strings.Join(tokens, ",") != "a,b,c" {
+		t.Errorf("Expected tokens a,b,c, got %v", tokens)
+	}
+}
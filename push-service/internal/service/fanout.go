@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
+)
+
+// how long the broker may take to confirm the children of a split message
+const fanoutPublishTimeout = 10 * time.Second
+
+// whether a message carries more device tokens than one child may
+func (s *NotificationService) shouldSplit(msg *models.NotificationMessage) bool {
+	return s.fanout.ChunkSize > 0 && msg.ParentID == "" && len(msg.DeviceTokens) > s.fanout.ChunkSize
+}
+
+// publishes the children of a large message back to the push queue, where
+// replicas process them in parallel. the parent is marked processed once every
+// child is confirmed, its final status follows from the children's results.
+// the parent's processing status is published before any child, so it never
+// follows the final status of a child that finished quickly
+func (s *NotificationService) splitNotification(ctx context.Context, msg *models.NotificationMessage) error {
+	children := SplitMessage(msg, s.fanout.ChunkSize)
+
+	loggerDetails := logger.Merge(
+		logger.WithNotificationID(msg.ID),
+		logger.WithUserID(msg.UserID),
+		logger.Fields{
+			"device_count": len(msg.DeviceTokens),
+			"child_count":  len(children),
+		},
+	)
+
+	key := cache.TenantKey(msg.TenantID, cache.GetFanoutKey(msg.ID))
+	if err := s.cache.StartFanout(ctx, key, len(children), s.fanoutRetention()); err != nil {
+		logger.Error("Failed to start fan-out", logger.Merge(loggerDetails, logger.WithError(err)))
+		s.publishStatus(ctx, msg, nil, models.NotificationStatusFailed, fmt.Sprintf("Failed to split notification: %s", err.Error()), 0, 0)
+		return err
+	}
+
+	s.publishStatusWithMetadata(ctx, msg, nil, models.NotificationStatusProcessing,
+		fmt.Sprintf("Split into %d child messages", len(children)), 0, 0, map[string]interface{}{
+			"child_count": len(children),
+		})
+
+	// children are republished until confirmed, their stable ids make the
+	// idempotency check drop the ones that were already processed
+	pending := children
+	err := s.fanoutRetry.RetryWithBackoff(ctx, func() error {
+		publishCtx, cancel := context.WithTimeout(ctx, fanoutPublishTimeout)
+		errs := s.queue.PublishBatch(publishCtx, "push.queue", pending)
+		cancel()
+
+		unconfirmed := make([]*models.NotificationMessage, 0)
+		for i, err := range errs {
+			if err != nil {
+				unconfirmed = append(unconfirmed, pending[i])
+			}
+		}
+		pending = unconfirmed
+
+		return errors.Join(errs...)
+	})
+	if err != nil {
+		logger.Error("Failed to publish child messages", logger.Merge(loggerDetails, logger.Fields{
+			"unconfirmed": len(pending),
+		}, logger.WithError(err)))
+		s.publishStatus(ctx, msg, nil, models.NotificationStatusFailed, fmt.Sprintf("Failed to split notification: %s", err.Error()), 0, 0)
+		return err
+	}
+
+	logger.Info("Notification split into child messages", loggerDetails)
+	s.markAsProcessed(ctx, msg)
+	return nil
+}
+
+// counts the final status of a child message towards its parent, publishing
+// the parent's final status once every child has finished. a child reported
+// again after that, such as a failed child replayed successfully, publishes
+// the parent status again with the updated counts
+func (s *NotificationService) recordChildOutcome(ctx context.Context, msg *models.NotificationMessage, status models.NotificationStatusEnum, successCount, failedCount int) {
+	if s.cache == nil {
+		return
+	}
+
+	// a child that failed before sending failed for all of its devices
+	if status != models.NotificationStatusDelivered && status != models.NotificationStatusPartiallyDelivered && successCount+failedCount == 0 {
+		failedCount = len(msg.DeviceTokens)
+	}
+
+	key := cache.TenantKey(msg.TenantID, cache.GetFanoutKey(msg.ParentID))
+	progress, changed, err := s.cache.RecordFanoutChild(ctx, key, msg.ID, successCount, failedCount, s.fanoutRetention())
+	if err != nil {
+		logger.Error("Failed to record child message outcome", logger.Merge(
+			logger.WithNotificationID(msg.ID),
+			logger.Fields{"parent_id": msg.ParentID},
+			logger.WithError(err),
+		))
+		return
+	}
+	if !changed || !progress.Complete() {
+		return
+	}
+
+	parent := ParentMessage(msg)
+	success, failed := int(progress.Success), int(progress.Failed)
+
+	finalStatus := models.NotificationStatusDelivered
+	statusMessage := "Notification delivered successfully"
+	if success == 0 {
+		finalStatus = models.NotificationStatusFailed
+		statusMessage = "All notifications failed to deliver"
+	} else if failed > 0 {
+		finalStatus = models.NotificationStatusPartiallyDelivered
+		statusMessage = fmt.Sprintf("Partially delivered: %d succeeded, %d failed", success, failed)
+	}
+
+	logger.Info("Split notification completed", logger.Merge(
+		logger.WithNotificationID(parent.ID),
+		logger.Fields{
+			"child_count":   progress.Children,
+			"success_count": success,
+			"failed_count":  failed,
+		},
+	))
+
+	s.publishStatusWithMetadata(ctx, parent, nil, finalStatus, statusMessage, success, failed, map[string]interface{}{
+		"child_count": progress.Children,
+	})
+}
+
+func (s *NotificationService) fanoutRetention() time.Duration {
+	return time.Duration(s.fanout.Retention) * time.Second
+}
+
+// splits a message into children of at most chunkSize device tokens. child
+// ids are derived from the parent id and position so a repeated split
+// produces the same children
+func SplitMessage(msg *models.NotificationMessage, chunkSize int) []*models.NotificationMessage {
+	children := make([]*models.NotificationMessage, 0, (len(msg.DeviceTokens)+chunkSize-1)/chunkSize)
+
+	for start := 0; start < len(msg.DeviceTokens); start += chunkSize {
+		end := start + chunkSize
+		if end > len(msg.DeviceTokens) {
+			end = len(msg.DeviceTokens)
+		}
+
+		child := *msg
+		child.ID = fmt.Sprintf("%s-%d", msg.ID, len(children))
+		child.ParentID = msg.ID
+		child.DeviceTokens = msg.DeviceTokens[start:end]
+		children = append(children, &child)
+	}
+
+	return children
+}
+
+// rebuilds the parent of a child message for its status events
+func ParentMessage(child *models.NotificationMessage) *models.NotificationMessage {
+	parent := *child
+	parent.ID = child.ParentID
+	parent.ParentID = ""
+	parent.DeviceTokens = nil
+	return &parent
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/config"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+func testTokens(count int) []string {
+	tokens := make([]string, count)
+	for i := range tokens {
+		tokens[i] = fmt.Sprintf("token-%d", i)
+	}
+	return tokens
+}
+
+// tests large messages are split into bounded children
+func TestSplitMessage(t *testing.T) {
+	msg := &models.NotificationMessage{
+		ID:           "n1",
+		UserID:       "u1",
+		TenantID:     "t1",
+		TemplateCode: "promo",
+		CallbackURL:  "https://example.com/hook",
+		DeviceTokens: testTokens(1201),
+	}
+
+	children := SplitMessage(msg, 500)
+
+	if len(children) != 3 {
+		t.Fatalf("Expected 3 children, got %d", len(children))
+	}
+
+	sizes := []int{500, 500, 201}
+	for i, child := range children {
+		if child.ID != fmt.Sprintf("n1-%d", i) {
+			t.Errorf("Unexpected child id '%s'", child.ID)
+		}
+		if child.ParentID != "n1" || child.TenantID != "t1" || child.CallbackURL != msg.CallbackURL {
+			t.Errorf("Expected child to keep the parent fields, got %+v", child)
+		}
+		if len(child.DeviceTokens) != sizes[i] {
+			t.Errorf("Expected child %d to have %d tokens, got %d", i, sizes[i], len(child.DeviceTokens))
+		}
+	}
+
+	if children[2].DeviceTokens[0] != "token-1000" {
+		t.Errorf("Expected the last child to start at token-1000, got %s", children[2].DeviceTokens[0])
+	}
+
+	if again := SplitMessage(msg, 500); again[1].ID != children[1].ID {
+		t.Errorf("Expected a repeated split to produce the same ids")
+	}
+}
+
+// tests the parent rebuilt from a child for its status events
+func TestParentMessage(t *testing.T) {
+	child := &models.NotificationMessage{
+		ID:           "n1-2",
+		ParentID:     "n1",
+		TenantID:     "t1",
+		TemplateCode: "promo",
+		DeviceTokens: testTokens(3),
+	}
+
+	parent := ParentMessage(child)
+
+	if parent.ID != "n1" || parent.ParentID != "" || parent.DeviceTokens != nil {
+		t.Errorf("Unexpected parent %+v", parent)
+	}
+	if parent.TenantID != "t1" || parent.TemplateCode != "promo" {
+		t.Errorf("Expected the parent to keep the child fields, got %+v", parent)
+	}
+	if child.ID != "n1-2" {
+		t.Errorf("Expected the child to be unchanged, got %s", child.ID)
+	}
+}
+
+// tests which messages are split
+func TestShouldSplit(t *testing.T) {
+	s := &NotificationService{fanout: config.FanoutConfig{ChunkSize: 500}}
+
+	testCases := []struct {
+		name   string
+		msg    *models.NotificationMessage
+		expect bool
+	}{
+		{"Within chunk size", &models.NotificationMessage{DeviceTokens: testTokens(500)}, false},
+		{"Over chunk size", &models.NotificationMessage{DeviceTokens: testTokens(501)}, true},
+		{"Child message", &models.NotificationMessage{ParentID: "n1", DeviceTokens: testTokens(501)}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := s.shouldSplit(tc.msg); got != tc.expect {
+				t.Errorf("Expected %v, got %v", tc.expect, got)
+			}
+		})
+	}
+
+	disabled := &NotificationService{}
+	if disabled.shouldSplit(&models.NotificationMessage{DeviceTokens: testTokens(10000)}) {
+		t.Error("Expected splitting to be disabled without a chunk size")
+	}
+}
+
+func newTestCache(t *testing.T) *cache.RedisCache {
+	t.Helper()
+
+	redisCache, err := cache.NewRedisCache(miniredis.RunT(t).Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("failed to connect to miniredis: %v", err)
+	}
+	t.Cleanup(func() { redisCache.Close() })
+	return redisCache
+}
+
+// fails the first batch publish and records how many statuses preceded each
+type splitPublisher struct {
+	fakePublisher
+	calls             int
+	statusesAtPublish []int
+}
+
+func (p *splitPublisher) PublishBatch(ctx context.Context, routingKey string, messages []*models.NotificationMessage) []error {
+	p.calls++
+	p.statusesAtPublish = append(p.statusesAtPublish, len(p.statuses))
+	if p.calls == 1 {
+		errs := make([]error, len(messages))
+		for i := range errs {
+			errs[i] = errors.New("not confirmed")
+		}
+		return errs
+	}
+	return p.fakePublisher.PublishBatch(ctx, routingKey, messages)
+}
+
+// tests the parent's processing status precedes its children and unconfirmed
+// children are published again with the fan-out retry policy
+func TestSplitNotification(t *testing.T) {
+	publisher := &splitPublisher{}
+	s := &NotificationService{
+		cache:       newTestCache(t),
+		queue:       publisher,
+		fanout:      config.FanoutConfig{ChunkSize: 2, Retention: 3600},
+		fanoutRetry: NewRetryService(2, 0, 0, 1),
+	}
+
+	msg := &models.NotificationMessage{ID: "n1", UserID: "u1", TenantID: "t1", DeviceTokens: testTokens(5)}
+	if err := s.splitNotification(context.Background(), msg); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if publisher.statusesAtPublish[0] != 1 || publisher.statuses[0].Status != models.NotificationStatusProcessing {
+		t.Errorf("Expected the processing status before the children, got %v statuses first", publisher.statusesAtPublish[0])
+	}
+	if publisher.calls != 2 || len(publisher.published) != 3 {
+		t.Errorf("Expected the unconfirmed children to be published again, got %d calls and %d children", publisher.calls, len(publisher.published))
+	}
+	if len(publisher.statuses) != 1 {
+		t.Errorf("Expected only the processing status, got %d statuses", len(publisher.statuses))
+	}
+}
+
+// tests the parent status is published once every child finished, a repeated
+// child is counted once and a replayed child updates the parent
+func TestRecordChildOutcome(t *testing.T) {
+	publisher := &fakePublisher{}
+	redisCache := newTestCache(t)
+	s := &NotificationService{cache: redisCache, queue: publisher, fanout: config.FanoutConfig{Retention: 3600}}
+	ctx := context.Background()
+
+	if err := redisCache.StartFanout(ctx, cache.TenantKey("t1", cache.GetFanoutKey("n1")), 2, time.Hour); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	first := &models.NotificationMessage{ID: "n1-0", ParentID: "n1", TenantID: "t1", DeviceTokens: testTokens(3)}
+	second := &models.NotificationMessage{ID: "n1-1", ParentID: "n1", TenantID: "t1", DeviceTokens: testTokens(2)}
+
+	s.recordChildOutcome(ctx, first, models.NotificationStatusDelivered, 3, 0)
+	s.recordChildOutcome(ctx, first, models.NotificationStatusDelivered, 3, 0)
+	if len(publisher.statuses) != 0 {
+		t.Fatalf("Expected no parent status before every child finished, got %+v", publisher.statuses)
+	}
+
+	// a child failing before sending failed for all of its devices
+	s.recordChildOutcome(ctx, second, models.NotificationStatusFailed, 0, 0)
+	if len(publisher.statuses) != 1 {
+		t.Fatalf("Expected the parent status once, got %d", len(publisher.statuses))
+	}
+	parent := publisher.statuses[0]
+	if parent.NotificationID != "n1" || parent.Status != models.NotificationStatusPartiallyDelivered {
+		t.Errorf("Expected the parent partially delivered, got %+v", parent)
+	}
+
+	s.recordChildOutcome(ctx, second, models.NotificationStatusFailed, 0, 0)
+	if len(publisher.statuses) != 1 {
+		t.Errorf("Expected a duplicate child not to publish again, got %d statuses", len(publisher.statuses))
+	}
+
+	s.recordChildOutcome(ctx, second, models.NotificationStatusDelivered, 2, 0)
+	if len(publisher.statuses) != 2 || publisher.statuses[1].Status != models.NotificationStatusDelivered {
+		t.Errorf("Expected the replayed child to update the parent to delivered, got %+v", publisher.statuses)
+	}
+}
@@ -2,45 +2,133 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
 	"github.com/zjoart/distributed-notification-system/push-service/internal/config"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/device"
 	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
 	"github.com/zjoart/distributed-notification-system/push-service/internal/push"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/status"
 	"github.com/zjoart/distributed-notification-system/push-service/internal/template"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/webhook"
 	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
 )
 
 type NotificationService struct {
-	fcmService     *push.FCMService
+	fcm            *push.Registry
 	retryService   *RetryService
 	cache          *cache.RedisCache
 	rateLimit      config.RateLimitConfig
+	digest         config.DigestConfig
+	status         config.StatusConfig
+	fanout         config.FanoutConfig
+	fanoutRetry    *RetryService
 	queue          QueuePublisher
-	templateClient *template.Client
+	templateClient TemplateRenderer
+	localRenderer  FallbackRenderer
+	devices        device.Registry
+	preferences    PreferencesProvider
+	statuses       status.Store
+	webhooks       WebhookDispatcher
+	stream         StatusStream
+	campaigns      CampaignTracker
 }
 
 type QueuePublisher interface {
 	PublishStatus(ctx context.Context, statusMsg *models.NotificationStatusMessage) error
+	PublishDelayed(ctx context.Context, msg *models.NotificationMessage, delay time.Duration) error
+	PublishDeviceStatus(ctx context.Context, deviceMsg *models.DeviceStatusMessage) error
+	PublishBatch(ctx context.Context, routingKey string, messages []*models.NotificationMessage) []error
 }
 
+type TemplateRenderer interface {
+	RenderPushTemplate(ctx context.Context, tenantID, templateCode, locale string, variables map[string]interface{}) (*template.PushTemplate, error)
+	GetTemplate(ctx context.Context, tenantID, templateCode, locale string) (*template.PushTemplate, error)
+}
+
+// looks up the push preferences of a user
+type PreferencesProvider interface {
+	GetPreferences(ctx context.Context, tenantID, userID string) (*models.UserPreferences, error)
+}
+
+// posts status events to callback urls without blocking
+type WebhookDispatcher interface {
+	Dispatch(target webhook.Target, msg *models.NotificationMessage, statusMsg *models.NotificationStatusMessage)
+}
+
+// fans status events out to live stream clients
+type StatusStream interface {
+	Publish(ctx context.Context, statusMsg *models.NotificationStatusMessage)
+}
+
+// counts the final statuses of campaign child messages
+type CampaignTracker interface {
+	RecordOutcome(ctx context.Context, campaignID, notificationID string, status models.NotificationStatusEnum) error
+}
+
+// renders templates in process when the template service is unavailable
+type FallbackRenderer interface {
+	Render(templateCode, locale string, variables map[string]interface{}) (*template.PushTemplate, string, error)
+	Schema(templateCode, locale string) []template.TemplateVariable
+}
+
+// policies applied when a message exceeds a rate limit
+const (
+	RateLimitPolicyReject          = "reject"
+	RateLimitPolicyDefer           = "defer"
+	RateLimitPolicyDropLowPriority = "drop_low_priority"
+)
+
+// shortest delay used when deferring a rate limited message
+const minDeferDelay = time.Second
+
+// queued statuses of a bulk send published concurrently
+const queuedStatusWorkers = 16
+
 func NewNotificationService(
-	fcmService *push.FCMService,
+	fcm *push.Registry,
 	retryService *RetryService,
 	cache *cache.RedisCache,
 	rateLimit config.RateLimitConfig,
+	digest config.DigestConfig,
+	status config.StatusConfig,
+	fanout config.FanoutConfig,
 	queue QueuePublisher,
-	templateClient *template.Client,
+	templateClient TemplateRenderer,
+	localRenderer FallbackRenderer,
+	devices device.Registry,
+	preferences PreferencesProvider,
+	statuses status.Store,
+	webhooks WebhookDispatcher,
+	stream StatusStream,
+	campaigns CampaignTracker,
 ) *NotificationService {
+	// publishing children waits on the broker, not on FCM, so it gets its own
+	// attempts rather than the send backoff
+	fanoutRetry := NewRetryService(fanout.PublishAttempts, fanout.PublishInterval, fanout.PublishInterval, 1)
+
 	return &NotificationService{
-		fcmService:     fcmService,
+		fcm:            fcm,
 		retryService:   retryService,
 		cache:          cache,
 		rateLimit:      rateLimit,
+		digest:         digest,
+		status:         status,
+		fanout:         fanout,
+		fanoutRetry:    fanoutRetry,
 		queue:          queue,
 		templateClient: templateClient,
+		localRenderer:  localRenderer,
+		devices:        devices,
+		preferences:    preferences,
+		statuses:       statuses,
+		webhooks:       webhooks,
+		stream:         stream,
+		campaigns:      campaigns,
 	}
 }
 
@@ -51,14 +139,6 @@ func (s *NotificationService) ProcessNotification(ctx context.Context, msg *mode
 		logger.WithNotificationID(msg.ID),
 		logger.WithUserID(msg.UserID),
 	)
-	if err := s.checkRateLimit(ctx, msg.UserID); err != nil {
-		logger.Warn("Rate limit exceeded", loggerDetails)
-
-		// publish failed status for rate limit
-		s.publishStatus(ctx, msg, nil, models.NotificationStatusFailed, "Rate limit exceeded", 0, 0)
-		return err
-	}
-
 	if err := msg.Validate(); err != nil {
 		logger.Error("Invalid notification message",
 			logger.Merge(loggerDetails, logger.WithError(err)),
@@ -68,17 +148,84 @@ func (s *NotificationService) ProcessNotification(ctx context.Context, msg *mode
 		return err
 	}
 
-	if err := s.checkIdempotency(ctx, msg.ID); err != nil {
+	if err := s.checkIdempotency(ctx, msg); err != nil {
 		logger.Warn("Duplicate notification detected", loggerDetails)
 
 		return nil // return nil to acknowledge the message
 	}
 
+	if msg.Expired(time.Now()) {
+		logger.Warn("Notification expired before it was sent", logger.Merge(loggerDetails, logger.Fields{
+			"expires_at": msg.ExpiresAt,
+		}))
+		s.publishStatus(ctx, msg, nil, models.NotificationStatusExpired,
+			fmt.Sprintf("Expired at %s before it was sent", msg.ExpiresAt.Format(time.RFC3339)), 0, 0)
+		s.markAsProcessed(ctx, msg)
+		return nil
+	}
+
+	// the raw template is fetched once for its variable schema and category
+	tmpl, templateErr, err := s.fetchTemplate(ctx, msg)
+	if err == nil {
+		// variables are checked before the message is buffered or rendered
+		err = s.validateVariables(msg, tmpl)
+	}
+	if err != nil {
+		logger.Error("Template variables failed validation",
+			logger.Merge(loggerDetails, logger.WithError(err)),
+		)
+		s.publishStatusWithMetadata(ctx, msg, nil, models.NotificationStatusFailed,
+			fmt.Sprintf("Validation failed: %s", err.Error()), 0, 0, violationMetadata(err))
+		return err
+	}
+
+	// messages the user opted out of are acknowledged without being sent
+	category := notificationCategory(msg, tmpl)
+	if reason := s.checkPreferences(ctx, msg, category); reason != "" {
+		logger.Info("Notification suppressed by user preferences", logger.Merge(loggerDetails, logger.Fields{
+			"reason":   reason,
+			"category": category,
+		}))
+		s.publishStatusWithMetadata(ctx, msg, nil, models.NotificationStatusSuppressed,
+			fmt.Sprintf("Suppressed by user preferences: %s", reason), 0, 0, map[string]interface{}{
+				"suppression_reason": reason,
+				"category":           category,
+			})
+		s.markAsProcessed(ctx, msg)
+		return nil
+	}
+
+	// digest messages are buffered before rate limiting, the summary is sent once per window
+	if msg.DigestGroup != "" && msg.ParentID == "" {
+		if err := s.bufferForDigest(ctx, msg); err != nil {
+			logger.Error("Failed to buffer notification for digest", logger.Merge(loggerDetails, logger.WithError(err)))
+		} else {
+			s.markAsProcessed(ctx, msg)
+			return nil
+		}
+	}
+
+	if limit, err := s.checkRateLimit(ctx, msg); err != nil {
+		logger.Warn("Rate limit exceeded", logger.Merge(loggerDetails, logger.Fields{
+			"remaining": limit.Remaining,
+			"reset_at":  limit.ResetAt(),
+			"policy":    s.rateLimit.Policy,
+		}))
+
+		return s.handleRateLimited(ctx, msg, limit, err)
+	}
+
+	// large messages are sent by their children, in parallel across replicas
+	if s.shouldSplit(msg) {
+		return s.splitNotification(ctx, msg)
+	}
+
 	logger.Info("Processing notification", logger.Merge(loggerDetails, logger.Fields{
 		"device_count": len(msg.DeviceTokens),
 	}))
+	s.publishStatus(ctx, msg, nil, models.NotificationStatusProcessing, "Rendering and sending notification", 0, 0)
 
-	notification, err := s.prepareNotification(ctx, msg)
+	notification, err := s.prepareNotification(ctx, msg, tmpl, templateErr)
 	if err != nil {
 		logger.Error("Failed to prepare notification", logger.Merge(loggerDetails,
 			logger.WithError(
@@ -105,15 +252,7 @@ func (s *NotificationService) ProcessNotification(ctx context.Context, msg *mode
 		return err
 	}
 
-	successCount := 0
-	failedCount := 0
-	for _, result := range results {
-		if result.Success {
-			successCount++
-		} else {
-			failedCount++
-		}
-	}
+	successCount, failedCount := countResults(results)
 
 	logger.Info("Notification processing completed", logger.Merge(loggerDetails,
 		logger.Fields{
@@ -129,6 +268,7 @@ func (s *NotificationService) ProcessNotification(ctx context.Context, msg *mode
 		finalStatus = models.NotificationStatusFailed
 		statusMessage = "All notifications failed to deliver"
 	} else if failedCount > 0 {
+		finalStatus = models.NotificationStatusPartiallyDelivered
 		statusMessage = fmt.Sprintf("Partially delivered: %d succeeded, %d failed", successCount, failedCount)
 	}
 
@@ -136,54 +276,196 @@ func (s *NotificationService) ProcessNotification(ctx context.Context, msg *mode
 	s.publishStatus(ctx, msg, results, finalStatus, statusMessage, successCount, failedCount)
 
 	// mark as processed for idempotency
-	s.markAsProcessed(ctx, msg.ID)
+	s.markAsProcessed(ctx, msg)
 
 	return nil
 }
 
-// prepare the notification content
-func (s *NotificationService) prepareNotification(ctx context.Context, msg *models.NotificationMessage) (*models.PushNotification, error) {
+// prepare the notification content. fetched is the raw template already
+// fetched for the message, plain templates are rendered from it directly.
+// templateErr is the error the template could not be fetched with, the
+// template service is then not called again
+func (s *NotificationService) prepareNotification(ctx context.Context, msg *models.NotificationMessage, fetched *template.PushTemplate, templateErr error) (*models.PushNotification, error) {
+	// inline content is sent as is, without the template service
+	if msg.Content != nil {
+		logger.Info("Using inline notification content", logger.WithNotificationID(msg.ID))
+
+		return &models.PushNotification{
+			Title:    msg.Content.Title,
+			Body:     msg.Content.Body,
+			ImageURL: msg.Content.ImageURL,
+			Link:     msg.Content.Link,
+			Data:     msg.Content.Data,
+			Priority: msg.Priority,
+		}, nil
+	}
+
 	logDetails := logger.Merge(
 		logger.WithNotificationID(msg.ID),
 		logger.Fields{
 			"template_code": msg.TemplateCode,
+			"locale":        msg.Locale,
 		},
 	)
 
 	logger.Info("Rendering template for notification", logDetails)
 
-	tmpl, err := s.templateClient.RenderPushTemplate(ctx, msg.TemplateCode, msg.Variables)
+	var tmpl *template.PushTemplate
+	err := templateErr
+	switch {
+	case err != nil:
+	case fetched != nil && template.IsPlainTemplate(fetched):
+		tmpl = template.RenderPlain(fetched, msg.Variables)
+	default:
+		tmpl, err = s.templateClient.RenderPushTemplate(ctx, msg.TenantID, msg.TemplateCode, msg.Locale, msg.Variables)
+	}
 	if err != nil {
 		logger.Error("Failed to render template", logger.Merge(
 			logger.WithError(err),
 			logDetails,
 		))
-		return nil, fmt.Errorf("failed to render template: %w", err)
+
+		// permanent errors such as a missing template are not rendered locally
+		if models.IsPermanent(err) || s.localRenderer == nil {
+			return nil, fmt.Errorf("failed to render template: %w", err)
+		}
+
+		localTmpl, version, localErr := s.localRenderer.Render(msg.TemplateCode, msg.Locale, msg.Variables)
+		if localErr != nil {
+			logger.Error("Local template fallback failed", logger.Merge(
+				logger.WithError(localErr),
+				logDetails,
+			))
+			return nil, fmt.Errorf("failed to render template: %w", err)
+		}
+
+		logger.Warn("Rendered template with local fallback", logger.Merge(logDetails, logger.Fields{
+			"template_version": version,
+		}))
+		tmpl = localTmpl
 	}
 
 	notification := &models.PushNotification{
-		Title:    tmpl.Title,
-		Body:     tmpl.Body,
-		ImageURL: tmpl.ImageURL,
-		Link:     tmpl.Link,
-		Data:     tmpl.Data,
-		Priority: msg.Priority,
+		Title:        tmpl.Title,
+		Body:         tmpl.Body,
+		ImageURL:     tmpl.ImageURL,
+		Link:         tmpl.Link,
+		Data:         tmpl.Data,
+		Priority:     msg.Priority,
+		TitleLocKey:  tmpl.TitleLocKey,
+		TitleLocArgs: tmpl.TitleLocArgs,
+		BodyLocKey:   tmpl.BodyLocKey,
+		BodyLocArgs:  tmpl.BodyLocArgs,
 	}
 
 	logger.Info("Template rendered successfully", logger.Merge(logDetails, logger.Fields{
-		"title":         notification.Title,
-		"template_name": tmpl.Name,
+		"title":           notification.Title,
+		"template_name":   tmpl.Name,
+		"template_locale": tmpl.Locale,
 	}))
 
 	return notification, nil
 }
 
+// returns the raw template of a message. nil is returned for inline content
+// and when the template service is unavailable, templateErr then holds the
+// error so rendering goes straight to the local fallback. err is only set
+// for permanent failures
+func (s *NotificationService) fetchTemplate(ctx context.Context, msg *models.NotificationMessage) (tmpl *template.PushTemplate, templateErr, err error) {
+	if msg.Content != nil {
+		return nil, nil, nil
+	}
+
+	tmpl, templateErr = s.templateClient.GetTemplate(ctx, msg.TenantID, msg.TemplateCode, msg.Locale)
+	if models.IsPermanent(templateErr) {
+		return nil, nil, fmt.Errorf("failed to fetch template schema: %w", templateErr)
+	}
+	if templateErr != nil {
+		logger.Warn("Template schema unavailable, skipping variable validation", logger.Merge(
+			logger.WithNotificationID(msg.ID),
+			logger.WithError(templateErr),
+			logger.Fields{"template_code": msg.TemplateCode},
+		))
+		return nil, templateErr, nil
+	}
+
+	return tmpl, nil, nil
+}
+
+// validates the message variables against the schema declared by its template.
+// the schema of the local copy is used when the template service declares
+// none or is unavailable, the check is skipped when neither has a schema
+func (s *NotificationService) validateVariables(msg *models.NotificationMessage, tmpl *template.PushTemplate) error {
+	if msg.Content != nil {
+		return nil
+	}
+
+	var schema []template.TemplateVariable
+	if tmpl != nil {
+		schema = tmpl.Variables
+	}
+	if len(schema) == 0 && s.localRenderer != nil {
+		schema = s.localRenderer.Schema(msg.TemplateCode, msg.Locale)
+	}
+	if len(schema) == 0 {
+		return nil
+	}
+
+	return template.ValidateVariables(msg.TemplateCode, schema, msg.Variables)
+}
+
+// the preference category of a message, the template category takes precedence
+func notificationCategory(msg *models.NotificationMessage, tmpl *template.PushTemplate) string {
+	if tmpl != nil && tmpl.Category != "" {
+		return tmpl.Category
+	}
+	return msg.Category
+}
+
+// returns why the user's preferences suppress a message, empty when it may be
+// sent. messages are sent when the preferences cannot be looked up
+func (s *NotificationService) checkPreferences(ctx context.Context, msg *models.NotificationMessage, category string) string {
+	// child messages were checked as part of their parent
+	if s.preferences == nil || msg.ParentID != "" {
+		return ""
+	}
+
+	prefs, err := s.preferences.GetPreferences(ctx, msg.TenantID, msg.UserID)
+	if err != nil {
+		logger.Warn("User preferences unavailable, sending notification", logger.Merge(
+			logger.WithNotificationID(msg.ID),
+			logger.WithUserID(msg.UserID),
+			logger.WithError(err),
+		))
+		return ""
+	}
+
+	return prefs.SuppressionReason(category, time.Now())
+}
+
+// field level details of a schema error for the status event
+func violationMetadata(err error) map[string]interface{} {
+	var schemaErr *template.SchemaError
+	if !errors.As(err, &schemaErr) {
+		return nil
+	}
+
+	return map[string]interface{}{
+		"violations": schemaErr.Violations,
+	}
+}
+
 // send the notification to device tokens
 func (s *NotificationService) sendNotification(ctx context.Context, msg *models.NotificationMessage, notification *models.PushNotification) ([]*models.NotificationResult, error) {
 
+	deviceTokens, err := s.resolveDeviceTokens(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
 	// validate device tokens
-	validTokens := make([]string, 0, len(msg.DeviceTokens))
-	for _, token := range msg.DeviceTokens {
+	validTokens := make([]string, 0, len(deviceTokens))
+	for _, token := range deviceTokens {
 		if token != "" {
 			validTokens = append(validTokens, token)
 		}
@@ -193,14 +475,29 @@ func (s *NotificationService) sendNotification(ctx context.Context, msg *models.
 		return nil, models.ErrNoDeviceTokens
 	}
 
-	var results []*models.NotificationResult
+	fcmService, err := s.fcm.Service(ctx, msg.TenantID, msg.AppID)
+	if err != nil {
+		return nil, err
+	}
 
-	err := s.retryService.RetryWithBackoff(ctx, func() error {
-		var err error
+	var results []*models.NotificationResult
+	attempt := 0
+
+	// results of the tokens FCM answered, kept across attempts so a retry only
+	// sends the tokens a failed chunk left unsent
+	var sent []*models.NotificationResult
+	pending := validTokens
+
+	err = s.retryService.RetryWithBackoff(ctx, func() error {
+		attempt++
+		if attempt > 1 {
+			s.publishStatus(ctx, msg, results, models.NotificationStatusRetrying,
+				fmt.Sprintf("Retrying send, attempt %d", attempt), 0, 0)
+		}
 
 		if len(validTokens) == 1 {
 			// send notification to single device
-			result, err := s.fcmService.SendNotification(ctx, validTokens[0], notification)
+			result, err := fcmService.SendNotification(ctx, validTokens[0], notification)
 			if err != nil {
 				return err
 			}
@@ -208,16 +505,22 @@ func (s *NotificationService) sendNotification(ctx context.Context, msg *models.
 			results = []*models.NotificationResult{result}
 		} else {
 			// send notification to multiple devices
-			results, err = s.fcmService.SendToMultipleDevices(ctx, validTokens, notification)
-
-			if err != nil {
-				return err
-			}
+			chunkResults, err := fcmService.SendToMultipleDevices(ctx, pending, notification)
 
 			// add correlation ID to results
-			for _, result := range results {
+			for _, result := range chunkResults {
 				result.CorrelationID = msg.CorrelationID
 			}
+			results = append(append(make([]*models.NotificationResult, 0, len(validTokens)), sent...), chunkResults...)
+
+			var partial *push.PartialSendError
+			if errors.As(err, &partial) {
+				sent = results[:len(sent)+partial.Sent]
+				pending = pending[partial.Sent:]
+			}
+			if err != nil {
+				return err
+			}
 		}
 
 		// check if all sends failed
@@ -230,6 +533,8 @@ func (s *NotificationService) sendNotification(ctx context.Context, msg *models.
 		}
 
 		if allFailed {
+			// nothing was delivered, so the next attempt sends every token again
+			sent, pending = nil, validTokens
 			return fmt.Errorf("all notification sends failed")
 		}
 
@@ -239,15 +544,41 @@ func (s *NotificationService) sendNotification(ctx context.Context, msg *models.
 	return results, err
 }
 
+// returns the message device tokens, or the user's active devices when the
+// message only names a user
+func (s *NotificationService) resolveDeviceTokens(ctx context.Context, msg *models.NotificationMessage) ([]string, error) {
+	if len(msg.DeviceTokens) > 0 || s.devices == nil {
+		return msg.DeviceTokens, nil
+	}
+
+	devices, err := s.devices.ActiveDevices(ctx, msg.TenantID, msg.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve user devices: %w", err)
+	}
+
+	tokens := device.Tokens(devices, msg.Platform)
+
+	logger.Info("Resolved device tokens from registry", logger.Merge(
+		logger.WithNotificationID(msg.ID),
+		logger.WithUserID(msg.UserID),
+		logger.Fields{
+			"device_count": len(tokens),
+			"platform":     msg.Platform,
+		},
+	))
+
+	return tokens, nil
+}
+
 // check if notification has already been processed
-func (s *NotificationService) checkIdempotency(ctx context.Context, notificationID string) error {
-	key := cache.GetIdempotencyKey(notificationID)
+func (s *NotificationService) checkIdempotency(ctx context.Context, msg *models.NotificationMessage) error {
+	key := cache.TenantKey(msg.TenantID, cache.GetIdempotencyKey(msg.ID))
 
 	exists, err := s.cache.Exists(ctx, key)
 	if err != nil {
 		logger.Error("Failed to check idempotency",
 			logger.Merge(
-				logger.WithNotificationID(notificationID),
+				logger.WithNotificationID(msg.ID),
 				logger.WithError(err),
 			),
 		)
@@ -263,43 +594,132 @@ func (s *NotificationService) checkIdempotency(ctx context.Context, notification
 }
 
 // mark notification as processed for idempotency
-func (s *NotificationService) markAsProcessed(ctx context.Context, notificationID string) {
-	key := cache.GetIdempotencyKey(notificationID)
+func (s *NotificationService) markAsProcessed(ctx context.Context, msg *models.NotificationMessage) {
+	key := cache.TenantKey(msg.TenantID, cache.GetIdempotencyKey(msg.ID))
 
 	// store for 24 hours
 	if err := s.cache.Set(ctx, key, "processed", 86400); err != nil {
 		logger.Error("Failed to mark notification as processed",
 			logger.Merge(
-				logger.WithNotificationID(notificationID),
+				logger.WithNotificationID(msg.ID),
 				logger.WithError(err),
 			))
 	}
 }
 
-// check if user has exceeded rate limit
-func (s *NotificationService) checkRateLimit(ctx context.Context, userID string) error {
-	key := cache.GetRateLimitKey(userID)
+// check if the message exceeds the user, template or tenant rate limit. the
+// limits are checked together and quota is only used when all of them allow
+// the message. returns the result of the limit that rejected the message
+func (s *NotificationService) checkRateLimit(ctx context.Context, msg *models.NotificationMessage) (*cache.RateLimitResult, error) {
+	// child messages were counted as their parent
+	if msg.ParentID != "" {
+		return nil, nil
+	}
+
+	scopes := []string{"user"}
+	limits := []cache.RateLimit{
+		{Key: cache.TenantKey(msg.TenantID, cache.GetRateLimitKey(msg.UserID)), Limit: int64(s.rateLimit.Requests), Window: s.rateLimit.Window},
+	}
+	if s.rateLimit.TemplateRequests > 0 && msg.TemplateCode != "" {
+		scopes = append(scopes, "template")
+		limits = append(limits, cache.RateLimit{
+			Key:    cache.TenantKey(msg.TenantID, cache.GetTemplateRateLimitKey(msg.TemplateCode)),
+			Limit:  int64(s.rateLimit.TemplateRequests),
+			Window: s.rateLimit.TemplateWindow,
+		})
+	}
+	if s.rateLimit.TenantRequests > 0 && msg.TenantID != "" {
+		scopes = append(scopes, "tenant")
+		limits = append(limits, cache.RateLimit{
+			Key:    cache.GetTenantRateLimitKey(msg.TenantID),
+			Limit:  int64(s.rateLimit.TenantRequests),
+			Window: s.rateLimit.TenantWindow,
+		})
+	}
 
-	// use configured rate limit values
-	allowed, err := s.cache.CheckRateLimit(ctx, key, int64(s.rateLimit.Requests), s.rateLimit.Window)
+	results, err := s.cache.CheckRateLimits(ctx, s.rateLimit.Algorithm, limits)
 	if err != nil {
 		logger.Error("Failed to check rate limit", logger.Merge(
-			logger.WithUserID(userID),
+			logger.WithUserID(msg.UserID),
 			logger.WithError(err),
 		))
 
-		return nil // continue on cache error
+		return nil, nil // continue on cache error
+	}
+
+	for i, result := range results {
+		if !result.Allowed {
+			logger.Debug("Rate limit rejected message", logger.Merge(
+				logger.WithNotificationID(msg.ID),
+				logger.Fields{
+					"scope":       scopes[i],
+					"limit":       result.Limit,
+					"reset_after": result.ResetAfter.String(),
+				},
+			))
+			return result, fmt.Errorf("%s %w", scopes[i], models.ErrRateLimitExceeded)
+		}
 	}
 
-	if !allowed {
-		return models.ErrRateLimitExceeded
+	return nil, nil
+}
+
+// applies the configured rate limit policy to a rejected message.
+// returns nil when the message was deferred or dropped and should be acknowledged
+func (s *NotificationService) handleRateLimited(ctx context.Context, msg *models.NotificationMessage, limit *cache.RateLimitResult, limitErr error) error {
+	loggerDetails := logger.Merge(
+		logger.WithNotificationID(msg.ID),
+		logger.WithUserID(msg.UserID),
+	)
+
+	policy := s.rateLimit.Policy
+	if policy == RateLimitPolicyDropLowPriority {
+		if msg.Priority != "high" {
+			logger.Warn("Dropping rate limited low priority notification", loggerDetails)
+			s.publishStatusWithMetadata(ctx, msg, nil, models.NotificationStatusRateLimited,
+				"Dropped: rate limit exceeded for low priority notification", 0, 0, map[string]interface{}{
+					"dropped": true,
+				})
+			return nil
+		}
+		policy = RateLimitPolicyDefer
 	}
 
-	return nil
+	if policy == RateLimitPolicyDefer && msg.DeferCount < s.rateLimit.MaxDefers {
+		delay := limit.ResetAfter
+		if delay < minDeferDelay {
+			delay = minDeferDelay
+		}
+
+		deferred := *msg
+		deferred.DeferCount++
+
+		if err := s.queue.PublishDelayed(ctx, &deferred, delay); err != nil {
+			logger.Error("Failed to defer rate limited notification", logger.Merge(loggerDetails, logger.WithError(err)))
+		} else {
+			logger.Info("Rate limited notification deferred", logger.Merge(loggerDetails, logger.Fields{
+				"delay":       delay.String(),
+				"defer_count": deferred.DeferCount,
+			}))
+			s.publishStatus(ctx, msg, nil, models.NotificationStatusRateLimited,
+				fmt.Sprintf("Rate limit exceeded, deferred until %s (%d/%d)", limit.ResetAt().Format(time.RFC3339), deferred.DeferCount, s.rateLimit.MaxDefers), 0, 0)
+			return nil
+		}
+	}
+
+	// publish failed status for rate limit
+	s.publishStatus(ctx, msg, nil, models.NotificationStatusFailed,
+		fmt.Sprintf("Rate limit exceeded, resets at %s", limit.ResetAt().Format(time.RFC3339)), 0, 0)
+	return limitErr
 }
 
 // publishes notification status to the status queue
 func (s *NotificationService) publishStatus(ctx context.Context, msg *models.NotificationMessage, results []*models.NotificationResult, status models.NotificationStatusEnum, message string, successCount, failedCount int) {
+	s.publishStatusWithMetadata(ctx, msg, results, status, message, successCount, failedCount, nil)
+}
+
+// publishes notification status with extra metadata merged into the event
+func (s *NotificationService) publishStatusWithMetadata(ctx context.Context, msg *models.NotificationMessage, results []*models.NotificationResult, status models.NotificationStatusEnum, message string, successCount, failedCount int, extra map[string]interface{}) {
 	var errorMsg *string
 	if status == models.NotificationStatusFailed {
 		errorMsg = &message
@@ -308,6 +728,7 @@ func (s *NotificationService) publishStatus(ctx context.Context, msg *models.Not
 	// build metadata from results
 	metadata := map[string]interface{}{
 		"provider":      "FCM",
+		"content_mode":  msg.ContentMode(),
 		"success_count": successCount,
 		"failed_count":  failedCount,
 	}
@@ -321,17 +742,38 @@ func (s *NotificationService) publishStatus(ctx context.Context, msg *models.Not
 		metadata["device_tokens"] = deviceTokens
 	}
 
+	if msg.ParentID != "" {
+		metadata["parent_id"] = msg.ParentID
+	}
+
+	for key, value := range extra {
+		metadata[key] = value
+	}
+
 	statusMsg := &models.NotificationStatusMessage{
 		NotificationID:   msg.ID,
+		Sequence:         s.nextSequence(ctx, msg),
 		Status:           status,
 		Timestamp:        time.Now(),
 		Error:            errorMsg,
 		UserID:           msg.UserID,
+		TenantID:         msg.TenantID,
 		NotificationType: msg.NotificationType,
 		TemplateCode:     msg.TemplateCode,
 		Metadata:         metadata,
 	}
 
+	// the stored status is what the status endpoints return
+	if s.statuses != nil {
+		if err := s.statuses.Record(ctx, msg, statusMsg, message, results); err != nil {
+			logger.Error("Failed to record notification status",
+				logger.Merge(
+					logger.WithNotificationID(msg.ID),
+					logger.WithError(err),
+				))
+		}
+	}
+
 	if err := s.queue.PublishStatus(ctx, statusMsg); err != nil {
 		logger.Error("Failed to publish status to queue",
 			logger.Merge(
@@ -340,20 +782,160 @@ func (s *NotificationService) publishStatus(ctx context.Context, msg *models.Not
 				logger.WithError(err),
 			))
 	}
+
+	if s.status.DeviceEvents {
+		s.publishDeviceStatuses(ctx, msg, statusMsg, results)
+	}
+
+	if s.stream != nil {
+		s.stream.Publish(ctx, statusMsg)
+	}
+
+	// a dropped message ends rate limited, it counts as failed for its campaign or parent
+	outcome, final := status, status.Final()
+	if dropped, _ := extra["dropped"].(bool); dropped {
+		outcome, final = models.NotificationStatusFailed, true
+	}
+
+	if msg.CampaignID != "" && s.campaigns != nil && final {
+		if err := s.campaigns.RecordOutcome(ctx, msg.CampaignID, msg.ID, outcome); err != nil {
+			logger.Error("Failed to record campaign outcome",
+				logger.Merge(
+					logger.WithNotificationID(msg.ID),
+					logger.Fields{"campaign_id": msg.CampaignID},
+					logger.WithError(err),
+				))
+		}
+	}
+
+	if msg.ParentID != "" && final {
+		s.recordChildOutcome(ctx, msg, outcome, successCount, failedCount)
+	}
+
+	s.dispatchWebhook(msg, statusMsg)
 }
 
-// validates device tokens(for testing purposes)
-func (s *NotificationService) ValidateDeviceTokens(ctx context.Context, tokens []string) ([]*models.DeviceTokenValidation, error) {
+// posts a status event to the message's callback url, or to its tenant app's
+// webhook. the tenant secret signs both, a callback url must name one of the
+// tenant app's callback hosts
+func (s *NotificationService) dispatchWebhook(msg *models.NotificationMessage, statusMsg *models.NotificationStatusMessage) {
+	if s.webhooks == nil {
+		return
+	}
+
+	target := webhook.Target{}
+	if s.fcm != nil {
+		target.URL, target.Secret = s.fcm.Webhook(msg.TenantID, msg.AppID)
+	}
+	if msg.CallbackURL != "" {
+		target.URL = msg.CallbackURL
+		target.Callback = true
+		if s.fcm != nil {
+			target.AllowedHosts = s.fcm.CallbackHosts(msg.TenantID, msg.AppID)
+		}
+	}
+	if target.URL == "" {
+		return
+	}
+
+	s.webhooks.Dispatch(target, msg, statusMsg)
+}
+
+// publishes one event per device result, carrying the provider message id
+func (s *NotificationService) publishDeviceStatuses(ctx context.Context, msg *models.NotificationMessage, statusMsg *models.NotificationStatusMessage, results []*models.NotificationResult) {
+	for _, result := range results {
+		deviceStatus := models.NotificationStatusDelivered
+		if !result.Success {
+			deviceStatus = models.NotificationStatusFailed
+		}
+
+		deviceMsg := &models.DeviceStatusMessage{
+			NotificationID:    msg.ID,
+			Sequence:          statusMsg.Sequence,
+			UserID:            msg.UserID,
+			TenantID:          msg.TenantID,
+			TemplateCode:      msg.TemplateCode,
+			DeviceToken:       result.DeviceToken,
+			Status:            deviceStatus,
+			ProviderMessageID: result.MessageID,
+			Error:             result.Error,
+			Timestamp:         result.SentAt,
+		}
+
+		if err := s.queue.PublishDeviceStatus(ctx, deviceMsg); err != nil {
+			logger.Error("Failed to publish device status to queue",
+				logger.Merge(
+					logger.WithNotificationID(msg.ID),
+					logger.WithDeviceToken(result.DeviceToken),
+					logger.WithError(err),
+				))
+		}
+	}
+}
+
+// returns the next transition sequence of a notification, 0 when it cannot be assigned
+func (s *NotificationService) nextSequence(ctx context.Context, msg *models.NotificationMessage) int64 {
+	if s.statuses == nil {
+		return 0
+	}
+
+	sequence, err := s.statuses.NextSequence(ctx, msg.TenantID, msg.ID)
+	if err != nil {
+		logger.Error("Failed to assign status sequence",
+			logger.Merge(
+				logger.WithNotificationID(msg.ID),
+				logger.WithError(err),
+			))
+		return 0
+	}
+	return sequence
+}
+
+// publishes the queued status of a notification accepted by the HTTP API
+func (s *NotificationService) PublishQueued(ctx context.Context, msg *models.NotificationMessage) {
+	s.publishStatus(ctx, msg, nil, models.NotificationStatusQueued, "Notification queued", 0, 0)
+}
+
+// publishes the queued status of every notification of a bulk send, a few at a time
+func (s *NotificationService) PublishQueuedAll(ctx context.Context, msgs []*models.NotificationMessage) {
+	sem := make(chan struct{}, queuedStatusWorkers)
+	var wg sync.WaitGroup
+
+	for _, msg := range msgs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(msg *models.NotificationMessage) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			s.PublishQueued(ctx, msg)
+		}(msg)
+	}
+
+	wg.Wait()
+}
+
+// publishes the failed status of an accepted notification that could not be queued
+func (s *NotificationService) PublishQueueFailed(ctx context.Context, msg *models.NotificationMessage, err error) {
+	s.publishStatus(ctx, msg, nil, models.NotificationStatusFailed, fmt.Sprintf("Failed to queue notification: %s", err.Error()), 0, 0)
+}
+
+// validates device tokens with the credentials of a tenant app(for testing purposes)
+func (s *NotificationService) ValidateDeviceTokens(ctx context.Context, tenantID, appID string, tokens []string) ([]*models.DeviceTokenValidation, error) {
+	fcmService, err := s.fcm.Service(ctx, tenantID, appID)
+	if err != nil {
+		return nil, err
+	}
+
 	results := make([]*models.DeviceTokenValidation, 0, len(tokens))
 
 	for _, token := range tokens {
-		validation, err := s.fcmService.ValidateDeviceToken(ctx, token)
+		validation, err := fcmService.ValidateDeviceToken(ctx, token)
 		if err != nil {
 			logger.Error("Failed to validate device token",
 				logger.Merge(logger.WithError(err), logger.Fields{
 					"token": token,
 				}))
-			continue
+			validation = &models.DeviceTokenValidation{Token: token, Valid: false, Error: err.Error()}
 		}
 		results = append(results, validation)
 	}
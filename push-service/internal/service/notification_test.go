@@ -0,0 +1,232 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/config"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/template"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/webhook"
+)
+
+// records published messages instead of sending them to RabbitMQ
+type fakePublisher struct {
+	statuses  []*models.NotificationStatusMessage
+	delayed   []*models.NotificationMessage
+	delays    []time.Duration
+	devices   []*models.DeviceStatusMessage
+	published []*models.NotificationMessage
+}
+
+func (f *fakePublisher) PublishStatus(ctx context.Context, statusMsg *models.NotificationStatusMessage) error {
+	f.statuses = append(f.statuses, statusMsg)
+	return nil
+}
+
+func (f *fakePublisher) PublishDeviceStatus(ctx context.Context, deviceMsg *models.DeviceStatusMessage) error {
+	f.devices = append(f.devices, deviceMsg)
+	return nil
+}
+
+func (f *fakePublisher) PublishBatch(ctx context.Context, routingKey string, messages []*models.NotificationMessage) []error {
+	f.published = append(f.published, messages...)
+	return make([]error, len(messages))
+}
+
+func (f *fakePublisher) PublishDelayed(ctx context.Context, msg *models.NotificationMessage, delay time.Duration) error {
+	f.delayed = append(f.delayed, msg)
+	f.delays = append(f.delays, delay)
+	return nil
+}
+
+// tests the rate limit policies
+func TestHandleRateLimited(t *testing.T) {
+	limit := &cache.RateLimitResult{Limit: 10, ResetAfter: 20 * time.Second}
+
+	testCases := []struct {
+		name           string
+		policy         string
+		priority       string
+		deferCount     int
+		expectErr      bool
+		expectDeferred bool
+		expectStatus   models.NotificationStatusEnum
+	}{
+		{
+			name:         "Reject",
+			policy:       RateLimitPolicyReject,
+			expectErr:    true,
+			expectStatus: models.NotificationStatusFailed,
+		},
+		{
+			name:           "Defer",
+			policy:         RateLimitPolicyDefer,
+			expectDeferred: true,
+			expectStatus:   models.NotificationStatusRateLimited,
+		},
+		{
+			name:         "Defer cap reached",
+			policy:       RateLimitPolicyDefer,
+			deferCount:   3,
+			expectErr:    true,
+			expectStatus: models.NotificationStatusFailed,
+		},
+		{
+			name:         "Drop low priority",
+			policy:       RateLimitPolicyDropLowPriority,
+			priority:     "normal",
+			expectStatus: models.NotificationStatusRateLimited,
+		},
+		{
+			name:           "Defer high priority",
+			policy:         RateLimitPolicyDropLowPriority,
+			priority:       "high",
+			expectDeferred: true,
+			expectStatus:   models.NotificationStatusRateLimited,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			publisher := &fakePublisher{}
+			s := &NotificationService{
+				queue:     publisher,
+				rateLimit: config.RateLimitConfig{Policy: tc.policy, MaxDefers: 3},
+			}
+
+			msg := &models.NotificationMessage{ID: "n1", UserID: "u1", Priority: tc.priority, DeferCount: tc.deferCount}
+			err := s.handleRateLimited(context.Background(), msg, limit, models.ErrRateLimitExceeded)
+
+			if tc.expectErr != (err != nil) {
+				t.Errorf("Expected error %v, got %v", tc.expectErr, err)
+			}
+			if err != nil && !errors.Is(err, models.ErrRateLimitExceeded) {
+				t.Errorf("Expected ErrRateLimitExceeded, got %v", err)
+			}
+
+			if tc.expectDeferred {
+				if len(publisher.delayed) != 1 {
+					t.Fatalf("Expected message to be deferred once, got %d", len(publisher.delayed))
+				}
+				if publisher.delays[0] != limit.ResetAfter {
+					t.Errorf("Expected delay %v, got %v", limit.ResetAfter, publisher.delays[0])
+				}
+				if publisher.delayed[0].DeferCount != tc.deferCount+1 {
+					t.Errorf("Expected defer count %d, got %d", tc.deferCount+1, publisher.delayed[0].DeferCount)
+				}
+			} else if len(publisher.delayed) != 0 {
+				t.Errorf("Expected no deferral, got %d", len(publisher.delayed))
+			}
+
+			if len(publisher.statuses) != 1 || publisher.statuses[0].Status != tc.expectStatus {
+				t.Errorf("Expected a single %s status, got %+v", tc.expectStatus, publisher.statuses)
+			}
+		})
+	}
+}
+
+// tests per-device events follow the notification status when enabled
+func TestPublishDeviceStatuses(t *testing.T) {
+	results := []*models.NotificationResult{
+		{DeviceToken: "a", Success: true, MessageID: "projects/p/messages/1"},
+		{DeviceToken: "b", Success: false, Error: "unregistered"},
+	}
+	msg := &models.NotificationMessage{ID: "n1", UserID: "u1"}
+
+	t.Run("Disabled", func(t *testing.T) {
+		publisher := &fakePublisher{}
+		s := &NotificationService{queue: publisher}
+
+		s.publishStatus(context.Background(), msg, results, models.NotificationStatusPartiallyDelivered, "Partially delivered", 1, 1)
+
+		if len(publisher.statuses) != 1 || len(publisher.devices) != 0 {
+			t.Errorf("Expected only the notification status, got %d statuses and %d device events", len(publisher.statuses), len(publisher.devices))
+		}
+	})
+
+	t.Run("Enabled", func(t *testing.T) {
+		publisher := &fakePublisher{}
+		s := &NotificationService{queue: publisher, status: config.StatusConfig{DeviceEvents: true}}
+
+		s.publishStatus(context.Background(), msg, results, models.NotificationStatusPartiallyDelivered, "Partially delivered", 1, 1)
+
+		if len(publisher.devices) != 2 {
+			t.Fatalf("Expected 2 device events, got %d", len(publisher.devices))
+		}
+		if publisher.devices[0].Status != models.NotificationStatusDelivered || publisher.devices[0].ProviderMessageID != "projects/p/messages/1" {
+			t.Errorf("Unexpected delivered event %+v", publisher.devices[0])
+		}
+		if publisher.devices[1].Status != models.NotificationStatusFailed || publisher.devices[1].Error != "unregistered" {
+			t.Errorf("Unexpected failed event %+v", publisher.devices[1])
+		}
+	})
+}
+
+// records dispatched webhooks
+type fakeDispatcher struct {
+	targets []webhook.Target
+}
+
+func (d *fakeDispatcher) Dispatch(target webhook.Target, msg *models.NotificationMessage, statusMsg *models.NotificationStatusMessage) {
+	d.targets = append(d.targets, target)
+}
+
+// tests status events are posted to the message's callback url
+func TestDispatchWebhook(t *testing.T) {
+	dispatcher := &fakeDispatcher{}
+	s := &NotificationService{queue: &fakePublisher{}, webhooks: dispatcher}
+
+	s.publishStatus(context.Background(), &models.NotificationMessage{ID: "n1", UserID: "u1"}, nil, models.NotificationStatusProcessing, "Processing", 0, 0)
+	if len(dispatcher.targets) != 0 {
+		t.Errorf("Expected no webhook without a callback url, got %+v", dispatcher.targets)
+	}
+
+	msg := &models.NotificationMessage{ID: "n2", UserID: "u1", CallbackURL: "https://example.com/hooks"}
+	s.publishStatus(context.Background(), msg, nil, models.NotificationStatusDelivered, "Delivered", 1, 0)
+	if len(dispatcher.targets) != 1 || dispatcher.targets[0].URL != "https://example.com/hooks" || !dispatcher.targets[0].Callback {
+		t.Errorf("Expected a webhook to the callback url, got %+v", dispatcher.targets)
+	}
+}
+
+// serves a fixed local schema
+type fakeFallback struct {
+	schema []template.TemplateVariable
+}
+
+func (f *fakeFallback) Render(templateCode, locale string, variables map[string]interface{}) (*template.PushTemplate, string, error) {
+	return nil, "", errors.New("not implemented")
+}
+
+func (f *fakeFallback) Schema(templateCode, locale string) []template.TemplateVariable {
+	return f.schema
+}
+
+// tests variables are checked against the template service schema, then the local one
+func TestValidateVariables(t *testing.T) {
+	required := []template.TemplateVariable{{Name: "code", Required: true}}
+	s := &NotificationService{localRenderer: &fakeFallback{schema: required}}
+	msg := &models.NotificationMessage{ID: "n1", TemplateCode: "reset", Variables: map[string]interface{}{}}
+
+	testCases := []struct {
+		name      string
+		tmpl      *template.PushTemplate
+		expectErr bool
+	}{
+		{"Template service schema", &template.PushTemplate{Variables: []template.TemplateVariable{{Name: "name"}}}, false},
+		{"Local schema when the template declares none", &template.PushTemplate{}, true},
+		{"Local schema when the template service is unavailable", nil, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := s.validateVariables(msg, tc.tmpl)
+			if tc.expectErr != errors.Is(err, models.ErrTemplateVariableMissing) {
+				t.Errorf("Expected error %v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
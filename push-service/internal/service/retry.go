@@ -2,9 +2,11 @@ package service
 
 import (
 	"context"
+	"errors"
 	"math"
 	"time"
 
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
 	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
 )
 
@@ -54,6 +56,12 @@ func (r *RetryService) RetryWithBackoff(ctx context.Context, fn func() error) er
 
 		lastErr = err
 
+		// permanent errors fail the same way on every attempt, and an open
+		// breaker keeps failing until its timeout, which outlasts the backoff
+		if models.IsPermanent(err) || errors.Is(err, models.ErrCircuitBreakerOpen) {
+			return err
+		}
+
 		// check if we should continue retrying
 		if attempt < r.maxAttempts-1 {
 			backoff := r.CalculateBackoff(attempt)
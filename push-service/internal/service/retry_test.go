@@ -3,8 +3,11 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"testing"
 	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
 )
 
 // tests backoff duration calculation
@@ -116,3 +119,34 @@ func TestRetryServiceRetryWithBackoff(t *testing.T) {
 		}
 	})
 }
+
+// tests permanent errors and an open breaker are returned without retrying
+func TestRetryServicePermanentError(t *testing.T) {
+	service := NewRetryService(3, 1, 60, 2.0)
+
+	testCases := []struct {
+		name string
+		err  error
+	}{
+		{"Permanent error", fmt.Errorf("%w: welcome", models.ErrTemplateNotFound)},
+		{"Open breaker", fmt.Errorf("%w: %w", models.ErrTemplateServiceUnavailable, models.ErrCircuitBreakerOpen)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			attempts := 0
+			err := service.RetryWithBackoff(context.Background(), func() error {
+				attempts++
+				return tc.err
+			})
+
+			if !errors.Is(err, tc.err) {
+				t.Errorf("Expected %v, got %v", tc.err, err)
+			}
+
+			if attempts != 1 {
+				t.Errorf("Expected 1 attempt, got %d", attempts)
+			}
+		})
+	}
+}
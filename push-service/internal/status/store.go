@@ -0,0 +1,265 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+// transitions kept per notification, older ones are dropped first
+const maxHistory = 50
+
+// Store keeps the current status of every notification so callers can look
+// up what happened after a notification was queued
+type Store interface {
+	// applies a status change, its message and per-device results to the stored status
+	Record(ctx context.Context, msg *models.NotificationMessage, update *models.NotificationStatusMessage, message string, results []*models.NotificationResult) error
+	// returns the next transition sequence of a notification, shared by all replicas
+	NextSequence(ctx context.Context, tenantID, notificationID string) (int64, error)
+	// returns the status of a notification, ErrNotificationNotFound when unknown or expired
+	Get(ctx context.Context, tenantID, notificationID string) (*models.NotificationStatusResponse, error)
+	// returns the status of every notification created for a request, newest first
+	FindByRequestID(ctx context.Context, tenantID, requestID string) ([]*models.NotificationStatusResponse, error)
+	// returns the statuses of the given notifications, skipping unknown or expired ones
+	GetMany(ctx context.Context, tenantID string, notificationIDs []string) ([]*models.NotificationStatusResponse, error)
+	// stores a bulk send so its progress can be queried
+	RecordBatch(ctx context.Context, batch *models.Batch) error
+	// returns a bulk send, ErrBatchNotFound when unknown or expired
+	GetBatch(ctx context.Context, tenantID, batchID string) (*models.Batch, error)
+}
+
+// RedisStore keeps one status document per notification plus a set of
+// notification ids per request id, both expiring after the retention
+type RedisStore struct {
+	cache     *cache.RedisCache
+	retention time.Duration
+}
+
+func NewRedisStore(redisCache *cache.RedisCache, retention time.Duration) *RedisStore {
+	return &RedisStore{
+		cache:     redisCache,
+		retention: retention,
+	}
+}
+
+func (s *RedisStore) Record(ctx context.Context, msg *models.NotificationMessage, update *models.NotificationStatusMessage, message string, results []*models.NotificationResult) error {
+	key := cache.TenantKey(msg.TenantID, cache.GetNotificationStatusKey(update.NotificationID))
+
+	return s.cache.UpdateNotificationStatus(ctx, key, s.retention, func(current *models.NotificationStatusResponse) (*models.NotificationStatusResponse, string) {
+		next := Apply(current, msg, update, message, results)
+
+		requestKey := ""
+		if next.RequestID != "" {
+			requestKey = cache.TenantKey(msg.TenantID, cache.GetRequestStatusKey(next.RequestID))
+		}
+		return next, requestKey
+	})
+}
+
+func (s *RedisStore) NextSequence(ctx context.Context, tenantID, notificationID string) (int64, error) {
+	key := cache.TenantKey(tenantID, cache.GetStatusSequenceKey(notificationID))
+	return s.cache.IncrementWithExpiry(ctx, key, int(s.retention.Seconds()))
+}
+
+func (s *RedisStore) Get(ctx context.Context, tenantID, notificationID string) (*models.NotificationStatusResponse, error) {
+	return s.cache.GetNotificationStatus(ctx, cache.TenantKey(tenantID, cache.GetNotificationStatusKey(notificationID)))
+}
+
+func (s *RedisStore) FindByRequestID(ctx context.Context, tenantID, requestID string) ([]*models.NotificationStatusResponse, error) {
+	ids, err := s.cache.GetRequestNotificationIDs(ctx, cache.TenantKey(tenantID, cache.GetRequestStatusKey(requestID)))
+	if err != nil {
+		return nil, err
+	}
+
+	// statuses that expired before the index are skipped
+	statuses, err := s.GetMany(ctx, tenantID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].CreatedAt.After(statuses[j].CreatedAt)
+	})
+
+	return statuses, nil
+}
+
+func (s *RedisStore) GetMany(ctx context.Context, tenantID string, notificationIDs []string) ([]*models.NotificationStatusResponse, error) {
+	keys := make([]string, len(notificationIDs))
+	for i, id := range notificationIDs {
+		keys[i] = cache.TenantKey(tenantID, cache.GetNotificationStatusKey(id))
+	}
+	return s.cache.GetNotificationStatuses(ctx, keys)
+}
+
+func (s *RedisStore) RecordBatch(ctx context.Context, batch *models.Batch) error {
+	payload, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("failed to marshal batch: %w", err)
+	}
+	return s.cache.Set(ctx, cache.TenantKey(batch.TenantID, cache.GetBatchKey(batch.ID)), string(payload), int(s.retention.Seconds()))
+}
+
+func (s *RedisStore) GetBatch(ctx context.Context, tenantID, batchID string) (*models.Batch, error) {
+	payload, err := s.cache.Get(ctx, cache.TenantKey(tenantID, cache.GetBatchKey(batchID)))
+	if errors.Is(err, models.ErrCacheMiss) {
+		return nil, fmt.Errorf("%w: %s", models.ErrBatchNotFound, batchID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	batch := &models.Batch{}
+	if err := json.Unmarshal([]byte(payload), batch); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal batch: %w", err)
+	}
+	return batch, nil
+}
+
+// aggregates the current statuses of a batch's queued notifications, other
+// statuses are ignored. queued notifications without a stored status yet
+// count as queued
+func Progress(batch *models.Batch, statuses []*models.NotificationStatusResponse) *models.BatchProgress {
+	progress := &models.BatchProgress{
+		Batch:  *batch,
+		Counts: make(map[models.NotificationStatusEnum]int),
+	}
+
+	queued := make(map[string]bool, len(batch.NotificationIDs))
+	for _, id := range batch.NotificationIDs {
+		queued[id] = true
+	}
+
+	counted := 0
+	for _, status := range statuses {
+		if !queued[status.ID] {
+			continue
+		}
+		counted++
+		progress.Counts[status.Status]++
+		if status.Status.Final() {
+			progress.Completed++
+		}
+	}
+	if missing := batch.Queued - counted; missing > 0 {
+		progress.Counts[models.NotificationStatusQueued] += missing
+	}
+
+	progress.Done = progress.Completed >= batch.Queued
+	return progress
+}
+
+// returns the status after applying an update to the current one, which may
+// be nil for the first update. an update older than the current status is only
+// added to the history. per-device results replace earlier results for the
+// same token, counts follow the merged results when there are any
+func Apply(current *models.NotificationStatusResponse, msg *models.NotificationMessage, update *models.NotificationStatusMessage, message string, results []*models.NotificationResult) *models.NotificationStatusResponse {
+	next := current
+	if next == nil {
+		next = &models.NotificationStatusResponse{
+			ID:            update.NotificationID,
+			UserID:        msg.UserID,
+			TenantID:      msg.TenantID,
+			TemplateCode:  msg.TemplateCode,
+			RequestID:     msg.RequestID,
+			CorrelationID: msg.CorrelationID,
+			CreatedAt:     update.Timestamp,
+		}
+		if !msg.CreatedAt.IsZero() {
+			next.CreatedAt = msg.CreatedAt
+		}
+	}
+
+	transition := models.StatusTransition{
+		Sequence:  update.Sequence,
+		Status:    update.Status,
+		Message:   message,
+		Timestamp: update.Timestamp,
+	}
+
+	// updates arriving out of order do not replace a newer status
+	if current != nil && update.Sequence > 0 && update.Sequence < current.Sequence {
+		next.History = insertTransition(next.History, transition)
+		return next
+	}
+
+	// the error describes the current status only
+	next.Error = ""
+	if update.Error != nil {
+		next.Error = *update.Error
+	}
+
+	next.Status = update.Status
+	next.Sequence = update.Sequence
+	next.LastUpdated = update.Timestamp
+	next.History = append(next.History, transition)
+	if len(next.History) > maxHistory {
+		next.History = next.History[len(next.History)-maxHistory:]
+	}
+
+	next.Results = mergeResults(next.Results, results)
+	if len(next.Results) > 0 {
+		next.SentCount, next.FailedCount = 0, 0
+		for _, result := range next.Results {
+			if result.Success {
+				next.SentCount++
+			} else {
+				next.FailedCount++
+			}
+		}
+	} else {
+		if count, ok := update.Metadata["success_count"].(int); ok {
+			next.SentCount = count
+		}
+		if count, ok := update.Metadata["failed_count"].(int); ok {
+			next.FailedCount = count
+		}
+	}
+
+	return next
+}
+
+// inserts a late transition in sequence order
+func insertTransition(history []models.StatusTransition, transition models.StatusTransition) []models.StatusTransition {
+	i := sort.Search(len(history), func(i int) bool {
+		return history[i].Sequence > transition.Sequence
+	})
+
+	history = append(history, models.StatusTransition{})
+	copy(history[i+1:], history[i:])
+	history[i] = transition
+
+	if len(history) > maxHistory {
+		history = history[len(history)-maxHistory:]
+	}
+	return history
+}
+
+// replaces results for tokens already present and appends new tokens
+func mergeResults(current, updates []*models.NotificationResult) []*models.NotificationResult {
+	if len(updates) == 0 {
+		return current
+	}
+
+	index := make(map[string]int, len(current))
+	for i, result := range current {
+		index[result.DeviceToken] = i
+	}
+
+	for _, result := range updates {
+		if i, ok := index[result.DeviceToken]; ok {
+			current[i] = result
+			continue
+		}
+		index[result.DeviceToken] = len(current)
+		current = append(current, result)
+	}
+
+	return current
+}
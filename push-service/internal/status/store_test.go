@@ -0,0 +1,179 @@
+package status
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+// tests status updates are folded into one status with merged device results
+func TestApply(t *testing.T) {
+	msg := &models.NotificationMessage{
+		ID:        "n1",
+		UserID:    "u1",
+		RequestID: "r1",
+		CreatedAt: time.Now().Add(-time.Minute),
+	}
+	failure := "all notification sends failed"
+	now := time.Now()
+
+	status := Apply(nil, msg, &models.NotificationStatusMessage{
+		NotificationID: "n1",
+		Status:         models.NotificationStatusFailed,
+		Timestamp:      now,
+		Error:          &failure,
+	}, failure, []*models.NotificationResult{
+		{DeviceToken: "a", Success: false, Error: "unavailable"},
+		{DeviceToken: "b", Success: false, Error: "unavailable"},
+	})
+
+	if status.ID != "n1" || status.RequestID != "r1" || !status.CreatedAt.Equal(msg.CreatedAt) {
+		t.Errorf("Unexpected identity %+v", status)
+	}
+	if status.SentCount != 0 || status.FailedCount != 2 || status.Error != failure {
+		t.Errorf("Unexpected failed status %+v", status)
+	}
+
+	status = Apply(status, msg, &models.NotificationStatusMessage{
+		NotificationID: "n1",
+		Status:         models.NotificationStatusDelivered,
+		Timestamp:      now.Add(time.Second),
+	}, "Partially delivered", []*models.NotificationResult{
+		{DeviceToken: "b", Success: true, MessageID: "m1"},
+	})
+
+	if status.Status != models.NotificationStatusDelivered || status.Error != "" {
+		t.Errorf("Expected delivered status without error, got %s %q", status.Status, status.Error)
+	}
+	if len(status.Results) != 2 || status.SentCount != 1 || status.FailedCount != 1 {
+		t.Errorf("Expected merged results with 1 sent and 1 failed, got %+v", status)
+	}
+	if len(status.History) != 2 || status.History[1].Message != "Partially delivered" {
+		t.Errorf("Unexpected history %+v", status.History)
+	}
+	if !status.LastUpdated.Equal(now.Add(time.Second)) {
+		t.Errorf("Expected last updated to follow the latest update, got %v", status.LastUpdated)
+	}
+}
+
+// tests a late transition does not replace a newer status
+func TestApplyOutOfOrder(t *testing.T) {
+	msg := &models.NotificationMessage{ID: "n1", UserID: "u1"}
+	now := time.Now()
+
+	status := Apply(nil, msg, &models.NotificationStatusMessage{
+		NotificationID: "n1",
+		Sequence:       3,
+		Status:         models.NotificationStatusDelivered,
+		Timestamp:      now,
+	}, "Delivered", nil)
+
+	status = Apply(status, msg, &models.NotificationStatusMessage{
+		NotificationID: "n1",
+		Sequence:       1,
+		Status:         models.NotificationStatusQueued,
+		Timestamp:      now.Add(-time.Second),
+	}, "Queued", nil)
+
+	if status.Status != models.NotificationStatusDelivered || status.Sequence != 3 {
+		t.Errorf("Expected delivered at sequence 3, got %s at %d", status.Status, status.Sequence)
+	}
+	if len(status.History) != 2 || status.History[0].Status != models.NotificationStatusQueued {
+		t.Errorf("Expected the late transition first in history, got %+v", status.History)
+	}
+}
+
+// tests batch progress counts current statuses and completion
+func TestProgress(t *testing.T) {
+	batch := &models.Batch{ID: "b1", Total: 6, Queued: 4, Rejected: 2, NotificationIDs: []string{"n1", "n2", "n3", "n4"}}
+	statuses := []*models.NotificationStatusResponse{
+		{ID: "n1", Status: models.NotificationStatusDelivered},
+		{ID: "n2", Status: models.NotificationStatusFailed},
+		{ID: "n3", Status: models.NotificationStatusProcessing},
+		// failed to queue, not part of the progress
+		{ID: "n5", Status: models.NotificationStatusFailed},
+	}
+
+	progress := Progress(batch, statuses)
+	if progress.Completed != 2 || progress.Done {
+		t.Errorf("Expected 2 of 4 completed, got %d done=%v", progress.Completed, progress.Done)
+	}
+	if progress.Counts[models.NotificationStatusQueued] != 1 || progress.Counts[models.NotificationStatusProcessing] != 1 {
+		t.Errorf("Expected the notification without a status to count as queued, got %v", progress.Counts)
+	}
+
+	statuses[2].Status = models.NotificationStatusSuppressed
+	statuses = append(statuses, &models.NotificationStatusResponse{ID: "n4", Status: models.NotificationStatusExpired})
+	if progress := Progress(batch, statuses); !progress.Done || progress.Completed != 4 {
+		t.Errorf("Expected the batch to be done, got %+v", progress)
+	}
+}
+
+func newTestStore(t *testing.T) *RedisStore {
+	t.Helper()
+
+	redisCache, err := cache.NewRedisCache(miniredis.RunT(t).Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("failed to connect to miniredis: %v", err)
+	}
+	t.Cleanup(func() { redisCache.Close() })
+
+	return NewRedisStore(redisCache, time.Hour)
+}
+
+// tests concurrent updates of one notification are all kept and requests list their notifications
+func TestRedisStoreRecord(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	msg := &models.NotificationMessage{ID: "n1", UserID: "u1", TenantID: "acme", RequestID: "r1"}
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 5; i++ {
+		wg.Add(1)
+		go func(sequence int64) {
+			defer wg.Done()
+			err := store.Record(ctx, msg, &models.NotificationStatusMessage{
+				NotificationID: "n1",
+				Status:         models.NotificationStatusProcessing,
+				Sequence:       sequence,
+				Timestamp:      time.Now(),
+			}, "processing", nil)
+			if err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+
+	status, err := store.Get(ctx, "acme", "n1")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(status.History) != 5 || status.Sequence != 5 {
+		t.Errorf("Expected every concurrent update to be kept, got %d transitions, sequence %d", len(status.History), status.Sequence)
+	}
+
+	t.Run("FindByRequestID", func(t *testing.T) {
+		second := &models.NotificationMessage{ID: "n2", UserID: "u1", TenantID: "acme", RequestID: "r1"}
+		if err := store.Record(ctx, second, &models.NotificationStatusMessage{
+			NotificationID: "n2",
+			Status:         models.NotificationStatusQueued,
+			Timestamp:      time.Now(),
+		}, "queued", nil); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		statuses, err := store.FindByRequestID(ctx, "acme", "r1")
+		if err != nil || len(statuses) != 2 {
+			t.Fatalf("Expected both notifications of the request, got %v, %v", statuses, err)
+		}
+		if other, _ := store.FindByRequestID(ctx, "", "r1"); len(other) != 0 {
+			t.Errorf("Expected no notifications for another tenant, got %v", other)
+		}
+	})
+}
@@ -0,0 +1,293 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/config"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
+)
+
+// how often replicas with subscribers announce them, and the longest wait
+// between attempts to subscribe to the relay
+const (
+	listenerRefresh = 2 * time.Second
+	maxRelayBackoff = 30 * time.Second
+)
+
+// Broker fans status events out to live stream subscribers. with a redis
+// cache, events are relayed through a pub/sub channel so subscribers see the
+// statuses of every replica, not only the one they are connected to. events
+// are only relayed while some replica has a subscriber
+type Broker struct {
+	cache          *cache.RedisCache // events stay in process when nil
+	maxSubscribers int
+	bufferSize     int
+	relaying       atomic.Bool   // set while this replica receives relayed events
+	listeners      atomic.Bool   // set while any replica has a subscriber
+	announce       chan struct{} // wakes the listener tracking when a subscriber connects
+	refresh        time.Duration
+	retryInterval  time.Duration
+
+	mutex       sync.RWMutex
+	subscribers map[*Subscription]struct{}
+}
+
+// selects the events a subscriber receives. the tenant always has to match,
+// other empty fields match everything
+type Filter struct {
+	TenantID       string
+	NotificationID string
+	UserID         string
+	TemplateCode   string
+	Statuses       []models.NotificationStatusEnum
+}
+
+// Subscription receives the events matching its filter. events that arrive
+// while its buffer is full are dropped and counted, so one slow client never
+// holds up sends or other clients
+type Subscription struct {
+	filter  Filter
+	events  chan *models.NotificationStatusMessage
+	dropped atomic.Int64
+}
+
+func NewBroker(redisCache *cache.RedisCache, cfg config.StreamConfig) *Broker {
+	bufferSize := cfg.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = 1
+	}
+
+	b := &Broker{
+		cache:          redisCache,
+		maxSubscribers: cfg.MaxConnections,
+		bufferSize:     bufferSize,
+		announce:       make(chan struct{}, 1),
+		refresh:        listenerRefresh,
+		retryInterval:  time.Second,
+		subscribers:    make(map[*Subscription]struct{}),
+	}
+	// relay until the first check tells otherwise
+	b.listeners.Store(true)
+	return b
+}
+
+// publishes a status event to the subscribers of every replica. while the
+// relay is down the event still reaches this replica's subscribers, and
+// nothing is published while no replica has a subscriber
+func (b *Broker) Publish(ctx context.Context, statusMsg *models.NotificationStatusMessage) {
+	if !b.relaying.Load() {
+		b.fanOut(statusMsg)
+		return
+	}
+	if !b.listeners.Load() && b.Subscribers() == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(statusMsg)
+	if err == nil {
+		err = b.cache.Publish(ctx, cache.GetStatusStreamChannel(), string(payload))
+	}
+	if err != nil {
+		logger.Error("Failed to relay status event", logger.Merge(
+			logger.WithNotificationID(statusMsg.NotificationID),
+			logger.WithError(err),
+		))
+		b.fanOut(statusMsg)
+	}
+}
+
+// relays events published by every replica to this replica's subscribers
+// until the context is cancelled. a failed or lost subscription is retried
+// with backoff, streams only receive local events meanwhile
+func (b *Broker) Run(ctx context.Context) {
+	if b.cache == nil {
+		return
+	}
+
+	go b.trackListeners(ctx)
+
+	backoff := b.retryInterval
+	for {
+		payloads, err := b.cache.Subscribe(ctx, cache.GetStatusStreamChannel())
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Error("Failed to subscribe to status events, streams only receive local events", logger.Merge(
+				logger.Fields{"retry_in": backoff.String()},
+				logger.WithError(err),
+			))
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, maxRelayBackoff)
+			continue
+		}
+
+		backoff = b.retryInterval
+		b.relay(payloads)
+
+		if ctx.Err() != nil {
+			logger.Info("Stopping status stream relay")
+			return
+		}
+		logger.Warn("Status stream relay disconnected, subscribing again")
+	}
+}
+
+// fans relayed events out until the subscription ends
+func (b *Broker) relay(payloads <-chan string) {
+	b.relaying.Store(true)
+	defer b.relaying.Store(false)
+	logger.Info("Status stream relay started")
+
+	for payload := range payloads {
+		statusMsg := &models.NotificationStatusMessage{}
+		if err := json.Unmarshal([]byte(payload), statusMsg); err != nil {
+			logger.Error("Failed to decode relayed status event", logger.WithError(err))
+			continue
+		}
+		b.fanOut(statusMsg)
+	}
+}
+
+// announces this replica's subscribers and checks whether any replica has
+// one, every refresh and whenever a subscriber connects. events are relayed
+// when the check fails
+func (b *Broker) trackListeners(ctx context.Context) {
+	ticker := time.NewTicker(b.refresh)
+	defer ticker.Stop()
+
+	key := cache.GetStatusStreamListenersKey()
+	ttl := int((3 * b.refresh).Seconds())
+	if ttl < 1 {
+		ttl = 1
+	}
+
+	for {
+		if b.Subscribers() > 0 {
+			if err := b.cache.Set(ctx, key, "1", ttl); err != nil {
+				logger.Error("Failed to announce status stream subscribers", logger.WithError(err))
+			}
+		}
+
+		exists, err := b.cache.Exists(ctx, key)
+		b.listeners.Store(exists || err != nil)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-b.announce:
+		}
+	}
+}
+
+// registers a subscriber, ErrTooManyStreams once the connection cap is reached
+func (b *Broker) Subscribe(filter Filter) (*Subscription, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.maxSubscribers > 0 && len(b.subscribers) >= b.maxSubscribers {
+		return nil, fmt.Errorf("%w: limit is %d", models.ErrTooManyStreams, b.maxSubscribers)
+	}
+
+	sub := &Subscription{
+		filter: filter,
+		events: make(chan *models.NotificationStatusMessage, b.bufferSize),
+	}
+	b.subscribers[sub] = struct{}{}
+
+	select {
+	case b.announce <- struct{}{}:
+	default:
+	}
+
+	return sub, nil
+}
+
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mutex.Lock()
+	delete(b.subscribers, sub)
+	b.mutex.Unlock()
+}
+
+// number of connected subscribers
+func (b *Broker) Subscribers() int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return len(b.subscribers)
+}
+
+func (b *Broker) fanOut(statusMsg *models.NotificationStatusMessage) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for sub := range b.subscribers {
+		if !sub.filter.Matches(statusMsg) {
+			continue
+		}
+		select {
+		case sub.events <- statusMsg:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// events matching the subscription's filter
+func (s *Subscription) Events() <-chan *models.NotificationStatusMessage {
+	return s.events
+}
+
+// returns the number of events dropped since the last call and resets it
+func (s *Subscription) TakeDropped() int64 {
+	return s.dropped.Swap(0)
+}
+
+// parses a comma separated status list, e.g. "delivered,failed"
+func ParseStatuses(raw string) []models.NotificationStatusEnum {
+	var statuses []models.NotificationStatusEnum
+	for _, status := range strings.Split(raw, ",") {
+		if status = strings.TrimSpace(status); status != "" {
+			statuses = append(statuses, models.NotificationStatusEnum(status))
+		}
+	}
+	return statuses
+}
+
+// returns whether an event passes the filter
+func (f Filter) Matches(statusMsg *models.NotificationStatusMessage) bool {
+	if f.TenantID != statusMsg.TenantID {
+		return false
+	}
+	if f.NotificationID != "" && f.NotificationID != statusMsg.NotificationID {
+		return false
+	}
+	if f.UserID != "" && f.UserID != statusMsg.UserID {
+		return false
+	}
+	if f.TemplateCode != "" && f.TemplateCode != statusMsg.TemplateCode {
+		return false
+	}
+	if len(f.Statuses) == 0 {
+		return true
+	}
+	for _, status := range f.Statuses {
+		if status == statusMsg.Status {
+			return true
+		}
+	}
+	return false
+}
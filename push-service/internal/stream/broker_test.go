@@ -0,0 +1,189 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/config"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+func statusEvent(notificationID string, status models.NotificationStatusEnum) *models.NotificationStatusMessage {
+	return &models.NotificationStatusMessage{
+		NotificationID: notificationID,
+		UserID:         "u1",
+		TemplateCode:   "welcome",
+		Status:         status,
+	}
+}
+
+// tests events reach only the subscribers whose filter matches
+func TestBrokerFilters(t *testing.T) {
+	broker := NewBroker(nil, config.StreamConfig{BufferSize: 10})
+
+	all, _ := broker.Subscribe(Filter{})
+	failures, _ := broker.Subscribe(Filter{Statuses: ParseStatuses("failed, partially_delivered")})
+	other, _ := broker.Subscribe(Filter{NotificationID: "n2"})
+
+	broker.Publish(context.Background(), statusEvent("n1", models.NotificationStatusDelivered))
+	broker.Publish(context.Background(), statusEvent("n1", models.NotificationStatusFailed))
+
+	if len(all.Events()) != 2 {
+		t.Errorf("Expected 2 events without a filter, got %d", len(all.Events()))
+	}
+	if len(failures.Events()) != 1 || (<-failures.Events()).Status != models.NotificationStatusFailed {
+		t.Error("Expected only the failed event for the status filter")
+	}
+	if len(other.Events()) != 0 {
+		t.Errorf("Expected no events for another notification, got %d", len(other.Events()))
+	}
+
+	t.Run("Tenants only see their own events", func(t *testing.T) {
+		acme, _ := broker.Subscribe(Filter{TenantID: "acme"})
+		event := statusEvent("n3", models.NotificationStatusDelivered)
+		event.TenantID = "globex"
+		broker.Publish(context.Background(), event)
+
+		if len(acme.Events()) != 0 {
+			t.Errorf("Expected no events of another tenant, got %d", len(acme.Events()))
+		}
+		if len(all.Events()) != 2 {
+			t.Errorf("Expected the default tenant not to see other tenants, got %d events", len(all.Events()))
+		}
+
+		event.TenantID = "acme"
+		broker.Publish(context.Background(), event)
+		if len(acme.Events()) != 1 {
+			t.Errorf("Expected the tenant's own event, got %d", len(acme.Events()))
+		}
+	})
+}
+
+// tests a full buffer drops events instead of blocking the publisher
+func TestBrokerBackpressure(t *testing.T) {
+	broker := NewBroker(nil, config.StreamConfig{BufferSize: 2})
+	sub, _ := broker.Subscribe(Filter{})
+
+	for i := 0; i < 5; i++ {
+		broker.Publish(context.Background(), statusEvent("n1", models.NotificationStatusProcessing))
+	}
+
+	if len(sub.Events()) != 2 {
+		t.Errorf("Expected the buffer to hold 2 events, got %d", len(sub.Events()))
+	}
+	if dropped := sub.TakeDropped(); dropped != 3 {
+		t.Errorf("Expected 3 dropped events, got %d", dropped)
+	}
+	if dropped := sub.TakeDropped(); dropped != 0 {
+		t.Errorf("Expected the dropped count to reset, got %d", dropped)
+	}
+}
+
+// tests the connection cap and that unsubscribing frees a slot
+func TestBrokerConnectionCap(t *testing.T) {
+	broker := NewBroker(nil, config.StreamConfig{MaxConnections: 1, BufferSize: 1})
+
+	sub, err := broker.Subscribe(Filter{})
+	if err != nil {
+		t.Fatalf("Expected first subscription to succeed, got %v", err)
+	}
+	if _, err := broker.Subscribe(Filter{}); !errors.Is(err, models.ErrTooManyStreams) {
+		t.Errorf("Expected ErrTooManyStreams, got %v", err)
+	}
+
+	broker.Unsubscribe(sub)
+	if _, err := broker.Subscribe(Filter{}); err != nil {
+		t.Errorf("Expected a free slot after unsubscribing, got %v", err)
+	}
+}
+
+func newTestCache(t *testing.T, server *miniredis.Miniredis) *cache.RedisCache {
+	t.Helper()
+
+	redisCache, err := cache.NewRedisCache(server.Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("failed to connect to miniredis: %v", err)
+	}
+	t.Cleanup(func() { redisCache.Close() })
+	return redisCache
+}
+
+// waits until cond holds, failing after a few seconds
+func eventually(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// tests events are relayed to the subscribers of other replicas, and not
+// published at all while no replica has a subscriber
+func TestBrokerRelay(t *testing.T) {
+	server := miniredis.RunT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	publisher := NewBroker(newTestCache(t, server), config.StreamConfig{BufferSize: 10})
+	receiver := NewBroker(newTestCache(t, server), config.StreamConfig{BufferSize: 10})
+	for _, broker := range []*Broker{publisher, receiver} {
+		broker.refresh = 20 * time.Millisecond
+		go broker.Run(ctx)
+	}
+	eventually(t, func() bool { return publisher.relaying.Load() && !publisher.listeners.Load() },
+		"Expected the relay to start without listeners")
+
+	// a raw subscriber to the channel sees whatever is published
+	raw, err := newTestCache(t, server).Subscribe(ctx, cache.GetStatusStreamChannel())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	publisher.Publish(ctx, statusEvent("n1", models.NotificationStatusDelivered))
+	select {
+	case payload := <-raw:
+		t.Errorf("Expected nothing published without subscribers, got %s", payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	sub, _ := receiver.Subscribe(Filter{})
+	eventually(t, publisher.listeners.Load, "Expected the publisher to learn of the subscriber")
+
+	publisher.Publish(ctx, statusEvent("n2", models.NotificationStatusDelivered))
+	select {
+	case event := <-sub.Events():
+		if event.NotificationID != "n2" {
+			t.Errorf("Expected the relayed event, got %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the event to reach the other replica")
+	}
+}
+
+// tests the relay keeps trying to subscribe when redis is down at start
+func TestBrokerRunRetriesSubscribe(t *testing.T) {
+	server := miniredis.RunT(t)
+	broker := NewBroker(newTestCache(t, server), config.StreamConfig{BufferSize: 10})
+	broker.retryInterval = 10 * time.Millisecond
+	server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go broker.Run(ctx)
+
+	time.Sleep(50 * time.Millisecond)
+	if broker.relaying.Load() {
+		t.Fatal("Expected no relay while redis is down")
+	}
+
+	if err := server.Restart(); err != nil {
+		t.Fatalf("failed to restart miniredis: %v", err)
+	}
+	eventually(t, broker.relaying.Load, "Expected the relay to start once redis is back")
+}
@@ -0,0 +1,215 @@
+package template
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
+)
+
+// how long a stale template is kept to be served while the template service is unavailable
+const maxStaleSeconds = 86400
+
+// raw template entry stored in redis. a locale the template has no copy for
+// is stored as missing so the locale chain is not fetched again every time
+type cachedTemplate struct {
+	Template   *PushTemplate `json:"template,omitempty"`
+	Missing    bool          `json:"missing,omitempty"`
+	ETag       string        `json:"etag,omitempty"`
+	FreshUntil time.Time     `json:"fresh_until"`
+}
+
+// CachedClient caches raw push templates in redis per tenant and renders
+// plain templates locally, only calling the template service to render
+// templates that use filters or expressions
+type CachedClient struct {
+	client        *Client
+	cache         *cache.RedisCache
+	ttl           time.Duration
+	defaultLocale string
+	local         *LocalRenderer // receives snapshots of fetched templates, optional
+}
+
+func NewCachedClient(client *Client, redisCache *cache.RedisCache, ttl time.Duration, defaultLocale string, local *LocalRenderer) *CachedClient {
+	return &CachedClient{
+		client:        client,
+		cache:         redisCache,
+		ttl:           ttl,
+		defaultLocale: defaultLocale,
+		local:         local,
+	}
+}
+
+func (c *CachedClient) RenderPushTemplate(ctx context.Context, tenantID, templateCode, locale string, variables map[string]interface{}) (*PushTemplate, error) {
+	locales := LocaleChain(locale, c.defaultLocale)
+
+	// a template that could not be fetched is not rendered remotely either, the
+	// template service was already retried and the caller falls back locally
+	tmpl, err := c.resolveTemplate(ctx, tenantID, templateCode, locales)
+	if err != nil {
+		return nil, err
+	}
+
+	logFields := logger.Fields{
+		"template_code": templateCode,
+		"locale":        tmpl.Locale,
+	}
+
+	if !IsPlainTemplate(tmpl) {
+		return c.client.RenderPushTemplate(ctx, templateCode, locales, variables)
+	}
+
+	logger.Debug("Rendering cached template locally", logFields)
+
+	return RenderPlain(tmpl, variables), nil
+}
+
+// returns the raw, unrendered template for its variable schema and category
+func (c *CachedClient) GetTemplate(ctx context.Context, tenantID, templateCode, locale string) (*PushTemplate, error) {
+	return c.resolveTemplate(ctx, tenantID, templateCode, LocaleChain(locale, c.defaultLocale))
+}
+
+// returns the template for the first locale of the chain it has a copy for,
+// e.g. pt-BR → pt → en. every step is cached, including the missing ones
+func (c *CachedClient) resolveTemplate(ctx context.Context, tenantID, templateCode string, locales []string) (*PushTemplate, error) {
+	if len(locales) == 0 {
+		locales = []string{""}
+	}
+
+	var err error
+	for _, locale := range locales {
+		var tmpl *PushTemplate
+		tmpl, err = c.getTemplate(ctx, tenantID, templateCode, locale)
+		if !errors.Is(err, models.ErrTemplateNotFound) {
+			if tmpl != nil && tmpl.Locale == "" {
+				tmpl.Locale = locale
+			}
+			return tmpl, err
+		}
+	}
+
+	return nil, err
+}
+
+// removes every locale of a template from the cache of every tenant and from
+// the fallback snapshots, the next render fetches it again
+func (c *CachedClient) Invalidate(ctx context.Context, templateCode string) error {
+	logger.Info("Invalidating cached template", logger.Fields{
+		"template_code": templateCode,
+	})
+
+	key := cache.GetTemplateCacheKey(templateCode)
+	if err := c.cache.Delete(ctx, key); err != nil {
+		return err
+	}
+	if _, err := c.cache.DeleteMatching(ctx, cache.TenantKey("*", key)); err != nil {
+		return err
+	}
+
+	// the fallback renderer must not keep serving a removed template
+	if c.local != nil {
+		return c.local.Forget(ctx, templateCode)
+	}
+	return nil
+}
+
+// returns the raw template for a locale, revalidating it with its etag once
+// stale. a stale template is served when the template service cannot be reached
+func (c *CachedClient) getTemplate(ctx context.Context, tenantID, templateCode, locale string) (*PushTemplate, error) {
+	key := cache.TenantKey(tenantID, cache.GetTemplateCacheKey(templateCode))
+	logFields := logger.Fields{
+		"template_code": templateCode,
+		"locale":        locale,
+	}
+
+	var entry *cachedTemplate
+	raw, err := c.cache.HGet(ctx, key, locale)
+	switch {
+	case err == nil:
+		entry = &cachedTemplate{}
+		if err := json.Unmarshal([]byte(raw), entry); err != nil || (entry.Template == nil && !entry.Missing) {
+			entry = nil
+		}
+	case !errors.Is(err, models.ErrCacheMiss):
+		logger.Error("Failed to read template cache", logger.Merge(
+			logger.WithError(err),
+			logFields,
+		))
+	}
+
+	if entry != nil && time.Now().Before(entry.FreshUntil) {
+		if entry.Missing {
+			return nil, fmt.Errorf("%w: %s (%s)", models.ErrTemplateNotFound, templateCode, locale)
+		}
+		return entry.Template, nil
+	}
+
+	etag := ""
+	if entry != nil && !entry.Missing {
+		etag = entry.ETag
+	}
+
+	tmpl, newETag, notModified, err := c.client.GetPushTemplate(ctx, templateCode, locale, etag)
+	if errors.Is(err, models.ErrTemplateNotFound) {
+		c.store(ctx, key, locale, &cachedTemplate{
+			Missing:    true,
+			FreshUntil: time.Now().Add(c.ttl),
+		})
+		return nil, err
+	}
+	if err != nil {
+		if entry != nil && !models.IsPermanent(err) {
+			logger.Warn("Serving stale template", logger.Merge(
+				logger.WithError(err),
+				logFields,
+			))
+			if entry.Missing {
+				return nil, fmt.Errorf("%w: %s (%s)", models.ErrTemplateNotFound, templateCode, locale)
+			}
+			return entry.Template, nil
+		}
+		return nil, err
+	}
+
+	if notModified && entry != nil && !entry.Missing {
+		tmpl = entry.Template
+	}
+	if tmpl == nil {
+		return nil, fmt.Errorf("template service returned no template for %s", templateCode)
+	}
+
+	// keep a snapshot of new versions for the local fallback renderer
+	if !notModified && c.local != nil {
+		c.local.Snapshot(ctx, tmpl, locale, newETag)
+	}
+
+	c.store(ctx, key, locale, &cachedTemplate{
+		Template:   tmpl,
+		ETag:       newETag,
+		FreshUntil: time.Now().Add(c.ttl),
+	})
+
+	return tmpl, nil
+}
+
+func (c *CachedClient) store(ctx context.Context, key, locale string, entry *cachedTemplate) {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		logger.Error("Failed to marshal cached template", logger.WithError(err))
+		return
+	}
+
+	if err := c.cache.HSet(ctx, key, locale, string(body)); err != nil {
+		logger.Error("Failed to cache template", logger.WithError(err))
+		return
+	}
+
+	if err := c.cache.Expire(ctx, key, maxStaleSeconds); err != nil {
+		logger.Error("Failed to set template cache expiry", logger.WithError(err))
+	}
+}
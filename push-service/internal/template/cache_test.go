@@ -0,0 +1,139 @@
+package template
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+)
+
+// tests the locale chain is walked and every step is cached
+func TestCachedClientLocaleChain(t *testing.T) {
+	var mu sync.Mutex
+	requested := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locale := r.URL.Query().Get("locale")
+		mu.Lock()
+		requested = append(requested, locale)
+		mu.Unlock()
+
+		if locale != "pt" && locale != "en" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"code":"welcome","name":"Welcome","title":"Olá","locale":"` + locale + `"}`))
+	}))
+	defer server.Close()
+
+	redisCache, err := cache.NewRedisCache(miniredis.RunT(t).Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("failed to connect to miniredis: %v", err)
+	}
+	defer redisCache.Close()
+
+	client := NewCachedClient(NewClient(server.URL, time.Second, nil, nil), redisCache, time.Minute, "en", nil)
+
+	for i := 0; i < 2; i++ {
+		tmpl, err := client.GetTemplate(context.Background(), "", "welcome", "pt_br")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if tmpl.Locale != "pt" {
+			t.Errorf("Expected the pt copy, got '%s'", tmpl.Locale)
+		}
+	}
+
+	if len(requested) != 2 || requested[0] != "pt-BR" || requested[1] != "pt" {
+		t.Errorf("Expected pt-BR then pt to be fetched once, got %v", requested)
+	}
+}
+
+// tests templates are cached per tenant and invalidated for every tenant
+func TestCachedClientInvalidate(t *testing.T) {
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write([]byte(`{"code":"welcome","name":"Welcome","title":"Hello","locale":"en"}`))
+	}))
+	defer server.Close()
+
+	redisCache, err := cache.NewRedisCache(miniredis.RunT(t).Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("failed to connect to miniredis: %v", err)
+	}
+	defer redisCache.Close()
+
+	client := NewCachedClient(NewClient(server.URL, time.Second, nil, nil), redisCache, time.Minute, "en", nil)
+	ctx := context.Background()
+
+	for _, tenantID := range []string{"", "acme", "acme"} {
+		if _, err := client.GetTemplate(ctx, tenantID, "welcome", "en"); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if fetches.Load() != 2 {
+		t.Errorf("Expected one fetch per tenant, got %d", fetches.Load())
+	}
+
+	if err := client.Invalidate(ctx, "welcome"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, tenantID := range []string{"", "acme"} {
+		if _, err := client.GetTemplate(ctx, tenantID, "welcome", "en"); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if fetches.Load() != 4 {
+		t.Errorf("Expected every tenant to fetch again after invalidation, got %d fetches", fetches.Load())
+	}
+}
+
+// tests invalidation removes the fallback snapshots of a template on every replica
+func TestCachedClientInvalidateSnapshots(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"code":"welcome","name":"Welcome","title":"Hello","locale":"en"}`))
+	}))
+	defer server.Close()
+
+	redisCache, err := cache.NewRedisCache(miniredis.RunT(t).Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("failed to connect to miniredis: %v", err)
+	}
+	defer redisCache.Close()
+
+	local := NewLocalRenderer("", redisCache, "en")
+	replica := NewLocalRenderer("", redisCache, "en")
+	client := NewCachedClient(NewClient(server.URL, time.Second, nil, nil), redisCache, time.Minute, "en", local)
+	ctx := context.Background()
+
+	if _, err := client.GetTemplate(ctx, "", "welcome", "en"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, renderer := range []*LocalRenderer{local, replica} {
+		if err := renderer.Sync(ctx); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, _, err := renderer.Render("welcome", "en", nil); err != nil {
+			t.Fatalf("Expected the snapshot to render, got %v", err)
+		}
+	}
+
+	if err := client.Invalidate(ctx, "welcome"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := replica.Sync(ctx); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for name, renderer := range map[string]*LocalRenderer{"local": local, "replica": replica} {
+		if _, _, err := renderer.Render("welcome", "en", nil); err == nil {
+			t.Errorf("Expected the %s snapshot to be removed", name)
+		}
+	}
+}
@@ -4,16 +4,27 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	neturl "net/url"
 	"time"
 
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/push"
 	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
 )
 
 type Client struct {
-	baseURL    string
-	httpClient *http.Client
+	baseURL        string
+	httpClient     *http.Client
+	circuitBreaker *push.CircuitBreaker
+	retrier        Retrier
+}
+
+// retries transient failures with backoff, permanent errors are returned at once
+type Retrier interface {
+	RetryWithBackoff(ctx context.Context, fn func() error) error
 }
 
 type PushTemplate struct {
@@ -32,88 +43,207 @@ type PushTemplate struct {
 	Sound       string                 `json:"sound,omitempty"`
 	Badge       int                    `json:"badge,omitempty"`
 	Priority    int                    `json:"priority,omitempty"`
+	Locale      string                 `json:"locale,omitempty"`
+
+	// keys and arguments for client-side localization on the device
+	TitleLocKey  string   `json:"title_loc_key,omitempty"`
+	TitleLocArgs []string `json:"title_loc_args,omitempty"`
+	BodyLocKey   string   `json:"body_loc_key,omitempty"`
+	BodyLocArgs  []string `json:"body_loc_args,omitempty"`
+
+	// declared variables, checked before the template is rendered
+	Variables []TemplateVariable `json:"variables,omitempty"`
 }
 
 type RenderPushTemplateRequest struct {
-	TemplateCode string            `json:"template_code"`
-	Context      map[string]string `json:"context"`
+	TemplateCode    string                 `json:"template_code"`
+	Context         map[string]interface{} `json:"context"`
+	Locale          string                 `json:"locale,omitempty"`
+	FallbackLocales []string               `json:"fallback_locales,omitempty"`
 }
 
-func NewClient(baseURL string, timeout time.Duration) *Client {
+func NewClient(baseURL string, timeout time.Duration, cb *push.CircuitBreaker, retrier Retrier) *Client {
 	return &Client{
 		baseURL: baseURL,
 		httpClient: &http.Client{
 			Timeout: timeout,
 		},
+		circuitBreaker: cb,
+		retrier:        retrier,
 	}
 }
 
-func (c *Client) RenderPushTemplate(ctx context.Context, templateCode string, variables map[string]string) (*PushTemplate, error) {
+// renders a template remotely. locales is the ordered fallback chain, the
+// template service picks the first locale it has copy for
+func (c *Client) RenderPushTemplate(ctx context.Context, templateCode string, locales []string, variables map[string]interface{}) (*PushTemplate, error) {
 	url := fmt.Sprintf("%s/templates/push/render", c.baseURL)
 
 	logFields := logger.Fields{
 		"template_code": templateCode,
 		"url":           url,
+		"locales":       locales,
 	}
 
 	renderReq := RenderPushTemplateRequest{
 		TemplateCode: templateCode,
 		Context:      variables,
 	}
+	if len(locales) > 0 {
+		renderReq.Locale = locales[0]
+		renderReq.FallbackLocales = locales[1:]
+	}
 
 	body, err := json.Marshal(renderReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(body))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
+	logger.Info("Rendering push template from template service", logFields)
 
-	req.Header.Set("Content-Type", "application/json")
+	var template *PushTemplate
+	err = c.call(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
 
-	logger.Info("Rendering push template from template service", logFields)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("%w: %w", models.ErrTemplateServiceUnavailable, err)
+		}
+		defer resp.Body.Close()
 
-	resp, err := c.httpClient.Do(req)
+		if resp.StatusCode != http.StatusOK {
+			return statusError(resp.StatusCode, templateCode)
+		}
+
+		template = &PushTemplate{}
+		if err := json.NewDecoder(resp.Body).Decode(template); err != nil {
+			return fmt.Errorf("failed to decode template: %w", err)
+		}
+
+		return nil
+	})
 	if err != nil {
 		logger.Error("Failed to render template from template service", logger.Merge(
 			logger.WithError(err),
 			logFields,
+			logger.Fields{"permanent": models.IsPermanent(err)},
 		))
-		return nil, fmt.Errorf("template service request failed: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotFound {
-		logger.Warn("Template not found in template service", logger.Merge(
-			logFields,
-			logger.Fields{"status_code": resp.StatusCode},
-		))
-		return nil, fmt.Errorf("template not found: %s", templateCode)
+	logger.Info("Successfully rendered template", logger.Merge(
+		logFields,
+		logger.Fields{"template_name": template.Name},
+	))
+
+	return template, nil
+}
+
+// fetches a raw push template. when etag matches the current version the
+// template service answers 304 and notModified is returned instead
+func (c *Client) GetPushTemplate(ctx context.Context, templateCode, locale, etag string) (tmpl *PushTemplate, newETag string, notModified bool, err error) {
+	url := fmt.Sprintf("%s/templates/pull/%s", c.baseURL, templateCode)
+	if locale != "" {
+		url = fmt.Sprintf("%s?locale=%s", url, neturl.QueryEscape(locale))
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		logger.Error("Template service returned error", logger.Merge(
-			logFields,
-			logger.Fields{"status_code": resp.StatusCode},
-		))
-		return nil, fmt.Errorf("template service returned status %d", resp.StatusCode)
+	logFields := logger.Fields{
+		"template_code": templateCode,
+		"url":           url,
 	}
 
-	var template PushTemplate
-	if err := json.NewDecoder(resp.Body).Decode(&template); err != nil {
-		logger.Error("Failed to decode template response", logger.Merge(
+	err = c.call(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("%w: %w", models.ErrTemplateServiceUnavailable, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusNotModified {
+			tmpl, newETag, notModified = nil, etag, true
+			return nil
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return statusError(resp.StatusCode, templateCode)
+		}
+
+		tmpl = &PushTemplate{}
+		if err := json.NewDecoder(resp.Body).Decode(tmpl); err != nil {
+			return fmt.Errorf("failed to decode template: %w", err)
+		}
+		newETag, notModified = resp.Header.Get("ETag"), false
+
+		return nil
+	})
+	if err != nil {
+		logger.Error("Failed to fetch template from template service", logger.Merge(
 			logger.WithError(err),
 			logFields,
+			logger.Fields{"permanent": models.IsPermanent(err)},
 		))
-		return nil, fmt.Errorf("failed to decode template: %w", err)
+		return nil, "", false, err
 	}
 
-	logger.Info("Successfully rendered template", logger.Merge(
-		logFields,
-		logger.Fields{"template_name": template.Name},
-	))
+	return tmpl, newETag, notModified, nil
+}
+
+// runs a template service request with retries behind the circuit breaker.
+// permanent errors are not retried and do not count as breaker failures
+func (c *Client) call(ctx context.Context, fn func() error) error {
+	attempt := func() error {
+		if c.circuitBreaker == nil {
+			return fn()
+		}
+
+		var permanentErr error
+		err := c.circuitBreaker.Call(func() error {
+			err := fn()
+			if models.IsPermanent(err) {
+				permanentErr = err
+				return nil
+			}
+			return err
+		})
+
+		if permanentErr != nil {
+			return permanentErr
+		}
+		if errors.Is(err, models.ErrCircuitBreakerOpen) {
+			return fmt.Errorf("%w: %w", models.ErrTemplateServiceUnavailable, err)
+		}
+		return err
+	}
 
-	return &template, nil
+	if c.retrier == nil {
+		return attempt()
+	}
+	return c.retrier.RetryWithBackoff(ctx, attempt)
+}
+
+// maps a template service status code to a typed error
+func statusError(statusCode int, templateCode string) error {
+	switch {
+	case statusCode == http.StatusNotFound:
+		return fmt.Errorf("%w: %s", models.ErrTemplateNotFound, templateCode)
+	case statusCode == http.StatusTooManyRequests, statusCode == http.StatusRequestTimeout, statusCode >= 500:
+		return fmt.Errorf("%w: status %d", models.ErrTemplateServiceUnavailable, statusCode)
+	case statusCode >= 400:
+		return fmt.Errorf("%w: %s returned status %d", models.ErrTemplateRenderFailed, templateCode, statusCode)
+	default:
+		return fmt.Errorf("%w: unexpected status %d", models.ErrTemplateServiceUnavailable, statusCode)
+	}
 }
@@ -0,0 +1,155 @@
+package template
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/push"
+)
+
+// retries immediately, stopping on permanent errors and an open breaker like the retry service
+type testRetrier struct {
+	maxAttempts int
+}
+
+func (r *testRetrier) RetryWithBackoff(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 0; attempt < r.maxAttempts; attempt++ {
+		if err = fn(); err == nil || models.IsPermanent(err) || errors.Is(err, models.ErrCircuitBreakerOpen) {
+			return err
+		}
+	}
+	return err
+}
+
+// tests status codes are mapped to typed errors
+func TestStatusError(t *testing.T) {
+	testCases := []struct {
+		statusCode int
+		expected   error
+		permanent  bool
+	}{
+		{http.StatusNotFound, models.ErrTemplateNotFound, true},
+		{http.StatusUnprocessableEntity, models.ErrTemplateRenderFailed, true},
+		{http.StatusBadRequest, models.ErrTemplateRenderFailed, true},
+		{http.StatusServiceUnavailable, models.ErrTemplateServiceUnavailable, false},
+		{http.StatusTooManyRequests, models.ErrTemplateServiceUnavailable, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(http.StatusText(tc.statusCode), func(t *testing.T) {
+			err := statusError(tc.statusCode, "welcome")
+			if !errors.Is(err, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, err)
+			}
+			if models.IsPermanent(err) != tc.permanent {
+				t.Errorf("Expected permanent %v for %v", tc.permanent, err)
+			}
+		})
+	}
+}
+
+// tests transient failures are retried and permanent ones are not
+func TestClientRenderPushTemplate(t *testing.T) {
+	t.Run("Transient failure is retried", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`{"code":"welcome","name":"Welcome","title":"Hi"}`))
+		}))
+		defer server.Close()
+
+		client := NewClient(server.URL, time.Second, push.NewCircuitBreaker(3, 5, time.Minute, time.Minute), &testRetrier{maxAttempts: 3})
+
+		tmpl, err := client.RenderPushTemplate(context.Background(), "welcome", []string{"en"}, nil)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if tmpl.Title != "Hi" {
+			t.Errorf("Unexpected template %+v", tmpl)
+		}
+		if calls.Load() != 3 {
+			t.Errorf("Expected 3 calls, got %d", calls.Load())
+		}
+	})
+
+	t.Run("Missing template is not retried", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		cb := push.NewCircuitBreaker(3, 1, time.Minute, time.Minute)
+		client := NewClient(server.URL, time.Second, cb, &testRetrier{maxAttempts: 3})
+
+		_, err := client.RenderPushTemplate(context.Background(), "missing", []string{"en"}, nil)
+		if !errors.Is(err, models.ErrTemplateNotFound) {
+			t.Fatalf("Expected ErrTemplateNotFound, got %v", err)
+		}
+		if calls.Load() != 1 {
+			t.Errorf("Expected 1 call, got %d", calls.Load())
+		}
+
+		// permanent errors do not trip the breaker
+		if cb.GetState() != push.StateClosed {
+			t.Errorf("Expected breaker to stay closed, got %s", cb.GetState().String())
+		}
+	})
+
+	t.Run("Open breaker is reported as unavailable", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.URL, time.Second, push.NewCircuitBreaker(1, 1, time.Minute, time.Minute), &testRetrier{maxAttempts: 2})
+
+		_, err := client.RenderPushTemplate(context.Background(), "welcome", []string{"en"}, nil)
+		if !errors.Is(err, models.ErrCircuitBreakerOpen) || !errors.Is(err, models.ErrTemplateServiceUnavailable) {
+			t.Errorf("Expected open breaker error, got %v", err)
+		}
+	})
+}
+
+// tests a template is revalidated with the etag the template service sent
+func TestClientGetPushTemplateRevalidation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.Header().Set("ETag", `"v1"`)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`{"code":"welcome","name":"Welcome","title":"Hi"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, time.Second, nil, nil)
+
+	tmpl, etag, notModified, err := client.GetPushTemplate(context.Background(), "welcome", "en", "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if notModified || tmpl == nil || etag != `"v1"` {
+		t.Fatalf("Expected the template with its etag, got %+v, %s, %v", tmpl, etag, notModified)
+	}
+
+	tmpl, etag, notModified, err = client.GetPushTemplate(context.Background(), "welcome", "en", etag)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !notModified || tmpl != nil || etag != `"v1"` {
+		t.Errorf("Expected the template to be unchanged, got %+v, %s, %v", tmpl, etag, notModified)
+	}
+}
@@ -0,0 +1,655 @@
+package template
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	templateparse "text/template/parse"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
+)
+
+// template syntaxes understood by the local renderer
+const (
+	SyntaxGo    = "go"    // text/template, variables as {{.name}}
+	SyntaxJinja = "jinja" // {{ name | filter }} as used by the template service
+)
+
+// a versioned push template rendered without the template service.
+// the locale comes from the embedded push template, empty for the default copy
+type LocalTemplate struct {
+	PushTemplate
+	Version string `json:"version"`
+	Syntax  string `json:"syntax,omitempty"`
+}
+
+// a local template parsed and ready to execute
+type compiledTemplate struct {
+	source       *LocalTemplate
+	fields       map[string]*texttemplate.Template
+	data         map[string]*texttemplate.Template
+	titleLocArgs []*texttemplate.Template
+	bodyLocArgs  []*texttemplate.Template
+	variables    []string // names referenced by the template, rendered empty when missing
+}
+
+// functions available to local templates. only pure formatting helpers are
+// exposed so templates cannot reach anything outside their variables.
+// values may be any JSON type and are formatted as text first
+var safeFuncs = texttemplate.FuncMap{
+	"default": func(def string, value interface{}) interface{} {
+		if isEmptyValue(value) {
+			return def
+		}
+		return value
+	},
+	"upper": func(value interface{}) string {
+		return strings.ToUpper(formatValue(value))
+	},
+	"lower": func(value interface{}) string {
+		return strings.ToLower(formatValue(value))
+	},
+	"trim": func(value interface{}) string {
+		return strings.TrimSpace(formatValue(value))
+	},
+	"title": func(value interface{}) string {
+		words := strings.Fields(formatValue(value))
+		for i, word := range words {
+			runes := []rune(word)
+			words[i] = strings.ToUpper(string(runes[0])) + string(runes[1:])
+		}
+		return strings.Join(words, " ")
+	},
+	"truncate": func(length int, value interface{}) string {
+		s := formatValue(value)
+		runes := []rune(s)
+		if length < 0 || len(runes) <= length {
+			return s
+		}
+		return string(runes[:length])
+	},
+	"join": func(sep string, value interface{}) string {
+		list, ok := value.([]interface{})
+		if !ok {
+			return formatValue(value)
+		}
+		parts := make([]string, len(list))
+		for i, item := range list {
+			parts[i] = formatValue(item)
+		}
+		return strings.Join(parts, sep)
+	},
+}
+
+// LocalRenderer renders push templates in process. templates are loaded from
+// a local directory and from snapshots of the template service kept in redis,
+// so pushes can still be rendered while the template service is down
+type LocalRenderer struct {
+	dir           string
+	cache         *cache.RedisCache
+	defaultLocale string
+
+	mutex     sync.RWMutex
+	templates map[string]*compiledTemplate // loaded from the local directory
+	snapshots map[string]*compiledTemplate // synced from redis, preferred over local files
+}
+
+func NewLocalRenderer(dir string, redisCache *cache.RedisCache, defaultLocale string) *LocalRenderer {
+	return &LocalRenderer{
+		dir:           dir,
+		cache:         redisCache,
+		defaultLocale: defaultLocale,
+		templates:     make(map[string]*compiledTemplate),
+		snapshots:     make(map[string]*compiledTemplate),
+	}
+}
+
+// key of a template in the local store, one entry per locale
+func localTemplateKey(templateCode, locale string) string {
+	if locale == "" {
+		return templateCode
+	}
+	return templateCode + "@" + NormalizeLocale(locale)
+}
+
+// returns the template in the first locale of the fallback chain that has
+// copy, then the unlocalized template, nil when there is none
+func (r *LocalRenderer) lookup(templateCode, locale string) *compiledTemplate {
+	keys := make([]string, 0, 4)
+	for _, l := range LocaleChain(locale, r.defaultLocale) {
+		keys = append(keys, localTemplateKey(templateCode, l))
+	}
+	keys = append(keys, templateCode)
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, key := range keys {
+		if t, ok := r.snapshots[key]; ok {
+			return t
+		}
+		if t, ok := r.templates[key]; ok {
+			return t
+		}
+	}
+	return nil
+}
+
+// returns the variable schema of the local copy of a template, nil when
+// there is no local copy or it declares no variables
+func (r *LocalRenderer) Schema(templateCode, locale string) []TemplateVariable {
+	compiled := r.lookup(templateCode, locale)
+	if compiled == nil {
+		return nil
+	}
+	return compiled.source.Variables
+}
+
+// renders a template in the first locale of the fallback chain that has
+// copy, then the unlocalized template, and returns the version that was used
+func (r *LocalRenderer) Render(templateCode, locale string, variables map[string]interface{}) (*PushTemplate, string, error) {
+	compiled := r.lookup(templateCode, locale)
+	if compiled == nil {
+		return nil, "", fmt.Errorf("no local template for %s", templateCode)
+	}
+
+	// missing values in an interface map would print as "<no value>"
+	data := make(map[string]interface{}, len(compiled.variables)+len(variables))
+	for _, name := range compiled.variables {
+		data[name] = ""
+	}
+	for name, value := range variables {
+		if value != nil {
+			data[name] = value
+		}
+	}
+
+	rendered := compiled.source.PushTemplate
+	targets := map[string]*string{
+		"title":     &rendered.Title,
+		"body":      &rendered.Body,
+		"image_url": &rendered.ImageURL,
+		"icon_url":  &rendered.IconURL,
+		"link":      &rendered.Link,
+	}
+	for name, tmpl := range compiled.fields {
+		value, err := execute(tmpl, data)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to render %s of %s: %w", name, templateCode, err)
+		}
+		*targets[name] = value
+	}
+
+	var err error
+	if rendered.TitleLocArgs, err = executeAll(compiled.titleLocArgs, data); err != nil {
+		return nil, "", fmt.Errorf("failed to render title_loc_args of %s: %w", templateCode, err)
+	}
+	if rendered.BodyLocArgs, err = executeAll(compiled.bodyLocArgs, data); err != nil {
+		return nil, "", fmt.Errorf("failed to render body_loc_args of %s: %w", templateCode, err)
+	}
+
+	if compiled.source.Data != nil {
+		rendered.Data = make(map[string]interface{}, len(compiled.source.Data))
+		for key, value := range compiled.source.Data {
+			rendered.Data[key] = value
+		}
+		for key, tmpl := range compiled.data {
+			value, err := execute(tmpl, data)
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to render data %s of %s: %w", key, templateCode, err)
+			}
+			rendered.Data[key] = value
+		}
+	}
+
+	return &rendered, compiled.source.Version, nil
+}
+
+// loads every *.json template in the local directory
+func (r *LocalRenderer) LoadDir() error {
+	if r.dir == "" {
+		return nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(r.dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list local templates: %w", err)
+	}
+
+	loaded := make(map[string]*compiledTemplate, len(files))
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read local template %s: %w", file, err)
+		}
+
+		var source LocalTemplate
+		if err := json.Unmarshal(raw, &source); err != nil {
+			return fmt.Errorf("failed to decode local template %s: %w", file, err)
+		}
+		if source.Syntax == "" {
+			source.Syntax = SyntaxGo
+		}
+
+		compiled, err := compileLocalTemplate(&source)
+		if err != nil {
+			return fmt.Errorf("failed to compile local template %s: %w", file, err)
+		}
+		loaded[localTemplateKey(source.Code, source.Locale)] = compiled
+	}
+
+	r.mutex.Lock()
+	for code, compiled := range loaded {
+		r.templates[code] = compiled
+	}
+	r.mutex.Unlock()
+
+	logger.Info("Local templates loaded", logger.Fields{
+		"dir":   r.dir,
+		"count": len(loaded),
+	})
+
+	return nil
+}
+
+// stores a snapshot of a template fetched from the template service.
+// templates that use features the local engine lacks are skipped
+func (r *LocalRenderer) Snapshot(ctx context.Context, tmpl *PushTemplate, locale, etag string) {
+	source := &LocalTemplate{
+		PushTemplate: *tmpl,
+		Version:      etag,
+		Syntax:       SyntaxJinja,
+	}
+	if source.Locale == "" {
+		source.Locale = locale
+	}
+	if source.Version == "" {
+		source.Version = contentVersion(tmpl)
+	}
+
+	if _, err := compileLocalTemplate(source); err != nil {
+		logger.Debug("Template not supported by local renderer", logger.Merge(
+			logger.WithError(err),
+			logger.Fields{"template_code": tmpl.Code},
+		))
+		return
+	}
+
+	body, err := json.Marshal(source)
+	if err != nil {
+		logger.Error("Failed to marshal template snapshot", logger.WithError(err))
+		return
+	}
+
+	key := localTemplateKey(source.Code, source.Locale)
+	if err := r.cache.HSet(ctx, cache.GetTemplateSnapshotKey(), key, string(body)); err != nil {
+		logger.Error("Failed to store template snapshot", logger.WithError(err))
+	}
+}
+
+// loads the latest template snapshots from redis. the snapshots replace the
+// synced ones, so templates removed from redis are no longer rendered
+func (r *LocalRenderer) Sync(ctx context.Context) error {
+	snapshots, err := r.cache.HGetAll(ctx, cache.GetTemplateSnapshotKey())
+	if err != nil {
+		return err
+	}
+
+	loaded := make(map[string]*compiledTemplate, len(snapshots))
+	for key, raw := range snapshots {
+		var source LocalTemplate
+		if err := json.Unmarshal([]byte(raw), &source); err != nil {
+			logger.Error("Failed to decode template snapshot", logger.Merge(
+				logger.WithError(err),
+				logger.Fields{"template_key": key},
+			))
+			continue
+		}
+
+		compiled, err := compileLocalTemplate(&source)
+		if err != nil {
+			logger.Error("Failed to compile template snapshot", logger.Merge(
+				logger.WithError(err),
+				logger.Fields{"template_key": key},
+			))
+			continue
+		}
+		loaded[key] = compiled
+	}
+
+	r.mutex.Lock()
+	r.snapshots = loaded
+	r.mutex.Unlock()
+
+	return nil
+}
+
+// removes the snapshots of a template in every locale, other replicas drop
+// them on their next sync
+func (r *LocalRenderer) Forget(ctx context.Context, templateCode string) error {
+	snapshots, err := r.cache.HGetAll(ctx, cache.GetTemplateSnapshotKey())
+	if err != nil {
+		return err
+	}
+
+	var fields []string
+	for key := range snapshots {
+		if key == templateCode || strings.HasPrefix(key, templateCode+"@") {
+			fields = append(fields, key)
+		}
+	}
+	if len(fields) > 0 {
+		if err := r.cache.HDel(ctx, cache.GetTemplateSnapshotKey(), fields...); err != nil {
+			return err
+		}
+	}
+
+	r.mutex.Lock()
+	for key := range r.snapshots {
+		if key == templateCode || strings.HasPrefix(key, templateCode+"@") {
+			delete(r.snapshots, key)
+		}
+	}
+	r.mutex.Unlock()
+
+	return nil
+}
+
+// periodically syncs template snapshots from redis
+func (r *LocalRenderer) RunSync(ctx context.Context, interval time.Duration) {
+	if err := r.Sync(ctx); err != nil {
+		logger.Error("Failed to sync template snapshots", logger.WithError(err))
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Sync(ctx); err != nil {
+				logger.Error("Failed to sync template snapshots", logger.WithError(err))
+			}
+		}
+	}
+}
+
+func compileLocalTemplate(source *LocalTemplate) (*compiledTemplate, error) {
+	compiled := &compiledTemplate{
+		source: source,
+		fields: make(map[string]*texttemplate.Template),
+		data:   make(map[string]*texttemplate.Template),
+	}
+
+	fields := map[string]string{
+		"title":     source.Title,
+		"body":      source.Body,
+		"image_url": source.ImageURL,
+		"icon_url":  source.IconURL,
+		"link":      source.Link,
+	}
+	for name, text := range fields {
+		tmpl, err := parse(name, text, source.Syntax)
+		if err != nil {
+			return nil, err
+		}
+		compiled.fields[name] = tmpl
+	}
+
+	var err error
+	if compiled.titleLocArgs, err = parseAll("title_loc_args", source.TitleLocArgs, source.Syntax); err != nil {
+		return nil, err
+	}
+	if compiled.bodyLocArgs, err = parseAll("body_loc_args", source.BodyLocArgs, source.Syntax); err != nil {
+		return nil, err
+	}
+
+	for key, value := range source.Data {
+		text, ok := value.(string)
+		if !ok {
+			continue
+		}
+		tmpl, err := parse("data."+key, text, source.Syntax)
+		if err != nil {
+			return nil, err
+		}
+		compiled.data[key] = tmpl
+	}
+
+	names := make(map[string]bool)
+	for _, tmpl := range compiled.fields {
+		referencedVariables(tmpl.Root, names)
+	}
+	for _, tmpl := range compiled.data {
+		referencedVariables(tmpl.Root, names)
+	}
+	for _, tmpl := range append(compiled.titleLocArgs, compiled.bodyLocArgs...) {
+		referencedVariables(tmpl.Root, names)
+	}
+	for name := range names {
+		compiled.variables = append(compiled.variables, name)
+	}
+
+	return compiled, nil
+}
+
+// collects the variable names a parsed template reads, either as {{.name}}
+// or as {{index . "name"}} from translated jinja
+func referencedVariables(node templateparse.Node, names map[string]bool) {
+	switch n := node.(type) {
+	case *templateparse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			referencedVariables(child, names)
+		}
+	case *templateparse.ActionNode:
+		referencedVariables(n.Pipe, names)
+	case *templateparse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			referencedVariables(cmd, names)
+		}
+	case *templateparse.CommandNode:
+		if len(n.Args) >= 3 {
+			identifier, isIdent := n.Args[0].(*templateparse.IdentifierNode)
+			_, isDot := n.Args[1].(*templateparse.DotNode)
+			key, isString := n.Args[2].(*templateparse.StringNode)
+			if isIdent && identifier.Ident == "index" && isDot && isString {
+				names[key.Text] = true
+			}
+		}
+		for _, arg := range n.Args {
+			referencedVariables(arg, names)
+		}
+	case *templateparse.FieldNode:
+		names[n.Ident[0]] = true
+	case *templateparse.IfNode:
+		referencedVariables(n.Pipe, names)
+		referencedVariables(n.List, names)
+		referencedVariables(n.ElseList, names)
+	case *templateparse.RangeNode:
+		referencedVariables(n.Pipe, names)
+		referencedVariables(n.List, names)
+		referencedVariables(n.ElseList, names)
+	case *templateparse.WithNode:
+		referencedVariables(n.Pipe, names)
+		referencedVariables(n.List, names)
+		referencedVariables(n.ElseList, names)
+	}
+}
+
+func parse(name, text, syntax string) (*texttemplate.Template, error) {
+	if syntax == SyntaxJinja {
+		translated, err := translateJinja(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		text = translated
+	}
+
+	tmpl, err := texttemplate.New(name).Funcs(safeFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+func parseAll(name string, texts []string, syntax string) ([]*texttemplate.Template, error) {
+	templates := make([]*texttemplate.Template, len(texts))
+	for i, text := range texts {
+		tmpl, err := parse(fmt.Sprintf("%s[%d]", name, i), text, syntax)
+		if err != nil {
+			return nil, err
+		}
+		templates[i] = tmpl
+	}
+	return templates, nil
+}
+
+func executeAll(templates []*texttemplate.Template, variables map[string]interface{}) ([]string, error) {
+	if len(templates) == 0 {
+		return nil, nil
+	}
+
+	values := make([]string, len(templates))
+	for i, tmpl := range templates {
+		value, err := execute(tmpl, variables)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func execute(tmpl *texttemplate.Template, variables map[string]interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, variables); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+var (
+	// a jinja expression: a variable followed by optional filters
+	jinjaExpressionPattern = regexp.MustCompile(`\{\{\s*(.*?)\s*\}\}`)
+	jinjaVariablePattern   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	jinjaFilterPattern     = regexp.MustCompile(`^([a-z_]+)\s*(?:\((.*)\))?$`)
+)
+
+// translates the jinja subset used by push templates into text/template syntax,
+// e.g. {{ name | default('there') }} becomes {{index . "name" | default "there"}}
+func translateJinja(text string) (string, error) {
+	if strings.Contains(text, "{%") || strings.Contains(text, "{#") {
+		return "", fmt.Errorf("jinja statements are not supported")
+	}
+
+	var translateErr error
+	translated := jinjaExpressionPattern.ReplaceAllStringFunc(text, func(match string) string {
+		expression := jinjaExpressionPattern.FindStringSubmatch(match)[1]
+		parts := strings.Split(expression, "|")
+
+		variable := strings.TrimSpace(parts[0])
+		if !jinjaVariablePattern.MatchString(variable) {
+			translateErr = fmt.Errorf("unsupported expression %q", expression)
+			return match
+		}
+
+		pipeline := []string{fmt.Sprintf("index . %q", variable)}
+		for _, filter := range parts[1:] {
+			command, err := translateFilter(strings.TrimSpace(filter))
+			if err != nil {
+				translateErr = err
+				return match
+			}
+			pipeline = append(pipeline, command)
+		}
+
+		return "{{" + strings.Join(pipeline, " | ") + "}}"
+	})
+
+	if translateErr != nil {
+		return "", translateErr
+	}
+	return translated, nil
+}
+
+func translateFilter(filter string) (string, error) {
+	match := jinjaFilterPattern.FindStringSubmatch(filter)
+	if match == nil {
+		return "", fmt.Errorf("unsupported filter %q", filter)
+	}
+
+	name, arg := match[1], strings.TrimSpace(match[2])
+	switch name {
+	case "upper", "lower", "title", "trim":
+		if arg != "" {
+			return "", fmt.Errorf("filter %s takes no arguments", name)
+		}
+		return name, nil
+	case "default":
+		value, err := unquote(arg)
+		if err != nil {
+			return "", fmt.Errorf("default: %w", err)
+		}
+		return fmt.Sprintf("default %q", value), nil
+	case "truncate":
+		length, err := strconv.Atoi(arg)
+		if err != nil {
+			return "", fmt.Errorf("truncate: invalid length %q", arg)
+		}
+		return fmt.Sprintf("truncate %d", length), nil
+	case "join":
+		sep := ""
+		if arg != "" {
+			value, err := unquote(arg)
+			if err != nil {
+				return "", fmt.Errorf("join: %w", err)
+			}
+			sep = value
+		}
+		return fmt.Sprintf("join %q", sep), nil
+	case "length":
+		if arg != "" {
+			return "", fmt.Errorf("filter %s takes no arguments", name)
+		}
+		return "len", nil
+	default:
+		return "", fmt.Errorf("unsupported filter %q", name)
+	}
+}
+
+// unquotes a single or double quoted jinja string literal
+func unquote(literal string) (string, error) {
+	if len(literal) >= 2 {
+		first, last := literal[0], literal[len(literal)-1]
+		if (first == '\'' || first == '"') && first == last {
+			return literal[1 : len(literal)-1], nil
+		}
+	}
+	return "", fmt.Errorf("expected a quoted string, got %q", literal)
+}
+
+// content hash used as the version of templates served without an etag
+func contentVersion(tmpl *PushTemplate) string {
+	body, _ := json.Marshal(tmpl)
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])[:12]
+}
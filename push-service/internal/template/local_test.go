@@ -0,0 +1,212 @@
+package template
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tests translation of the jinja subset used by the template service
+func TestTranslateJinja(t *testing.T) {
+	testCases := []struct {
+		name      string
+		input     string
+		expected  string
+		expectErr bool
+	}{
+		{"Plain text", "Hello", "Hello", false},
+		{"Variable", "Code {{reset_code}}", `Code {{index . "reset_code"}}`, false},
+		{"Default filter", "Hey {{user_name | default('there')}}", `Hey {{index . "user_name" | default "there"}}`, false},
+		{"Chained filters", "{{ name | trim | upper }}", `{{index . "name" | trim | upper}}`, false},
+		{"Truncate", "{{ body | truncate(10) }}", `{{index . "body" | truncate 10}}`, false},
+		{"Join", "{{ items | join(', ') }}", `{{index . "items" | join ", "}}`, false},
+		{"Length", "{{ items | length }}", `{{index . "items" | len}}`, false},
+		{"Statement", "{% if name %}Hi{% endif %}", "", true},
+		{"Unknown filter", "{{ name | escape }}", "", true},
+		{"Expression", "{{ a + b }}", "", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			translated, err := translateJinja(tc.input)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("Expected error, got '%s'", translated)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if translated != tc.expected {
+				t.Errorf("Expected '%s', got '%s'", tc.expected, translated)
+			}
+		})
+	}
+}
+
+// tests rendering templates loaded from a directory
+func TestLocalRendererLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	tmpl := `{
+		"code": "WELCOME",
+		"version": "v3",
+		"title": "Welcome {{.name | default \"there\" | title}}",
+		"body": "Your code is {{.code}}",
+		"data": {"code": "{{.code}}", "badge": 1}
+	}`
+	if err := os.WriteFile(filepath.Join(dir, "welcome.json"), []byte(tmpl), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	renderer := NewLocalRenderer(dir, nil, "en")
+	if err := renderer.LoadDir(); err != nil {
+		t.Fatalf("Expected no error loading templates, got %v", err)
+	}
+
+	rendered, version, err := renderer.Render("WELCOME", "", map[string]interface{}{"name": "ada lovelace", "code": "42"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if version != "v3" {
+		t.Errorf("Expected version v3, got %s", version)
+	}
+	if rendered.Title != "Welcome Ada Lovelace" {
+		t.Errorf("Unexpected title '%s'", rendered.Title)
+	}
+	if rendered.Body != "Your code is 42" {
+		t.Errorf("Unexpected body '%s'", rendered.Body)
+	}
+	if rendered.Data["code"] != "42" || rendered.Data["badge"] != float64(1) {
+		t.Errorf("Unexpected data %v", rendered.Data)
+	}
+
+	// missing variables render empty and defaults apply
+	rendered, _, err = renderer.Render("WELCOME", "", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if rendered.Title != "Welcome There" || rendered.Body != "Your code is " {
+		t.Errorf("Unexpected render with missing variables: %+v", rendered)
+	}
+
+	if _, _, err := renderer.Render("UNKNOWN", "", nil); err == nil {
+		t.Error("Expected error for unknown template")
+	}
+}
+
+// tests a jinja snapshot compiles and renders like the template service
+func TestCompileJinjaTemplate(t *testing.T) {
+	source := &LocalTemplate{
+		PushTemplate: PushTemplate{
+			Code:  "WELCOME_EMAIL",
+			Title: "Welcome to {{product_name | default('Notifications Hub')}}",
+			Body:  "Hey {{user_name | default('there')}}, tap to explore your new workspace.",
+		},
+		Version: "abc",
+		Syntax:  SyntaxJinja,
+	}
+
+	compiled, err := compileLocalTemplate(source)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	renderer := NewLocalRenderer("", nil, "en")
+	renderer.templates[source.Code] = compiled
+
+	rendered, _, err := renderer.Render("WELCOME_EMAIL", "", map[string]interface{}{"user_name": "Ada"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if rendered.Title != "Welcome to Notifications Hub" {
+		t.Errorf("Unexpected title '%s'", rendered.Title)
+	}
+	if rendered.Body != "Hey Ada, tap to explore your new workspace." {
+		t.Errorf("Unexpected body '%s'", rendered.Body)
+	}
+}
+
+// tests the local renderer picks the closest locale it has copy for
+func TestLocalRendererLocaleSelection(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"welcome.json":    `{"code": "WELCOME", "title": "Welcome {{.name}}"}`,
+		"welcome.pt.json": `{"code": "WELCOME", "locale": "pt", "title": "Bem-vindo {{.name}}"}`,
+		"welcome.fr.json": `{"code": "WELCOME", "locale": "fr-FR", "title": "Bienvenue {{.name}}"}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	renderer := NewLocalRenderer(dir, nil, "en")
+	if err := renderer.LoadDir(); err != nil {
+		t.Fatalf("Expected no error loading templates, got %v", err)
+	}
+
+	testCases := []struct {
+		locale   string
+		expected string
+	}{
+		{"pt-BR", "Bem-vindo Ada"},
+		{"pt", "Bem-vindo Ada"},
+		{"fr_fr", "Bienvenue Ada"},
+		{"fr-CA", "Welcome Ada"},
+		{"de", "Welcome Ada"},
+		{"", "Welcome Ada"},
+	}
+
+	for _, tc := range testCases {
+		rendered, _, err := renderer.Render("WELCOME", tc.locale, map[string]interface{}{"name": "Ada"})
+		if err != nil {
+			t.Fatalf("Expected no error for locale %s, got %v", tc.locale, err)
+		}
+		if rendered.Title != tc.expected {
+			t.Errorf("Expected '%s' for locale %s, got '%s'", tc.expected, tc.locale, rendered.Title)
+		}
+	}
+}
+
+// tests typed variables render in local templates
+func TestLocalRendererTypedVariables(t *testing.T) {
+	source := &LocalTemplate{
+		PushTemplate: PushTemplate{
+			Code:  "NEW_ITEMS",
+			Title: "{{ items | length }} new items",
+			Body:  "{{ items | join(', ') }} from {{ store | default('your store') }}, total {{ total }}",
+			Data:  map[string]interface{}{"vip": "{{ vip }}"},
+		},
+		Syntax: SyntaxJinja,
+	}
+
+	compiled, err := compileLocalTemplate(source)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	renderer := NewLocalRenderer("", nil, "en")
+	renderer.templates[source.Code] = compiled
+
+	rendered, _, err := renderer.Render("NEW_ITEMS", "", map[string]interface{}{
+		"items": []interface{}{"a", "b", "c"},
+		"total": 12.5,
+		"vip":   true,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if rendered.Title != "3 new items" {
+		t.Errorf("Unexpected title '%s'", rendered.Title)
+	}
+	if rendered.Body != "a, b, c from your store, total 12.5" {
+		t.Errorf("Unexpected body '%s'", rendered.Body)
+	}
+	if rendered.Data["vip"] != "true" {
+		t.Errorf("Unexpected data %v", rendered.Data)
+	}
+}
@@ -0,0 +1,54 @@
+package template
+
+import "strings"
+
+// returns the ordered locales to try for a notification, from the most
+// specific to the default locale, e.g. pt-BR → pt → en
+func LocaleChain(locale, defaultLocale string) []string {
+	chain := make([]string, 0, 3)
+	seen := make(map[string]bool)
+
+	add := func(l string) {
+		if l != "" && !seen[l] {
+			seen[l] = true
+			chain = append(chain, l)
+		}
+	}
+
+	locale = NormalizeLocale(locale)
+	for locale != "" {
+		add(locale)
+
+		i := strings.LastIndex(locale, "-")
+		if i < 0 {
+			break
+		}
+		locale = locale[:i]
+	}
+
+	add(NormalizeLocale(defaultLocale))
+
+	return chain
+}
+
+// normalizes a locale tag to the BCP 47 form, e.g. pt_br becomes pt-BR
+func NormalizeLocale(locale string) string {
+	parts := strings.Split(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"), "-")
+	if parts[0] == "" {
+		return ""
+	}
+
+	parts[0] = strings.ToLower(parts[0])
+	for i := 1; i < len(parts); i++ {
+		switch len(parts[i]) {
+		case 2:
+			parts[i] = strings.ToUpper(parts[i]) // region
+		case 4:
+			parts[i] = strings.ToUpper(parts[i][:1]) + strings.ToLower(parts[i][1:]) // script
+		default:
+			parts[i] = strings.ToLower(parts[i])
+		}
+	}
+
+	return strings.Join(parts, "-")
+}
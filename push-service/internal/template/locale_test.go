@@ -0,0 +1,30 @@
+package template
+
+import (
+	"reflect"
+	"testing"
+)
+
+// tests the locale fallback chain
+func TestLocaleChain(t *testing.T) {
+	testCases := []struct {
+		locale        string
+		defaultLocale string
+		expected      []string
+	}{
+		{"pt-BR", "en", []string{"pt-BR", "pt", "en"}},
+		{"pt_br", "en", []string{"pt-BR", "pt", "en"}},
+		{"zh-hant-tw", "en", []string{"zh-Hant-TW", "zh-Hant", "zh", "en"}},
+		{"en-GB", "en", []string{"en-GB", "en"}},
+		{"en", "en", []string{"en"}},
+		{"", "en", []string{"en"}},
+		{"", "", []string{}},
+	}
+
+	for _, tc := range testCases {
+		chain := LocaleChain(tc.locale, tc.defaultLocale)
+		if !reflect.DeepEqual(chain, tc.expected) {
+			t.Errorf("Expected %v for %q, got %v", tc.expected, tc.locale, chain)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package template
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// matches a plain {{ variable }} placeholder without filters or expressions
+var plainVariablePattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// reports whether every field of the template only uses plain variable
+// substitution, so it can be rendered without the template service
+func IsPlainTemplate(tmpl *PushTemplate) bool {
+	fields := []string{tmpl.Title, tmpl.Body, tmpl.ImageURL, tmpl.IconURL, tmpl.Link}
+	fields = append(fields, tmpl.TitleLocArgs...)
+	fields = append(fields, tmpl.BodyLocArgs...)
+	for _, field := range fields {
+		if !isPlainString(field) {
+			return false
+		}
+	}
+
+	for _, value := range tmpl.Data {
+		switch v := value.(type) {
+		case string:
+			if !isPlainString(v) {
+				return false
+			}
+		case map[string]interface{}, []interface{}:
+			return false
+		}
+	}
+
+	return true
+}
+
+func isPlainString(s string) bool {
+	rest := plainVariablePattern.ReplaceAllString(s, "")
+	return !strings.Contains(rest, "{{") && !strings.Contains(rest, "{%") && !strings.Contains(rest, "{#")
+}
+
+// renders a plain template locally. missing variables render as empty
+// strings, matching the template service
+func RenderPlain(tmpl *PushTemplate, variables map[string]interface{}) *PushTemplate {
+	rendered := *tmpl
+	rendered.Title = substitute(tmpl.Title, variables)
+	rendered.Body = substitute(tmpl.Body, variables)
+	rendered.ImageURL = substitute(tmpl.ImageURL, variables)
+	rendered.IconURL = substitute(tmpl.IconURL, variables)
+	rendered.Link = substitute(tmpl.Link, variables)
+	rendered.TitleLocArgs = substituteAll(tmpl.TitleLocArgs, variables)
+	rendered.BodyLocArgs = substituteAll(tmpl.BodyLocArgs, variables)
+
+	if tmpl.Data != nil {
+		rendered.Data = make(map[string]interface{}, len(tmpl.Data))
+		for key, value := range tmpl.Data {
+			if s, ok := value.(string); ok {
+				rendered.Data[key] = substitute(s, variables)
+			} else {
+				rendered.Data[key] = value
+			}
+		}
+	}
+
+	return &rendered
+}
+
+func substitute(s string, variables map[string]interface{}) string {
+	return plainVariablePattern.ReplaceAllStringFunc(s, func(match string) string {
+		name := plainVariablePattern.FindStringSubmatch(match)[1]
+		return formatValue(variables[name])
+	})
+}
+
+func substituteAll(values []string, variables map[string]interface{}) []string {
+	if values == nil {
+		return nil
+	}
+
+	rendered := make([]string, len(values))
+	for i, value := range values {
+		rendered[i] = substitute(value, variables)
+	}
+	return rendered
+}
+
+// formats a variable value as template text. lists are joined with ", "
+// and objects are written as JSON
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			parts[i] = formatValue(item)
+		}
+		return strings.Join(parts, ", ")
+	default:
+		body, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(body)
+	}
+}
+
+// reports whether a variable value is missing, blank or an empty collection
+func isEmptyValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(v) == ""
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	default:
+		return false
+	}
+}
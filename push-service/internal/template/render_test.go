@@ -0,0 +1,107 @@
+package template
+
+import "testing"
+
+// tests detection of templates that only use plain substitution
+func TestIsPlainTemplate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		template *PushTemplate
+		expected bool
+	}{
+		{
+			name:     "No placeholders",
+			template: &PushTemplate{Title: "Hello", Body: "World"},
+			expected: true,
+		},
+		{
+			name:     "Plain variables",
+			template: &PushTemplate{Title: "Hi {{ name }}", Body: "Code {{reset_code}}", Data: map[string]interface{}{"code": "{{reset_code}}", "badge": 1}},
+			expected: true,
+		},
+		{
+			name:     "Filter",
+			template: &PushTemplate{Body: "Hey {{user_name | default('there')}}"},
+			expected: false,
+		},
+		{
+			name:     "Block statement",
+			template: &PushTemplate{Body: "{% if name %}Hi{% endif %}"},
+			expected: false,
+		},
+		{
+			name:     "Filter in data",
+			template: &PushTemplate{Body: "Hi", Data: map[string]interface{}{"name": "{{ name | upper }}"}},
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := IsPlainTemplate(tc.template); got != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+// tests local rendering of plain templates
+func TestRenderPlain(t *testing.T) {
+	tmpl := &PushTemplate{
+		Code:  "password_reset",
+		Title: "Hi {{ name }}",
+		Body:  "Use code {{reset_code}} before {{expires_at}}",
+		Link:  "https://example.com/reset/{{reset_code}}",
+		Data:  map[string]interface{}{"code": "{{reset_code}}", "badge": 1},
+	}
+
+	rendered := RenderPlain(tmpl, map[string]interface{}{"name": "Ada", "reset_code": "1234"})
+
+	if rendered.Title != "Hi Ada" {
+		t.Errorf("Unexpected title '%s'", rendered.Title)
+	}
+
+	// missing variables render empty
+	if rendered.Body != "Use code 1234 before " {
+		t.Errorf("Unexpected body '%s'", rendered.Body)
+	}
+
+	if rendered.Link != "https://example.com/reset/1234" {
+		t.Errorf("Unexpected link '%s'", rendered.Link)
+	}
+
+	if rendered.Data["code"] != "1234" || rendered.Data["badge"] != 1 {
+		t.Errorf("Unexpected data %v", rendered.Data)
+	}
+
+	// the cached template is not modified
+	if tmpl.Title != "Hi {{ name }}" || tmpl.Data["code"] != "{{reset_code}}" {
+		t.Error("Expected source template to be unchanged")
+	}
+}
+
+// tests formatting of typed variable values
+func TestFormatValue(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{"Nil", nil, ""},
+		{"String", "Ada", "Ada"},
+		{"Integer number", float64(3), "3"},
+		{"Large number", float64(1500000), "1500000"},
+		{"Decimal", 12.5, "12.5"},
+		{"Boolean", true, "true"},
+		{"List", []interface{}{"a", float64(2), "c"}, "a, 2, c"},
+		{"Object", map[string]interface{}{"id": "1"}, `{"id":"1"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := formatValue(tc.value); got != tc.expected {
+				t.Errorf("Expected '%s', got '%s'", tc.expected, got)
+			}
+		})
+	}
+}
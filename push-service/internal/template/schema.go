@@ -0,0 +1,154 @@
+package template
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+// variable types a template can declare
+const (
+	VariableTypeString  = "string"
+	VariableTypeNumber  = "number"
+	VariableTypeBoolean = "boolean"
+	VariableTypeURL     = "url"
+	VariableTypeList    = "list"
+	VariableTypeObject  = "object"
+)
+
+// a variable declared by a template
+type TemplateVariable struct {
+	Name      string `json:"name"`
+	Type      string `json:"type,omitempty"` // defaults to string
+	Required  bool   `json:"required,omitempty"`
+	MaxLength int    `json:"max_length,omitempty"` // characters, or items for a list
+}
+
+// a single variable that does not match the template schema
+type VariableViolation struct {
+	Field  string `json:"field"`
+	Rule   string `json:"rule"` // "required", "type" or "max_length"
+	Detail string `json:"detail"`
+}
+
+// returned when variables do not match the template schema, it wraps
+// models.ErrTemplateVariableMissing so it is treated as permanent
+type SchemaError struct {
+	TemplateCode string
+	Violations   []VariableViolation
+}
+
+func (e *SchemaError) Error() string {
+	details := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		details = append(details, violation.Detail)
+	}
+	return fmt.Sprintf("%s: %s: %s", models.ErrTemplateVariableMissing, e.TemplateCode, strings.Join(details, "; "))
+}
+
+func (e *SchemaError) Unwrap() error {
+	return models.ErrTemplateVariableMissing
+}
+
+// validates variables against a template schema, returns a *SchemaError
+// listing every violation in declaration order
+func ValidateVariables(templateCode string, schema []TemplateVariable, variables map[string]interface{}) error {
+	violations := make([]VariableViolation, 0)
+
+	for _, variable := range schema {
+		value := variables[variable.Name]
+		if isEmptyValue(value) {
+			if variable.Required {
+				violations = append(violations, VariableViolation{
+					Field:  variable.Name,
+					Rule:   "required",
+					Detail: fmt.Sprintf("%s is required", variable.Name),
+				})
+			}
+			continue
+		}
+
+		if !matchesType(variable.Type, value) {
+			violations = append(violations, VariableViolation{
+				Field:  variable.Name,
+				Rule:   "type",
+				Detail: fmt.Sprintf("%s must be a %s", variable.Name, variable.Type),
+			})
+			continue
+		}
+
+		if variable.MaxLength <= 0 {
+			continue
+		}
+		if list, ok := value.([]interface{}); ok {
+			if len(list) > variable.MaxLength {
+				violations = append(violations, VariableViolation{
+					Field:  variable.Name,
+					Rule:   "max_length",
+					Detail: fmt.Sprintf("%s exceeds %d items", variable.Name, variable.MaxLength),
+				})
+			}
+		} else if utf8.RuneCountInString(formatValue(value)) > variable.MaxLength {
+			violations = append(violations, VariableViolation{
+				Field:  variable.Name,
+				Rule:   "max_length",
+				Detail: fmt.Sprintf("%s exceeds %d characters", variable.Name, variable.MaxLength),
+			})
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+
+	return &SchemaError{TemplateCode: templateCode, Violations: violations}
+}
+
+// reports whether value can be used as a variable of the declared type.
+// numbers and booleans sent as strings are accepted for string-only producers
+func matchesType(variableType string, value interface{}) bool {
+	switch variableType {
+	case VariableTypeNumber:
+		switch v := value.(type) {
+		case float64, int, int64:
+			return true
+		case string:
+			_, err := strconv.ParseFloat(v, 64)
+			return err == nil
+		}
+		return false
+	case VariableTypeBoolean:
+		switch v := value.(type) {
+		case bool:
+			return true
+		case string:
+			_, err := strconv.ParseBool(v)
+			return err == nil
+		}
+		return false
+	case VariableTypeURL:
+		s, ok := value.(string)
+		if !ok {
+			return false
+		}
+		parsed, err := url.Parse(s)
+		return err == nil && parsed.Scheme != "" && parsed.Host != ""
+	case VariableTypeList:
+		_, ok := value.([]interface{})
+		return ok
+	case VariableTypeObject:
+		_, ok := value.(map[string]interface{})
+		return ok
+	default:
+		// strings accept any scalar
+		switch value.(type) {
+		case []interface{}, map[string]interface{}:
+			return false
+		}
+		return true
+	}
+}
@@ -0,0 +1,64 @@
+package template
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+// tests variables are validated against the template schema
+func TestValidateVariables(t *testing.T) {
+	schema := []TemplateVariable{
+		{Name: "name", Required: true, MaxLength: 10},
+		{Name: "count", Type: VariableTypeNumber},
+		{Name: "vip", Type: VariableTypeBoolean},
+		{Name: "avatar", Type: VariableTypeURL},
+		{Name: "items", Type: VariableTypeList, MaxLength: 3},
+	}
+
+	testCases := []struct {
+		name      string
+		variables map[string]interface{}
+		expected  []string // violated fields, in schema order
+	}{
+		{"Valid", map[string]interface{}{"name": "Ada", "count": "3", "vip": "true", "avatar": "https://cdn.example.com/a.png"}, nil},
+		{"Optional omitted", map[string]interface{}{"name": "Ada"}, nil},
+		{"Missing required", map[string]interface{}{"count": "3"}, []string{"name"}},
+		{"Blank required", map[string]interface{}{"name": "  "}, []string{"name"}},
+		{"Too long", map[string]interface{}{"name": "Ada Lovelace Byron"}, []string{"name"}},
+		{"Wrong types", map[string]interface{}{"name": "Ada", "count": "many", "vip": "maybe", "avatar": "a.png"}, []string{"count", "vip", "avatar"}},
+		{"Nil variables", nil, []string{"name"}},
+		{"Typed values", map[string]interface{}{"name": "Ada", "count": float64(3), "vip": false, "items": []interface{}{"a", "b"}}, nil},
+		{"Typed mismatches", map[string]interface{}{"name": []interface{}{"Ada"}, "count": true, "items": "a, b"}, []string{"name", "count", "items"}},
+		{"Too many items", map[string]interface{}{"name": "Ada", "items": []interface{}{"a", "b", "c", "d"}}, []string{"items"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateVariables("WELCOME", schema, tc.variables)
+			if len(tc.expected) == 0 {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+
+			var schemaErr *SchemaError
+			if !errors.As(err, &schemaErr) {
+				t.Fatalf("Expected schema error, got %v", err)
+			}
+			if !errors.Is(err, models.ErrTemplateVariableMissing) || !models.IsPermanent(err) {
+				t.Errorf("Expected permanent ErrTemplateVariableMissing, got %v", err)
+			}
+			if len(schemaErr.Violations) != len(tc.expected) {
+				t.Fatalf("Expected %d violations, got %v", len(tc.expected), schemaErr.Violations)
+			}
+			for i, field := range tc.expected {
+				if schemaErr.Violations[i].Field != field {
+					t.Errorf("Expected violation on %s, got %s", field, schemaErr.Violations[i].Field)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,409 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/config"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/queue"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/id"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
+)
+
+// where a status event is posted, the secret signs the request when set.
+// a callback url comes from the message, its host must be in AllowedHosts
+type Target struct {
+	URL          string
+	Secret       string
+	Callback     bool
+	AllowedHosts []string
+}
+
+// spaces the attempts of a delivery
+type Backoff interface {
+	CalculateBackoff(attemptCount int) time.Duration
+}
+
+// Dispatcher posts status events to callback urls. deliveries are scheduled
+// in the store and attempted by a pool of workers on any replica, so a slow
+// receiver does not hold up sends and pending deliveries survive restarts. a
+// failed attempt is rescheduled after a backoff rather than waited out by the
+// worker. every attempt is recorded, undelivered webhooks stay retrievable
+// from the store until they expire
+type Dispatcher struct {
+	httpClient    *http.Client
+	store         Store
+	backoff       Backoff
+	config        config.WebhookConfig
+	callbackHosts []string // allowed callback hosts of messages without a tenant
+}
+
+func NewDispatcher(cfg config.WebhookConfig, store Store, backoff Backoff) *Dispatcher {
+	dialer := &net.Dialer{Timeout: time.Duration(cfg.Timeout) * time.Second}
+	if !cfg.AllowPrivateNetworks {
+		// checked on the resolved address, so a public name pointing at a
+		// private address is refused as well
+		dialer.Control = blockPrivateAddress
+	}
+
+	callbackHosts := []string{}
+	for _, host := range strings.Split(cfg.CallbackHosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			callbackHosts = append(callbackHosts, host)
+		}
+	}
+
+	return &Dispatcher{
+		httpClient: &http.Client{
+			Timeout: time.Duration(cfg.Timeout) * time.Second,
+			// no proxy, it would dial the receiver on our behalf
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: 10 * time.Second,
+				MaxIdleConnsPerHost: 4,
+				IdleConnTimeout:     90 * time.Second,
+			},
+			// a redirect could point anywhere, receivers must answer directly
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		store:         store,
+		backoff:       backoff,
+		config:        cfg,
+		callbackHosts: callbackHosts,
+	}
+}
+
+// schedules a status event for delivery to a target. only final statuses are
+// posted unless every status is configured
+func (d *Dispatcher) Dispatch(target Target, msg *models.NotificationMessage, statusMsg *models.NotificationStatusMessage) {
+	if !d.config.AllStatuses && !statusMsg.Status.Final() {
+		return
+	}
+
+	routingKey := queue.StatusRoutingKey("status", statusMsg.Status, statusMsg.TemplateCode)
+	now := time.Now()
+
+	delivery := &models.WebhookDelivery{
+		ID:             id.Generate(),
+		NotificationID: msg.ID,
+		TenantID:       msg.TenantID,
+		URL:            target.URL,
+		Status:         statusMsg.Status,
+		Sequence:       statusMsg.Sequence,
+		Event:          queue.NewStatusEvent(routingKey, msg.ID, statusMsg.Sequence, statusMsg.Timestamp, statusMsg),
+		Attempts:       []models.WebhookAttempt{},
+		NextAttemptAt:  &now,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+
+	secret := target.Secret
+	if secret == "" {
+		secret = d.config.Secret
+	}
+
+	if target.Callback {
+		allowed := target.AllowedHosts
+		if msg.TenantID == "" {
+			allowed = d.callbackHosts
+		}
+		if !HostAllowed(target.URL, allowed) {
+			delivery.NextAttemptAt = nil
+			delivery.Error = models.ErrCallbackHostNotAllowed.Error()
+			d.save(context.Background(), delivery)
+
+			logger.Warn("Callback host not allowed, delivery recorded as failed", logger.Merge(
+				logger.WithNotificationID(msg.ID),
+				logger.Fields{"delivery_id": delivery.ID, "url": target.URL},
+			))
+			return
+		}
+	}
+
+	if err := d.store.Queue(context.Background(), delivery, secret); err != nil {
+		logger.Error("Failed to queue webhook delivery", logger.Merge(
+			logger.WithNotificationID(msg.ID),
+			logger.Fields{"delivery_id": delivery.ID},
+			logger.WithError(err),
+		))
+	}
+}
+
+// attempts due deliveries until the context is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	workers := d.config.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	logger.Info("Webhook dispatcher started", logger.Fields{
+		"workers":       workers,
+		"poll_interval": d.pollInterval().String(),
+	})
+
+	for i := 0; i < workers; i++ {
+		go d.work(ctx)
+	}
+
+	<-ctx.Done()
+	logger.Info("Stopping webhook dispatcher")
+}
+
+// claims one due delivery at a time and attempts it, waiting a poll interval
+// whenever nothing is due
+func (d *Dispatcher) work(ctx context.Context) {
+	for {
+		attempted, err := d.attemptDue(ctx)
+		if err != nil {
+			logger.Error("Failed to claim webhook deliveries", logger.WithError(err))
+		}
+		if attempted {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.pollInterval()):
+		}
+	}
+}
+
+// claims a due delivery and attempts it, reporting whether one was due. the
+// lease outlasts an attempt, a delivery whose replica stopped mid attempt is
+// claimed again once it lapses
+func (d *Dispatcher) attemptDue(ctx context.Context) (bool, error) {
+	lease := time.Duration(d.config.Timeout)*time.Second + 30*time.Second
+	ids, err := d.store.ClaimDue(ctx, time.Now(), 1, lease)
+	if err != nil || len(ids) == 0 {
+		return false, err
+	}
+	deliveryID := ids[0]
+
+	delivery, err := d.store.Get(ctx, deliveryID)
+	if errors.Is(err, models.ErrWebhookNotFound) {
+		// expired before it could be delivered
+		return true, d.store.Unschedule(ctx, deliveryID)
+	}
+	if err != nil {
+		return true, err
+	}
+
+	secret, err := d.store.Secret(ctx, deliveryID)
+	if err != nil {
+		return true, err
+	}
+
+	_ = d.Deliver(ctx, delivery, secret)
+	return true, nil
+}
+
+// makes one attempt at a delivery and stores it with the outcome. a failed
+// delivery with attempts left is rescheduled after a backoff, ErrWebhookFailed
+// is returned once it is given up on
+func (d *Dispatcher) Deliver(ctx context.Context, delivery *models.WebhookDelivery, secret string) error {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook event: %w", err)
+	}
+
+	err = d.post(ctx, delivery, secret, body)
+
+	now := time.Now()
+	delivery.UpdatedAt = now
+	delivery.Delivered = err == nil
+	delivery.Error = ""
+	delivery.NextAttemptAt = nil
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+
+	maxAttempts := d.config.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+
+	retry := false
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		// interrupted by shutdown, another replica picks it up
+		delivery.Attempts = delivery.Attempts[:len(delivery.Attempts)-1]
+		delivery.NextAttemptAt = &now
+		retry = true
+	case len(delivery.Attempts) < maxAttempts && !models.IsPermanent(err):
+		next := now.Add(d.retryDelay(len(delivery.Attempts) - 1))
+		delivery.NextAttemptAt = &next
+		retry = true
+	}
+
+	// recorded even when shutdown cancelled the attempt
+	d.save(context.WithoutCancel(ctx), delivery)
+
+	if err == nil {
+		return nil
+	}
+
+	fields := logger.Merge(
+		logger.WithNotificationID(delivery.NotificationID),
+		logger.Fields{
+			"delivery_id": delivery.ID,
+			"url":         delivery.URL,
+			"attempts":    len(delivery.Attempts),
+		},
+		logger.WithError(err),
+	)
+	if retry {
+		logger.Info("Webhook attempt failed, rescheduled", logger.Merge(fields, logger.Fields{
+			"next_attempt_at": delivery.NextAttemptAt,
+		}))
+		return nil
+	}
+
+	logger.Error("Webhook delivery failed", fields)
+	return fmt.Errorf("%w: %w", models.ErrWebhookFailed, err)
+}
+
+// returns the wait before the next attempt after the given failed attempt,
+// counted from zero
+func (d *Dispatcher) retryDelay(attempt int) time.Duration {
+	if d.backoff == nil {
+		return time.Duration(d.config.InitialInterval) * time.Second
+	}
+	return d.backoff.CalculateBackoff(attempt)
+}
+
+// how long an idle worker waits before checking for due deliveries
+func (d *Dispatcher) pollInterval() time.Duration {
+	if d.config.PollInterval <= 0 {
+		return time.Second
+	}
+	return time.Duration(d.config.PollInterval) * time.Second
+}
+
+// makes one signed request and records it as an attempt
+func (d *Dispatcher) post(ctx context.Context, delivery *models.WebhookDelivery, secret string, body []byte) error {
+	started := time.Now()
+	record := models.WebhookAttempt{
+		Attempt:   len(delivery.Attempts) + 1,
+		Timestamp: started,
+	}
+	defer func() {
+		record.Duration = time.Since(started).String()
+		delivery.Attempts = append(delivery.Attempts, record)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		record.Error = err.Error()
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	timestamp := started.Unix()
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	req.Header.Set(models.WebhookIDHeader, delivery.ID)
+	req.Header.Set(models.WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	if secret != "" {
+		req.Header.Set(models.WebhookSignatureHeader, Sign(secret, timestamp, delivery.URL, body))
+	}
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		record.Error = err.Error()
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	record.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		record.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+		return errors.New(record.Error)
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) save(ctx context.Context, delivery *models.WebhookDelivery) {
+	if err := d.store.Save(ctx, delivery); err != nil {
+		logger.Error("Failed to record webhook delivery", logger.Merge(
+			logger.WithNotificationID(delivery.NotificationID),
+			logger.Fields{"delivery_id": delivery.ID},
+			logger.WithError(err),
+		))
+	}
+}
+
+// returns the signature header value of a webhook body, receivers recompute it
+// from the timestamp header, the url they are reached at and the raw body, so
+// a signed request replayed to another endpoint does not verify
+func Sign(secret string, timestamp int64, url string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write([]byte(url))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// reports whether a url's host is one of the allowed hosts, a "*.example.com"
+// entry matches any subdomain of example.com. nothing is allowed by an empty list
+func HostAllowed(rawURL string, allowed []string) bool {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	if host == "" {
+		return false
+	}
+
+	for _, entry := range allowed {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if suffix, ok := strings.CutPrefix(entry, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if host == entry {
+			return true
+		}
+	}
+	return false
+}
+
+// refuses connections to loopback, private, link-local, multicast and
+// unspecified addresses
+func blockPrivateAddress(network, address string, conn syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", models.ErrWebhookAddressBlocked, address)
+	}
+
+	addr := addrPort.Addr().Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return fmt.Errorf("%w: %s", models.ErrWebhookAddressBlocked, addr)
+	}
+	return nil
+}
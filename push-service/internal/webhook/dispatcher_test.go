@@ -0,0 +1,370 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/config"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+// waits the same time before every retry
+type fixedBackoff struct {
+	delay time.Duration
+}
+
+func (b fixedBackoff) CalculateBackoff(attemptCount int) time.Duration {
+	return b.delay
+}
+
+func newTestStore(t *testing.T) *RedisStore {
+	t.Helper()
+
+	redisCache, err := cache.NewRedisCache(miniredis.RunT(t).Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("failed to connect to miniredis: %v", err)
+	}
+	t.Cleanup(func() { redisCache.Close() })
+
+	return NewRedisStore(redisCache, time.Hour)
+}
+
+// a receiver answering with the given status codes in turn, checking signatures
+type receiver struct {
+	t        *testing.T
+	secret   string
+	url      string // where the receiver is reached, part of the signature
+	statuses []int
+
+	mutex       sync.Mutex
+	requests    int
+	deliveryIDs []string
+	events      []*models.CloudEvent
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	timestamp, err := strconv.ParseInt(r.Header.Get(models.WebhookTimestampHeader), 10, 64)
+	if err != nil {
+		rc.t.Errorf("Expected a unix timestamp header, got %q", r.Header.Get(models.WebhookTimestampHeader))
+	}
+	if got := r.Header.Get(models.WebhookSignatureHeader); got != Sign(rc.secret, timestamp, rc.url, body) {
+		rc.t.Errorf("Signature %q does not match the body", got)
+	}
+	if r.Header.Get(models.WebhookIDHeader) == "" {
+		rc.t.Error("Expected a delivery id header")
+	}
+
+	event := &models.CloudEvent{}
+	if err := json.Unmarshal(body, event); err != nil {
+		rc.t.Errorf("Expected a CloudEvent body, got %v", err)
+	}
+
+	rc.mutex.Lock()
+	status := http.StatusOK
+	if rc.requests < len(rc.statuses) {
+		status = rc.statuses[rc.requests]
+	}
+	rc.requests++
+	rc.deliveryIDs = append(rc.deliveryIDs, r.Header.Get(models.WebhookIDHeader))
+	rc.events = append(rc.events, event)
+	rc.mutex.Unlock()
+
+	w.WriteHeader(status)
+}
+
+func testMessages() (*models.NotificationMessage, *models.NotificationStatusMessage) {
+	msg := &models.NotificationMessage{ID: "n1", UserID: "u1", TenantID: "acme", TemplateCode: "welcome"}
+	statusMsg := &models.NotificationStatusMessage{
+		NotificationID: "n1",
+		Sequence:       3,
+		Status:         models.NotificationStatusDelivered,
+		Timestamp:      time.Now(),
+		TemplateCode:   "welcome",
+	}
+	return msg, statusMsg
+}
+
+// claims the scheduled deliveries due now
+func dueDeliveries(t *testing.T, store *RedisStore) []string {
+	t.Helper()
+	ids, err := store.ClaimDue(context.Background(), time.Now(), 10, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return ids
+}
+
+// attempts the next due delivery, failing when none is due
+func attemptNext(t *testing.T, d *Dispatcher) {
+	t.Helper()
+	attempted, err := d.attemptDue(context.Background())
+	if err != nil || !attempted {
+		t.Fatalf("Expected a due delivery to be attempted, got %v, %v", attempted, err)
+	}
+}
+
+// tests signed delivery, rescheduled attempts on non-2xx and the recorded attempts
+func TestDispatcherDeliver(t *testing.T) {
+	cfg := config.WebhookConfig{Secret: "default-secret", Timeout: 5, MaxAttempts: 5, AllowPrivateNetworks: true}
+
+	t.Run("Retries until the receiver accepts", func(t *testing.T) {
+		rc := &receiver{t: t, secret: "tenant-secret", statuses: []int{http.StatusInternalServerError, http.StatusBadGateway}}
+		server := httptest.NewServer(rc)
+		defer server.Close()
+		rc.url = server.URL
+
+		store := newTestStore(t)
+		d := NewDispatcher(cfg, store, fixedBackoff{})
+
+		msg, statusMsg := testMessages()
+		d.Dispatch(Target{URL: server.URL, Secret: "tenant-secret"}, msg, statusMsg)
+
+		for i := 0; i < 3; i++ {
+			attemptNext(t, d)
+		}
+		if attempted, _ := d.attemptDue(context.Background()); attempted {
+			t.Error("Expected a delivered webhook to leave the schedule")
+		}
+
+		delivery, err := store.Get(context.Background(), rc.deliveryIDs[0])
+		if err != nil {
+			t.Fatalf("Expected delivery to be recorded, got %v", err)
+		}
+		if !delivery.Delivered || delivery.Error != "" || delivery.NextAttemptAt != nil {
+			t.Errorf("Expected a delivered webhook, got %+v", delivery)
+		}
+		if len(delivery.Attempts) != 3 {
+			t.Fatalf("Expected 3 attempts, got %d", len(delivery.Attempts))
+		}
+		if delivery.Attempts[0].StatusCode != http.StatusInternalServerError || delivery.Attempts[2].StatusCode != http.StatusOK {
+			t.Errorf("Expected attempts to record status codes, got %+v", delivery.Attempts)
+		}
+		if secret, _ := store.Secret(context.Background(), delivery.ID); secret != "" {
+			t.Error("Expected the secret to be dropped once delivered")
+		}
+
+		event := rc.events[0]
+		if event.Subject != "n1" || event.Sequence != "3" || event.Type != "com.notification.push.status.delivered.welcome" {
+			t.Errorf("Unexpected event %+v", event)
+		}
+	})
+
+	t.Run("Failed attempts are rescheduled after the backoff", func(t *testing.T) {
+		rc := &receiver{t: t, secret: "default-secret", statuses: []int{http.StatusInternalServerError}}
+		server := httptest.NewServer(rc)
+		defer server.Close()
+		rc.url = server.URL
+
+		store := newTestStore(t)
+		d := NewDispatcher(cfg, store, fixedBackoff{delay: time.Minute})
+
+		msg, statusMsg := testMessages()
+		d.Dispatch(Target{URL: server.URL}, msg, statusMsg)
+		attemptNext(t, d)
+
+		delivery, err := store.Get(context.Background(), rc.deliveryIDs[0])
+		if err != nil {
+			t.Fatalf("Expected delivery to be recorded, got %v", err)
+		}
+		if delivery.Delivered || len(delivery.Attempts) != 1 {
+			t.Errorf("Expected one failed attempt, got %+v", delivery)
+		}
+		if delivery.NextAttemptAt == nil || time.Until(*delivery.NextAttemptAt) < 50*time.Second {
+			t.Errorf("Expected the next attempt after the backoff, got %v", delivery.NextAttemptAt)
+		}
+		if attempted, _ := d.attemptDue(context.Background()); attempted {
+			t.Error("Expected nothing due before the backoff")
+		}
+		if failed, _ := store.ListFailed(context.Background(), "acme", 10); len(failed) != 0 {
+			t.Errorf("Expected a pending delivery not to be listed as failed, got %+v", failed)
+		}
+	})
+
+	t.Run("Falls back to the configured secret", func(t *testing.T) {
+		rc := &receiver{t: t, secret: "default-secret"}
+		server := httptest.NewServer(rc)
+		defer server.Close()
+		rc.url = server.URL
+
+		d := NewDispatcher(cfg, newTestStore(t), fixedBackoff{})
+
+		msg, statusMsg := testMessages()
+		d.Dispatch(Target{URL: server.URL}, msg, statusMsg)
+
+		attemptNext(t, d)
+		if rc.requests != 1 {
+			t.Errorf("Expected one request, got %d", rc.requests)
+		}
+	})
+
+	t.Run("Exhausted retries are retrievable as failed", func(t *testing.T) {
+		rc := &receiver{t: t, secret: "default-secret", statuses: []int{500, 500, 500}}
+		server := httptest.NewServer(rc)
+		defer server.Close()
+		rc.url = server.URL
+
+		store := newTestStore(t)
+		cfg := cfg
+		cfg.MaxAttempts = 3
+		d := NewDispatcher(cfg, store, fixedBackoff{})
+
+		msg, statusMsg := testMessages()
+		d.Dispatch(Target{URL: server.URL}, msg, statusMsg)
+
+		for i := 0; i < 3; i++ {
+			attemptNext(t, d)
+		}
+		if attempted, _ := d.attemptDue(context.Background()); attempted {
+			t.Error("Expected a failed webhook to leave the schedule")
+		}
+
+		failed, _ := store.ListFailed(context.Background(), "acme", 10)
+		if len(failed) != 1 || len(failed[0].Attempts) != 3 || failed[0].Error == "" || failed[0].NextAttemptAt != nil {
+			t.Errorf("Expected one failed delivery with 3 attempts, got %+v", failed)
+		}
+	})
+}
+
+// tests only final statuses are posted unless every status is configured
+func TestDispatcherStatuses(t *testing.T) {
+	store := newTestStore(t)
+	msg, statusMsg := testMessages()
+	statusMsg.Status = models.NotificationStatusProcessing
+
+	NewDispatcher(config.WebhookConfig{}, store, nil).Dispatch(Target{URL: "https://hooks.example.com"}, msg, statusMsg)
+	if due := dueDeliveries(t, store); len(due) != 0 {
+		t.Errorf("Expected no webhook for a processing status, got %v", due)
+	}
+
+	NewDispatcher(config.WebhookConfig{AllStatuses: true}, store, nil).Dispatch(Target{URL: "https://hooks.example.com"}, msg, statusMsg)
+	if due := dueDeliveries(t, store); len(due) != 1 {
+		t.Errorf("Expected a webhook for every status, got %v", due)
+	}
+}
+
+// tests deliveries scheduled by one replica are delivered by the workers of another
+func TestDispatcherRun(t *testing.T) {
+	delivered := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- struct{}{}
+	}))
+	defer server.Close()
+
+	store := newTestStore(t)
+	cfg := config.WebhookConfig{Timeout: 5, Workers: 2, MaxAttempts: 1, AllowPrivateNetworks: true}
+
+	msg, statusMsg := testMessages()
+	NewDispatcher(cfg, store, nil).Dispatch(Target{URL: server.URL}, msg, statusMsg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewDispatcher(cfg, store, nil).Run(ctx)
+
+	select {
+	case <-delivered:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the webhook to be delivered")
+	}
+}
+
+// tests callback urls must name an allowed host of the tenant, or of the
+// configured list for messages without a tenant
+func TestDispatcherCallbackHosts(t *testing.T) {
+	store := newTestStore(t)
+	d := NewDispatcher(config.WebhookConfig{CallbackHosts: "hooks.example.com"}, store, nil)
+
+	msg, statusMsg := testMessages()
+	d.Dispatch(Target{URL: "https://evil.example.org/hook", Callback: true, AllowedHosts: []string{"*.acme.com"}}, msg, statusMsg)
+	failed, _ := store.ListFailed(context.Background(), "acme", 10)
+	if len(failed) != 1 || failed[0].Error != models.ErrCallbackHostNotAllowed.Error() {
+		t.Errorf("Expected the callback to be refused, got %+v", failed)
+	}
+	if due := dueDeliveries(t, store); len(due) != 0 {
+		t.Errorf("Expected a refused callback not to be scheduled, got %v", due)
+	}
+
+	d.Dispatch(Target{URL: "https://hooks.acme.com/status", Callback: true, AllowedHosts: []string{"*.acme.com"}}, msg, statusMsg)
+
+	// tenant webhooks come from the app config and are not checked
+	d.Dispatch(Target{URL: "https://anywhere.example.net/hook"}, msg, statusMsg)
+
+	msg.TenantID = ""
+	d.Dispatch(Target{URL: "https://hooks.example.com/status", Callback: true}, msg, statusMsg)
+
+	if due := dueDeliveries(t, store); len(due) != 3 {
+		t.Errorf("Expected the allowed webhooks to be scheduled, got %v", due)
+	}
+}
+
+// tests host matching of the callback allowlist
+func TestHostAllowed(t *testing.T) {
+	allowed := []string{"hooks.example.com", "*.acme.com"}
+	cases := map[string]bool{
+		"https://hooks.example.com/a":      true,
+		"https://HOOKS.example.com:8443/a": true,
+		"https://api.acme.com/a":           true,
+		"https://a.b.acme.com/a":           true,
+		"https://acme.com/a":               false,
+		"https://evilacme.com/a":           false,
+		"https://example.com/a":            false,
+		"not a url":                        false,
+	}
+	for rawURL, expected := range cases {
+		if got := HostAllowed(rawURL, allowed); got != expected {
+			t.Errorf("Expected %v for %s, got %v", expected, rawURL, got)
+		}
+	}
+	if HostAllowed("https://hooks.example.com", nil) {
+		t.Error("Expected an empty list to allow nothing")
+	}
+}
+
+// tests webhooks to private addresses are refused when dialed, without retries
+func TestDispatcherBlocksPrivateAddresses(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	store := newTestStore(t)
+	d := NewDispatcher(config.WebhookConfig{Timeout: 5, MaxAttempts: 5}, store, nil)
+
+	msg, statusMsg := testMessages()
+	d.Dispatch(Target{URL: server.URL}, msg, statusMsg)
+	attemptNext(t, d)
+
+	failed, _ := store.ListFailed(context.Background(), "acme", 10)
+	if len(failed) != 1 || len(failed[0].Attempts) != 1 || !strings.Contains(failed[0].Error, models.ErrWebhookAddressBlocked.Error()) {
+		t.Errorf("Expected the loopback address to be blocked, got %+v", failed)
+	}
+	if requests != 0 {
+		t.Errorf("Expected no request to reach the receiver, got %d", requests)
+	}
+
+	err := d.Deliver(context.Background(), failed[0], "")
+	if !errors.Is(err, models.ErrWebhookFailed) {
+		t.Errorf("Expected ErrWebhookFailed, got %v", err)
+	}
+}
+
+// tests the signature covers the url
+func TestSignCoversURL(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	if Sign("secret", 1, "https://a.example.com/hook", body) == Sign("secret", 1, "https://b.example.com/hook", body) {
+		t.Error("Expected different urls to give different signatures")
+	}
+}
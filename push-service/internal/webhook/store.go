@@ -0,0 +1,71 @@
+package webhook
+
+import (
+	"context"
+	"time"
+
+	"github.com/zjoart/distributed-notification-system/push-service/internal/cache"
+	"github.com/zjoart/distributed-notification-system/push-service/internal/models"
+)
+
+// Store keeps webhook deliveries with their attempts, the schedule of pending
+// deliveries and an index of the deliveries that were given up on
+type Store interface {
+	// saves a new delivery and the secret signing it, scheduled for its next attempt
+	Queue(ctx context.Context, delivery *models.WebhookDelivery, secret string) error
+	// claims up to limit deliveries due for an attempt, each for one lease
+	ClaimDue(ctx context.Context, now time.Time, limit int64, lease time.Duration) ([]string, error)
+	// returns the secret of a pending delivery, empty when unsigned
+	Secret(ctx context.Context, deliveryID string) (string, error)
+	// drops a delivery from the schedule
+	Unschedule(ctx context.Context, deliveryID string) error
+	// saves a delivery, rescheduling it while it has a next attempt and adding
+	// it to its tenant's failed index once given up on
+	Save(ctx context.Context, delivery *models.WebhookDelivery) error
+	// returns a delivery, ErrWebhookNotFound when unknown or expired
+	Get(ctx context.Context, deliveryID string) (*models.WebhookDelivery, error)
+	// returns up to limit failed deliveries of a tenant, most recent first
+	ListFailed(ctx context.Context, tenantID string, limit int64) ([]*models.WebhookDelivery, error)
+}
+
+// RedisStore keeps one document per delivery, expiring after the retention
+type RedisStore struct {
+	cache     *cache.RedisCache
+	retention time.Duration
+}
+
+func NewRedisStore(redisCache *cache.RedisCache, retention time.Duration) *RedisStore {
+	return &RedisStore{
+		cache:     redisCache,
+		retention: retention,
+	}
+}
+
+func (s *RedisStore) Queue(ctx context.Context, delivery *models.WebhookDelivery, secret string) error {
+	return s.cache.QueueWebhookDelivery(ctx, delivery, secret, s.retention)
+}
+
+func (s *RedisStore) ClaimDue(ctx context.Context, now time.Time, limit int64, lease time.Duration) ([]string, error) {
+	return s.cache.ClaimDueWebhooks(ctx, now, limit, lease)
+}
+
+func (s *RedisStore) Secret(ctx context.Context, deliveryID string) (string, error) {
+	return s.cache.GetWebhookSecret(ctx, deliveryID)
+}
+
+func (s *RedisStore) Unschedule(ctx context.Context, deliveryID string) error {
+	return s.cache.UnscheduleWebhook(ctx, deliveryID)
+}
+
+func (s *RedisStore) Save(ctx context.Context, delivery *models.WebhookDelivery) error {
+	failedKey := cache.TenantKey(delivery.TenantID, cache.GetFailedWebhooksKey())
+	return s.cache.SaveWebhookDelivery(ctx, failedKey, delivery, s.retention)
+}
+
+func (s *RedisStore) Get(ctx context.Context, deliveryID string) (*models.WebhookDelivery, error) {
+	return s.cache.GetWebhookDelivery(ctx, deliveryID)
+}
+
+func (s *RedisStore) ListFailed(ctx context.Context, tenantID string, limit int64) ([]*models.WebhookDelivery, error) {
+	return s.cache.GetFailedWebhookDeliveries(ctx, cache.TenantKey(tenantID, cache.GetFailedWebhooksKey()), limit)
+}
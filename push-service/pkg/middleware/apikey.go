@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// header carrying an API key, "Authorization: ApiKey <key>" is accepted too
+const APIKeyHeader = "X-API-Key"
+
+// an API key known by the sha256 of its value
+type apiKey struct {
+	name     string
+	tenantID string
+	hash     []byte
+	scopes   []string
+}
+
+// APIKeyAuthenticator authenticates static API keys. only their hashes are
+// configured, so the configuration does not hold usable keys
+type APIKeyAuthenticator struct {
+	keys []apiKey
+}
+
+// parses comma separated keys of the form name:sha256hex:scope|scope, e.g.
+// "billing:9f86d0...:notifications:send|tokens:validate". a "tenant/name"
+// name binds the key to the tenant, keys without one act for the default
+// tenant, or for any tenant when granted admin
+func NewAPIKeyAuthenticator(spec string) (*APIKeyAuthenticator, error) {
+	authenticator := &APIKeyAuthenticator{}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid api key entry %q, expected name:sha256:scopes", entry)
+		}
+
+		hash, err := hex.DecodeString(parts[1])
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid api key entry %q, hash must be a hex sha256", parts[0])
+		}
+
+		var scopes []string
+		for _, scope := range strings.Split(parts[2], "|") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				scopes = append(scopes, scope)
+			}
+		}
+
+		name := parts[0]
+		tenantID, keyName, bound := strings.Cut(name, "/")
+		if bound {
+			if tenantID == "" || keyName == "" {
+				return nil, fmt.Errorf("invalid api key entry %q, expected tenant/name", name)
+			}
+			name = keyName
+		} else {
+			tenantID = ""
+		}
+
+		authenticator.keys = append(authenticator.keys, apiKey{name: name, tenantID: tenantID, hash: hash, scopes: scopes})
+	}
+
+	return authenticator, nil
+}
+
+// number of configured keys
+func (a *APIKeyAuthenticator) Len() int {
+	return len(a.keys)
+}
+
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		if value, ok := strings.CutPrefix(r.Header.Get("Authorization"), "ApiKey "); ok {
+			key = strings.TrimSpace(value)
+		}
+	}
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	sum := sha256.Sum256([]byte(key))
+
+	// every key is compared so the match position is not timed
+	var found *apiKey
+	for i := range a.keys {
+		if subtle.ConstantTimeCompare(sum[:], a.keys[i].hash) == 1 {
+			found = &a.keys[i]
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("unknown api key")
+	}
+
+	return &Principal{
+		ID:       found.name,
+		Method:   "api_key",
+		TenantID: found.tenantID,
+		Scopes:   found.scopes,
+	}, nil
+}
+
+// returns the hex sha256 of a key, the form keys are configured in
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/handler"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
+)
+
+// route scopes
+const (
+	ScopeNotificationsSend = "notifications:send"
+	ScopeNotificationsSelf = "notifications:self" // sends only to the caller's own user_id
+	ScopeTokensValidate    = "tokens:validate"
+	ScopeAdmin             = "admin" // grants every scope
+)
+
+// returned by an authenticator when the request carries no credentials it
+// understands, so the next authenticator is tried
+var ErrNoCredentials = errors.New("no credentials")
+
+// the authenticated caller of a request
+type Principal struct {
+	ID       string   `json:"id"`
+	Method   string   `json:"method"` // "api_key" or "jwt"
+	TenantID string   `json:"tenant_id,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+}
+
+// whether the principal was granted the scope, admin is granted every scope
+func (p *Principal) HasScope(scope string) bool {
+	for _, granted := range p.Scopes {
+		if granted == scope || granted == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// whether the principal may only send to its own user, as user-service tokens
+func (p *Principal) SelfOnly() bool {
+	return !p.HasScope(ScopeNotificationsSend) && p.HasScope(ScopeNotificationsSelf)
+}
+
+// the caller identity recorded on queued messages, e.g. "api_key:billing", or
+// "api_key:acme/billing" for a caller bound to a tenant
+func (p *Principal) Identity() string {
+	if p.TenantID != "" {
+		return p.Method + ":" + p.TenantID + "/" + p.ID
+	}
+	return p.Method + ":" + p.ID
+}
+
+// Authenticator identifies the caller of a request
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Authenticators tries each authenticator in turn until one finds credentials
+type Authenticators []Authenticator
+
+func (a Authenticators) Authenticate(r *http.Request) (*Principal, error) {
+	for _, authenticator := range a {
+		principal, err := authenticator.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return principal, err
+	}
+	return nil, ErrNoCredentials
+}
+
+type principalKey struct{}
+
+// returns a context carrying the principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// returns the principal authenticated for a request, if any
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
+
+// @Middleware		AuthMiddleware
+// @Description	Authenticates the caller with an API key or a bearer JWT
+// @Usage			AuthMiddleware(authenticator)
+// @Checks			Rejects requests without valid credentials with 401, stores the caller in the request context
+func AuthMiddleware(authenticator Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// preflight requests carry no credentials
+			if r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			principal, err := authenticator.Authenticate(r)
+			if err != nil {
+				reqFields := logger.Fields{
+					"path":        r.URL.Path,
+					"method":      r.Method,
+					"remote_addr": r.RemoteAddr,
+				}
+
+				message := "Authentication required"
+				if !errors.Is(err, ErrNoCredentials) {
+					message = "Invalid credentials"
+					reqFields["reason"] = err.Error()
+				}
+				logger.Warn("rejected unauthenticated request", reqFields)
+
+				w.Header().Set("WWW-Authenticate", `Bearer realm="push-service"`)
+				handler.RespondWithError(w, http.StatusUnauthorized, message, nil)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+// @Middleware		RequireScope
+// @Description	Restricts a route to callers granted one of the scopes
+// @Usage			RequireScope(ScopeNotificationsSend, ScopeNotificationsSelf)
+// @Checks			Rejects callers without any of the scopes with 403, requires AuthMiddleware to run first
+func RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			principal, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				handler.RespondWithError(w, http.StatusUnauthorized, "Authentication required", nil)
+				return
+			}
+
+			for _, scope := range scopes {
+				if principal.HasScope(scope) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			logger.Warn("rejected request missing scope", logger.Fields{
+				"path":      r.URL.Path,
+				"method":    r.Method,
+				"principal": principal.Identity(),
+				"scope":     scopes[0],
+			})
+			handler.RespondWithError(w, http.StatusForbidden, "Missing required scope: "+scopes[0], nil)
+		})
+	}
+}
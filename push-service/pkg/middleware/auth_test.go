@@ -0,0 +1,377 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const testSecret = "user-service-secret"
+
+func signHS256(t *testing.T, secret string, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return token
+}
+
+// tests API keys are matched by hash and carry their scopes
+func TestAPIKeyAuthenticator(t *testing.T) {
+	spec := "billing:" + HashAPIKey("s3cret") + ":notifications:send|tokens:validate, ops:" + HashAPIKey("0ps") + ":admin"
+
+	auth, err := NewAPIKeyAuthenticator(spec)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if auth.Len() != 2 {
+		t.Fatalf("Expected 2 keys, got %d", auth.Len())
+	}
+
+	t.Run("Header", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/notifications/", nil)
+		r.Header.Set(APIKeyHeader, "s3cret")
+
+		principal, err := auth.Authenticate(r)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if principal.Identity() != "api_key:billing" {
+			t.Errorf("Unexpected identity '%s'", principal.Identity())
+		}
+		if !principal.HasScope(ScopeNotificationsSend) || !principal.HasScope(ScopeTokensValidate) || principal.HasScope(ScopeAdmin) {
+			t.Errorf("Unexpected scopes %v", principal.Scopes)
+		}
+	})
+
+	t.Run("Authorization header", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/stats", nil)
+		r.Header.Set("Authorization", "ApiKey 0ps")
+
+		principal, err := auth.Authenticate(r)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !principal.HasScope(ScopeTokensValidate) {
+			t.Error("Expected admin to grant every scope")
+		}
+	})
+
+	t.Run("Unknown key", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(APIKeyHeader, "guess")
+
+		if _, err := auth.Authenticate(r); err == nil || err == ErrNoCredentials {
+			t.Errorf("Expected an invalid key error, got %v", err)
+		}
+	})
+
+	t.Run("No key", func(t *testing.T) {
+		if _, err := auth.Authenticate(httptest.NewRequest(http.MethodGet, "/", nil)); err != ErrNoCredentials {
+			t.Errorf("Expected ErrNoCredentials, got %v", err)
+		}
+	})
+
+	t.Run("Tenant key", func(t *testing.T) {
+		tenantAuth, err := NewAPIKeyAuthenticator("acme/billing:" + HashAPIKey("acme-key") + ":notifications:send")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		r := httptest.NewRequest(http.MethodPost, "/notifications/", nil)
+		r.Header.Set(APIKeyHeader, "acme-key")
+
+		principal, err := tenantAuth.Authenticate(r)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if principal.TenantID != "acme" || principal.ID != "billing" || principal.Identity() != "api_key:acme/billing" {
+			t.Errorf("Unexpected principal %+v", principal)
+		}
+	})
+
+	if _, err := NewAPIKeyAuthenticator("/billing:" + HashAPIKey("s3cret") + ":admin"); err == nil {
+		t.Error("Expected an error for an empty tenant")
+	}
+	if _, err := NewAPIKeyAuthenticator("billing:not-a-hash:admin"); err == nil {
+		t.Error("Expected an error for an invalid hash")
+	}
+}
+
+// tests JWT validation, including tokens shaped like the user-service's
+func TestJWTAuthenticator(t *testing.T) {
+	auth, err := NewJWTAuthenticator(JWTOptions{Secret: testSecret})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	request := func(token string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/notifications/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		return r
+	}
+
+	t.Run("User service token", func(t *testing.T) {
+		token := signHS256(t, testSecret, jwt.MapClaims{
+			"id":       "user-42",
+			"email":    "ada@example.com",
+			"username": "Ada",
+			"iat":      time.Now().Unix(),
+			"exp":      time.Now().Add(time.Hour).Unix(),
+		})
+
+		principal, err := auth.Authenticate(request(token))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if principal.Identity() != "jwt:user-42" {
+			t.Errorf("Unexpected identity '%s'", principal.Identity())
+		}
+		if !principal.SelfOnly() || principal.HasScope(ScopeNotificationsSend) || principal.HasScope(ScopeTokensValidate) {
+			t.Errorf("Expected only the self scope, got %v", principal.Scopes)
+		}
+	})
+
+	t.Run("Default scopes", func(t *testing.T) {
+		withDefaults, err := NewJWTAuthenticator(JWTOptions{Secret: testSecret, DefaultScopes: []string{ScopeTokensValidate}})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		token := signHS256(t, testSecret, jwt.MapClaims{"id": "user-42", "exp": time.Now().Add(time.Hour).Unix()})
+		principal, err := withDefaults.Authenticate(request(token))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !principal.HasScope(ScopeNotificationsSelf) || !principal.HasScope(ScopeTokensValidate) {
+			t.Errorf("Expected the self and default scopes, got %v", principal.Scopes)
+		}
+	})
+
+	t.Run("Scope claim", func(t *testing.T) {
+		token := signHS256(t, testSecret, jwt.MapClaims{
+			"sub":       "svc-template",
+			"scope":     "admin",
+			"tenant_id": "acme",
+			"exp":       time.Now().Add(time.Hour).Unix(),
+		})
+
+		principal, err := auth.Authenticate(request(token))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !principal.HasScope(ScopeAdmin) || principal.TenantID != "acme" {
+			t.Errorf("Unexpected principal %+v", principal)
+		}
+	})
+
+	rejected := map[string]string{
+		"Expired": signHS256(t, testSecret, jwt.MapClaims{
+			"id":  "user-42",
+			"exp": time.Now().Add(-time.Minute).Unix(),
+		}),
+		"No expiry":    signHS256(t, testSecret, jwt.MapClaims{"id": "user-42"}),
+		"Wrong secret": signHS256(t, "other", jwt.MapClaims{"id": "user-42", "exp": time.Now().Add(time.Hour).Unix()}),
+		"No subject":   signHS256(t, testSecret, jwt.MapClaims{"email": "ada@example.com", "exp": time.Now().Add(time.Hour).Unix()}),
+		"Unsigned":     unsignedToken(t),
+	}
+	for name, token := range rejected {
+		t.Run(name, func(t *testing.T) {
+			if _, err := auth.Authenticate(request(token)); err == nil || err == ErrNoCredentials {
+				t.Errorf("Expected the token to be rejected, got %v", err)
+			}
+		})
+	}
+
+	t.Run("Issuer and audience", func(t *testing.T) {
+		strict, err := NewJWTAuthenticator(JWTOptions{Secret: testSecret, Issuer: "user-service", Audience: "push-service"})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		exp := time.Now().Add(time.Hour).Unix()
+		valid := signHS256(t, testSecret, jwt.MapClaims{"sub": "u1", "iss": "user-service", "aud": "push-service", "exp": exp})
+		if _, err := strict.Authenticate(request(valid)); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+
+		wrongAudience := signHS256(t, testSecret, jwt.MapClaims{"sub": "u1", "iss": "user-service", "aud": "billing", "exp": exp})
+		if _, err := strict.Authenticate(request(wrongAudience)); err == nil {
+			t.Error("Expected a token for another audience to be rejected")
+		}
+	})
+}
+
+// tests public key tokens, and that an HMAC token cannot use the public key as its secret
+func TestJWTAuthenticatorPublicKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	auth, err := NewJWTAuthenticator(JWTOptions{PublicKeyPEM: publicPEM})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"sub": "svc", "exp": exp}).SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	if _, err := auth.Authenticate(r); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	forged := signHS256(t, string(publicPEM), jwt.MapClaims{"sub": "svc", "exp": exp})
+	r.Header.Set("Authorization", "Bearer "+forged)
+	if _, err := auth.Authenticate(r); err == nil {
+		t.Error("Expected an HS256 token to be rejected without a secret")
+	}
+}
+
+func unsignedToken(t *testing.T) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"id": "user-42", "exp": time.Now().Add(time.Hour).Unix()}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("failed to build token: %v", err)
+	}
+	return token
+}
+
+// tests the middleware responses for missing credentials and scopes
+func TestAuthMiddleware(t *testing.T) {
+	auth, err := NewAPIKeyAuthenticator("billing:" + HashAPIKey("s3cret") + ":notifications:send")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var seen *Principal
+	handler := AuthMiddleware(Authenticators{auth})(RequireScope(ScopeTokensValidate)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = PrincipalFromContext(r.Context())
+	})))
+	sendHandler := AuthMiddleware(auth)(RequireScope(ScopeNotificationsSend)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = PrincipalFromContext(r.Context())
+	})))
+	anyHandler := AuthMiddleware(auth)(RequireScope(ScopeTokensValidate, ScopeNotificationsSend)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = PrincipalFromContext(r.Context())
+	})))
+
+	testCases := []struct {
+		name    string
+		handler http.Handler
+		key     string
+		expect  int
+	}{
+		{"No credentials", sendHandler, "", http.StatusUnauthorized},
+		{"Invalid key", sendHandler, "guess", http.StatusUnauthorized},
+		{"Missing scope", handler, "s3cret", http.StatusForbidden},
+		{"Any of the scopes", anyHandler, "s3cret", http.StatusOK},
+		{"Allowed", sendHandler, "s3cret", http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			seen = nil
+			r := httptest.NewRequest(http.MethodPost, "/notifications/", nil)
+			if tc.key != "" {
+				r.Header.Set(APIKeyHeader, tc.key)
+			}
+			w := httptest.NewRecorder()
+
+			tc.handler.ServeHTTP(w, r)
+
+			if w.Code != tc.expect {
+				t.Errorf("Expected status %d, got %d", tc.expect, w.Code)
+			}
+			if tc.expect == http.StatusOK && (seen == nil || seen.ID != "billing") {
+				t.Errorf("Expected the principal in the request context, got %+v", seen)
+			}
+			if tc.expect == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Expected a WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+// tests credentials are only allowed for listed origins
+func TestCorsMiddlewareCredentials(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	testCases := []struct {
+		name              string
+		allowed           []string
+		expectOrigin      string
+		expectCredentials string
+	}{
+		{"Wildcard", []string{"*"}, "*", ""},
+		{"Listed", []string{"https://app.example.com", "*"}, "https://app.example.com", "true"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/notifications", nil)
+			r.Header.Set("Origin", "https://app.example.com")
+			w := httptest.NewRecorder()
+
+			CorsMiddleware(tc.allowed)(next).ServeHTTP(w, r)
+
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tc.expectOrigin {
+				t.Errorf("Expected origin '%s', got '%s'", tc.expectOrigin, got)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tc.expectCredentials {
+				t.Errorf("Expected credentials '%s', got '%s'", tc.expectCredentials, got)
+			}
+		})
+	}
+}
+
+// tests internal routes need the shared service key
+func TestServiceKeyMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	testCases := []struct {
+		name       string
+		configured string
+		key        string
+		expect     int
+	}{
+		{"Valid key", "internal", "internal", http.StatusOK},
+		{"Missing key", "internal", "", http.StatusUnauthorized},
+		{"Wrong key", "internal", "guess", http.StatusUnauthorized},
+		{"No key configured", "", "", http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/templates/invalidate", nil)
+			if tc.key != "" {
+				r.Header.Set(ServiceKeyHeader, tc.key)
+			}
+			w := httptest.NewRecorder()
+
+			ServiceKeyMiddleware(tc.configured)(next).ServeHTTP(w, r)
+
+			if w.Code != tc.expect {
+				t.Errorf("Expected status %d, got %d", tc.expect, w.Code)
+			}
+		})
+	}
+}
@@ -9,7 +9,7 @@ import (
 // @Middleware		CorsMiddleware
 // @Description	Handles Cross-Origin Resource Sharing (CORS) for HTTP requests
 // @Usage			CorsMiddleware(allowedOrigins)
-// @Checks			Validates origin against allowed origins, sets CORS headers, handles preflight requests
+// @Checks			Validates origin against allowed origins, sets CORS headers, handles preflight requests, allows credentials for listed origins only
 func CorsMiddleware(allowedOrigins []string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -24,27 +24,32 @@ func CorsMiddleware(allowedOrigins []string) func(http.Handler) http.Handler {
 			}
 
 			// Check if origin is allowed
-			allowed := false
+			listed, wildcard := false, false
 			for _, allowedOrigin := range allowedOrigins {
-				if origin == allowedOrigin || allowedOrigin == "*" {
-					allowed = true
-					break
+				if origin == allowedOrigin {
+					listed = true
+				}
+				if allowedOrigin == "*" {
+					wildcard = true
 				}
 			}
 
-			if !allowed && origin != "" {
+			if !listed && !wildcard && origin != "" {
 				logger.Warn("blocked request from unauthorized origin", reqFields)
 				http.Error(w, "Unauthorized origin", http.StatusForbidden)
 				return
 			}
 
 			// Set CORS headers
-			if origin != "" {
+			w.Header().Add("Vary", "Origin")
+			if listed {
 				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			} else if origin != "" {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
 			}
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Idempotency-Key, "+APIKeyHeader)
 
 			// Handle preflight requests
 			if r.Method == "OPTIONS" {
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// JWT validation settings, a secret, a public key or both must be set
+type JWTOptions struct {
+	Secret        string   // verifies HS256 tokens, such as those issued by the user-service
+	PublicKeyPEM  []byte   // verifies RS256 or ES256 tokens
+	Issuer        string   // required "iss" when set
+	Audience      string   // required "aud" when set
+	DefaultScopes []string // granted with notifications:self to tokens without a "scope" or "scopes" claim
+}
+
+// JWTAuthenticator authenticates bearer JWTs
+type JWTAuthenticator struct {
+	options   JWTOptions
+	parser    *jwt.Parser
+	publicKey interface{}
+}
+
+func NewJWTAuthenticator(options JWTOptions) (*JWTAuthenticator, error) {
+	authenticator := &JWTAuthenticator{options: options}
+
+	var methods []string
+	if options.Secret != "" {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+
+	if len(options.PublicKeyPEM) > 0 {
+		if key, err := jwt.ParseRSAPublicKeyFromPEM(options.PublicKeyPEM); err == nil {
+			authenticator.publicKey = key
+			methods = append(methods, jwt.SigningMethodRS256.Alg())
+		} else if key, err := jwt.ParseECPublicKeyFromPEM(options.PublicKeyPEM); err == nil {
+			authenticator.publicKey = key
+			methods = append(methods, jwt.SigningMethodES256.Alg())
+		} else {
+			return nil, fmt.Errorf("failed to parse jwt public key: expected an RSA or EC public key")
+		}
+	}
+
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("jwt authentication needs a secret or a public key")
+	}
+
+	// the algorithm is pinned so a token cannot pick how it is verified
+	authenticator.parser = jwt.NewParser(jwt.WithValidMethods(methods))
+	return authenticator, nil
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	tokenString, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || strings.TrimSpace(tokenString) == "" {
+		return nil, ErrNoCredentials
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := a.parser.ParseWithClaims(strings.TrimSpace(tokenString), claims, a.key); err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	// the parser only checks "exp" when present, tokens must expire
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("invalid token: missing or past expiry")
+	}
+	if a.options.Issuer != "" && !claims.VerifyIssuer(a.options.Issuer, true) {
+		return nil, fmt.Errorf("invalid token: unexpected issuer")
+	}
+	if a.options.Audience != "" && !claims.VerifyAudience(a.options.Audience, true) {
+		return nil, fmt.Errorf("invalid token: unexpected audience")
+	}
+
+	// user-service tokens identify the user by "id" instead of "sub"
+	id := stringClaim(claims, "sub")
+	if id == "" {
+		id = stringClaim(claims, "id")
+	}
+	if id == "" {
+		return nil, fmt.Errorf("invalid token: no subject")
+	}
+
+	// tokens without scopes are end user tokens, such as the user-service's,
+	// and may only send to their own user
+	scopes := tokenScopes(claims)
+	if scopes == nil {
+		scopes = append([]string{ScopeNotificationsSelf}, a.options.DefaultScopes...)
+	}
+
+	return &Principal{
+		ID:       id,
+		Method:   "jwt",
+		TenantID: stringClaim(claims, "tenant_id"),
+		Scopes:   scopes,
+	}, nil
+}
+
+// returns the verification key for the token's algorithm
+func (a *JWTAuthenticator) key(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		return []byte(a.options.Secret), nil
+	case *jwt.SigningMethodRSA:
+		if key, ok := a.publicKey.(*rsa.PublicKey); ok {
+			return key, nil
+		}
+	case *jwt.SigningMethodECDSA:
+		if key, ok := a.publicKey.(*ecdsa.PublicKey); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected signing method %s", token.Header["alg"])
+}
+
+// reads a string claim, numeric ids are formatted as integers
+func stringClaim(claims jwt.MapClaims, name string) string {
+	switch value := claims[name].(type) {
+	case string:
+		return value
+	case float64:
+		return fmt.Sprintf("%.0f", value)
+	}
+	return ""
+}
+
+// reads the space separated "scope" claim or the "scopes" array, nil when
+// the token has neither
+func tokenScopes(claims jwt.MapClaims) []string {
+	if scope, ok := claims["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+
+	values, ok := claims["scopes"].([]interface{})
+	if !ok {
+		return nil
+	}
+	scopes := make([]string, 0, len(values))
+	for _, value := range values {
+		if scope, ok := value.(string); ok {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
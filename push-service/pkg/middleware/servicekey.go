@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/handler"
+	"github.com/zjoart/distributed-notification-system/push-service/pkg/logger"
+)
+
+// header carrying the key shared with an internal service
+const ServiceKeyHeader = "X-Service-Key"
+
+// @Middleware		ServiceKeyMiddleware
+// @Description	Restricts a route to internal services holding the shared key
+// @Usage			ServiceKeyMiddleware(key)
+// @Checks			Rejects requests without the key in X-Service-Key with 401, used for internal routes when auth is disabled
+func ServiceKeyMiddleware(key string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			given := r.Header.Get(ServiceKeyHeader)
+			if key == "" || subtle.ConstantTimeCompare([]byte(given), []byte(key)) != 1 {
+				logger.Warn("rejected request without a valid service key", logger.Fields{
+					"path":        r.URL.Path,
+					"method":      r.Method,
+					"remote_addr": r.RemoteAddr,
+				})
+				handler.RespondWithError(w, http.StatusUnauthorized, "Invalid service key", nil)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -1,6 +1,9 @@
 from __future__ import annotations
 
-from fastapi import Depends, FastAPI, status
+import hashlib
+import json
+
+from fastapi import Depends, FastAPI, Header, Response, status
 from fastapi.responses import JSONResponse
 
 from .config import Settings, get_settings
@@ -61,12 +64,27 @@ async def render_template(
     )
 
 
+def template_etag(template: PushTemplateResponse) -> str:
+    """Strong ETag derived from the template content."""
+    payload = json.dumps(template.model_dump(mode="json"), sort_keys=True).encode()
+    return f'"{hashlib.sha256(payload).hexdigest()}"'
+
+
 @app.get("/templates/pull/{template_code}", response_model=PushTemplateResponse)
 async def get_push_template(
     template_code: str,
+    response: Response,
+    locale: str | None = None,
+    if_none_match: str | None = Header(default=None),
     service: TemplateService = Depends(get_template_service),
-) -> PushTemplateResponse:
-    return service.get_push_template(template_code)
+):
+    template = service.get_push_template(template_code, locale)
+    etag = template_etag(template)
+    if if_none_match and etag in [tag.strip() for tag in if_none_match.split(",")]:
+        return Response(status_code=status.HTTP_304_NOT_MODIFIED, headers={"ETag": etag})
+
+    response.headers["ETag"] = etag
+    return template
 
 
 @app.post("/templates/push/render", response_model=PushTemplateResponse)
@@ -23,6 +23,13 @@ class RenderResponse(BaseModel):
     content: str
 
 
+class TemplateVariable(BaseModel):
+    name: str
+    type: Literal["string", "number", "boolean", "url", "list", "object"] = "string"
+    required: bool = False
+    max_length: int | None = None
+
+
 class PushTemplateResponse(BaseModel):
     code: str
     name: str
@@ -39,11 +46,15 @@ class PushTemplateResponse(BaseModel):
     badge: int | None = None
     priority: int | None = None
     link: str = "https://example.com/welcome"
+    locale: str | None = None
+    variables: list[TemplateVariable] = Field(default_factory=list)
 
 
 class PushRenderRequest(BaseModel):
     template_code: str
     context: dict[str, Any] = Field(default_factory=dict)
+    locale: str | None = None
+    fallback_locales: list[str] = Field(default_factory=list)
 
 
 class HealthResponse(BaseModel):
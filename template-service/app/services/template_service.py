@@ -1,5 +1,5 @@
 from dataclasses import asdict, dataclass, field
-from typing import Literal
+from typing import Any, Literal
 
 from jinja2 import Environment, FileSystemLoader, TemplateNotFound, select_autoescape
 
@@ -23,6 +23,10 @@ class TemplateSourceMissingError(TemplateError):
     """Template file is missing on disk."""
 
 
+class TemplateLocaleMissingError(TemplateNotRegisteredError):
+    """Template has no copy for the requested locale."""
+
+
 @dataclass(frozen=True)
 class TemplateDefinition:
     key: TemplateKey
@@ -46,6 +50,10 @@ class PushTemplateDefinition:
     sound: str | None = None
     badge: int | None = None
     priority: int | None = None
+    # title and body overrides per locale, the fields above are the default locale copy
+    locales: dict[str, dict[str, str]] = field(default_factory=dict)
+    # variable schema checked by callers before rendering
+    variables: list[dict[str, Any]] = field(default_factory=list)
 
 
 TEMPLATE_REGISTRY: dict[TemplateKey, TemplateDefinition] = {
@@ -92,6 +100,11 @@ PUSH_TEMPLATE_REGISTRY: dict[str, PushTemplateDefinition] = {
         sound="notification.mp3",
         badge=1,
         priority=10,
+        variables=[
+            {"name": "reset_code", "type": "string", "required": True, "max_length": 12},
+            {"name": "expires_at", "type": "string", "required": True},
+            {"name": "request_time", "type": "string"},
+        ],
     ),
     "WELCOME_EMAIL": PushTemplateDefinition(
         code="WELCOME_EMAIL",
@@ -114,6 +127,17 @@ PUSH_TEMPLATE_REGISTRY: dict[str, PushTemplateDefinition] = {
         sound="notification.mp3",
         badge=1,
         priority=5,
+        locales={
+            "pt": {
+                "title": "Bem-vindo ao {{product_name | default('Notifications Hub')}}",
+                "body": "Olá {{user_name | default('você')}}, toque para conhecer seu novo espaço de trabalho.",
+            },
+        },
+        variables=[
+            {"name": "user_name", "type": "string", "max_length": 64},
+            {"name": "product_name", "type": "string", "max_length": 64},
+            {"name": "request_time", "type": "string"},
+        ],
     ),
 }
 
@@ -156,17 +180,36 @@ class TemplateService:
         subject = self._string_env.from_string(definition.subject_template).render(**render_context)
         return subject.strip(), content
 
-    def get_push_template(self, template_code: str) -> PushTemplateResponse:
+    def _resolve_locale(self, definition: PushTemplateDefinition, locales: list[str]) -> tuple[str, dict[str, str]]:
+        """Returns the first locale the template has a copy for and its overrides."""
+        for locale in locales:
+            if locale == self._settings.default_locale:
+                return locale, {}
+            if locale in definition.locales:
+                return locale, definition.locales[locale]
+        raise TemplateLocaleMissingError(
+            f"push template {definition.code} has no copy for locales {', '.join(locales)}."
+        )
+
+    def get_push_template(self, template_code: str, locale: str | None = None) -> PushTemplateResponse:
         definition = PUSH_TEMPLATE_REGISTRY.get(template_code)
         if not definition:
             raise TemplateNotRegisteredError(f"push template {template_code} is not registered.")
-        return PushTemplateResponse(**asdict(definition))
+
+        # only the exact locale is served, callers walk their own fallback chain
+        resolved, overrides = self._resolve_locale(definition, [locale or self._settings.default_locale])
+        fields = asdict(definition)
+        fields.pop("locales")
+        return PushTemplateResponse(**{**fields, **overrides, "locale": resolved})
 
     def render_push_template(self, request: PushRenderRequest) -> PushTemplateResponse:
         definition = PUSH_TEMPLATE_REGISTRY.get(request.template_code)
         if not definition:
             raise TemplateNotRegisteredError(f"push template {request.template_code} is not registered.")
 
+        locales = [locale for locale in [request.locale, *request.fallback_locales] if locale]
+        resolved, overrides = self._resolve_locale(definition, [*locales, self._settings.default_locale])
+
         render_context = {**request.context}
 
         def render_value(value: str | None) -> str | None:
@@ -182,8 +225,8 @@ class TemplateService:
             description=definition.description,
             category=definition.category,
             type=definition.type,
-            title=render_value(definition.title),
-            body=render_value(definition.body),
+            title=render_value(overrides.get("title", definition.title)),
+            body=render_value(overrides.get("body", definition.body)),
             image_url=render_value(definition.image_url),
             icon_url=render_value(definition.icon_url),
             data=rendered_data,
@@ -191,4 +234,6 @@ class TemplateService:
             sound=definition.sound,
             badge=definition.badge,
             priority=definition.priority,
+            locale=resolved,
+            variables=definition.variables,
         )
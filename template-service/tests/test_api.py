@@ -12,10 +12,25 @@ def build_client():
 @pytest.mark.asyncio
 async def test_get_push_template():
     async with build_client() as client:
-        response = await client.get("/templates/push/PASSWORD_RESET_CODE")
+        response = await client.get("/templates/pull/PASSWORD_RESET_CODE")
 
     assert response.status_code == 200
     assert response.json()["code"] == "PASSWORD_RESET_CODE"
+    assert response.headers["ETag"]
+
+
+@pytest.mark.asyncio
+async def test_get_push_template_not_modified():
+    async with build_client() as client:
+        first = await client.get("/templates/pull/PASSWORD_RESET_CODE")
+        etag = first.headers["ETag"]
+        response = await client.get(
+            "/templates/pull/PASSWORD_RESET_CODE",
+            headers={"If-None-Match": etag},
+        )
+
+    assert response.status_code == 304
+    assert response.headers["ETag"] == etag
 
 
 @pytest.mark.asyncio
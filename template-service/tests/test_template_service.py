@@ -63,3 +63,37 @@ def test_render_push_template_unknown():
     service = TemplateService(settings=build_settings())
     with pytest.raises(TemplateNotRegisteredError):
         service.render_push_template(PushRenderRequest(template_code="UNKNOWN"))
+
+
+def test_get_push_template_locale():
+    service = TemplateService(settings=build_settings())
+
+    assert service.get_push_template("WELCOME_EMAIL", "pt").title.startswith("Bem-vindo")
+    assert service.get_push_template("WELCOME_EMAIL").locale == "en"
+    with pytest.raises(TemplateNotRegisteredError):
+        service.get_push_template("WELCOME_EMAIL", "pt-BR")
+
+
+def test_render_push_template_locale_fallback():
+    service = TemplateService(settings=build_settings())
+    request = PushRenderRequest(
+        template_code="WELCOME_EMAIL",
+        context={"user_name": "Nia"},
+        locale="pt-BR",
+        fallback_locales=["pt", "en"],
+    )
+
+    response = service.render_push_template(request)
+
+    assert response.locale == "pt"
+    assert "Olá Nia" in response.body
+
+
+def test_get_push_template_variables():
+    service = TemplateService(settings=build_settings())
+
+    variables = {variable.name: variable for variable in service.get_push_template("PASSWORD_RESET_CODE").variables}
+
+    assert variables["reset_code"].required
+    assert variables["reset_code"].max_length == 12
+    assert not variables["request_time"].required
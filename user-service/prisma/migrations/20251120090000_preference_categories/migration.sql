@@ -0,0 +1,3 @@
+-- AlterTable
+ALTER TABLE "Preference" ADD COLUMN     "disabled_categories" TEXT[] DEFAULT ARRAY[]::TEXT[],
+ADD COLUMN     "muted_until" TIMESTAMP(3);
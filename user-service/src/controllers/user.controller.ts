@@ -1,5 +1,5 @@
 import type { FastifyReply, FastifyRequest } from "fastify";
-import { loginUser, createUser, updatePreferences, getUserById, updatePushToken} from "../services/user.service.js";
+import { loginUser, createUser, updatePreferences, getUserById, updatePushToken, getUserPreferences} from "../services/user.service.js";
 import { successResponse, errorResponse } from "../utils/response.js"; 
 import { PrismaClient } from "@prisma/client";
 
@@ -66,9 +66,21 @@ export const getUserProfile = async (req: any, reply: FastifyReply) => {
 // app.put('/api/users/preferences', { preHandler: authenticate }, 
 export const updateUserPreferences = async (req: any, reply: FastifyReply) => {
   try {
-    const { email, push } = req.body;
-    
-    const user = await updatePreferences(req.user.id, { email, push });
+    const { email, push, disabled_categories, muted_until } = req.body;
+
+    if (disabled_categories !== undefined && (!Array.isArray(disabled_categories) || disabled_categories.some((c: unknown) => typeof c !== "string"))) {
+      return reply.code(400).send(
+        errorResponse("Failed to update preferences", "disabled_categories must be a list of strings")
+      );
+    }
+    const mutedUntil = muted_until ? new Date(muted_until) : muted_until;
+    if (mutedUntil instanceof Date && isNaN(mutedUntil.getTime())) {
+      return reply.code(400).send(
+        errorResponse("Failed to update preferences", "muted_until must be an ISO 8601 date")
+      );
+    }
+
+    const user = await updatePreferences(req.user.id, { email, push, disabled_categories, muted_until: mutedUntil });
 
     return reply.send(
       successResponse({ ...user },"Preferences updated successfully")
@@ -96,3 +108,22 @@ export const handlePushTokenUpdate = async (req: any, reply: FastifyReply) => {
   }
 };
 
+// app.get('/api/users/:id/preferences', { preHandler: authenticateService },
+export const getPreferences = async (req: any, reply: FastifyReply) => {
+  try {
+    const preferences = await getUserPreferences(req.params.id);
+    if (!preferences) {
+      return reply.code(404).send(
+        errorResponse("User not found", `No user with id ${req.params.id}`)
+      );
+    }
+
+    return reply.send(
+      successResponse(preferences, "Preferences retrieved successfully")
+    );
+  } catch (err) {
+    return reply.code(500).send(
+      errorResponse("Failed to retrieve preferences", err instanceof Error ? err.message : "Unknown error")
+    );
+  }
+};
@@ -1,3 +1,4 @@
+import { timingSafeEqual } from 'node:crypto';
 import { verifyToken } from '../utils/auth.js';
 import type { FastifyRequest, FastifyReply } from 'fastify';
 
@@ -14,4 +15,35 @@ export const authenticate = async (req: FastifyRequest, reply: FastifyReply) =>
   } catch (err) {
     return reply.code(401).send({ error: 'Invalid token' });
   }
-};
\ No newline at end of file
+};
+
+const keysMatch = (given: string, expected: string) => {
+  const a = Buffer.from(given);
+  const b = Buffer.from(expected);
+  return a.length === b.length && timingSafeEqual(a, b);
+};
+
+// lets other services read user data with the shared SERVICE_API_KEY, or a
+// user read their own data with their token
+export const authenticateService = async (req: FastifyRequest, reply: FastifyReply) => {
+  const serviceKey = process.env.SERVICE_API_KEY;
+  const givenKey = req.headers['x-service-key'];
+  if (serviceKey && typeof givenKey === 'string' && keysMatch(givenKey, serviceKey)) {
+    return;
+  }
+
+  try {
+    const token = req.headers.authorization?.replace('Bearer ', '');
+    if (!token) {
+      return reply.code(401).send({ error: 'No token provided' });
+    }
+
+    const decoded = verifyToken(token) as any;
+    if (decoded?.id !== (req.params as any).id) {
+      return reply.code(403).send({ error: 'Token does not belong to this user' });
+    }
+    (req as any).user = decoded;
+  } catch (err) {
+    return reply.code(401).send({ error: 'Invalid token' });
+  }
+};
@@ -1,6 +1,6 @@
 import type { FastifyInstance } from 'fastify';
-import { create, login, getUserProfile, updateUserPreferences , handlePushTokenUpdate} from '../controllers/user.controller.js';
-import { authenticate } from '../middlewares/auth.js';
+import { create, login, getUserProfile, updateUserPreferences , handlePushTokenUpdate, getPreferences} from '../controllers/user.controller.js';
+import { authenticate, authenticateService } from '../middlewares/auth.js';
 
 export default async function userRoutes(app: FastifyInstance) {
   app.post('/api/users/register', create);
@@ -8,4 +8,5 @@ export default async function userRoutes(app: FastifyInstance) {
   app.put('/api/users/me/push-token', { preHandler: authenticate }, handlePushTokenUpdate);
   app.get('/api/users/profile', { preHandler: authenticate }, getUserProfile);
   app.put('/api/users/profile', { preHandler: authenticate }, updateUserPreferences);
+  app.get('/api/users/:id/preferences', { preHandler: authenticateService }, getPreferences);
 }
\ No newline at end of file
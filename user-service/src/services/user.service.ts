@@ -14,6 +14,8 @@ interface CreateUserInput {
 type PreferenceUpdateData = {
   email?: boolean;
   push?: boolean;
+  disabled_categories?: string[];
+  muted_until?: Date | null;
 };
 
 export const createUser = async (data: CreateUserInput) => {
@@ -75,6 +77,25 @@ export const updatePushToken = async (userId: string, push_token: string) => {
 };
 
 
+// preferences of a user as read by other services, null when the user does not exist.
+// users without a stored preference row get the defaults
+export const getUserPreferences = async (userId: string) => {
+  const user = await prisma.user.findUnique({
+    where: { id: userId },
+    select: { id: true, preference: true },
+  });
+  if (!user) return null;
+
+  const preference = user.preference;
+  return {
+    user_id: user.id,
+    email: preference?.email ?? true,
+    push: preference?.push ?? true,
+    disabled_categories: preference?.disabled_categories ?? [],
+    muted_until: preference?.muted_until ?? null,
+  };
+};
+
 export const getUserById = async (userId: string) => {
   return prisma.user.findUnique({
     where: { id: userId },